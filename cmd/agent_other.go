@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// dialSSHAgent connects to the ssh-agent at SSH_AUTH_SOCK.
+func dialSSHAgent() (io.ReadWriteCloser, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if len(socket) == 0 {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
+	}
+	return net.Dial("unix", socket)
+}
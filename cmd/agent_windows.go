@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package cmd
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+// windowsSSHAgentPipe is the named pipe of the ssh-agent service of the
+// OpenSSH client which ships with Windows 10.
+const windowsSSHAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+// dialSSHAgent connects to the ssh-agent at SSH_AUTH_SOCK, which Windows
+// 10 can dial as a unix socket, and otherwise to the named pipe of the
+// Windows OpenSSH agent. Pageant is only reached through SSH_AUTH_SOCK,
+// i.e. with its OpenSSH compatible socket.
+func dialSSHAgent() (io.ReadWriteCloser, error) {
+	if socket := os.Getenv("SSH_AUTH_SOCK"); len(socket) > 0 {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			return conn, nil
+		}
+	}
+	return os.OpenFile(windowsSSHAgentPipe, os.O_RDWR, 0)
+}
@@ -3,10 +3,11 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/alexellis/k3sup/pkg/install"
 	"github.com/spf13/cobra"
 )
 
-const getScript = "curl -sfL https://get.k3s.io"
+const getScript = "curl -sfL " + install.DefaultScriptURL
 
 func MakeApps() *cobra.Command {
 	var command = &cobra.Command{
@@ -0,0 +1,365 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// applyFieldManager is the field manager of the objects which --apply
+// creates with a server-side apply.
+const applyFieldManager = "k3sup"
+
+// maxManifestSize is the most which is downloaded of a manifest of --apply.
+const maxManifestSize = 10 << 20
+
+func addApplyFlags(command *cobra.Command) {
+	command.Flags().StringArray("apply", []string{}, "Optional: YAML file or http(s) URL of manifests to apply to the cluster with the kubeconfig once it is written, i.e. a CNI for --no-cni, repeat for more")
+	command.Flags().Duration("apply-timeout", 2*time.Minute, "Time to wait for the API server with --apply, and for the kinds of the CRDs it applied")
+	command.Flags().Bool("apply-force-conflicts", false, "Take the fields of the objects of --apply from the other managers which set them, as kubectl apply --server-side --force-conflicts does, rather than failing")
+}
+
+// applyOptions are the manifests of --apply and how they are applied.
+type applyOptions struct {
+	Sources []string
+	Timeout time.Duration
+	// ForceConflicts takes the fields which another manager set, rather
+	// than failing with a conflict.
+	ForceConflicts bool
+}
+
+// applyFromFlags returns the manifests of --apply, which are checked to
+// exist before anything is installed, and how to apply them.
+func applyFromFlags(command *cobra.Command) (applyOptions, error) {
+	sources, _ := command.Flags().GetStringArray("apply")
+	for _, source := range sources {
+		if isHTTPURL(source) {
+			continue
+		}
+		if u, err := url.Parse(source); err == nil && len(u.Scheme) > 1 {
+			return applyOptions{}, fmt.Errorf("--apply must be a file or a http or https URL, got: %q", source)
+		}
+		if info, err := os.Stat(expandPath(source)); err != nil {
+			return applyOptions{}, fmt.Errorf("unable to read --apply %s: %s", source, err)
+		} else if info.IsDir() {
+			return applyOptions{}, fmt.Errorf("--apply %s is a directory, give each file of it with --apply", source)
+		}
+	}
+
+	timeout, _ := command.Flags().GetDuration("apply-timeout")
+	if len(sources) > 0 && timeout <= 0 {
+		return applyOptions{}, fmt.Errorf("--apply-timeout must be greater than zero")
+	}
+	forceConflicts, _ := command.Flags().GetBool("apply-force-conflicts")
+	return applyOptions{Sources: sources, Timeout: timeout, ForceConflicts: forceConflicts}, nil
+}
+
+// manifestApplier applies manifests to a cluster with server-side apply,
+// which needs no client-side knowledge of the kinds it applies. It talks
+// to the API server with apiClient, as client-go and its RESTMapper are
+// not among the dependencies of k3sup, and a server-side apply only needs
+// the discovery of the kinds which are applied.
+type manifestApplier struct {
+	client         *apiClient
+	timeout        time.Duration
+	interval       time.Duration
+	forceConflicts bool
+
+	// resources are the resources of each apiVersion, by kind.
+	resources map[string]map[string]apiResource
+}
+
+// apiResource is a resource of the API discovery of a group version.
+type apiResource struct {
+	Name       string `json:"name"`
+	Namespaced bool   `json:"namespaced"`
+	Kind       string `json:"kind"`
+}
+
+// applyManifests applies each of the sources of options to the cluster of
+// kubeconfig, once its API server is ready, and reports each object it
+// applied.
+func applyManifests(kubeconfig []byte, options applyOptions) error {
+	if len(options.Sources) == 0 {
+		return nil
+	}
+
+	client, err := newAPIClient(kubeconfig, options.Timeout)
+	if err != nil {
+		return err
+	}
+	applier := &manifestApplier{client: client, timeout: options.Timeout, interval: 2 * time.Second, forceConflicts: options.ForceConflicts}
+
+	if err := applier.waitForAPI(); err != nil {
+		return err
+	}
+
+	applied := 0
+	for _, source := range options.Sources {
+		data, err := readManifestSource(source, options.Timeout)
+		if err != nil {
+			return err
+		}
+		objects, err := decodeManifests(data, source)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if err := applier.apply(object); err != nil {
+				return fmt.Errorf("unable to apply %s from %s: %w", object, source, err)
+			}
+			infof("Applied %s from %s\n", object, source)
+			applied++
+		}
+	}
+	infof("Applied %d objects from %d manifests\n", applied, len(options.Sources))
+	return nil
+}
+
+// readManifestSource reads a file, or downloads a http or https URL.
+func readManifestSource(source string, timeout time.Duration) ([]byte, error) {
+	if !isHTTPURL(source) {
+		data, err := ioutil.ReadFile(expandPath(source))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read --apply %s: %s", source, err)
+		}
+		return data, nil
+	}
+
+	client := &http.Client{Timeout: timeout}
+	res, err := client.Get(source)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download --apply %s: %s", source, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download --apply %s: %s", source, res.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to download --apply %s: %s", source, err)
+	}
+	if len(data) > maxManifestSize {
+		return nil, fmt.Errorf("unable to download --apply %s: it is larger than %d MB", source, maxManifestSize>>20)
+	}
+	return data, nil
+}
+
+// manifestObject is an object of a manifest, in the form of JSON.
+type manifestObject struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	Content    map[string]interface{}
+}
+
+func (o manifestObject) String() string {
+	if len(o.Namespace) > 0 {
+		return fmt.Sprintf("%s %s/%s", o.Kind, o.Namespace, o.Name)
+	}
+	return fmt.Sprintf("%s %s", o.Kind, o.Name)
+}
+
+// decodeManifests returns the objects of the YAML documents of data,
+// skipping those which are empty.
+func decodeManifests(data []byte, source string) ([]manifestObject, error) {
+	objects := []manifestObject{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for i := 1; ; i++ {
+		document := map[interface{}]interface{}{}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d of %s is not valid YAML: %s", i, source, err)
+		}
+		if len(document) == 0 {
+			continue
+		}
+
+		content, _ := jsonValue(document).(map[string]interface{})
+		object := manifestObject{Content: content}
+		object.APIVersion, _ = content["apiVersion"].(string)
+		object.Kind, _ = content["kind"].(string)
+		metadata, _ := content["metadata"].(map[string]interface{})
+		object.Name, _ = metadata["name"].(string)
+		object.Namespace, _ = metadata["namespace"].(string)
+
+		if len(object.APIVersion) == 0 || len(object.Kind) == 0 || len(object.Name) == 0 {
+			return nil, fmt.Errorf("document %d of %s needs an apiVersion, kind and metadata.name", i, source)
+		}
+		if strings.HasSuffix(object.Kind, "List") {
+			return nil, fmt.Errorf("document %d of %s is a %s, give its items as separate documents", i, source, object.Kind)
+		}
+		objects = append(objects, object)
+	}
+}
+
+// jsonValue converts the maps decoded by yaml.v2, whose keys may be of
+// any type, to maps which encoding/json can marshal.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = jsonValue(item)
+		}
+		return converted
+	}
+	return value
+}
+
+// waitForAPI waits for /readyz of the API server to pass.
+func (a *manifestApplier) waitForAPI() error {
+	deadline := time.Now().Add(a.timeout)
+	for {
+		status, body, err := a.client.do(http.MethodGet, "/readyz", "", nil)
+		if err == nil && status == http.StatusOK {
+			return nil
+		}
+		if time.Now().Add(a.interval).After(deadline) {
+			if err == nil {
+				err = fmt.Errorf("%d %s: %s", status, http.StatusText(status), strings.TrimSpace(string(body)))
+			}
+			return fmt.Errorf("the API server at %s was not ready to apply manifests within %s: %s", a.client.server, a.timeout, err)
+		}
+		debugf("Waiting for the API server at %s to apply manifests\n", a.client.server)
+		time.Sleep(a.interval)
+	}
+}
+
+// apply creates or updates object with a server-side apply. A kind which
+// the API server does not know yet, i.e. of a CRD applied just before, is
+// looked up again until the timeout.
+func (a *manifestApplier) apply(object manifestObject) error {
+	deadline := time.Now().Add(a.timeout)
+	resource, err := a.resource(object.APIVersion, object.Kind)
+	for err == errUnknownKind && time.Now().Add(a.interval).Before(deadline) {
+		debugf("Waiting for the API server to serve %s %s\n", object.APIVersion, object.Kind)
+		time.Sleep(a.interval)
+		delete(a.resources, object.APIVersion)
+		resource, err = a.resource(object.APIVersion, object.Kind)
+	}
+	if err == errUnknownKind {
+		return fmt.Errorf("the API server does not serve the kind %s of %s", object.Kind, object.APIVersion)
+	}
+	if err != nil {
+		return err
+	}
+
+	path := groupVersionPath(object.APIVersion)
+	if resource.Namespaced {
+		namespace := object.Namespace
+		if len(namespace) == 0 {
+			namespace = "default"
+		}
+		path += "/namespaces/" + url.PathEscape(namespace)
+	}
+	path += fmt.Sprintf("/%s/%s?fieldManager=%s", resource.Name, url.PathEscape(object.Name), applyFieldManager)
+	if a.forceConflicts {
+		path += "&force=true"
+	}
+
+	body, err := json.Marshal(object.Content)
+	if err != nil {
+		return err
+	}
+	status, answer, err := a.client.do(http.MethodPatch, path, "application/apply-patch+yaml", body)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusConflict && !a.forceConflicts {
+		return fmt.Errorf("the API server answered %d %s: %s, give --apply-force-conflicts to take the fields", status, http.StatusText(status), apiErrorMessage(answer))
+	}
+	if status != http.StatusOK && status != http.StatusCreated {
+		return fmt.Errorf("the API server answered %d %s: %s", status, http.StatusText(status), apiErrorMessage(answer))
+	}
+	return nil
+}
+
+// errUnknownKind is returned by resource for a kind the API server does not
+// serve.
+var errUnknownKind = fmt.Errorf("unknown kind")
+
+// resource returns the resource of kind in apiVersion, from the discovery
+// of the API server.
+func (a *manifestApplier) resource(apiVersion, kind string) (apiResource, error) {
+	if a.resources == nil {
+		a.resources = map[string]map[string]apiResource{}
+	}
+
+	resources, ok := a.resources[apiVersion]
+	if !ok {
+		status, body, err := a.client.do(http.MethodGet, groupVersionPath(apiVersion), "", nil)
+		if err != nil {
+			return apiResource{}, err
+		}
+		if status == http.StatusNotFound {
+			return apiResource{}, errUnknownKind
+		}
+		if status != http.StatusOK {
+			return apiResource{}, fmt.Errorf("the API server answered the discovery of %s with %d %s: %s", apiVersion, status, http.StatusText(status), apiErrorMessage(body))
+		}
+
+		list := struct {
+			Resources []apiResource `json:"resources"`
+		}{}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return apiResource{}, fmt.Errorf("unexpected discovery of %s from the API server: %s", apiVersion, err)
+		}
+		resources = map[string]apiResource{}
+		for _, resource := range list.Resources {
+			// Subresources such as deployments/scale have the kind of
+			// another object.
+			if !strings.Contains(resource.Name, "/") {
+				resources[resource.Kind] = resource
+			}
+		}
+		a.resources[apiVersion] = resources
+	}
+
+	resource, ok := resources[kind]
+	if !ok {
+		return apiResource{}, errUnknownKind
+	}
+	return resource, nil
+}
+
+// groupVersionPath is the path of the API of apiVersion, which is /api/v1
+// for the core group and /apis/<group>/<version> for the others.
+func groupVersionPath(apiVersion string) string {
+	if !strings.Contains(apiVersion, "/") {
+		return "/api/" + apiVersion
+	}
+	return "/apis/" + apiVersion
+}
+
+// apiErrorMessage returns the message of a Status from the API server, or
+// the answer as it is when it is not one.
+func apiErrorMessage(answer []byte) string {
+	status := struct {
+		Message string `json:"message"`
+	}{}
+	if json.Unmarshal(answer, &status) == nil && len(status.Message) > 0 {
+		return status.Message
+	}
+	return strings.TrimSpace(string(answer))
+}
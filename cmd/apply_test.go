@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const applyExample = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+---
+# only a comment
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: apps
+spec:
+  replicas: 2
+  selector:
+    matchLabels: {app: web}
+`
+
+// fakeAPIServer serves /readyz, the discovery of v1 and apps/v1 and
+// server-side applies, recording the path and body of each apply.
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	applies map[string]map[string]interface{}
+	// forced are the paths applied with force=true.
+	forced []string
+	// discoveries of a group version which answer 404 before it is served.
+	pending map[string]int
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/readyz":
+		fmt.Fprint(w, "ok")
+	case r.Method == http.MethodGet && f.pending[r.URL.Path] > 0:
+		f.pending[r.URL.Path]--
+		http.NotFound(w, r)
+	case r.URL.Path == "/api/v1":
+		fmt.Fprint(w, `{"resources": [{"name": "namespaces", "namespaced": false, "kind": "Namespace"}, {"name": "namespaces/status", "namespaced": false, "kind": "Namespace"}]}`)
+	case r.URL.Path == "/apis/apps/v1":
+		fmt.Fprint(w, `{"resources": [{"name": "deployments", "namespaced": true, "kind": "Deployment"}, {"name": "deployments/scale", "namespaced": true, "kind": "Scale"}]}`)
+	case r.Method == http.MethodPatch:
+		if r.Header.Get("Content-Type") != "application/apply-patch+yaml" || r.URL.Query().Get("fieldManager") != "k3sup" {
+			http.Error(w, `{"kind": "Status", "message": "not a server-side apply"}`, http.StatusUnsupportedMediaType)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/forbidden") {
+			http.Error(w, `{"kind": "Status", "message": "deployments.apps \"forbidden\" is forbidden"}`, http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Get("force") == "true" {
+			f.forced = append(f.forced, r.URL.Path)
+		} else if strings.Contains(r.URL.Path, "/conflict") {
+			http.Error(w, `{"kind": "Status", "message": "Apply failed with 1 conflict: conflict with \"kubectl\": .spec.replicas"}`, http.StatusConflict)
+			return
+		}
+		body := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.applies[r.URL.Path] = body
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFakeAPIServer(t *testing.T) (*fakeAPIServer, *httptest.Server, []byte) {
+	api := &fakeAPIServer{applies: map[string]map[string]interface{}{}, pending: map[string]int{}}
+	server := httptest.NewTLSServer(api)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	return api, server, testKubeconfig(server.URL, caPEM)
+}
+
+func Test_applyManifests(t *testing.T) {
+	api, server, kubeconfig := newFakeAPIServer(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "k3sup-apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "web.yaml")
+	if err := ioutil.WriteFile(manifest, []byte(applyExample), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, logInfo, func() {
+		err = applyManifests(kubeconfig, applyOptions{Sources: []string{manifest}, Timeout: 5 * time.Second})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	paths := []string{}
+	for path := range api.applies {
+		paths = append(paths, path)
+	}
+	if len(paths) != 2 || api.applies["/api/v1/namespaces/apps"] == nil || api.applies["/apis/apps/v1/namespaces/apps/deployments/web"] == nil {
+		t.Fatalf("want the namespace and deployment applied, got: %q", paths)
+	}
+	spec, _ := api.applies["/apis/apps/v1/namespaces/apps/deployments/web"]["spec"].(map[string]interface{})
+	if spec["replicas"] != float64(2) {
+		t.Errorf("want the spec of the deployment sent, got: %v", api.applies["/apis/apps/v1/namespaces/apps/deployments/web"])
+	}
+
+	if len(api.forced) > 0 {
+		t.Errorf("want no fields forced by default, got: %q", api.forced)
+	}
+
+	for _, want := range []string{"Applied Namespace apps from " + manifest, "Applied Deployment apps/web from " + manifest, "Applied 2 objects from 1 manifests"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q reported, got:\n%s", want, out)
+		}
+	}
+}
+
+func Test_applyManifests_URL(t *testing.T) {
+	api, server, kubeconfig := newFakeAPIServer(t)
+	defer server.Close()
+
+	manifests := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: cni\n")
+	}))
+	defer manifests.Close()
+
+	var err error
+	captureStdout(t, logQuiet, func() {
+		err = applyManifests(kubeconfig, applyOptions{Sources: []string{manifests.URL + "/cni.yaml"}, Timeout: 5 * time.Second})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if api.applies["/api/v1/namespaces/cni"] == nil {
+		t.Errorf("want the namespace of the URL applied, got: %v", api.applies)
+	}
+}
+
+func Test_applyManifests_Error(t *testing.T) {
+	_, server, kubeconfig := newFakeAPIServer(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "k3sup-apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "forbidden.yaml")
+	ioutil.WriteFile(manifest, []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: forbidden\n"), 0600)
+
+	captureStdout(t, logQuiet, func() {
+		err = applyManifests(kubeconfig, applyOptions{Sources: []string{manifest}, Timeout: 5 * time.Second})
+	})
+	want := `unable to apply Deployment forbidden from ` + manifest + `: the API server answered 403 Forbidden: deployments.apps "forbidden" is forbidden`
+	if err == nil || err.Error() != want {
+		t.Errorf("want: %q, got: %v", want, err)
+	}
+}
+
+func Test_manifestApplier_Conflict(t *testing.T) {
+	api, server, kubeconfig := newFakeAPIServer(t)
+	defer server.Close()
+
+	client, err := newAPIClient(kubeconfig, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applier := &manifestApplier{client: client, timeout: 5 * time.Second, interval: time.Millisecond}
+
+	object := manifestObject{APIVersion: "apps/v1", Kind: "Deployment", Name: "conflict", Content: map[string]interface{}{"kind": "Deployment"}}
+	if err := applier.apply(object); err == nil || !strings.Contains(err.Error(), "give --apply-force-conflicts") {
+		t.Errorf("want a conflict naming --apply-force-conflicts, got: %v", err)
+	}
+
+	applier.forceConflicts = true
+	if err := applier.apply(object); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"/apis/apps/v1/namespaces/default/deployments/conflict"}; !reflect.DeepEqual(api.forced, want) {
+		t.Errorf("want: %q, got: %q", want, api.forced)
+	}
+}
+
+func Test_readManifestSource_TooLarge(t *testing.T) {
+	manifests := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, maxManifestSize+1))
+	}))
+	defer manifests.Close()
+
+	if _, err := readManifestSource(manifests.URL+"/huge.yaml", 5*time.Second); err == nil || !strings.Contains(err.Error(), "larger than 10 MB") {
+		t.Errorf("want an error for a manifest larger than the limit, got: %v", err)
+	}
+}
+
+func Test_manifestApplier_WaitsForKind(t *testing.T) {
+	api, server, kubeconfig := newFakeAPIServer(t)
+	defer server.Close()
+	api.pending["/apis/apps/v1"] = 2
+
+	client, err := newAPIClient(kubeconfig, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applier := &manifestApplier{client: client, timeout: 5 * time.Second, interval: time.Millisecond}
+
+	object := manifestObject{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Content: map[string]interface{}{"kind": "Deployment"}}
+	if err := applier.apply(object); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if api.applies["/apis/apps/v1/namespaces/default/deployments/web"] == nil {
+		t.Errorf("want the deployment applied in the default namespace, got: %v", api.applies)
+	}
+
+	applier.timeout = 10 * time.Millisecond
+	object = manifestObject{APIVersion: "example.com/v1", Kind: "Widget", Name: "w"}
+	if err := applier.apply(object); err == nil || !strings.Contains(err.Error(), "does not serve the kind Widget of example.com/v1") {
+		t.Errorf("want an error for a kind which is never served, got: %v", err)
+	}
+}
+
+func Test_decodeManifests(t *testing.T) {
+	objects, err := decodeManifests([]byte(applyExample), "web.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got := []string{}
+	for _, object := range objects {
+		got = append(got, object.String())
+	}
+	if want := []string{"Namespace apps", "Deployment apps/web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+	if _, err := json.Marshal(objects[1].Content); err != nil {
+		t.Errorf("want the content to marshal to JSON: %s", err)
+	}
+
+	invalid := map[string]string{
+		"no name":  "apiVersion: v1\nkind: Namespace\n",
+		"a list":   "apiVersion: v1\nkind: List\nmetadata:\n  name: all\n",
+		"not yaml": "apiVersion: [v1\n",
+	}
+	for name, manifest := range invalid {
+		if _, err := decodeManifests([]byte(manifest), "bad.yaml"); err == nil {
+			t.Errorf("want an error for %s", name)
+		}
+	}
+}
+
+func Test_applyFromFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "cni.yaml")
+	ioutil.WriteFile(manifest, []byte(applyExample), 0600)
+
+	command := MakeInstall()
+	command.Flags().Set("apply", manifest)
+	command.Flags().Set("apply", "https://example.com/operator.yaml")
+	apply, err := applyFromFlags(command)
+	if err != nil || len(apply.Sources) != 2 || apply.Timeout != 2*time.Minute || apply.ForceConflicts {
+		t.Errorf("want both sources, got: %+v %v", apply, err)
+	}
+
+	for _, invalid := range []string{filepath.Join(dir, "missing.yaml"), dir, "ftp://example.com/cni.yaml"} {
+		command := MakeInstall()
+		command.Flags().Set("apply", invalid)
+		if _, err := applyFromFlags(command); err == nil {
+			t.Errorf("want an error for %q", invalid)
+		}
+	}
+}
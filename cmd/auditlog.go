@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+// auditLog appends a JSON line for each command run on a node to the file
+// given by the global --audit-log flag. The file is opened for each record,
+// so that every record is on disk before the next command runs.
+type auditLog struct {
+	path string
+	// command is the k3sup command which ran, i.e. "install".
+	command string
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time        string `json:"time"`
+	K3supCmd    string `json:"k3sup_command"`
+	Host        string `json:"host"`
+	Command     string `json:"command,omitempty"`
+	Upload      string `json:"upload,omitempty"`
+	UploadBytes int64  `json:"upload_bytes,omitempty"`
+	ExitStatus  int    `json:"exit_status"`
+	DurationMs  int64  `json:"duration_ms"`
+	Error       string `json:"error,omitempty"`
+}
+
+// auditLogFromFlags returns the audit log of the --audit-log flag, or nil
+// when it is not set.
+func auditLogFromFlags(command *cobra.Command) (*auditLog, error) {
+	path, err := command.Flags().GetString("audit-log")
+	if err != nil || len(path) == 0 {
+		return nil, nil
+	}
+
+	path = expandPath(path)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the --audit-log file: %s", err)
+	}
+	file.Close()
+
+	return &auditLog{path: path, command: command.Name()}, nil
+}
+
+// recorder returns the operator.Audit for commands run on host, which also
+// prints each command with --verbose. It is nil when there is no audit log
+// and nothing to print.
+func (l *auditLog) recorder(host string) operator.Audit {
+	if l == nil && verbosity < logDebug {
+		return nil
+	}
+	return func(record operator.AuditRecord) {
+		debugAuditRecord(host, record)
+		if l == nil {
+			return
+		}
+		if err := l.write(makeAuditRecord(l.command, host, record)); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write to the --audit-log: %s\n", err)
+		}
+	}
+}
+
+func (l *auditLog) write(record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// debugAuditRecord prints a command which ran on host with --verbose, an
+// upload is printed with its path and size only.
+func debugAuditRecord(host string, record operator.AuditRecord) {
+	what := redactSecrets(strings.TrimSpace(record.Command))
+	if len(record.UploadPath) > 0 {
+		what = fmt.Sprintf("upload of %d bytes to %s", record.UploadBytes, record.UploadPath)
+	}
+	debugf("[%s] ran in %s with exit status %d: %s\n", host, roundDuration(record.Duration), record.ExitStatus, what)
+}
+
+// makeAuditRecord redacts the secrets in the command of record. An upload
+// is recorded with its path and size, its content, which may hold
+// credentials such as those of registries.yaml, is never part of the
+// record.
+func makeAuditRecord(k3supCommand, host string, record operator.AuditRecord) auditRecord {
+	entry := auditRecord{
+		Time:        record.Start.UTC().Format(time.RFC3339Nano),
+		K3supCmd:    k3supCommand,
+		Host:        host,
+		Command:     maskSecrets(strings.TrimSpace(record.Command)),
+		Upload:      record.UploadPath,
+		UploadBytes: record.UploadBytes,
+		ExitStatus:  record.ExitStatus,
+		DurationMs:  record.Duration.Milliseconds(),
+	}
+	if record.Err != nil {
+		entry.Error = maskSecrets(record.Err.Error())
+	}
+	return entry
+}
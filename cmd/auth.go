@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// passwordPrompt is the value of --ssh-password when it is given without a
+// value, in which case the password is read from the terminal.
+const passwordPrompt = "\x00prompt"
+
+func addSSHPasswordFlags(command *cobra.Command) {
+	command.Flags().String("ssh-password", "", "Optional: SSH password, tried after the ssh key. Give it as --ssh-password=<password>, or without a value to be prompted for it")
+	command.Flags().Lookup("ssh-password").NoOptDefVal = passwordPrompt
+	command.Flags().Bool("ssh-password-stdin", false, "Read the SSH password from the first line of stdin")
+	command.Flags().String("ssh-key-passphrase", "", "Optional: passphrase of an encrypted ssh key, prefer --ssh-key-passphrase-file or "+sshKeyPassphraseEnv+" as it is visible to other users of this computer")
+	command.Flags().String("ssh-key-passphrase-file", "", "Optional: file containing the passphrase of an encrypted ssh key, i.e. for CI")
+}
+
+// sshKeyPassphraseEnv is read for the passphrase of the ssh key when no
+// passphrase flag is given. It is the only variable of the passphrase,
+// --ssh-key-passphrase is not bound to K3SUP_SSH_KEY_PASSPHRASE.
+const sshKeyPassphraseEnv = "K3SUP_SSH_PASSPHRASE"
+
+// sshKeyPassphraseFromFlags returns the passphrase of the ssh key from
+// --ssh-key-passphrase-file, --ssh-key-passphrase or K3SUP_SSH_PASSPHRASE,
+// or nil when none was given.
+func sshKeyPassphraseFromFlags(command *cobra.Command) ([]byte, error) {
+	passphrase, _ := command.Flags().GetString("ssh-key-passphrase")
+	passphraseFile, _ := command.Flags().GetString("ssh-key-passphrase-file")
+
+	switch {
+	case len(passphrase) > 0 && len(passphraseFile) > 0:
+		return nil, fmt.Errorf("give either --ssh-key-passphrase or --ssh-key-passphrase-file, not both")
+	case len(passphraseFile) > 0:
+		data, err := ioutil.ReadFile(expandPath(passphraseFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the ssh key passphrase: %s", err)
+		}
+		passphrase := bytes.TrimRight(data, "\r\n")
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("the ssh key passphrase file %s is empty", passphraseFile)
+		}
+		return passphrase, nil
+	case len(passphrase) > 0:
+		return []byte(passphrase), nil
+	}
+
+	if env, ok := os.LookupEnv(sshKeyPassphraseEnv); ok && len(env) > 0 {
+		return []byte(env), nil
+	}
+	return nil, nil
+}
+
+// sshPasswordFromFlags returns the SSH password, or nil when none was
+// given. Unlike the passphrase and the sudo password it is not zeroed, as
+// the ssh package takes it as a string, which cannot be overwritten.
+func sshPasswordFromFlags(command *cobra.Command) ([]byte, error) {
+	passwordStdin, _ := command.Flags().GetBool("ssh-password-stdin")
+	if passwordStdin {
+		if flagGiven(command, "ssh-password") {
+			return nil, fmt.Errorf("give either --ssh-password or --ssh-password-stdin, not both")
+		}
+		return readPasswordLine(os.Stdin, "SSH password")
+	}
+
+	password, _ := command.Flags().GetString("ssh-password")
+	if password != passwordPrompt {
+		if len(password) == 0 {
+			return nil, nil
+		}
+		return []byte(password), nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter SSH password: ")
+	bytePassword, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("reading password from stdin failed: %s", err)
+	}
+	return bytePassword, nil
+}
+
+// readPasswordLine reads the first line of r, which is buffered, so only
+// one password can be read from stdin.
+func readPasswordLine(r *os.File, what string) ([]byte, error) {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, fmt.Errorf("unable to read the %s from stdin: %s", what, err)
+	}
+
+	password := bytes.TrimRight(line, "\r\n")
+	if len(password) == 0 {
+		return nil, fmt.Errorf("the %s read from stdin is empty", what)
+	}
+	return password, nil
+}
+
+// sudoPasswordEnv is read for the sudo password when no sudo password flag
+// is given.
+const sudoPasswordEnv = "K3SUP_SUDO_PASSWORD"
+
+func addSudoPasswordFlags(command *cobra.Command) {
+	command.Flags().String("sudo-password", "", "Optional: password for sudo on the node, when it is not passwordless, only with --sudo-binary sudo. Give it as --sudo-password=<password>, or without a value to be prompted for it, or use --sudo-password-stdin or "+sudoPasswordEnv)
+	command.Flags().Lookup("sudo-password").NoOptDefVal = passwordPrompt
+	command.Flags().Bool("sudo-password-stdin", false, "Read the password for sudo from the first line of stdin")
+}
+
+// sudoPasswordFromFlags returns the password for sudo from --sudo-password,
+// --sudo-password-stdin or K3SUP_SUDO_PASSWORD, or nil when none was given.
+// It is an error with another --sudo-binary, as the operator always runs
+// sudo with the password.
+// Callers should zero it with zeroPassword once done.
+func sudoPasswordFromFlags(command *cobra.Command) ([]byte, error) {
+	if command.Flags().Lookup("sudo-password") == nil {
+		return nil, nil
+	}
+
+	password, _ := command.Flags().GetString("sudo-password")
+	passwordStdin, _ := command.Flags().GetBool("sudo-password-stdin")
+
+	var sudoPassword []byte
+	switch {
+	case passwordStdin && flagGiven(command, "sudo-password"):
+		return nil, fmt.Errorf("give either --sudo-password or --sudo-password-stdin, not both")
+	case passwordStdin:
+		if sshPasswordStdin, _ := command.Flags().GetBool("ssh-password-stdin"); sshPasswordStdin {
+			return nil, fmt.Errorf("only one password can be read from stdin, give either --ssh-password-stdin or --sudo-password-stdin")
+		}
+		line, err := readPasswordLine(os.Stdin, "sudo password")
+		if err != nil {
+			return nil, err
+		}
+		sudoPassword = line
+	case password == passwordPrompt:
+		fmt.Fprintf(os.Stderr, "Enter sudo password: ")
+		line, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("reading the sudo password from stdin failed: %s", err)
+		}
+		sudoPassword = line
+	case len(password) > 0:
+		sudoPassword = []byte(password)
+	default:
+		if env, ok := os.LookupEnv(sudoPasswordEnv); ok && len(env) > 0 {
+			sudoPassword = []byte(env)
+		}
+	}
+
+	if len(sudoPassword) == 0 {
+		return nil, nil
+	}
+
+	useSudo, _ := command.Flags().GetBool("sudo")
+	sudoBinary, _ := command.Flags().GetString("sudo-binary")
+	if !useSudo {
+		zeroPassword(sudoPassword)
+		return nil, fmt.Errorf("a sudo password cannot be used with --sudo=false")
+	}
+	if strings.TrimSpace(sudoBinary) != "sudo" {
+		zeroPassword(sudoPassword)
+		return nil, fmt.Errorf("a sudo password only works with --sudo-binary sudo, not %q", sudoBinary)
+	}
+	return sudoPassword, nil
+}
+
+func zeroPassword(password []byte) {
+	for i := range password {
+		password[i] = 0
+	}
+}
+
+// zero overwrites the passphrase of the ssh key and the sudo password, see
+// sshPasswordFromFlags for the SSH password.
+func (o sshOptions) zero() {
+	zeroPassword(o.KeyPassphrase)
+	zeroPassword(o.SudoPassword)
+}
+
+// makeAuthMethods tries the ssh key first, then the keys of identityFiles,
+// and then the password, when one is given. Without a password the ssh key
+// must load, any of identityFiles which does not is skipped.
+func makeAuthMethods(sshKeyPath string, identityFiles []string, passphrase, password []byte) ([]ssh.AuthMethod, func() error, error) {
+	authMethods := []ssh.AuthMethod{}
+	closers := []func() error{}
+	closeAll := func() error {
+		for _, close := range closers {
+			close()
+		}
+		return nil
+	}
+
+	// The ssh package tries an authentication method only once, so every
+	// key is offered through the one public key method.
+	keys := []func() ([]ssh.Signer, error){}
+
+	signers, closeSSHAgent, err := loadSigners(sshKeyPath, passphrase)
+	closers = append(closers, closeSSHAgent)
+	if err != nil {
+		if len(password) == 0 {
+			return nil, closeAll, errors.Wrapf(err, "unable to load the ssh key with path %q", sshKeyPath)
+		}
+		infof("Unable to load the ssh key with path %q, using password authentication\n", sshKeyPath)
+	} else {
+		keys = append(keys, signers)
+	}
+
+	for _, identityFile := range identityFiles {
+		signers, close, err := loadSigners(identityFile, passphrase)
+		closers = append(closers, close)
+		if err != nil {
+			debugf("Skipping the ssh key %s: %s\n", identityFile, err)
+			continue
+		}
+		keys = append(keys, signers)
+	}
+
+	if len(keys) > 0 {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			all := []ssh.Signer{}
+			for _, signers := range keys {
+				s, err := signers()
+				if err != nil {
+					return nil, err
+				}
+				all = append(all, s...)
+			}
+			return all, nil
+		}))
+	}
+
+	if len(password) > 0 {
+		authMethods = append(authMethods, ssh.PasswordCallback(func() (string, error) {
+			return string(password), nil
+		}))
+	}
+
+	return authMethods, closeAll, nil
+}
@@ -0,0 +1,523 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// execReply is what the test SSH server answers every exec request with.
+type execReply struct {
+	Stdout     string
+	Stderr     string
+	ExitStatus uint32
+	// Banner is printed before the command runs, as by a .bashrc.
+	Banner string
+}
+
+// outputMarkerPattern finds the marker of a command wrapped by the
+// SSHOperator, so that the test server can print it as sh would.
+var outputMarkerPattern = regexp.MustCompile(`printf '%s\\n' (k3sup-output-[0-9a-f]+)-begin`)
+
+// startPasswordServer starts an SSH server on a random local port which
+// only accepts password authentication and answers every exec request
+// with "ok" and an exit status of 0. It forwards direct-tcpip channels, so
+// it can also act as a jump host.
+func startPasswordServer(t *testing.T, user, password string) (string, func()) {
+	return startExecServer(t, user, password, execReply{Stdout: "ok\n"})
+}
+
+// startExecServer is startPasswordServer answering with reply.
+func startExecServer(t *testing.T, user, password string, reply execReply) (string, func()) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, given []byte) (*ssh.Permissions, error) {
+			if conn.User() == user && string(given) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go servePasswordConn(conn, config, reply)
+		}
+	}()
+
+	return listener.Addr().String(), func() { listener.Close() }
+}
+
+func servePasswordConn(conn net.Conn, config *ssh.ServerConfig, reply execReply) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go forwardChannel(newChannel)
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(channel ssh.Channel, requests <-chan *ssh.Request) {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				channel.Write([]byte(reply.Banner))
+				if match := outputMarkerPattern.FindSubmatch(req.Payload); match != nil {
+					channel.Write([]byte(fmt.Sprintf("%s-begin\n%s%s-end\n", match[1], reply.Stdout, match[1])))
+				} else {
+					channel.Write([]byte(reply.Stdout))
+				}
+				channel.Stderr().Write([]byte(reply.Stderr))
+
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, reply.ExitStatus)
+				channel.SendRequest("exit-status", false, status)
+				return
+			}
+		}(channel, requests)
+	}
+}
+
+func Test_connectSSH_Password(t *testing.T) {
+	address, stop := startPasswordServer(t, "pi", "raspberry")
+	defer stop()
+
+	missingKey := filepath.Join(os.TempDir(), "k3sup-does-not-exist", "id_rsa")
+	password := []byte("raspberry")
+
+	op, err := connectSSH(address, "pi", missingKey, sshOptions{Password: password, HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeouts: sshTimeouts{Dial: 5 * time.Second}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer op.Close()
+
+	res, err := op.ExecuteStreaming("hostname", ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(res.StdOut) != "ok\n" {
+		t.Errorf("want: %q, got: %q", "ok\n", res.StdOut)
+	}
+}
+
+func Test_connectSSH_StripsBanner(t *testing.T) {
+	address, stop := startExecServer(t, "pi", "raspberry", execReply{Stdout: "apiVersion: v1\n", Banner: "Welcome to the node\n"})
+	defer stop()
+
+	missingKey := filepath.Join(os.TempDir(), "k3sup-does-not-exist", "id_rsa")
+	for _, noOutputMarkers := range []bool{false, true} {
+		op, err := connectSSH(address, "pi", missingKey, sshOptions{Password: []byte("raspberry"), HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeouts: sshTimeouts{Dial: 5 * time.Second}, NoOutputMarkers: noOutputMarkers})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		stdout := strings.Builder{}
+		res, err := op.ExecuteStreaming("cat /etc/rancher/k3s/k3s.yaml", &stdout, ioutil.Discard)
+		op.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		want := "apiVersion: v1\n"
+		if noOutputMarkers {
+			want = "Welcome to the node\napiVersion: v1\n"
+		}
+		if string(res.StdOut) != want || stdout.String() != want {
+			t.Errorf("with NoOutputMarkers %v want: %q, got: %q, streamed %q", noOutputMarkers, want, res.StdOut, stdout.String())
+		}
+	}
+}
+
+func Test_connectSSH_WrongPassword(t *testing.T) {
+	address, stop := startPasswordServer(t, "pi", "raspberry")
+	defer stop()
+
+	missingKey := filepath.Join(os.TempDir(), "k3sup-does-not-exist", "id_rsa")
+	if _, err := connectSSH(address, "pi", missingKey, sshOptions{Password: []byte("wrong"), HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeouts: sshTimeouts{Dial: 5 * time.Second}}); err == nil {
+		t.Fatalf("want an error for a wrong password")
+	}
+}
+
+func Test_connectSSH_NoKeyNoPassword(t *testing.T) {
+	missingKey := filepath.Join(os.TempDir(), "k3sup-does-not-exist", "id_rsa")
+	if _, err := connectSSH("127.0.0.1:22", "pi", missingKey, sshOptions{HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeouts: sshTimeouts{Dial: 5 * time.Second}}); err == nil {
+		t.Fatalf("want an error when the key is missing and no password is given")
+	}
+}
+
+func Test_zeroPassword(t *testing.T) {
+	password := []byte("raspberry")
+	zeroPassword(password)
+	for _, b := range password {
+		if b != 0 {
+			t.Fatalf("want the password to be zeroed, got: %q", password)
+		}
+	}
+}
+
+func Test_sshKeyPassphraseFromFlags(t *testing.T) {
+	env, ok := os.LookupEnv(sshKeyPassphraseEnv)
+	os.Unsetenv(sshKeyPassphraseEnv)
+	if ok {
+		defer os.Setenv(sshKeyPassphraseEnv, env)
+	}
+
+	file, err := ioutil.TempFile("", "k3sup-passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("from-file\n")
+	file.Close()
+
+	cases := []struct {
+		name    string
+		args    []string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "flag", args: []string{"--ssh-key-passphrase", "from-flag"}, env: "from-env", want: "from-flag"},
+		{name: "file", args: []string{"--ssh-key-passphrase-file", file.Name()}, env: "from-env", want: "from-file"},
+		{name: "env", env: "from-env", want: "from-env"},
+		{name: "both flags", args: []string{"--ssh-key-passphrase", "a", "--ssh-key-passphrase-file", file.Name()}, wantErr: true},
+		{name: "missing file", args: []string{"--ssh-key-passphrase-file", file.Name() + "-missing"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			os.Setenv(sshKeyPassphraseEnv, c.env)
+			defer os.Unsetenv(sshKeyPassphraseEnv)
+
+			command := &cobra.Command{}
+			addSSHPasswordFlags(command)
+			if err := command.ParseFlags(c.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := sshKeyPassphraseFromFlags(command)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(got) != c.want {
+				t.Errorf("want: %q, got: %q", c.want, got)
+			}
+		})
+	}
+}
+
+func forwardChannel(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprint(target.Port)))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	go io.Copy(channel, conn)
+	io.Copy(conn, channel)
+}
+
+func Test_sudoPasswordFromFlags(t *testing.T) {
+	env, ok := os.LookupEnv(sudoPasswordEnv)
+	os.Unsetenv(sudoPasswordEnv)
+	if ok {
+		defer os.Setenv(sudoPasswordEnv, env)
+	}
+
+	cases := []struct {
+		name    string
+		args    []string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "flag", args: []string{"--sudo-password=from-flag"}, env: "from-env", want: "from-flag"},
+		{name: "env", env: "from-env", want: "from-env"},
+		{name: "both flags", args: []string{"--sudo-password=a", "--sudo-password-stdin"}, wantErr: true},
+		{name: "both from stdin", args: []string{"--ssh-password-stdin", "--sudo-password-stdin"}, wantErr: true},
+		{name: "without sudo", args: []string{"--sudo=false", "--sudo-password=a"}, wantErr: true},
+		{name: "with doas", args: []string{"--sudo-binary", "doas", "--sudo-password=a"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			os.Setenv(sudoPasswordEnv, c.env)
+			defer os.Unsetenv(sudoPasswordEnv)
+
+			command := &cobra.Command{}
+			addSSHPasswordFlags(command)
+			command.Flags().Bool("sudo", true, "")
+			command.Flags().String("sudo-binary", "sudo", "")
+			addSudoPasswordFlags(command)
+			if err := command.ParseFlags(c.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := sudoPasswordFromFlags(command)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(got) != c.want {
+				t.Errorf("want: %q, got: %q", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_checkSudo(t *testing.T) {
+	cases := []struct {
+		reply   string
+		wantErr string
+	}{
+		{"found\n", ""},
+		{"password\n", "--sudo-password-stdin"},
+		{"missing\n", "--sudo-binary"},
+	}
+
+	for _, c := range cases {
+		op := &scriptedOperator{replies: map[string]string{sudoCheckCommand("sudo"): c.reply}}
+		err := checkSudo(op, "sudo ")
+		if c.wantErr == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %q: %s", c.reply, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("want an error mentioning %s for %q, got: %v", c.wantErr, c.reply, err)
+		}
+	}
+
+	op := &scriptedOperator{}
+	if err := checkSudo(op, ""); err != nil || len(op.commands) != 0 {
+		t.Errorf("want no check without sudo, got: %v, commands: %q", err, op.commands)
+	}
+}
+
+func Test_sshAgent_NoSocket(t *testing.T) {
+	socket, ok := os.LookupEnv("SSH_AUTH_SOCK")
+	os.Unsetenv("SSH_AUTH_SOCK")
+	if ok {
+		defer os.Setenv("SSH_AUTH_SOCK", socket)
+	}
+
+	method, close := sshAgent("/nonexistent/id_rsa.pub")
+	if method != nil {
+		t.Errorf("want no ssh-agent without SSH_AUTH_SOCK")
+	}
+	if err := close(); err != nil {
+		t.Errorf("unexpected error closing: %s", err)
+	}
+}
+
+func Test_loadSigners_AgentOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	previous, ok := os.LookupEnv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", socket)
+	if ok {
+		defer os.Setenv("SSH_AUTH_SOCK", previous)
+	} else {
+		defer os.Unsetenv("SSH_AUTH_SOCK")
+	}
+
+	// Only the public key is on disk, the private key is in the agent.
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := ioutil.WriteFile(keyPath+".pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	signers, close, err := loadSigners(keyPath, nil)
+	if err != nil {
+		t.Fatalf("want the key from the agent, got: %s", err)
+	}
+	defer close()
+	if keys, err := signers(); err != nil || len(keys) != 1 {
+		t.Fatalf("want the signer of the agent, got: %v", err)
+	}
+
+	if _, _, err := loadSigners(filepath.Join(dir, "id_rsa"), nil); err == nil {
+		t.Errorf("want an error for a key which is neither on disk nor in the agent")
+	}
+}
+
+func Test_connectSSH_IdentityFiles(t *testing.T) {
+	key, err := ioutil.ReadFile(filepath.Join("testdata", "keys", "ed25519_openssh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server only accepts the last of the keys, as when the first
+	// IdentityFile of the SSH config is for another host.
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(accepted.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("key rejected")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go servePasswordConn(conn, config, execReply{Stdout: "ok\n"})
+		}
+	}()
+
+	options := sshOptions{
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeouts:        sshTimeouts{Dial: 5 * time.Second},
+		IdentityFiles: []string{
+			filepath.Join(os.TempDir(), "k3sup-does-not-exist", "id_rsa"),
+			filepath.Join("testdata", "keys", "ed25519_openssh"),
+		},
+	}
+	op, err := connectSSH(listener.Addr().String(), "pi", filepath.Join("testdata", "keys", "rsa_openssh"), options)
+	if err != nil {
+		t.Fatalf("want the connection made with the last identity file, got: %s", err)
+	}
+	op.Close()
+
+	options.IdentityFiles = nil
+	if _, err := connectSSH(listener.Addr().String(), "pi", filepath.Join("testdata", "keys", "rsa_openssh"), options); err == nil {
+		t.Errorf("want an error with only the key which is rejected")
+	}
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// certCheckScript prints the expiry of each certificate of the server and
+// the agent in dataDir, the --data-dir of k3s, which validateDataDir keeps
+// free of quotes and whitespace.
+func certCheckScript(dataDir string) string {
+	if len(dataDir) == 0 {
+		dataDir = defaultK3sDataDir
+	}
+	return fmt.Sprintf(`for f in %s/server/tls/*.crt %s/agent/*.crt; do [ -f "$f" ] && echo "$f $(openssl x509 -enddate -noout -in "$f")"; done`, dataDir, dataDir)
+}
+
+type nodeCertReport struct {
+	IP           string       `json:"ip"`
+	Certificates []certExpiry `json:"certificates"`
+	Error        string       `json:"error,omitempty"`
+}
+
+type certExpiry struct {
+	Path     string    `json:"path"`
+	NotAfter time.Time `json:"notAfter"`
+	Days     int       `json:"daysUntilExpiry"`
+}
+
+func MakeCert() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "cert",
+		Short:        "Manage the certificates of k3s nodes",
+		Long:         `Manage the certificates of k3s nodes.`,
+		Example:      `  k3sup cert check --plan cluster.yaml`,
+		SilenceUsage: true,
+	}
+
+	command.AddCommand(makeCertCheck())
+
+	return command
+}
+
+func makeCertCheck() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "check",
+		Short: "Report the days until the k3s certificates expire on each node",
+		Long:  `Report the days until the k3s certificates expire on each node of a plan, or on a single node given by --ip.`,
+		Example: `  k3sup cert check --plan cluster.yaml
+  k3sup cert check --plan servers.yaml --plan agents.yaml
+  k3sup cert check --ip 192.168.0.100 --user root --output json`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().StringArray("plan", []string{}, "Plan file listing the nodes to check, can be repeated to merge several plans")
+	command.Flags().IP("ip", nil, "Public IP of a single node to check")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	addHostKeyFlags(command)
+	addSSHPasswordFlags(command)
+	addSSHTimeoutFlags(command)
+	addSSHProxyFlags(command)
+	command.Flags().String("data-dir", "", "Optional: the --data-dir k3s was installed with, defaults to "+defaultK3sDataDir)
+	command.Flags().Bool("sudo", true, "Use sudo to read the certificates")
+	command.Flags().String("sudo-binary", "sudo", "The binary used for privilege escalation with --sudo, i.e. doas")
+	addSudoPasswordFlags(command)
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		planPaths, _ := command.Flags().GetStringArray("plan")
+		ip, _ := command.Flags().GetIP("ip")
+		useSudo, _ := command.Flags().GetBool("sudo")
+		sudoBinary, _ := command.Flags().GetString("sudo-binary")
+		output, err := outputFormatFromFlags(command)
+		if err != nil {
+			return err
+		}
+		dataDir, _ := command.Flags().GetString("data-dir")
+		if err := validateDataDir("--data-dir", dataDir); err != nil {
+			return err
+		}
+
+		var nodes []planNode
+		if len(planPaths) > 0 {
+			p, err := loadPlans(planPaths)
+			if err != nil {
+				return err
+			}
+			nodes = p.Nodes
+		} else if ip != nil {
+			user, _ := command.Flags().GetString("user")
+			sshKey, _ := command.Flags().GetString("ssh-key")
+			port, _ := command.Flags().GetInt("ssh-port")
+			nodes = []planNode{{IP: ip.String(), User: user, SSHKey: sshKey, SSHPort: port}}
+		} else {
+			return fmt.Errorf("give a value for --plan or --ip")
+		}
+
+		sudoPrefix, err := makeSudoPrefix(useSudo, sudoBinary)
+		if err != nil {
+			return err
+		}
+		checkCommand := fmt.Sprintf("%ssh -c '%s'", sudoPrefix, certCheckScript(dataDir))
+
+		sshOpts, err := sshOptionsFromFlags(command, "")
+		if err != nil {
+			return err
+		}
+		defer sshOpts.zero()
+
+		reports := []nodeCertReport{}
+		failed := false
+		for _, node := range nodes {
+			report := checkNodeCerts(node, checkCommand, sshOpts, time.Now())
+			if len(report.Error) > 0 {
+				failed = true
+			}
+			reports = append(reports, report)
+		}
+
+		if output == "json" {
+			out, err := json.MarshalIndent(reports, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		} else {
+			printCertReports(reports)
+		}
+
+		if failed {
+			return fmt.Errorf("unable to check the certificates of one or more nodes")
+		}
+		return nil
+	}
+
+	return command
+}
+
+func checkNodeCerts(node planNode, checkCommand string, options sshOptions, now time.Time) nodeCertReport {
+	report := nodeCertReport{IP: node.IP, Certificates: []certExpiry{}}
+
+	address := net.JoinHostPort(node.IP, strconv.Itoa(node.SSHPort))
+	operator, err := connectSSH(address, node.User, expandPath(node.SSHKey), options)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer operator.Close()
+
+	res, err := operator.Execute(checkCommand)
+	if err != nil {
+		report.Error = fmt.Sprintf("error received processing command: %s", err)
+		return report
+	}
+
+	certs, err := parseCertExpiry(string(res.StdOut), now)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	if len(certs) == 0 {
+		report.Error = "no k3s certificates found"
+	}
+	report.Certificates = certs
+
+	return report
+}
+
+// parseCertExpiry parses lines of "<path> notAfter=<date>" as printed by
+// openssl x509 -enddate.
+func parseCertExpiry(output string, now time.Time) ([]certExpiry, error) {
+	certs := []certExpiry{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(line, " notAfter=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected output from openssl: %q", line)
+		}
+
+		notAfter, err := time.Parse("Jan _2 15:04:05 2006 MST", parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse expiry date of %s: %s", parts[0], err)
+		}
+
+		certs = append(certs, certExpiry{
+			Path:     parts[0],
+			NotAfter: notAfter,
+			Days:     int(math.Floor(notAfter.Sub(now).Hours() / 24)),
+		})
+	}
+	return certs, nil
+}
+
+func printCertReports(reports []nodeCertReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tCERTIFICATE\tEXPIRES\tDAYS")
+	for _, report := range reports {
+		if len(report.Error) > 0 {
+			fmt.Fprintf(w, "%s\t-\t-\t%s\n", report.IP, report.Error)
+			continue
+		}
+		for _, cert := range report.Certificates {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", report.IP, cert.Path, cert.NotAfter.Format("2006-01-02"), cert.Days)
+		}
+	}
+	w.Flush()
+}
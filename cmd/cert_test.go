@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseCertExpiry(t *testing.T) {
+	now := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+	output := `/var/lib/rancher/k3s/server/tls/client-admin.crt notAfter=Jun  1 12:00:00 2021 GMT
+/var/lib/rancher/k3s/server/tls/server-ca.crt notAfter=May 30 12:00:00 2030 GMT
+`
+
+	certs, err := parseCertExpiry(output, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(certs) != 2 {
+		t.Fatalf("want 2 certificates, got: %d", len(certs))
+	}
+
+	if certs[0].Path != "/var/lib/rancher/k3s/server/tls/client-admin.crt" {
+		t.Errorf("unexpected path: %q", certs[0].Path)
+	}
+
+	if certs[0].Days != 365 {
+		t.Errorf("want: %d days, got: %d", 365, certs[0].Days)
+	}
+}
+
+func Test_parseCertExpiry_Invalid(t *testing.T) {
+	_, err := parseCertExpiry("unable to load certificate\n", time.Now())
+	if err == nil {
+		t.Fatalf("want error for unexpected output")
+	}
+}
+
+func Test_certCheckScript(t *testing.T) {
+	if got := certCheckScript(""); !strings.Contains(got, "for f in /var/lib/rancher/k3s/server/tls/*.crt /var/lib/rancher/k3s/agent/*.crt;") {
+		t.Errorf("want the certificates of the default data-dir, got: %q", got)
+	}
+	if got := certCheckScript("/srv/k3s"); !strings.Contains(got, "for f in /srv/k3s/server/tls/*.crt /srv/k3s/agent/*.crt;") {
+		t.Errorf("want the certificates of --data-dir, got: %q", got)
+	}
+}
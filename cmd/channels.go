@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	"github.com/spf13/cobra"
+)
+
+const defaultChannelURL = "https://update.k3s.io/v1-release/channels"
+
+// defaultK3sChannel is the default of --k3s-channel for install and join.
+const defaultK3sChannel = install.DefaultChannel
+
+// resolveChannel asks the channel server which version a channel points
+// to, in the same way as the k3s installer by reading the redirect.
+func resolveChannel(channelURL, channel string) (string, error) {
+	if len(channelURL) == 0 {
+		channelURL = defaultChannelURL
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	channelPath := strings.TrimSuffix(channelURL, "/") + "/" + channel
+	res, err := client.Get(channelPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve channel %s: %s", channel, err)
+	}
+	defer res.Body.Close()
+
+	location := res.Header.Get("Location")
+	if res.StatusCode < 300 || res.StatusCode >= 400 || len(location) == 0 {
+		return "", fmt.Errorf("unable to resolve channel %s from %s: unexpected status %d", channel, channelPath, res.StatusCode)
+	}
+
+	return path.Base(location), nil
+}
+
+// releaseChannel is a channel of the channel server and the version it
+// points to, for a minor channel such as v1.18 its latest patch release.
+type releaseChannel struct {
+	Name   string `json:"name"`
+	Latest string `json:"latest"`
+}
+
+// fetchChannels lists the channels of the channel server, which answers
+// the channel URL itself with a JSON collection.
+func fetchChannels(channelURL string) ([]releaseChannel, error) {
+	if len(channelURL) == 0 {
+		channelURL = defaultChannelURL
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequest(http.MethodGet, channelURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list channels: %s", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list channels: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to list channels from %s: unexpected status %d", channelURL, res.StatusCode)
+	}
+
+	collection := struct {
+		Data []releaseChannel `json:"data"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&collection); err != nil {
+		return nil, fmt.Errorf("unable to list channels from %s: %s", channelURL, err)
+	}
+	if len(collection.Data) == 0 {
+		return nil, fmt.Errorf("unable to list channels from %s: no channels found", channelURL)
+	}
+	return collection.Data, nil
+}
+
+func addPinChannelFlag(command *cobra.Command) {
+	command.Flags().Bool("pin-channel", false, "Resolve --k3s-channel to its version once, before installing, and install exactly that version on every node, even when the channel moves on during the run, see k3sup list-channels")
+}
+
+// versionFromFlags returns k3sVersion, or with --pin-channel the version
+// which k3sChannel points to now, see pinChannel.
+func versionFromFlags(command *cobra.Command, k3sCommit, k3sVersion, k3sChannel, channelURL string) (string, error) {
+	if pin, _ := command.Flags().GetBool("pin-channel"); !pin {
+		return k3sVersion, nil
+	}
+	if len(k3sCommit) > 0 || len(k3sVersion) > 0 {
+		return "", fmt.Errorf("--pin-channel cannot be used with --k3s-version or --k3s-commit")
+	}
+	return pinChannel(channelURL, k3sChannel)
+}
+
+// pinChannel resolves channel to the version it points to now and prints
+// it. It is called once per run, so that every node of a --hosts-file, or
+// the node of upgrade, ends up on exactly that version.
+func pinChannel(channelURL, channel string) (string, error) {
+	version, err := resolveChannel(channelURL, channel)
+	if err != nil {
+		return "", err
+	}
+	infof("Pinned channel %s to k3s %s\n", channel, version)
+	return version, nil
+}
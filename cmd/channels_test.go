@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func Test_resolveChannel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1-release/channels/stable" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "https://github.com/k3s-io/k3s/releases/tag/v1.19.5+k3s2", http.StatusFound)
+	}))
+	defer server.Close()
+
+	got, err := resolveChannel(server.URL+"/v1-release/channels", "stable")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if want := "v1.19.5+k3s2"; got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	if _, err := resolveChannel(server.URL+"/v1-release/channels", "unknown"); err == nil {
+		t.Errorf("want error for an unknown channel")
+	}
+}
+
+const channelsJSON = `{"type":"collection","resourceType":"channels","data":[
+{"id":"stable","type":"channel","name":"stable","latest":"v1.19.5+k3s2"},
+{"id":"latest","type":"channel","name":"latest","latest":"v1.20.0+k3s2"},
+{"id":"v1.18","type":"channel","name":"v1.18","latest":"v1.18.13+k3s1","latestRegexp":"v1\\.18\\..*"}]}`
+
+func Test_fetchChannels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(channelsJSON))
+	}))
+	defer server.Close()
+
+	got, err := fetchChannels(server.URL + "/v1-release/channels")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []releaseChannel{{"stable", "v1.19.5+k3s2"}, {"latest", "v1.20.0+k3s2"}, {"v1.18", "v1.18.13+k3s1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+}
+
+func Test_fetchChannels_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/empty":
+			w.Write([]byte(`{"data":[]}`))
+		case "/html":
+			w.Write([]byte(`<html></html>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/empty", "/html", "/missing"} {
+		if _, err := fetchChannels(server.URL + path); err == nil {
+			t.Errorf("%s: want an error", path)
+		}
+	}
+}
+
+func Test_versionFromFlags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://github.com/k3s-io/k3s/releases/tag/v1.19.5+k3s2", http.StatusFound)
+	}))
+	defer server.Close()
+
+	command := &cobra.Command{}
+	addPinChannelFlag(command)
+
+	got, err := versionFromFlags(command, "", "", "stable", server.URL)
+	if err != nil || got != "" {
+		t.Errorf("want the channel left to the installer without --pin-channel, got: %q, %v", got, err)
+	}
+
+	command.Flags().Set("pin-channel", "true")
+	captureStdout(t, logQuiet, func() {
+		got, err = versionFromFlags(command, "", "", "stable", server.URL)
+	})
+	if err != nil || got != "v1.19.5+k3s2" {
+		t.Errorf("want the version of the channel, got: %q, %v", got, err)
+	}
+
+	if _, err := versionFromFlags(command, "", "v1.19.1+k3s1", "stable", server.URL); err == nil {
+		t.Errorf("want an error for --pin-channel with --k3s-version")
+	}
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+// checkProbeScript prints KEY=VALUE lines for each probe after those of
+// detectOSScript. The listening TCP ports are read from /proc/net, so that
+// neither ss nor netstat is needed, and the free space is that of the
+// nearest existing parent of the data-dir, which k3s creates on install.
+const checkProbeScript = `echo KERNEL=$(uname -r)
+echo SWAP=$(awk 'NR > 1' /proc/swaps 2>/dev/null | wc -l)
+[ -d /sys/module/br_netfilter ] && echo BR_NETFILTER=1 || echo BR_NETFILTER=0
+echo LISTEN=$(awk 'FNR > 1 && $4 == "0A" { split($2, a, ":"); print a[2] }' /proc/net/tcp /proc/net/tcp6 2>/dev/null | sort -u)
+d=%s
+while [ ! -d "$d" ]; do d=$(dirname "$d"); done
+echo DISK_FREE_KB=$(df -Pk "$d" | awk 'NR == 2 { print $4 }')
+`
+
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// minKernelMajor and minKernelMinor are the oldest kernel k3s supports.
+const (
+	minKernelMajor = 3
+	minKernelMinor = 10
+)
+
+// Free space under the data-dir below which the check fails or warns, in
+// KiB. Images and the datastore grow from the first start.
+const (
+	minDiskFreeKB  = 1 << 20
+	warnDiskFreeKB = 5 << 20
+)
+
+// checkResult is a line of the report of k3sup check.
+type checkResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// nodeProbes is the output of the probes run on a node.
+type nodeProbes struct {
+	OS           osInfo
+	Kernel       string
+	SwapDevices  int
+	BrNetfilter  bool
+	ListenPorts  map[int]bool
+	DiskFreeKB   int64
+	DiskFreeRead bool
+}
+
+func MakeCheck() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "check",
+		Short: "Check whether a node is ready for k3s before installing",
+		Long: `Check whether a node is ready for k3s via SSH, before installing it: the
+kernel version, cgroups, swap, br_netfilter, the ports k3s listens on and the
+free space under the data-dir. Exits non-zero if any check fails.`,
+		Example: `  k3sup check --ip 192.168.0.100 --user root
+  k3sup check --ip 192.168.0.101 --user pi --agent --output json`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("ip", net.ParseIP("127.0.0.1"), "Public IP of node")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	addHostKeyFlags(command)
+	addSSHPasswordFlags(command)
+	addSSHTimeoutFlags(command)
+	addSSHProxyFlags(command)
+
+	command.Flags().Bool("agent", false, "Check the node for a k3s agent, which does not need the port of the API server")
+	command.Flags().String("data-dir", "", "Optional: the --data-dir k3s will be installed with, defaults to "+defaultK3sDataDir)
+	command.Flags().Int("api-port", k3sAPIPort, "Optional: the --api-port k3s will be installed with, which must be free")
+
+	var results []checkResult
+
+	runCheck := func(command *cobra.Command, args []string) error {
+
+		ip, _ := command.Flags().GetIP("ip")
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		agent, _ := command.Flags().GetBool("agent")
+
+		dataDir, _ := command.Flags().GetString("data-dir")
+		if err := validateDataDir("--data-dir", dataDir); err != nil {
+			return err
+		}
+		if len(dataDir) == 0 {
+			dataDir = defaultK3sDataDir
+		}
+		apiPort, _ := command.Flags().GetInt("api-port")
+		if apiPort < 1 || apiPort > 65535 {
+			return fmt.Errorf("--api-port must be between 1 and 65535")
+		}
+
+		sshOpts, err := sshOptionsFromFlags(command, "")
+		if err != nil {
+			return err
+		}
+		defer sshOpts.zero()
+
+		address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		op, err := connectSSH(address, user, expandPath(sshKey), sshOpts)
+		if err != nil {
+			return err
+		}
+		defer op.Close()
+
+		results, err = checkNode(op, dataDir, apiPort, agent)
+		if err != nil {
+			return err
+		}
+		if output, _ := outputFormatFromFlags(command); output != "json" {
+			printCheckResults(os.Stdout, results)
+		}
+
+		failed := 0
+		for _, result := range results {
+			if result.Status == checkFail {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d check(s) failed on %s", failed, ip)
+		}
+		return nil
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		output, err := outputFormatFromFlags(command)
+		if err != nil {
+			return err
+		}
+		if output != "json" {
+			return runCheck(command, args)
+		}
+
+		// Only the results go to stdout, so that they can be piped to jq.
+		return runWithJSONOutput(func() error {
+			return runCheck(command, args)
+		}, func(err error, duration time.Duration) interface{} {
+			if results == nil {
+				return []checkResult{}
+			}
+			return results
+		})
+	}
+
+	return command
+}
+
+// checkNode runs the probes on the node in one command and evaluates them.
+func checkNode(op operator.CommandOperator, dataDir string, apiPort int, agent bool) ([]checkResult, error) {
+	res, err := op.Execute(detectOSScript + fmt.Sprintf(checkProbeScript, operator.ShellQuote(dataDir)))
+	if err != nil {
+		return nil, fmt.Errorf("error received checking the node: %s", err)
+	}
+	return evaluateNodeProbes(parseNodeProbes(string(res.StdOut)), dataDir, apiPort, agent), nil
+}
+
+func parseNodeProbes(output string) nodeProbes {
+	probes := nodeProbes{
+		OS:          parseOSInfo(output),
+		ListenPorts: map[int]bool{},
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(parts[1])
+		switch parts[0] {
+		case "KERNEL":
+			probes.Kernel = value
+		case "SWAP":
+			probes.SwapDevices, _ = strconv.Atoi(value)
+		case "BR_NETFILTER":
+			probes.BrNetfilter = value == "1"
+		case "LISTEN":
+			for _, field := range strings.Fields(value) {
+				if port, err := strconv.ParseInt(field, 16, 32); err == nil {
+					probes.ListenPorts[int(port)] = true
+				}
+			}
+		case "DISK_FREE_KB":
+			if free, err := strconv.ParseInt(value, 10, 64); err == nil {
+				probes.DiskFreeKB = free
+				probes.DiskFreeRead = true
+			}
+		}
+	}
+	return probes
+}
+
+// evaluateNodeProbes turns the probes into a result for each check. Only
+// what stops k3s from starting fails, the rest warns. apiPort is not
+// checked for an agent.
+func evaluateNodeProbes(probes nodeProbes, dataDir string, apiPort int, agent bool) []checkResult {
+	results := []checkResult{checkKernel(probes.Kernel)}
+
+	if probes.OS.MemoryCgroup {
+		results = append(results, checkResult{"cgroups", checkPass, fmt.Sprintf("cgroup v%s with the memory controller", probes.OS.CgroupVersion)})
+	} else {
+		results = append(results, checkResult{"cgroups", checkFail, osWarnings(probes.OS)[0]})
+	}
+	if probes.OS.CgroupVersion == "2" {
+		results = append(results, checkResult{"cgroup v2", checkWarn, "cgroup v2 is in use, this requires k3s v1.20.4 or newer"})
+	}
+
+	if probes.SwapDevices > 0 {
+		results = append(results, checkResult{"swap", checkWarn, fmt.Sprintf("%d swap device(s) in use, the kubelet may evict pods late under memory pressure, turn it off with \"swapoff -a\" and remove it from /etc/fstab", probes.SwapDevices)})
+	} else {
+		results = append(results, checkResult{"swap", checkPass, "off"})
+	}
+
+	if probes.BrNetfilter {
+		results = append(results, checkResult{"br_netfilter", checkPass, "loaded"})
+	} else {
+		results = append(results, checkResult{"br_netfilter", checkWarn, "not loaded, k3s loads it on start, if that fails run \"modprobe br_netfilter\""})
+	}
+
+	ports := []struct {
+		port int
+		use  string
+	}{
+		{apiPort, "the API server"},
+		{10250, "the kubelet"},
+	}
+	for _, p := range ports {
+		if agent && p.port == apiPort {
+			continue
+		}
+		name := fmt.Sprintf("port %d", p.port)
+		if probes.ListenPorts[p.port] {
+			results = append(results, checkResult{name, checkFail, fmt.Sprintf("in use, it is needed for %s, is k3s or another Kubernetes already running?", p.use)})
+		} else {
+			results = append(results, checkResult{name, checkPass, "free"})
+		}
+	}
+
+	results = append(results, checkDiskFree(probes, dataDir))
+	return results
+}
+
+func checkKernel(kernel string) checkResult {
+	parts := strings.SplitN(kernel, ".", 3)
+	if len(parts) < 2 {
+		return checkResult{"kernel", checkWarn, fmt.Sprintf("unable to parse the kernel version %q", kernel)}
+	}
+
+	major, errMajor := strconv.Atoi(parts[0])
+	minor, errMinor := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if errMajor != nil || errMinor != nil {
+		return checkResult{"kernel", checkWarn, fmt.Sprintf("unable to parse the kernel version %q", kernel)}
+	}
+
+	if major < minKernelMajor || (major == minKernelMajor && minor < minKernelMinor) {
+		return checkResult{"kernel", checkFail, fmt.Sprintf("%s is older than %d.%d, which k3s needs", kernel, minKernelMajor, minKernelMinor)}
+	}
+	return checkResult{"kernel", checkPass, kernel}
+}
+
+func checkDiskFree(probes nodeProbes, dataDir string) checkResult {
+	if !probes.DiskFreeRead {
+		return checkResult{"disk", checkWarn, fmt.Sprintf("unable to read the free space under %s", dataDir)}
+	}
+
+	detail := fmt.Sprintf("%.1f GiB free under %s", float64(probes.DiskFreeKB)/(1<<20), dataDir)
+	switch {
+	case probes.DiskFreeKB < minDiskFreeKB:
+		return checkResult{"disk", checkFail, detail + ", at least 1 GiB is needed"}
+	case probes.DiskFreeKB < warnDiskFreeKB:
+		return checkResult{"disk", checkWarn, detail + ", images may soon fill it"}
+	}
+	return checkResult{"disk", checkPass, detail}
+}
+
+func printCheckResults(w io.Writer, results []checkResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tDETAIL")
+	for _, result := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Name, strings.ToUpper(result.Status), result.Detail)
+	}
+	tw.Flush()
+}
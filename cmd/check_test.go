@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const checkProbeOutput = `NAME="Ubuntu"
+ID=ubuntu
+VERSION_ID="20.04"
+PRETTY_NAME="Ubuntu 20.04.1 LTS"
+CGROUP_VERSION=1
+MEMORY_CGROUP=1
+MODEL=
+KERNEL=5.4.0-42-generic
+SWAP=0
+BR_NETFILTER=1
+LISTEN=0016 0035
+DISK_FREE_KB=20971520
+`
+
+func Test_parseNodeProbes(t *testing.T) {
+	probes := parseNodeProbes(checkProbeOutput)
+
+	if probes.Kernel != "5.4.0-42-generic" {
+		t.Errorf("want kernel 5.4.0-42-generic, got: %q", probes.Kernel)
+	}
+	if !probes.OS.MemoryCgroup || probes.OS.CgroupVersion != "1" {
+		t.Errorf("want cgroup v1 with memory, got: %+v", probes.OS)
+	}
+	if probes.SwapDevices != 0 || !probes.BrNetfilter {
+		t.Errorf("want no swap and br_netfilter, got: %+v", probes)
+	}
+	if !probes.ListenPorts[22] || !probes.ListenPorts[53] || len(probes.ListenPorts) != 2 {
+		t.Errorf("want ports 22 and 53, got: %v", probes.ListenPorts)
+	}
+	if !probes.DiskFreeRead || probes.DiskFreeKB != 20971520 {
+		t.Errorf("want 20971520 KiB free, got: %d", probes.DiskFreeKB)
+	}
+}
+
+func Test_evaluateNodeProbes_Ready(t *testing.T) {
+	results := evaluateNodeProbes(parseNodeProbes(checkProbeOutput), defaultK3sDataDir, k3sAPIPort, false)
+
+	names := []string{}
+	for _, result := range results {
+		names = append(names, result.Name)
+		if result.Status != checkPass {
+			t.Errorf("want %s to pass, got: %s (%s)", result.Name, result.Status, result.Detail)
+		}
+	}
+	want := "kernel,cgroups,swap,br_netfilter,port 6443,port 10250,disk"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("want checks: %s, got: %s", want, got)
+	}
+}
+
+func Test_evaluateNodeProbes_Problems(t *testing.T) {
+	output := strings.NewReplacer(
+		"KERNEL=5.4.0-42-generic", "KERNEL=3.2.0-4-amd64",
+		"CGROUP_VERSION=1", "CGROUP_VERSION=2",
+		"MEMORY_CGROUP=1", "MEMORY_CGROUP=0",
+		"SWAP=0", "SWAP=1",
+		"BR_NETFILTER=1", "BR_NETFILTER=0",
+		"LISTEN=0016 0035", fmt.Sprintf("LISTEN=0016 %04X", 6443),
+		"DISK_FREE_KB=20971520", "DISK_FREE_KB=524288",
+	).Replace(checkProbeOutput)
+
+	results := evaluateNodeProbes(parseNodeProbes(output), "/srv/k3s", k3sAPIPort, false)
+
+	want := map[string]string{
+		"kernel":       checkFail,
+		"cgroups":      checkFail,
+		"cgroup v2":    checkWarn,
+		"swap":         checkWarn,
+		"br_netfilter": checkWarn,
+		"port 6443":    checkFail,
+		"port 10250":   checkPass,
+		"disk":         checkFail,
+	}
+	if len(results) != len(want) {
+		t.Fatalf("want %d results, got: %+v", len(want), results)
+	}
+	for _, result := range results {
+		if result.Status != want[result.Name] {
+			t.Errorf("want %s to %s, got: %s (%s)", result.Name, want[result.Name], result.Status, result.Detail)
+		}
+	}
+}
+
+func Test_evaluateNodeProbes_APIPort(t *testing.T) {
+	output := strings.Replace(checkProbeOutput, "LISTEN=0016 0035", fmt.Sprintf("LISTEN=%04X", 6443), 1)
+
+	status := map[string]string{}
+	for _, result := range evaluateNodeProbes(parseNodeProbes(output), defaultK3sDataDir, 7443, false) {
+		status[result.Name] = result.Status
+	}
+	if status["port 7443"] != checkPass || len(status["port 6443"]) > 0 {
+		t.Errorf("want the port of --api-port checked rather than 6443, got: %v", status)
+	}
+}
+
+func Test_evaluateNodeProbes_AgentSkipsAPIServerPort(t *testing.T) {
+	output := strings.Replace(checkProbeOutput, "LISTEN=0016 0035", fmt.Sprintf("LISTEN=%04X", 6443), 1)
+
+	for _, result := range evaluateNodeProbes(parseNodeProbes(output), defaultK3sDataDir, k3sAPIPort, true) {
+		if result.Name == "port 6443" {
+			t.Errorf("want no check of port 6443 for an agent, got: %+v", result)
+		}
+	}
+}
+
+func Test_checkKernel(t *testing.T) {
+	cases := []struct {
+		kernel string
+		want   string
+	}{
+		{"5.4.0-42-generic", checkPass},
+		{"3.10.0-1160.el7.x86_64", checkPass},
+		{"4.19.118-v7l+", checkPass},
+		{"3.9.11", checkFail},
+		{"2.6.32-754.el6.x86_64", checkFail},
+		{"", checkWarn},
+	}
+
+	for _, c := range cases {
+		if got := checkKernel(c.kernel); got.Status != c.want {
+			t.Errorf("kernel %q, want: %s, got: %s (%s)", c.kernel, c.want, got.Status, got.Detail)
+		}
+	}
+}
+
+func Test_checkNode_QuotesDataDir(t *testing.T) {
+	script := detectOSScript + fmt.Sprintf(checkProbeScript, "'/srv/k3s data'")
+	op := &scriptedOperator{replies: map[string]string{script: checkProbeOutput}}
+
+	results, err := checkNode(op, "/srv/k3s data", k3sAPIPort, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(op.commands) != 1 || op.commands[0] != script {
+		t.Errorf("want the probes in one command, got: %q", op.commands)
+	}
+	if len(results) == 0 || results[len(results)-1].Detail != "20.0 GiB free under /srv/k3s data" {
+		t.Errorf("want the free space of the data-dir, got: %+v", results)
+	}
+}
+
+func Test_printCheckResults(t *testing.T) {
+	out := &bytes.Buffer{}
+	printCheckResults(out, []checkResult{{"swap", checkWarn, "1 swap device(s) in use"}})
+
+	want := "CHECK  STATUS  DETAIL\nswap   WARN    1 swap device(s) in use\n"
+	if out.String() != want {
+		t.Errorf("want: %q, got: %q", want, out.String())
+	}
+}
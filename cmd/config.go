@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// clusterConfig is a --config file, its keys are named after the flags they
+// set a default for.
+type clusterConfig struct {
+	User         string       `yaml:"user"`
+	SSHKey       string       `yaml:"ssh-key"`
+	SSHPort      int          `yaml:"ssh-port"`
+	Sudo         *bool        `yaml:"sudo"`
+	K3sVersion   string       `yaml:"k3s-version"`
+	K3sChannel   string       `yaml:"k3s-channel"`
+	K3sExtraArgs string       `yaml:"k3s-extra-args"`
+	Nodes        []configNode `yaml:"nodes"`
+}
+
+// configNode is a node of a --config file, its settings override those at
+// the top of the file.
+type configNode struct {
+	IP      string `yaml:"ip"`
+	User    string `yaml:"user"`
+	SSHKey  string `yaml:"ssh-key"`
+	SSHPort int    `yaml:"ssh-port"`
+}
+
+func addConfigFlag(command *cobra.Command) {
+	command.Flags().String("config", "", "Optional: YAML file with defaults for the flags, such as user, ssh-key, ssh-port, sudo, k3s-channel and k3s-extra-args, and the nodes to pick --ip from")
+}
+
+// loadClusterConfig reads and validates a --config file, unknown keys are
+// an error so that a typo is not silently ignored.
+func loadClusterConfig(path string) (clusterConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return clusterConfig{}, fmt.Errorf("unable to read config %s: %s", path, err)
+	}
+
+	config := clusterConfig{}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return clusterConfig{}, fmt.Errorf("unable to parse config %s: %s", path, err)
+	}
+
+	if config.SSHPort < 0 || config.SSHPort > 65535 {
+		return clusterConfig{}, fmt.Errorf("config %s has an invalid ssh-port: %d", path, config.SSHPort)
+	}
+	seen := map[string]bool{}
+	for i, node := range config.Nodes {
+		if net.ParseIP(node.IP) == nil {
+			return clusterConfig{}, fmt.Errorf("node %d in config %s has an invalid ip: %q", i, path, node.IP)
+		}
+		if seen[node.IP] {
+			return clusterConfig{}, fmt.Errorf("duplicate ip %s in config %s", node.IP, path)
+		}
+		seen[node.IP] = true
+		if node.SSHPort < 0 || node.SSHPort > 65535 {
+			return clusterConfig{}, fmt.Errorf("node %s in config %s has an invalid ssh-port: %d", node.IP, path, node.SSHPort)
+		}
+	}
+	return config, nil
+}
+
+// flagValues returns the value of each flag set by the config for the node
+// at ip, which may be empty when no --ip was given.
+func (c clusterConfig) flagValues(ip string) (map[string]string, error) {
+	values := map[string]string{
+		"user":           c.User,
+		"ssh-key":        c.SSHKey,
+		"k3s-version":    c.K3sVersion,
+		"k3s-channel":    c.K3sChannel,
+		"k3s-extra-args": c.K3sExtraArgs,
+	}
+	if c.SSHPort > 0 {
+		values["ssh-port"] = strconv.Itoa(c.SSHPort)
+	}
+	if c.Sudo != nil {
+		values["sudo"] = strconv.FormatBool(*c.Sudo)
+	}
+
+	var node *configNode
+	for i := range c.Nodes {
+		if c.Nodes[i].IP == ip {
+			node = &c.Nodes[i]
+		}
+	}
+	if len(ip) == 0 {
+		switch len(c.Nodes) {
+		case 0:
+		case 1:
+			node = &c.Nodes[0]
+			values["ip"] = node.IP
+		default:
+			return nil, fmt.Errorf("the config lists %d nodes, choose one with --ip", len(c.Nodes))
+		}
+	}
+
+	if node != nil {
+		if len(node.User) > 0 {
+			values["user"] = node.User
+		}
+		if len(node.SSHKey) > 0 {
+			values["ssh-key"] = node.SSHKey
+		}
+		if node.SSHPort > 0 {
+			values["ssh-port"] = strconv.Itoa(node.SSHPort)
+		}
+	}
+	return values, nil
+}
+
+// applyConfig sets the flags of command which were not given on the command
+// line, or by bindEnv from the environment, from the --config file. The
+// values replace the defaults of the flags, as those of bindEnv do, so a
+// flag on the command line never conflicts with a value of the file.
+func applyConfig(command *cobra.Command) error {
+	configPath, _ := command.Flags().GetString("config")
+	if len(configPath) == 0 {
+		return nil
+	}
+
+	config, err := loadClusterConfig(expandPath(configPath))
+	if err != nil {
+		return err
+	}
+
+	ip := ""
+	if flagGiven(command, "ip") {
+		ip, _ = command.Flags().GetString("ip")
+	}
+	values, err := config.flagValues(ip)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range values {
+		if len(value) == 0 || flagGiven(command, name) {
+			continue
+		}
+		flag := command.Flags().Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("%s in %s is not a flag of %s", name, configPath, command.Name())
+		}
+		if err := setFlagDefault(flag, value); err != nil {
+			return fmt.Errorf("invalid value %q for --%s in %s: %s", value, name, configPath, err)
+		}
+	}
+	return nil
+}
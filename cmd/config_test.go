@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func writeClusterConfig(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "k3sup-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(content)
+	file.Close()
+	return file.Name()
+}
+
+func Test_loadClusterConfig_Errors(t *testing.T) {
+	cases := []struct {
+		content string
+		want    string
+	}{
+		{"user: ubuntu\nchannel: stable\n", "field channel not found"},
+		{"ssh-port: 70000\n", "invalid ssh-port"},
+		{"nodes:\n  - ip: server-1\n", "invalid ip"},
+		{"nodes:\n  - ip: 192.168.0.100\n  - ip: 192.168.0.100\n", "duplicate ip 192.168.0.100"},
+	}
+
+	for _, c := range cases {
+		path := writeClusterConfig(t, c.content)
+		defer os.Remove(path)
+
+		if _, err := loadClusterConfig(path); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: want error containing %q, got: %v", c.content, c.want, err)
+		}
+	}
+}
+
+func Test_clusterConfig_flagValues(t *testing.T) {
+	sudo := false
+	config := clusterConfig{
+		User:       "ubuntu",
+		SSHKey:     "~/.ssh/cluster",
+		Sudo:       &sudo,
+		K3sChannel: "stable",
+		Nodes: []configNode{
+			{IP: "192.168.0.100"},
+			{IP: "192.168.0.101", User: "pi", SSHPort: 2222},
+		},
+	}
+
+	values, err := config.flagValues("192.168.0.101")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := map[string]string{"user": "pi", "ssh-key": "~/.ssh/cluster", "ssh-port": "2222", "sudo": "false", "k3s-channel": "stable"}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("want %s: %q, got: %q", name, value, values[name])
+		}
+	}
+	if len(values["ip"]) > 0 {
+		t.Errorf("want no ip when it was given, got: %q", values["ip"])
+	}
+
+	if _, err := config.flagValues(""); err == nil || !strings.Contains(err.Error(), "choose one with --ip") {
+		t.Errorf("want error without --ip for more than one node, got: %v", err)
+	}
+
+	config.Nodes = config.Nodes[1:]
+	values, err = config.flagValues("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if values["ip"] != "192.168.0.101" || values["user"] != "pi" {
+		t.Errorf("want the only node picked, got: %v", values)
+	}
+}
+
+func Test_applyConfig_Precedence(t *testing.T) {
+	path := writeClusterConfig(t, `user: ubuntu
+ssh-port: 2222
+k3s-channel: stable
+k3s-extra-args: --disable traefik
+nodes:
+  - ip: 192.168.0.100
+`)
+	defer os.Remove(path)
+
+	os.Setenv("K3SUP_SSH_PORT", "2200")
+	defer os.Unsetenv("K3SUP_SSH_PORT")
+
+	command := MakeInstall()
+	if err := command.ParseFlags([]string{"--config", path, "--user", "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bindEnv(command); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := applyConfig(command); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	get := func(name string) string {
+		return command.Flags().Lookup(name).Value.String()
+	}
+	want := map[string]string{
+		"ip":             "192.168.0.100",
+		"user":           "admin",
+		"ssh-port":       "2200",
+		"k3s-channel":    "stable",
+		"k3s-extra-args": "--disable traefik",
+		"ssh-key":        "~/.ssh/id_rsa",
+		"sudo":           "true",
+	}
+	for name, value := range want {
+		if got := get(name); got != value {
+			t.Errorf("want %s: %q, got: %q", name, value, got)
+		}
+	}
+}
+
+func Test_applyConfig_ChannelWithCommit(t *testing.T) {
+	path := writeClusterConfig(t, "k3s-channel: stable\n")
+	defer os.Remove(path)
+
+	command := MakeInstall()
+	commit := "9ac3d4fd9b8c05d9b3d5b9fec4d7c0e1b6fcf3a2"
+	if err := command.ParseFlags([]string{"--config", path, "--k3s-commit", commit}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(command); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if command.Flags().Changed("k3s-channel") {
+		t.Errorf("want k3s-channel from the file not to be marked as changed")
+	}
+	got, err := k3sCommitFromFlags(command)
+	if err != nil || got != commit {
+		t.Errorf("want --k3s-commit to override the k3s-channel of the file, got: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+// contextTemplateData are the fields of --context-template.
+type contextTemplateData struct {
+	IP         string
+	Hostname   string
+	K3sVersion string
+}
+
+// contextFactsCommand prints the hostname of the node, then the first line
+// of k3s --version.
+const contextFactsCommand = "hostname; k3s --version 2>/dev/null | head -n 1"
+
+func addContextTemplateFlag(command *cobra.Command) {
+	command.Flags().String("context-template", "", "Optional: template for the name of the kubeconfig context, resolved once k3s is installed, i.e. \"{{.Hostname}}-{{.K3sVersion}}\", with the fields .IP, .Hostname and .K3sVersion. Not used with --context")
+}
+
+// contextTemplateFromFlags parses --context-template, it is nil when none
+// is given.
+func contextTemplateFromFlags(command *cobra.Command) (*template.Template, error) {
+	text, _ := command.Flags().GetString("context-template")
+	if len(text) == 0 {
+		return nil, nil
+	}
+	if flagGiven(command, "context") {
+		return nil, fmt.Errorf("--context-template cannot be used with --context")
+	}
+
+	tmpl, err := template.New("context").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("--context-template is not valid: %s", err)
+	}
+
+	// Unknown fields only fail once executed, so fail before installing.
+	if _, err := executeContextTemplate(tmpl, contextTemplateData{IP: "ip", Hostname: "hostname", K3sVersion: "version"}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func executeContextTemplate(tmpl *template.Template, data contextTemplateData) (string, error) {
+	out := bytes.Buffer{}
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("--context-template is not valid: %s", err)
+	}
+
+	context := strings.TrimSpace(out.String())
+	if len(context) == 0 {
+		return "", fmt.Errorf("--context-template gave an empty context name")
+	}
+	if strings.ContainsAny(context, " \t\n") {
+		return "", fmt.Errorf("--context-template gave %q, a context name cannot contain whitespace", context)
+	}
+	return context, nil
+}
+
+// renderContext names the context with tmpl, from the hostname and k3s
+// version of the node at ip.
+func renderContext(tmpl *template.Template, operator operator.CommandOperator, ip string) (string, error) {
+	res, err := operator.Execute(contextFactsCommand)
+	if err != nil {
+		return "", fmt.Errorf("error received reading the hostname and k3s version for --context-template: %s", err)
+	}
+
+	lines := strings.SplitN(strings.TrimSpace(string(res.StdOut)), "\n", 2)
+	data := contextTemplateData{IP: ip, Hostname: strings.TrimSpace(lines[0])}
+	if len(lines) > 1 {
+		data.K3sVersion, _ = install.ParseK3sVersion(lines[1])
+	}
+	if len(data.Hostname) == 0 || len(data.K3sVersion) == 0 {
+		return "", fmt.Errorf("unable to read the hostname and k3s version for --context-template, got: %q", res.StdOut)
+	}
+
+	context, err := executeContextTemplate(tmpl, data)
+	if err != nil {
+		return "", err
+	}
+	infof("Naming the context %s\n", context)
+	return context, nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+func contextTemplateCommand(args ...string) *cobra.Command {
+	command := &cobra.Command{}
+	command.Flags().String("context", "default", "")
+	addContextTemplateFlag(command)
+	command.ParseFlags(args)
+	return command
+}
+
+func Test_contextTemplateFromFlags(t *testing.T) {
+	tmpl, err := contextTemplateFromFlags(contextTemplateCommand())
+	if err != nil || tmpl != nil {
+		t.Errorf("want no template without the flag, got: %v, %v", tmpl, err)
+	}
+
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--context-template", "{{.Hostname"}, "is not valid"},
+		{[]string{"--context-template", "{{.Role}}"}, "is not valid"},
+		{[]string{"--context-template", "{{.IP}}", "--context", "edge"}, "cannot be used with --context"},
+		{[]string{"--context-template", "{{if false}}{{end}}"}, "empty context name"},
+	}
+	for _, c := range cases {
+		_, err := contextTemplateFromFlags(contextTemplateCommand(c.args...))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: want error containing %q, got: %v", c.args, c.want, err)
+		}
+	}
+}
+
+func Test_renderContext(t *testing.T) {
+	tmpl, err := contextTemplateFromFlags(contextTemplateCommand("--context-template", "{{.Hostname}}-{{.K3sVersion}}-{{.IP}}"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	node := &operator.FakeOperator{Replies: map[string]operator.CommandRes{
+		contextFactsCommand: {StdOut: []byte("edge-1\nk3s version v1.19.1+k3s1 (b66760fc)\n")},
+	}}
+
+	var got string
+	captureStdout(t, logQuiet, func() {
+		got, err = renderContext(tmpl, node, "192.168.0.100")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := "edge-1-v1.19.1+k3s1-192.168.0.100"; got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
+
+func Test_renderContext_NoK3s(t *testing.T) {
+	tmpl, err := contextTemplateFromFlags(contextTemplateCommand("--context-template", "{{.Hostname}}"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	node := &operator.FakeOperator{Replies: map[string]operator.CommandRes{
+		contextFactsCommand: {StdOut: []byte("edge-1\n")},
+	}}
+	if _, err := renderContext(tmpl, node, "192.168.0.100"); err == nil {
+		t.Errorf("want an error when the k3s version cannot be read")
+	}
+}
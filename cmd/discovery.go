@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	k3sAPIPort    = install.DefaultAPIPort
+	mdnsAddress   = "224.0.0.251:5353"
+	probeTimeout  = 2 * time.Second
+	mdnsQueryWait = 3 * time.Second
+)
+
+// discoverServer finds a k3s server from either a file listing candidate
+// server IPs, or an mDNS service name such as _k3s._tcp.local. The first
+// candidate which accepts connections on the API port is returned.
+func discoverServer(source string) (net.IP, error) {
+	var candidates []net.IP
+	var err error
+
+	if _, statErr := os.Stat(source); statErr == nil {
+		infof("Discovering server from candidates in %s\n", source)
+		candidates, err = readCandidateFile(source)
+	} else {
+		infof("Discovering server via mDNS service %s\n", source)
+		candidates, err = mdnsCandidates(source, mdnsQueryWait)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no candidate servers found with %s, give a value for --server-ip", source)
+	}
+
+	ip := probeCandidates(candidates, k3sAPIPort, probeTimeout)
+	if ip == nil {
+		return nil, fmt.Errorf("none of the %d candidate servers responded on port %d, give a value for --server-ip", len(candidates), k3sAPIPort)
+	}
+
+	infof("Discovered server: %s\n", ip)
+	return ip, nil
+}
+
+// readCandidateFile reads one IP per line, ignoring blank lines and
+// comments starting with #.
+func readCandidateFile(path string) ([]net.IP, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	candidates := []net.IP{}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ip := net.ParseIP(line)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip %q on line %d of %s", line, n, path)
+		}
+		candidates = append(candidates, ip)
+	}
+
+	return candidates, scanner.Err()
+}
+
+// probeCandidates returns the first candidate accepting TCP connections
+// on port, or nil if none do.
+func probeCandidates(candidates []net.IP, port int, timeout time.Duration) net.IP {
+	for _, ip := range candidates {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), timeout)
+		if err != nil {
+			infof("Server candidate %s: %s\n", ip, err)
+			continue
+		}
+		conn.Close()
+		return ip
+	}
+	return nil
+}
+
+// mdnsCandidates sends a single multicast PTR query for service and
+// collects the addresses advertised in the answers until wait has elapsed.
+// The query is only sent to the IPv4 group, a server which only answers
+// over IPv6 is listed in a candidate file instead. The IPv6 addresses of
+// the answers are kept, see responseAddresses.
+func mdnsCandidates(service string, wait time.Duration) ([]net.IP, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(service, ".") + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid mDNS service name %q: %s", service, err)
+	}
+
+	query := dnsmessage.Message{
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET},
+		},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := net.ResolveUDPAddr("udp4", mdnsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for mDNS responses: %s", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(packet, group); err != nil {
+		return nil, fmt.Errorf("unable to send mDNS query: %s", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wait))
+
+	seen := map[string]bool{}
+	candidates := []net.IP{}
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// the read deadline ends the collection of responses
+			break
+		}
+
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+
+		for _, ip := range responseAddresses(msg) {
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				candidates = append(candidates, ip)
+			}
+		}
+	}
+
+	return candidates, nil
+}
+
+// responseAddresses returns the IPv4 and IPv6 addresses of the A and AAAA
+// records of an mDNS response. Link-local IPv6 addresses are left out, as
+// they cannot be dialled without the zone of the interface.
+func responseAddresses(msg dnsmessage.Message) []net.IP {
+	addresses := []net.IP{}
+	for _, resource := range append(msg.Answers, msg.Additionals...) {
+		switch body := resource.Body.(type) {
+		case *dnsmessage.AResource:
+			addresses = append(addresses, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			if ip := net.IP(body.AAAA[:]); !ip.IsLinkLocalUnicast() {
+				addresses = append(addresses, ip)
+			}
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func Test_readCandidateFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("# lab servers\n192.168.0.100\n\n  192.168.0.101  \n")
+	file.Close()
+
+	candidates, err := readCandidateFile(file.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(candidates) != 2 || candidates[0].String() != "192.168.0.100" || candidates[1].String() != "192.168.0.101" {
+		t.Errorf("unexpected candidates: %v", candidates)
+	}
+}
+
+func Test_probeCandidates(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	// 192.0.2.0/24 is reserved for documentation and never routed
+	candidates := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("127.0.0.1")}
+
+	got := probeCandidates(candidates, port, 100*time.Millisecond)
+	if got == nil || got.String() != "127.0.0.1" {
+		t.Errorf("want: 127.0.0.1, got: %v", got)
+	}
+}
+
+func Test_responseAddresses(t *testing.T) {
+	name := dnsmessage.MustNewName("k3s-1.local.")
+	header := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET}
+	aaaa := func(ip string) *dnsmessage.AAAAResource {
+		r := &dnsmessage.AAAAResource{}
+		copy(r.AAAA[:], net.ParseIP(ip).To16())
+		return r
+	}
+
+	msg := dnsmessage.Message{
+		Answers: []dnsmessage.Resource{
+			{Header: header, Body: &dnsmessage.AResource{A: [4]byte{192, 168, 0, 100}}},
+		},
+		Additionals: []dnsmessage.Resource{
+			{Header: header, Body: aaaa("fd00::100")},
+			{Header: header, Body: aaaa("fe80::1")},
+		},
+	}
+
+	got := responseAddresses(msg)
+	want := []net.IP{net.ParseIP("192.168.0.100"), net.ParseIP("fd00::100")}
+	if len(got) != len(want) {
+		t.Fatalf("want: %v, got: %v", want, got)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("want: %v, got: %v", want, got)
+		}
+	}
+}
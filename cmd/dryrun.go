@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// dryRunAnnotation marks the commands which honour the global --dry-run
+// flag, every other command refuses to run with it.
+const dryRunAnnotation = "k3sup.dev/dry-run"
+
+func supportDryRun(command *cobra.Command) {
+	if command.Annotations == nil {
+		command.Annotations = map[string]string{}
+	}
+	command.Annotations[dryRunAnnotation] = "true"
+}
+
+// ValidateDryRun stops commands which do not support --dry-run from
+// running with it, rather than letting them change a node.
+func ValidateDryRun(command *cobra.Command, args []string) error {
+	if !dryRunFromFlags(command) {
+		return nil
+	}
+	if command.Annotations[dryRunAnnotation] != "true" {
+		return fmt.Errorf("--dry-run is not supported by %s", command.CommandPath())
+	}
+	return nil
+}
+
+// dryRunFromFlags returns the value of the global --dry-run flag, which is
+// false when the flag is not defined.
+func dryRunFromFlags(command *cobra.Command) bool {
+	dryRun, err := command.Flags().GetBool("dry-run")
+	return err == nil && dryRun
+}
+
+// dryRunPlan collects the commands a k3sup command would run and the local
+// files it would write, so that --dry-run can print them instead.
+type dryRunPlan struct {
+	steps []dryRunStep
+}
+
+type dryRunStep struct {
+	// Where the commands run, i.e. "root@192.168.0.100:22" or "locally".
+	Where    string
+	Commands []string
+}
+
+// run adds a command which would be run where.
+func (p *dryRunPlan) run(where, command string) {
+	command = strings.TrimSpace(command)
+	if n := len(p.steps); n > 0 && p.steps[n-1].Where == where {
+		p.steps[n-1].Commands = append(p.steps[n-1].Commands, command)
+		return
+	}
+	p.steps = append(p.steps, dryRunStep{Where: where, Commands: []string{command}})
+}
+
+// note adds a comment for something which is not a command, such as a
+// local file which would be written.
+func (p *dryRunPlan) note(where, format string, a ...interface{}) {
+	p.run(where, "# "+fmt.Sprintf(format, a...))
+}
+
+// print writes the plan as shell, commands are printed as they would be
+// run and everything else as comments, so that it can be copied.
+func (p *dryRunPlan) print(w io.Writer) {
+	fmt.Fprintln(w, "# Dry run, nothing has been executed")
+	for _, step := range p.steps {
+		fmt.Fprintf(w, "\n# %s:\n", step.Where)
+		for _, command := range step.Commands {
+			fmt.Fprintln(w, redactSecrets(command))
+		}
+	}
+}
+
+func sshDestination(user, ip string, port int) string {
+	return fmt.Sprintf("ssh %s@%s -p %d", user, ip, port)
+}
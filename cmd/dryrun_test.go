@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+func Test_dryRunPlan_print(t *testing.T) {
+	plan := dryRunPlan{}
+	where := sshDestination("root", "192.168.0.100", 22)
+	plan.run(where, "curl -sfL https://get.k3s.io | INSTALL_K3S_EXEC='server --vpn-auth name=tailscale,joinKey=tskey-123' sh -\n")
+	plan.run(where, "sudo cat /etc/rancher/k3s/k3s.yaml\n")
+	plan.note("locally", "write the kubeconfig to %s", "/home/k3sup/kubeconfig")
+
+	out := &strings.Builder{}
+	plan.print(out)
+
+	want := `# Dry run, nothing has been executed
+
+# ssh root@192.168.0.100 -p 22:
+curl -sfL https://get.k3s.io | INSTALL_K3S_EXEC='server --vpn-auth name=tailscale,joinKey=<redacted>' sh -
+sudo cat /etc/rancher/k3s/k3s.yaml
+
+# locally:
+# write the kubeconfig to /home/k3sup/kubeconfig
+`
+	if out.String() != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, out.String())
+	}
+}
+
+func Test_ValidateDryRun(t *testing.T) {
+	supported := &cobra.Command{Use: "install"}
+	supported.Flags().Bool("dry-run", false, "")
+	supportDryRun(supported)
+
+	unsupported := &cobra.Command{Use: "uninstall"}
+	unsupported.Flags().Bool("dry-run", false, "")
+
+	for _, command := range []*cobra.Command{supported, unsupported} {
+		if err := ValidateDryRun(command, nil); err != nil {
+			t.Errorf("%s: unexpected error without --dry-run: %s", command.Use, err)
+		}
+		command.Flags().Set("dry-run", "true")
+	}
+
+	if err := ValidateDryRun(supported, nil); err != nil {
+		t.Errorf("unexpected error for a command which supports --dry-run: %s", err)
+	}
+	if err := ValidateDryRun(unsupported, nil); err == nil || !strings.Contains(err.Error(), "not supported by uninstall") {
+		t.Errorf("want error for a command which does not support --dry-run, got: %v", err)
+	}
+}
+
+func Test_registryConfig_dryRun(t *testing.T) {
+	plan := dryRunPlan{}
+	registryConfig{TemplatePath: "registries.tmpl"}.dryRun(&plan, "locally", "sudo ")
+	registryConfig{Content: []byte("mirrors: {}\n")}.dryRun(&plan, "locally", "sudo ")
+	registryConfig{}.dryRun(&plan, "locally", "sudo ")
+
+	want := []string{
+		"# render registries.tmpl for the node and write it to /etc/rancher/k3s/registries.yaml",
+		"echo bWlycm9yczoge30K | base64 -d | { " + operator.UploadCommand("sudo ", "/etc/rancher/k3s/registries.yaml", 0600) + "; }",
+	}
+	if len(plan.steps) != 1 || strings.Join(plan.steps[0].Commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want: %q, got: %+v", want, plan.steps)
+	}
+}
+
+func Test_writeRemoteFileCommand_WritesTheFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rancher", "registries.yaml")
+
+	// "env " stands in for sudo, as for Upload.
+	command := writeRemoteFileCommand("env ", path, []byte("mirrors: {}\n"), 0640)
+	if out, err := exec.Command("/bin/sh", "-c", command).CombinedOutput(); err != nil {
+		t.Fatalf("unexpected error running %q: %s: %s", command, err, out)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil || string(data) != "mirrors: {}\n" {
+		t.Errorf("want the content written, got: %q %v", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("want mode 0640, got: %v %v", info, err)
+	}
+}
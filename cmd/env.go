@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix is the prefix of the environment variable of each flag, i.e.
+// K3SUP_SSH_KEY for --ssh-key.
+const envPrefix = "K3SUP_"
+
+// envIgnoredFlags are not bound to an environment variable: help, which
+// cobra handles before any flag is read, sudo-password, whose variable
+// K3SUP_SUDO_PASSWORD is read by sudoPasswordFromFlags after
+// --sudo-password-stdin, and ssh-key-passphrase, whose variable is
+// K3SUP_SSH_PASSPHRASE, see sshKeyPassphraseFromFlags.
+var envIgnoredFlags = map[string]bool{
+	"help":               true,
+	"sudo-password":      true,
+	"ssh-key-passphrase": true,
+}
+
+// flagEnv is the environment variable which sets the flag name.
+func flagEnv(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// envAnnotation marks a flag whose value came from its environment
+// variable.
+const envAnnotation = "k3sup_env"
+
+// bindEnv sets each flag of command which was not given on the command line
+// from its environment variable, if that is set and not empty. The value
+// replaces the default of the flag, which is not marked as changed, so use
+// flagGiven rather than Changed to tell whether the flag was given. Flags
+// override the environment, which overrides a --config file and the
+// defaults of the flags.
+func bindEnv(command *cobra.Command) error {
+	var err error
+	command.Flags().VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || envIgnoredFlags[flag.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(flagEnv(flag.Name))
+		if !ok || len(value) == 0 {
+			return
+		}
+		if setErr := setFlagDefault(flag, value); setErr != nil {
+			err = fmt.Errorf("invalid value for --%s from %s: %s", flag.Name, flagEnv(flag.Name), setErr)
+			return
+		}
+		command.Flags().SetAnnotation(flag.Name, envAnnotation, []string{flagEnv(flag.Name)})
+	})
+	return err
+}
+
+// setFlagDefault replaces the default of flag with value, without marking
+// the flag as changed.
+func setFlagDefault(flag *pflag.Flag, value string) error {
+	if err := flag.Value.Set(value); err != nil {
+		return err
+	}
+	flag.DefValue = flag.Value.String()
+	return nil
+}
+
+// flagGiven reports whether the flag name was given on the command line or
+// by its environment variable, i.e. to reject flags which cannot be
+// combined, or for a value which takes the place of one from elsewhere,
+// such as the SSH config.
+func flagGiven(command *cobra.Command, name string) bool {
+	flag := command.Flags().Lookup(name)
+	return flag != nil && (flag.Changed || len(flag.Annotations[envAnnotation]) > 0)
+}
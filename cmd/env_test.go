@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func Test_flagEnv(t *testing.T) {
+	cases := map[string]string{
+		"ip":                   "K3SUP_IP",
+		"ssh-key":              "K3SUP_SSH_KEY",
+		"datastore":            "K3SUP_DATASTORE",
+		"server-ready-timeout": "K3SUP_SERVER_READY_TIMEOUT",
+	}
+	for name, want := range cases {
+		if got := flagEnv(name); got != want {
+			t.Errorf("%s: want %s, got: %s", name, want, got)
+		}
+	}
+}
+
+func Test_bindEnv(t *testing.T) {
+	env := map[string]string{
+		"K3SUP_IP":            "192.168.0.100",
+		"K3SUP_USER":          "ubuntu",
+		"K3SUP_TOKEN":         "a-long-enough-secret",
+		"K3SUP_SSH_PORT":      "2222",
+		"K3SUP_SUDO_PASSWORD": "secret",
+		"K3SUP_K3S_CHANNEL":   "",
+
+		"K3SUP_SSH_KEY_PASSPHRASE": "secret",
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	command := MakeInstall()
+	if err := command.ParseFlags([]string{"--user", "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bindEnv(command); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := map[string]string{
+		"ip":            "192.168.0.100",
+		"user":          "admin",
+		"token":         "a-long-enough-secret",
+		"ssh-port":      "2222",
+		"sudo-password": "",
+		"k3s-channel":   "v1.18",
+
+		"ssh-key-passphrase": "",
+	}
+	for name, value := range want {
+		if got := command.Flags().Lookup(name).Value.String(); got != value {
+			t.Errorf("want %s: %q, got: %q", name, value, got)
+		}
+	}
+	if command.Flags().Changed("token") || !flagGiven(command, "token") || flagGiven(command, "k3s-channel") {
+		t.Errorf("want only the flags set from the environment given, without being marked as changed")
+	}
+}
+
+func Test_bindEnv_InvalidValue(t *testing.T) {
+	os.Setenv("K3SUP_SUDO", "maybe")
+	defer os.Unsetenv("K3SUP_SUDO")
+
+	if err := bindEnv(MakeInstall()); err == nil || !strings.Contains(err.Error(), "--sudo from K3SUP_SUDO") {
+		t.Errorf("want error for an invalid K3SUP_SUDO, got: %v", err)
+	}
+}
+
+func Test_bindEnv_Conflicts(t *testing.T) {
+	cases := []struct {
+		env, value string
+		args       []string
+		check      func(command *cobra.Command) error
+		want       string
+	}{
+		{
+			env: "K3SUP_CONTEXT", value: "edge",
+			args: []string{"--context-template", "{{.Hostname}}"},
+			check: func(command *cobra.Command) error {
+				_, err := contextTemplateFromFlags(command)
+				return err
+			},
+			want: "--context-template cannot be used with --context",
+		},
+		{
+			env: "K3SUP_KUBECONFIG_HOST", value: "k3s.example.com",
+			args: []string{"--kubeconfig-server-url", "https://k3s.example.com:6443"},
+			check: func(command *cobra.Command) error {
+				_, err := kubeconfigServerURLFromFlags(command)
+				return err
+			},
+			want: "--kubeconfig-host cannot be used with --kubeconfig-server-url",
+		},
+		{
+			env: "K3SUP_KUBECONFIG_SERVER_URL", value: "https://k3s.example.com:6443",
+			args: []string{"--lb-endpoint", "lb.example.com:6443"},
+			check: func(command *cobra.Command) error {
+				_, err := lbEndpointFromFlags(command)
+				return err
+			},
+			want: "--kubeconfig-server-url cannot be used with --lb-endpoint",
+		},
+	}
+
+	for _, c := range cases {
+		os.Setenv(c.env, c.value)
+		command := MakeInstall()
+		if err := command.ParseFlags(c.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := bindEnv(command); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := c.check(command); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("want %s to count as its flag, got: %v", c.env, err)
+		}
+		os.Unsetenv(c.env)
+	}
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+func MakeGetKubeconfig() *cobra.Command {
+	var command = &cobra.Command{
+		Use:     "get-kubeconfig",
+		Aliases: []string{"kubeconfig"},
+		Short:   "Fetch the kubeconfig from an existing k3s server",
+		Long: `Fetch the kubeconfig from an existing k3s server via SSH and save or merge
+it locally, without running the k3s installer, i.e. after losing the local copy.`,
+		Example: `  k3sup get-kubeconfig --ip 192.168.0.100 --user root
+  k3sup get-kubeconfig --ip 192.168.0.100 --local-path ~/.kube/config --merge --context my-k3s`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("ip", net.ParseIP("127.0.0.1"), "Public IP of node")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	addHostKeyFlags(command)
+	addSSHPasswordFlags(command)
+	addSSHTimeoutFlags(command)
+	addSSHProxyFlags(command)
+	command.Flags().Bool("sudo", true, "Use sudo to read the kubeconfig. e.g. set to false when using the root user and no sudo is available.")
+	command.Flags().String("sudo-binary", "sudo", "The binary used for privilege escalation with --sudo, i.e. doas")
+	addSudoPasswordFlags(command)
+
+	command.Flags().String("local-path", "kubeconfig", "Local path to save the kubeconfig file, or - to write it to stdout")
+	addKubeconfigModeFlag(command)
+	command.Flags().String("context", "default", "Set the name of the kubeconfig context.")
+	addContextTemplateFlag(command)
+	command.Flags().String("cluster-name", "", "Optional: set the name of the kubeconfig cluster, defaults to --context")
+	command.Flags().String("user-name", "", "Optional: set the name of the kubeconfig user, defaults to --context")
+	command.Flags().Int("kubeconfig-server-port", 0, "Optional: port of the server URL in the kubeconfig, i.e. 16443 when the API is reached via \"ssh -L 16443:localhost:6443\"")
+	addVerifyFlags(command)
+	command.Flags().String("kubeconfig-server-url", "", "Optional: server URL to write into the kubeconfig in place of the server IP and port, i.e. https://k3s.example.com:443 for a load balancer")
+	command.Flags().Bool("no-embed-certs", false, "Write the certificates and key to files next to --local-path and reference them from the kubeconfig")
+	command.Flags().Bool("merge", false, `Merge the config with existing kubeconfig if it already exists.
+Provide the --local-path flag with --merge if a kubeconfig already exists in some other directory`)
+	addOverwriteFlag(command)
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		localKubeconfig, _ := command.Flags().GetString("local-path")
+
+		var kubeconfigOutput io.Writer
+		if localKubeconfig == kubeconfigStdout {
+			if err := validateKubeconfigStdout(command); err != nil {
+				return err
+			}
+
+			stdout := os.Stdout
+			os.Stdout = os.Stderr
+			defer func() { os.Stdout = stdout }()
+			kubeconfigOutput = stdout
+		}
+
+		infof("Running: k3sup get-kubeconfig\n")
+
+		ip, _ := command.Flags().GetIP("ip")
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+
+		useSudo, _ := command.Flags().GetBool("sudo")
+		sudoBinary, _ := command.Flags().GetString("sudo-binary")
+		sudoPrefix, err := makeSudoPrefix(useSudo, sudoBinary)
+		if err != nil {
+			return err
+		}
+
+		context, _ := command.Flags().GetString("context")
+		contextTemplate, err := contextTemplateFromFlags(command)
+		if err != nil {
+			return err
+		}
+		clusterName, _ := command.Flags().GetString("cluster-name")
+		userName, _ := command.Flags().GetString("user-name")
+		serverPort, _ := command.Flags().GetInt("kubeconfig-server-port")
+		if serverPort < 0 || serverPort > 65535 {
+			return fmt.Errorf("--kubeconfig-server-port must be between 1 and 65535")
+		}
+		serverURL, err := kubeconfigServerURLFromFlags(command)
+		if err != nil {
+			return err
+		}
+		verifyTimeout, err := verifyTimeoutFromFlags(command)
+		if err != nil {
+			return err
+		}
+		kubeconfigMode, err := kubeconfigModeFromFlags(command)
+		if err != nil {
+			return err
+		}
+		noEmbedCerts, _ := command.Flags().GetBool("no-embed-certs")
+		merge, _ := command.Flags().GetBool("merge")
+		overwrite, _ := command.Flags().GetBool("overwrite")
+
+		sshOpts, err := sshOptionsFromFlags(command, "")
+		if err != nil {
+			return err
+		}
+		defer sshOpts.zero()
+
+		address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		op, err := connectSSH(address, user, expandPath(sshKey), sshOpts)
+		if err != nil {
+			return err
+		}
+		defer op.Close()
+
+		if contextTemplate != nil {
+			context, err = renderContext(contextTemplate, op, ip.String())
+			if err != nil {
+				return err
+			}
+		}
+
+		return getKubeconfig(op, sudoPrefix, ip.String(), kubeconfigOptions{
+			Context:       context,
+			ClusterName:   clusterName,
+			UserName:      userName,
+			LocalPath:     localKubeconfig,
+			Merge:         merge,
+			Overwrite:     overwrite,
+			NoEmbedCerts:  noEmbedCerts,
+			ServerPort:    serverPort,
+			ServerURL:     serverURL,
+			VerifyTimeout: verifyTimeout,
+			Output:        kubeconfigOutput,
+			Mode:          kubeconfigMode,
+		})
+	}
+
+	return command
+}
+
+// getKubeconfig fetches the kubeconfig of the server, with a clear error
+// when the node is not a k3s server.
+func getKubeconfig(operator operator.CommandOperator, sudoPrefix, ip string, options kubeconfigOptions) error {
+	if err := checkSudo(operator, sudoPrefix); err != nil {
+		return err
+	}
+
+	artifact := kubeconfigArtifact(sudoPrefix)
+	res, err := operator.Execute(artifact.Command)
+	if err != nil {
+		return fmt.Errorf("error received checking for the kubeconfig: %s", err)
+	}
+	if strings.TrimSpace(string(res.StdOut)) != "ready" {
+		return fmt.Errorf("no kubeconfig found at %s on %s, is it a k3s server?", install.KubeconfigPath, ip)
+	}
+
+	options.RemotePath = install.KubeconfigPath
+	_, err = obtainKubeconfig(operator, sudoPrefix+"cat "+install.KubeconfigPath+"\n", ip, options)
+	return err
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_getKubeconfig(t *testing.T) {
+	op := &scriptedOperator{replies: map[string]string{
+		kubeconfigArtifact("").Command:    "ready\n",
+		"cat /etc/rancher/k3s/k3s.yaml\n": kubeconfigExample,
+	}}
+
+	dir, err := ioutil.TempDir("", "k3sup-kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "kubeconfig")
+
+	err = getKubeconfig(op, "", "192.168.0.100", kubeconfigOptions{Context: "edge", LocalPath: localPath})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	saved, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatalf("want the kubeconfig to be saved: %s", err)
+	}
+	if !strings.Contains(string(saved), "server: https://192.168.0.100:6443") || !strings.Contains(string(saved), "name: edge") {
+		t.Errorf("want the rewritten kubeconfig, got:\n%s", saved)
+	}
+}
+
+func Test_getKubeconfig_NotAServer(t *testing.T) {
+	op := &scriptedOperator{replies: map[string]string{}}
+
+	err := getKubeconfig(op, "", "192.168.0.101", kubeconfigOptions{LocalPath: "kubeconfig"})
+	if err == nil || !strings.Contains(err.Error(), "is it a k3s server?") {
+		t.Fatalf("want an error for a node without a kubeconfig, got: %v", err)
+	}
+	if len(op.commands) != 1 {
+		t.Errorf("want only the check for the kubeconfig, got: %q", op.commands)
+	}
+}
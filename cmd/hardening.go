@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+// kernelParamsPath is where --set-kernel-params writes the sysctls of
+// kernelParams, so that they are kept after a reboot.
+const kernelParamsPath = "/etc/sysctl.d/90-kubelet.conf"
+
+// kernelParams are the sysctls which the kubelet expects with
+// --protect-kernel-defaults, it refuses to start when any of them differs.
+// These are the values of the CIS hardening guide of k3s.
+var kernelParams = []struct {
+	Name  string
+	Value string
+}{
+	{"vm.panic_on_oom", "0"},
+	{"vm.overcommit_memory", "1"},
+	{"kernel.panic", "10"},
+	{"kernel.panic_on_oops", "1"},
+}
+
+// hardening is the CIS hardening of k3s on a node, from the flags of
+// addHardeningFlags.
+type hardening struct {
+	ProtectKernelDefaults bool
+	SELinux               bool
+	// SecretsEncryption is set by --cis-hardening for a server.
+	SecretsEncryption bool
+	// SetKernelParams writes kernelParams to the node before installing.
+	SetKernelParams bool
+}
+
+func addHardeningFlags(command *cobra.Command) {
+	command.Flags().Bool("protect-kernel-defaults", false, "Start the kubelet with --protect-kernel-defaults, it will not start until the sysctls of the CIS hardening guide are set on the node, see --set-kernel-params")
+	command.Flags().Bool("selinux", false, "Enable SELinux in containerd (k3s --selinux), the node needs the k3s-selinux policy")
+	command.Flags().Bool("cis-hardening", false, "Apply the CIS hardening of k3s: --protect-kernel-defaults, and --secrets-encryption on servers")
+	command.Flags().Bool("set-kernel-params", false, "Write the sysctls needed by --protect-kernel-defaults to "+kernelParamsPath+" on the node and load them before installing")
+}
+
+// hardeningFromFlags reads the flags of addHardeningFlags, server is
+// whether the node runs k3s server.
+func hardeningFromFlags(command *cobra.Command, server bool) (hardening, error) {
+	protectKernelDefaults, _ := command.Flags().GetBool("protect-kernel-defaults")
+	selinux, _ := command.Flags().GetBool("selinux")
+	cisHardening, _ := command.Flags().GetBool("cis-hardening")
+	setKernelParams, _ := command.Flags().GetBool("set-kernel-params")
+
+	h := hardening{
+		ProtectKernelDefaults: protectKernelDefaults || cisHardening,
+		SELinux:               selinux,
+		SecretsEncryption:     cisHardening && server,
+		SetKernelParams:       setKernelParams,
+	}
+	if h.SetKernelParams && !h.ProtectKernelDefaults {
+		return hardening{}, fmt.Errorf("--set-kernel-params needs --protect-kernel-defaults or --cis-hardening")
+	}
+	return h, nil
+}
+
+// k3sArgs are the k3s flags of the hardening, other than
+// --secrets-encryption which only a server takes.
+func (h hardening) k3sArgs() []string {
+	args := []string{}
+	if h.ProtectKernelDefaults {
+		args = append(args, "--protect-kernel-defaults")
+	}
+	if h.SELinux {
+		args = append(args, "--selinux")
+	}
+	return args
+}
+
+// validateExtraArgs rejects the flags of the hardening when they are also
+// given in extraArgs, as k3s would be started with them twice.
+func (h hardening) validateExtraArgs(extraArgs string) error {
+	for _, arg := range h.k3sArgs() {
+		if install.HasK3sArg(extraArgs, arg) {
+			return fmt.Errorf("%s cannot be used together with %s in --k3s-extra-args, --agent-arg or --server-arg", arg, arg)
+		}
+	}
+	return nil
+}
+
+// kernelParamsConfig is the content of kernelParamsPath.
+func kernelParamsConfig() []byte {
+	config := ""
+	for _, param := range kernelParams {
+		config += fmt.Sprintf("%s=%s\n", param.Name, param.Value)
+	}
+	return []byte(config)
+}
+
+// loadKernelParamsCommand applies kernelParamsPath without a reboot.
+func loadKernelParamsCommand(sudoPrefix string) string {
+	return fmt.Sprintf("%ssysctl -p %s", sudoPrefix, kernelParamsPath)
+}
+
+// readKernelParamsCommand prints each of kernelParams as "name = value",
+// sysctl -e skips those which the kernel does not have.
+func readKernelParamsCommand() string {
+	names := []string{}
+	for _, param := range kernelParams {
+		names = append(names, param.Name)
+	}
+	return "sysctl -e " + strings.Join(names, " ")
+}
+
+// unsetKernelParams returns each of kernelParams which the output of
+// readKernelParamsCommand does not show at its value, as name=value.
+func unsetKernelParams(output string) []string {
+	values := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	unset := []string{}
+	for _, param := range kernelParams {
+		if values[param.Name] != param.Value {
+			unset = append(unset, param.Name+"="+param.Value)
+		}
+	}
+	return unset
+}
+
+// apply writes and loads the kernel params when asked to, then warns when
+// --protect-kernel-defaults is given but the node does not have them, as
+// the kubelet would not start.
+func (h hardening) apply(operator operator.CommandOperator, ip, sudoPrefix string) error {
+	if !h.ProtectKernelDefaults {
+		return nil
+	}
+
+	if h.SetKernelParams {
+		infof("Writing %s\n", kernelParamsPath)
+		if err := writeRemoteFile(operator, kernelParamsPath, kernelParamsConfig(), 0644); err != nil {
+			return err
+		}
+		if res, err := operator.Execute(loadKernelParamsCommand(sudoPrefix)); err != nil {
+			return fmt.Errorf("error received loading %s: %s\nstderr: %s", kernelParamsPath, err, strings.TrimSpace(string(res.StdErr)))
+		}
+	}
+
+	res, err := operator.Execute(readKernelParamsCommand())
+	if err != nil {
+		warnf("Unable to check the kernel params needed by --protect-kernel-defaults on %s: %s\n", ip, err)
+		return nil
+	}
+	if unset := unsetKernelParams(string(res.StdOut)); len(unset) > 0 {
+		warnf("--protect-kernel-defaults needs %s on %s, the kubelet will not start until they are set, i.e. with --set-kernel-params\n", strings.Join(unset, ", "), ip)
+	}
+	return nil
+}
+
+// dryRun adds the write of the kernel params to plan.
+func (h hardening) dryRun(plan *dryRunPlan, where, sudoPrefix string) {
+	if !h.SetKernelParams {
+		return
+	}
+	plan.run(where, writeRemoteFileCommand(sudoPrefix, kernelParamsPath, kernelParamsConfig(), 0644))
+	plan.run(where, loadKernelParamsCommand(sudoPrefix))
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+func Test_hardeningFromFlags(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    []string
+		server  bool
+		want    hardening
+		wantErr bool
+	}{
+		{name: "none", args: []string{}, server: true, want: hardening{}},
+		{name: "cis on a server", args: []string{"--cis-hardening"}, server: true, want: hardening{ProtectKernelDefaults: true, SecretsEncryption: true}},
+		{name: "cis on an agent", args: []string{"--cis-hardening", "--selinux"}, want: hardening{ProtectKernelDefaults: true, SELinux: true}},
+		{name: "set kernel params", args: []string{"--protect-kernel-defaults", "--set-kernel-params"}, want: hardening{ProtectKernelDefaults: true, SetKernelParams: true}},
+		{name: "kernel params without protect", args: []string{"--set-kernel-params"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			command := MakeJoin()
+			if err := command.ParseFlags(tc.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := hardeningFromFlags(command, tc.server)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("want an error for %q", tc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.want {
+				t.Errorf("want: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_hardening_k3sArgs(t *testing.T) {
+	h := hardening{ProtectKernelDefaults: true, SELinux: true, SecretsEncryption: true}
+	want := []string{"--protect-kernel-defaults", "--selinux"}
+	if got := h.k3sArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	if err := h.validateExtraArgs("--node-label zone=a"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := h.validateExtraArgs("--selinux=true"); err == nil {
+		t.Errorf("want an error for --selinux given twice")
+	}
+}
+
+func Test_unsetKernelParams(t *testing.T) {
+	output := "vm.panic_on_oom = 0\nvm.overcommit_memory = 0\nkernel.panic = 10\n"
+	want := []string{"vm.overcommit_memory=1", "kernel.panic_on_oops=1"}
+	if got := unsetKernelParams(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	output = "vm.panic_on_oom = 0\nvm.overcommit_memory = 1\nkernel.panic = 10\nkernel.panic_on_oops = 1\n"
+	if got := unsetKernelParams(output); len(got) != 0 {
+		t.Errorf("want all params set, got: %q", got)
+	}
+}
+
+func Test_hardening_apply_WarnsForUnsetParams(t *testing.T) {
+	node := &operator.FakeOperator{Replies: map[string]operator.CommandRes{
+		readKernelParamsCommand(): {StdOut: []byte("vm.panic_on_oom = 0\nvm.overcommit_memory = 0\nkernel.panic = 0\nkernel.panic_on_oops = 1\n")},
+	}}
+
+	var err error
+	out := captureStdout(t, logInfo, func() {
+		err = hardening{ProtectKernelDefaults: true}.apply(node, "192.168.0.100", "sudo ")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(out, "Warning: --protect-kernel-defaults needs vm.overcommit_memory=1, kernel.panic=10 on 192.168.0.100") {
+		t.Errorf("want a warning naming the unset params, got: %q", out)
+	}
+	if len(node.Commands()) != 1 {
+		t.Errorf("want only the params read, got: %q", node.Commands())
+	}
+}
+
+func Test_hardening_apply_SetKernelParams(t *testing.T) {
+	node := &operator.FakeOperator{Replies: map[string]operator.CommandRes{
+		readKernelParamsCommand(): {StdOut: kernelParamsOutput()},
+	}}
+
+	var err error
+	out := captureStdout(t, logInfo, func() {
+		err = hardening{ProtectKernelDefaults: true, SetKernelParams: true}.apply(node, "192.168.0.100", "sudo ")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	config, ok := node.Uploaded(kernelParamsPath)
+	if !ok || string(config) != "vm.panic_on_oom=0\nvm.overcommit_memory=1\nkernel.panic=10\nkernel.panic_on_oops=1\n" {
+		t.Errorf("want the params written to %s, got: %q", kernelParamsPath, config)
+	}
+	want := []string{"upload " + kernelParamsPath + " 644", "sudo sysctl -p " + kernelParamsPath, readKernelParamsCommand()}
+	if !reflect.DeepEqual(node.Commands(), want) {
+		t.Errorf("want: %q, got: %q", want, node.Commands())
+	}
+	if strings.Contains(out, "Warning") {
+		t.Errorf("want no warning once the params are set, got: %q", out)
+	}
+}
+
+func Test_MakeInstall_CISHardening(t *testing.T) {
+	node := &operator.FakeOperator{
+		Replies: map[string]operator.CommandRes{readKernelParamsCommand(): {StdOut: kernelParamsOutput()}},
+		Reply:   pretendReply,
+	}
+	defer fakeNode(node)()
+
+	dir, err := ioutil.TempDir("", "k3sup-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	command := MakeInstall()
+	command.Flags().Set("ip", "192.168.0.100")
+	command.Flags().Set("k3s-version", "v1.19.1+k3s1")
+	command.Flags().Set("local-path", filepath.Join(dir, "kubeconfig"))
+	command.Flags().Set("cis-hardening", "true")
+	command.Flags().Set("set-kernel-params", "true")
+
+	captureStdout(t, logQuiet, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, ok := node.Uploaded(kernelParamsPath); !ok {
+		t.Errorf("want the kernel params written, got: %q", node.Commands())
+	}
+	if !containsCommand(node.Commands(), "--secrets-encryption --protect-kernel-defaults'") {
+		t.Errorf("want the hardening flags in INSTALL_K3S_EXEC, got: %q", node.Commands())
+	}
+}
+
+// kernelParamsOutput is the output of readKernelParamsCommand on a node
+// which has kernelParams.
+func kernelParamsOutput() []byte {
+	output := ""
+	for _, param := range kernelParams {
+		output += param.Name + " = " + param.Value + "\n"
+	}
+	return []byte(output)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func addHostKeyFlags(command *cobra.Command) {
+	command.Flags().String("ssh-known-hosts", "~/.ssh/known_hosts", "The known_hosts file used to verify the host key of each node")
+	command.Flags().Bool("skip-host-key-check", false, "Do not verify the host key of each node, this leaves the connection open to man-in-the-middle attacks")
+}
+
+func hostKeyCallbackFromFlags(command *cobra.Command) (ssh.HostKeyCallback, error) {
+	knownHosts, _ := command.Flags().GetString("ssh-known-hosts")
+	skip, _ := command.Flags().GetBool("skip-host-key-check")
+	return makeHostKeyCallback(expandPath(knownHosts), skip)
+}
+
+// makeHostKeyCallback verifies host keys against the known_hosts file at
+// knownHostsPath. A missing file is treated as empty, so that every host
+// is reported as unknown along with how to add it.
+func makeHostKeyCallback(knownHostsPath string, skip bool) (ssh.HostKeyCallback, error) {
+	if skip {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	files := []string{}
+	if _, err := os.Stat(knownHostsPath); err == nil {
+		files = append(files, knownHostsPath)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read known_hosts file %s: %s", knownHostsPath, err)
+	}
+
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse known_hosts file %s: %s", knownHostsPath, err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			debugf("Verified the %s host key of %s, %s, against %s\n", key.Type(), hostname, ssh.FingerprintSHA256(key), knownHostsPath)
+		}
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok {
+			return err
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) == 0 {
+			host, port, splitErr := net.SplitHostPort(hostname)
+			if splitErr != nil {
+				host, port = hostname, "22"
+			}
+			return fmt.Errorf("the host key of %s is not in %s, its %s fingerprint is %s\n"+
+				"Verify the fingerprint, then add it with: ssh-keyscan -p %s %s >> %s\n"+
+				"Or pass --skip-host-key-check to connect without verifying the host key",
+				hostname, knownHostsPath, key.Type(), fingerprint, port, host, knownHostsPath)
+		}
+
+		want := keyErr.Want[0]
+		return fmt.Errorf("the host key of %s does not match the one in %s:%d, someone could be intercepting the connection (a man-in-the-middle attack), or the host key may have changed\n"+
+			"Received %s fingerprint %s, expected %s\n"+
+			"If the host was reinstalled, remove the old key with: ssh-keygen -R %s -f %s",
+			hostname, want.Filename, want.Line, key.Type(), fingerprint, ssh.FingerprintSHA256(want.Key), knownhosts.Normalize(hostname), knownHostsPath)
+	}, nil
+}
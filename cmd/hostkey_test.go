@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writeKnownHosts(t *testing.T, address string, key ssh.PublicKey) string {
+	file, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, key) + "\n"
+	if _, err := file.WriteString(line); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func Test_makeHostKeyCallback_Match(t *testing.T) {
+	key := newTestHostKey(t)
+	path := writeKnownHosts(t, "192.168.0.100:22", key)
+	defer os.Remove(path)
+
+	callback, err := makeHostKeyCallback(path, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.0.100"), Port: 22}
+	if err := callback("192.168.0.100:22", remote, key); err != nil {
+		t.Errorf("want known key to be accepted, got: %s", err)
+	}
+}
+
+func Test_makeHostKeyCallback_Mismatch(t *testing.T) {
+	path := writeKnownHosts(t, "192.168.0.100:22", newTestHostKey(t))
+	defer os.Remove(path)
+
+	callback, err := makeHostKeyCallback(path, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.0.100"), Port: 22}
+	err = callback("192.168.0.100:22", remote, newTestHostKey(t))
+	if err == nil {
+		t.Fatalf("want an error for a changed host key")
+	}
+	if !strings.Contains(err.Error(), "man-in-the-middle") {
+		t.Errorf("want the error to mention a man-in-the-middle attack, got: %s", err)
+	}
+}
+
+func Test_makeHostKeyCallback_Missing(t *testing.T) {
+	path := writeKnownHosts(t, "192.168.0.100:22", newTestHostKey(t))
+	defer os.Remove(path)
+
+	callback, err := makeHostKeyCallback(path, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	key := newTestHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.0.101"), Port: 2222}
+	err = callback("192.168.0.101:2222", remote, key)
+	if err == nil {
+		t.Fatalf("want an error for an unknown host")
+	}
+	if !strings.Contains(err.Error(), ssh.FingerprintSHA256(key)) {
+		t.Errorf("want the error to include the fingerprint, got: %s", err)
+	}
+	if !strings.Contains(err.Error(), "ssh-keyscan -p 2222 192.168.0.101 >> "+path) {
+		t.Errorf("want the error to explain how to add the key, got: %s", err)
+	}
+}
+
+func Test_makeHostKeyCallback_MissingFile(t *testing.T) {
+	callback, err := makeHostKeyCallback(filepath.Join(os.TempDir(), "k3sup-does-not-exist", "known_hosts"), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.0.100"), Port: 22}
+	if err := callback("192.168.0.100:22", remote, newTestHostKey(t)); err == nil {
+		t.Errorf("want an error for an unknown host")
+	}
+}
+
+func Test_makeHostKeyCallback_Skip(t *testing.T) {
+	callback, err := makeHostKeyCallback("", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.0.100"), Port: 22}
+	if err := callback("192.168.0.100:22", remote, newTestHostKey(t)); err != nil {
+		t.Errorf("want any key to be accepted, got: %s", err)
+	}
+}
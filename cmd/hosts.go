@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+// hostEntry is one line of a --hosts-file, in the form user@ip:port where
+// the user and port are optional.
+type hostEntry struct {
+	User string
+	IP   net.IP
+	Port int
+}
+
+func (h hostEntry) String() string {
+	return fmt.Sprintf("%s@%s", h.User, net.JoinHostPort(h.IP.String(), strconv.Itoa(h.Port)))
+}
+
+// hostResult is the outcome of running a task against a host.
+type hostResult struct {
+	Host     hostEntry
+	Err      error
+	Duration time.Duration
+}
+
+// parseHostsFile reads one host per line, blank lines and lines starting
+// with # are skipped.
+func parseHostsFile(path, defaultUser string, defaultPort int) ([]hostEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read hosts file %s: %s", path, err)
+	}
+
+	hosts := []hostEntry{}
+	seen := map[string]bool{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		host, err := parseHostEntry(line, defaultUser, defaultPort)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, i+1, err)
+		}
+		if seen[host.IP.String()] {
+			return nil, fmt.Errorf("%s:%d: duplicate host %s", path, i+1, host.IP)
+		}
+		seen[host.IP.String()] = true
+
+		hosts = append(hosts, host)
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts found in %s", path)
+	}
+	return hosts, nil
+}
+
+func parseHostEntry(value, defaultUser string, defaultPort int) (hostEntry, error) {
+	host := hostEntry{User: defaultUser, Port: defaultPort}
+
+	if at := strings.LastIndex(value, "@"); at >= 0 {
+		host.User = value[:at]
+		value = value[at+1:]
+		if len(host.User) == 0 {
+			return hostEntry{}, fmt.Errorf("empty user in %q", value)
+		}
+	}
+
+	address := value
+	if h, p, err := net.SplitHostPort(value); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return hostEntry{}, fmt.Errorf("invalid port %q", p)
+		}
+		address = h
+		host.Port = port
+	}
+
+	host.IP = net.ParseIP(strings.Trim(address, "[]"))
+	if host.IP == nil {
+		return hostEntry{}, fmt.Errorf("invalid IP %q", address)
+	}
+	return host, nil
+}
+
+// runParallel runs task against every host with at most parallel tasks
+// running at once. A failing host does not stop the others, the results
+// are returned in the order of hosts.
+func runParallel(hosts []hostEntry, parallel int, task func(hostEntry) error) []hostResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]hostResult, len(hosts))
+	slots := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+
+	for i, host := range hosts {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, host hostEntry) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			start := time.Now()
+			err := task(host)
+			results[i] = hostResult{Host: host, Err: err, Duration: time.Since(start)}
+		}(i, host)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// printHostResults prints a summary table and returns an error when any
+// host failed.
+func printHostResults(results []hostResult) error {
+	failed := 0
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS\tERROR")
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			status := "failed"
+			if errors.Is(result.Err, operator.ErrTimeout) {
+				status = "timed out"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", result.Host, status, strings.Replace(result.Err.Error(), "\n", " ", -1))
+			continue
+		}
+		fmt.Fprintf(w, "%s\tok\t-\n", result.Host)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d hosts failed", failed, len(results))
+	}
+	return nil
+}
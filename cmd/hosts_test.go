@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_parseHostsFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString(`# edge devices
+pi@192.168.0.101:2222
+
+192.168.0.102
+ubuntu@192.168.0.103
+[fd00::1]:22
+`)
+	file.Close()
+
+	hosts, err := parseHostsFile(file.Name(), "root", 22)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{
+		"pi@192.168.0.101:2222",
+		"root@192.168.0.102:22",
+		"ubuntu@192.168.0.103:22",
+		"root@[fd00::1]:22",
+	}
+	if len(hosts) != len(want) {
+		t.Fatalf("want %d hosts, got: %v", len(want), hosts)
+	}
+	for i, host := range hosts {
+		if host.String() != want[i] {
+			t.Errorf("want: %q, got: %q", want[i], host.String())
+		}
+	}
+}
+
+func Test_parseHostEntry_Invalid(t *testing.T) {
+	for _, value := range []string{"pi@", "node-1", "192.168.0.101:ssh", "@192.168.0.101"} {
+		if _, err := parseHostEntry(value, "root", 22); err == nil {
+			t.Errorf("want an error for %q", value)
+		}
+	}
+}
+
+func Test_runParallel_Bounded(t *testing.T) {
+	hosts := []hostEntry{}
+	for i := 0; i < 12; i++ {
+		host, _ := parseHostEntry(fmt.Sprintf("192.168.0.%d", i+1), "root", 22)
+		hosts = append(hosts, host)
+	}
+
+	var running, maxRunning int32
+	var mu sync.Mutex
+	results := runParallel(hosts, 3, func(host hostEntry) error {
+		current := atomic.AddInt32(&running, 1)
+		mu.Lock()
+		if current > maxRunning {
+			maxRunning = current
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+
+	if len(results) != len(hosts) {
+		t.Fatalf("want %d results, got: %d", len(hosts), len(results))
+	}
+	if maxRunning > 3 {
+		t.Errorf("want at most 3 tasks at once, got: %d", maxRunning)
+	}
+}
+
+func Test_runParallel_FailureDoesNotStopOthers(t *testing.T) {
+	hosts := []hostEntry{}
+	for _, value := range []string{"192.168.0.1", "192.168.0.2", "192.168.0.3"} {
+		host, _ := parseHostEntry(value, "root", 22)
+		hosts = append(hosts, host)
+	}
+
+	var completed int32
+	results := runParallel(hosts, 2, func(host hostEntry) error {
+		if host.IP.String() == "192.168.0.1" {
+			return fmt.Errorf("unable to connect")
+		}
+		atomic.AddInt32(&completed, 1)
+		return nil
+	})
+
+	if completed != 2 {
+		t.Errorf("want the other 2 hosts to complete, got: %d", completed)
+	}
+	if results[0].Err == nil || results[1].Err != nil || results[2].Err != nil {
+		t.Errorf("want only the first host to fail, got: %v", results)
+	}
+	if err := printHostResults(results); err == nil {
+		t.Errorf("want an error when a host failed")
+	}
+}
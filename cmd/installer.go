@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// k3sBinary is where the k3s installer expects the binary when it is told
+// to skip the download.
+const k3sBinary = "/usr/local/bin/k3s"
+
+// defaultK3sDataDir is where k3s keeps its state unless --data-dir is given.
+const defaultK3sDataDir = "/var/lib/rancher/k3s"
+
+// validateDataDir checks the value of a --data-dir flag, named by flag.
+func validateDataDir(flag, dataDir string) error {
+	if len(dataDir) == 0 {
+		return nil
+	}
+	if !path.IsAbs(dataDir) {
+		return fmt.Errorf("%s must be an absolute path on the node, got: %q", flag, dataDir)
+	}
+	if strings.ContainsAny(dataDir, "' \t\n") {
+		return fmt.Errorf("%s must not contain quotes or whitespace, got: %q", flag, dataDir)
+	}
+	return nil
+}
+
+// serverDataPath returns the path of name under the server directory of
+// the k3s data-dir, i.e. the node-token.
+func serverDataPath(dataDir, name string) string {
+	if len(dataDir) == 0 {
+		dataDir = defaultK3sDataDir
+	}
+	return path.Join(dataDir, "server", name)
+}
+
+// k3sSource is where the k3s installer script and binary come from, by
+// default get.k3s.io and the GitHub releases of k3s.
+type k3sSource struct {
+	// InstallURL is a http(s) URL of the installer, or its path on the node.
+	InstallURL string
+	// BinaryURL replaces the GitHub releases URL the binary is downloaded
+	// from (GITHUB_URL).
+	BinaryURL string
+	// Mirror is passed as INSTALL_K3S_MIRROR, i.e. "cn".
+	Mirror string
+	// BinaryPath is a k3s binary already on the node, nothing is downloaded.
+	BinaryPath string
+}
+
+func addK3sSourceFlags(command *cobra.Command) {
+	command.Flags().String("k3s-install-url", "", "Optional: URL of the k3s install script, or its path on the node for offline installs, defaults to https://get.k3s.io")
+	command.Flags().String("k3s-binary-url", "", "Optional: URL of a mirror of the k3s GitHub releases to download the binary from (GITHUB_URL)")
+	command.Flags().String("k3s-mirror", "", "Optional: mirror for the installer to download k3s from (INSTALL_K3S_MIRROR), i.e. cn")
+	command.Flags().String("k3s-binary-path", "", "Optional: path of a k3s binary already on the node, which is installed instead of downloading one")
+}
+
+func k3sSourceFromFlags(command *cobra.Command) (k3sSource, error) {
+	source := k3sSource{}
+	source.InstallURL, _ = command.Flags().GetString("k3s-install-url")
+	source.BinaryURL, _ = command.Flags().GetString("k3s-binary-url")
+	source.Mirror, _ = command.Flags().GetString("k3s-mirror")
+	source.BinaryPath, _ = command.Flags().GetString("k3s-binary-path")
+
+	if err := source.validate(); err != nil {
+		return k3sSource{}, err
+	}
+	return source, nil
+}
+
+func (s k3sSource) validate() error {
+	if len(s.InstallURL) > 0 && !path.IsAbs(s.InstallURL) && !isHTTPURL(s.InstallURL) {
+		return fmt.Errorf("--k3s-install-url must be a http or https URL or an absolute path on the node, got: %q", s.InstallURL)
+	}
+	if len(s.BinaryURL) > 0 && !isHTTPURL(s.BinaryURL) {
+		return fmt.Errorf("--k3s-binary-url must be a http or https URL, got: %q", s.BinaryURL)
+	}
+	if len(s.BinaryPath) > 0 {
+		if !path.IsAbs(s.BinaryPath) {
+			return fmt.Errorf("--k3s-binary-path must be an absolute path on the node, got: %q", s.BinaryPath)
+		}
+		if len(s.BinaryURL) > 0 || len(s.Mirror) > 0 {
+			return fmt.Errorf("--k3s-binary-path downloads nothing, so it cannot be used with --k3s-binary-url or --k3s-mirror")
+		}
+	}
+	for _, value := range []string{s.InstallURL, s.BinaryURL, s.Mirror, s.BinaryPath} {
+		if strings.ContainsAny(value, "'\n") {
+			return fmt.Errorf("k3s install sources must not contain quotes or newlines, got: %q", value)
+		}
+	}
+	return nil
+}
+
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+}
+
+// scriptCommand prints the installer script, which is piped to sh. A
+// staged binary is copied into place first.
+func (s k3sSource) scriptCommand(sudoPrefix string) string {
+	script := getScript
+	if path.IsAbs(s.InstallURL) {
+		script = fmt.Sprintf("cat '%s'", s.InstallURL)
+	} else if len(s.InstallURL) > 0 {
+		script = fmt.Sprintf("curl -sfL '%s'", s.InstallURL)
+	}
+
+	if len(s.BinaryPath) > 0 && s.BinaryPath != k3sBinary {
+		script = fmt.Sprintf("%sinstall -m 755 '%s' %s && %s", sudoPrefix, s.BinaryPath, k3sBinary, script)
+	}
+	return script
+}
+
+// withEnv adds the environment variables for the installer to installStr.
+func (s k3sSource) withEnv(installStr string) string {
+	env := []string{}
+	if len(installStr) > 0 {
+		env = append(env, installStr)
+	}
+	if len(s.BinaryURL) > 0 {
+		env = append(env, fmt.Sprintf("GITHUB_URL='%s'", strings.TrimSuffix(s.BinaryURL, "/")))
+	}
+	if len(s.Mirror) > 0 {
+		env = append(env, fmt.Sprintf("INSTALL_K3S_MIRROR='%s'", s.Mirror))
+	}
+	if len(s.BinaryPath) > 0 {
+		env = append(env, "INSTALL_K3S_SKIP_DOWNLOAD='true'")
+	}
+	return strings.Join(env, " ")
+}
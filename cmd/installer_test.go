@@ -0,0 +1,110 @@
+package cmd
+
+import "testing"
+
+func Test_k3sSource_validate(t *testing.T) {
+	tests := []struct {
+		title   string
+		source  k3sSource
+		wantErr bool
+	}{
+		{title: "defaults", source: k3sSource{}},
+		{title: "install script URL", source: k3sSource{InstallURL: "https://mirror.example.com/k3s/install.sh"}},
+		{title: "install script on the node", source: k3sSource{InstallURL: "/opt/k3s/install.sh", BinaryPath: "/opt/k3s/k3s"}},
+		{title: "mirror", source: k3sSource{Mirror: "cn"}},
+		{title: "relative install script", source: k3sSource{InstallURL: "install.sh"}, wantErr: true},
+		{title: "ftp binary URL", source: k3sSource{BinaryURL: "ftp://mirror.example.com/k3s"}, wantErr: true},
+		{title: "relative binary path", source: k3sSource{BinaryPath: "k3s"}, wantErr: true},
+		{title: "binary path with mirror", source: k3sSource{BinaryPath: "/opt/k3s/k3s", Mirror: "cn"}, wantErr: true},
+		{title: "quote in mirror", source: k3sSource{Mirror: "cn'; rm -rf /"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			err := test.source.validate()
+			if test.wantErr && err == nil {
+				t.Fatalf("want an error for %+v", test.source)
+			}
+			if !test.wantErr && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func Test_k3sSource_scriptCommand(t *testing.T) {
+	tests := []struct {
+		title  string
+		source k3sSource
+		want   string
+	}{
+		{title: "defaults", source: k3sSource{}, want: "curl -sfL https://get.k3s.io"},
+		{title: "install script URL", source: k3sSource{InstallURL: "https://mirror.example.com/install.sh"}, want: "curl -sfL 'https://mirror.example.com/install.sh'"},
+		{title: "offline with a staged binary", source: k3sSource{InstallURL: "/opt/k3s/install.sh", BinaryPath: "/opt/k3s/k3s"}, want: "sudo install -m 755 '/opt/k3s/k3s' /usr/local/bin/k3s && cat '/opt/k3s/install.sh'"},
+		{title: "binary already in place", source: k3sSource{BinaryPath: "/usr/local/bin/k3s"}, want: "curl -sfL https://get.k3s.io"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			got := test.source.scriptCommand("sudo ")
+			if got != test.want {
+				t.Errorf("want: %q, got: %q", test.want, got)
+			}
+		})
+	}
+}
+
+func Test_k3sSource_withEnv(t *testing.T) {
+	tests := []struct {
+		title      string
+		source     k3sSource
+		installStr string
+		want       string
+	}{
+		{title: "defaults", installStr: "INSTALL_K3S_VERSION='v1.19.1+k3s1'", want: "INSTALL_K3S_VERSION='v1.19.1+k3s1'"},
+		{title: "mirror", source: k3sSource{Mirror: "cn"}, installStr: "INSTALL_K3S_CHANNEL='stable'", want: "INSTALL_K3S_CHANNEL='stable' INSTALL_K3S_MIRROR='cn'"},
+		{title: "binary URL", source: k3sSource{BinaryURL: "https://mirror.example.com/k3s/releases/"}, want: "GITHUB_URL='https://mirror.example.com/k3s/releases'"},
+		{title: "staged binary", source: k3sSource{BinaryPath: "/opt/k3s/k3s"}, installStr: "INSTALL_K3S_VERSION='v1.19.1+k3s1'", want: "INSTALL_K3S_VERSION='v1.19.1+k3s1' INSTALL_K3S_SKIP_DOWNLOAD='true'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			got := test.source.withEnv(test.installStr)
+			if got != test.want {
+				t.Errorf("want: %q, got: %q", test.want, got)
+			}
+		})
+	}
+}
+
+func Test_validateDataDir(t *testing.T) {
+	cases := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"", false},
+		{"/mnt/data/k3s", false},
+		{"data/k3s", true},
+		{"/mnt/my data", true},
+		{"/mnt/'k3s'", true},
+	}
+
+	for _, c := range cases {
+		err := validateDataDir("--data-dir", c.value)
+		if c.wantErr && err == nil {
+			t.Errorf("want an error for %q", c.value)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("unexpected error for %q: %s", c.value, err)
+		}
+	}
+}
+
+func Test_serverDataPath(t *testing.T) {
+	if got, want := serverDataPath("", "node-token"), "/var/lib/rancher/k3s/server/node-token"; got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+	if got, want := serverDataPath("/mnt/data/k3s/", "tls/server-ca.crt"), "/mnt/data/k3s/server/tls/server-ca.crt"; got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
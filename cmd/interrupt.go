@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interruptContext returns a context which is cancelled by the first
+// SIGINT or SIGTERM, so that the command running on the node is stopped and
+// the connection closed. A second signal exits straight away, in case the
+// node does not respond. Call stop once the command has finished.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go watchInterrupts(signals, done, cancel, os.Exit)
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
+
+// watchInterrupts calls cancel on the first signal and exit on the second,
+// until done is closed.
+func watchInterrupts(signals <-chan os.Signal, done <-chan struct{}, cancel func(), exit func(int)) {
+	select {
+	case <-signals:
+	case <-done:
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "\nInterrupted, stopping the command on the node, press Ctrl-C again to exit now")
+	cancel()
+
+	select {
+	case <-signals:
+		fmt.Fprintln(os.Stderr, "Exiting without waiting for the node")
+		exit(130)
+	case <-done:
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_watchInterrupts(t *testing.T) {
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	cancelled := make(chan bool, 1)
+	exited := make(chan int, 1)
+
+	finished := make(chan bool)
+	go func() {
+		watchInterrupts(signals, done, func() { cancelled <- true }, func(code int) { exited <- code })
+		finished <- true
+	}()
+
+	signals <- os.Interrupt
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want the first signal to cancel")
+	}
+
+	signals <- os.Interrupt
+	select {
+	case code := <-exited:
+		if code != 130 {
+			t.Errorf("want exit code 130, got: %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want the second signal to exit")
+	}
+	<-finished
+}
+
+func Test_watchInterrupts_Done(t *testing.T) {
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+
+	finished := make(chan bool)
+	go func() {
+		watchInterrupts(signals, done, func() { t.Errorf("want no cancel") }, func(int) { t.Errorf("want no exit") })
+		finished <- true
+	}()
+
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want watchInterrupts to return once done is closed")
+	}
+}
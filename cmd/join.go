@@ -2,68 +2,176 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/alexellis/k3sup/pkg/install"
 	operator "github.com/alexellis/k3sup/pkg/operator"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"golang.org/x/crypto/ssh"
 )
 
 func MakeJoin() *cobra.Command {
 	var command = &cobra.Command{
-		Use:          "join",
-		Short:        "Install the k3s agent on a remote host and join it to an existing server",
-		Long:         `Install the k3s agent on a remote host and join it to an existing server`,
-		Example:      `  k3sup join --user root --server-ip 192.168.0.100 --ip 192.168.0.101`,
+		Use:   "join",
+		Short: "Install the k3s agent on a remote host and join it to an existing server",
+		Long:  `Install the k3s agent on a remote host and join it to an existing server`,
+		Example: `  k3sup join --user root --server-ip 192.168.0.100 --ip 192.168.0.101
+  k3sup join --user pi --ip 192.168.0.101 --server-ip 192.168.0.100 --server-user ubuntu --server-ssh-key ~/.ssh/server_rsa
+  k3sup join --server-ip 192.168.0.100 --hosts-file nodes.txt --parallel 10`,
 		SilenceUsage: true,
 	}
 
-	command.Flags().IP("server-ip", nil, "Public IP of existing k3s server")
-	command.Flags().IP("ip", nil, "Public IP of node on which to install agent")
+	command.Flags().String("server-ip", "", "Public IP of existing k3s server, or a Host alias from the SSH config")
+	command.Flags().String("server-discovery", "", "Find the server when --server-ip is not given, from a file listing candidate server IPs or an mDNS service name, i.e. _k3s._tcp.local, which is queried over IPv4")
+	command.Flags().String("ip", "", "Public IP of node on which to install agent, or a Host alias from the SSH config")
+	command.Flags().String("hosts-file", "", "Optional: file listing the nodes to join instead of --ip, one user@ip:port per line, the user and port default to --user and --ssh-port")
+	command.Flags().Int("parallel", 5, "Number of nodes from --hosts-file to join at once")
 
 	command.Flags().String("user", "root", "Username for SSH login")
 	command.Flags().String("server-user", "root", "Server username for SSH login (Default to --user)")
 
 	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().String("server-ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login to the server (Default to --ssh-key)")
 	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	addHostKeyFlags(command)
+	addSSHPasswordFlags(command)
+	addSSHTimeoutFlags(command)
+	addSSHProxyFlags(command)
+	addSSHConfigFlag(command)
 	command.Flags().Int("server-ssh-port", 22, "The port on which to connect to server for ssh (Default to --ssh-port)")
 	command.Flags().Bool("skip-install", false, "Skip the k3s installer")
 	command.Flags().Bool("sudo", true, "Use sudo for installation. e.g. set to false when using the root user and no sudo is available.")
+	command.Flags().String("sudo-binary", "sudo", "The binary used for privilege escalation with --sudo, i.e. doas")
+	addSudoPasswordFlags(command)
 
 	command.Flags().Bool("server", false, "Join the cluster as a server rather than as an agent")
+	command.Flags().Bool("force", false, "Join the agent even if it has already joined a cluster, which resets its node password")
 	command.Flags().Bool("print-command", false, "Print a command that you can use with SSH to manually recover from an error")
+	command.Flags().String("remote-shell-prefix", "", "Optional: command prepended to the commands run on the nodes, i.e. \"source /etc/profile &&\"")
 
 	command.Flags().String("k3s-extra-args", "", "Optional extra arguments to pass to k3s installer, wrapped in quotes (e.g. --k3s-extra-args '--node-taint key=value:NoExecute')")
+	command.Flags().StringArray("agent-arg", []string{}, "Optional: an argument for k3s agent, repeat for more. Each is passed as it is, so it may contain spaces, quotes or $")
+	command.Flags().StringArray("server-arg", []string{}, "Optional: an argument for k3s server with --server, repeat for more. Each is passed as it is, so it may contain spaces, quotes or $")
 	command.Flags().String("k3s-version", "", "Optional: set a version to install, overrides k3s-channel")
-	command.Flags().String("k3s-channel", "v1.18", "Optional release channel: stable, latest, or i.e. v1.18")
+	command.Flags().String("k3s-commit", "", "Optional: full hash of a k3s commit to install the build of, it must match the commit of the server (INSTALL_K3S_COMMIT), not used with --k3s-version or --k3s-channel")
+	command.Flags().String("k3s-channel", defaultK3sChannel, "Optional release channel: stable, latest, or i.e. v1.18, see k3sup list-channels")
+	command.Flags().String("channel-url", "", "Optional: URL of a self-hosted channel server used to resolve --k3s-channel (INSTALL_K3S_CHANNEL_URL)")
+	addPinChannelFlag(command)
+	addK3sSourceFlags(command)
+
+	command.Flags().String("vpn-auth", "", "Optional: VPN integration for k3s, i.e. \"name=tailscale,joinKey=<key>\", the joinKey is redacted in output")
+	addNodeIPFlags(command)
+	command.Flags().String("node-name", "", "Optional: name of the node in Kubernetes in place of its hostname, must be a DNS label such as agent-1, not used with --hosts-file")
+	addNodeLabelFlags(command)
+	addComponentArgFlags(command)
+	command.Flags().String("data-dir", "", "Optional: absolute path on the node for the state of k3s, defaults to "+defaultK3sDataDir)
+	command.Flags().Bool("secrets-encryption", false, "Encrypt secrets at rest with --server (k3s --secrets-encryption), every server of a cluster needs the same setting")
+	addHardeningFlags(command)
+	command.Flags().String("token", "", "Optional: token of the cluster, as set with --token on install, to join without reading the node-token from the server over SSH. It is redacted in output")
+	command.Flags().String("token-file", "", "Optional: file containing the token of the cluster, in place of --token")
+	command.Flags().Int("server-api-port", k3sAPIPort, "Optional: port the API server of the server listens on, as set with --api-port on install")
+	command.Flags().String("server-data-dir", "", "Optional: the --data-dir of the server, where its node-token is read from, defaults to "+defaultK3sDataDir)
+
+	command.Flags().Duration("server-ready-timeout", time.Minute, "Time to wait for the node-token and /readyz of the server, set to 0 to skip waiting")
+	command.Flags().Duration("server-ready-interval", 2*time.Second, "Interval between checks whilst waiting for the server")
+
+	addRegistryFlags(command)
+	addManifestFlag(command)
+
+	command.Flags().String("log-file", "", "Optional: append all output, including the output from the nodes, to a file with a timestamp on each line")
+	command.Flags().String("log-dir", "", "Optional: also append the output of the k3s installer on each node, and the error which ended its join, to a file of that node in this directory named after its IP, i.e. 192.168.0.101.log")
+	addMetricsFileFlag(command)
+	command.Flags().Bool("stream", false, "Print each line of output from the k3s installer as it runs, prefixed with the IP of the node, as --verbose does")
+
+	command.Flags().String("ca-hash", "", "Optional: expected CA hash of the cluster as printed by k3sup install, a warning is printed on mismatch")
 
 	command.RunE = func(command *cobra.Command, args []string) error {
-		fmt.Printf("Running: k3sup join\n")
+		logFile, _ := command.Flags().GetString("log-file")
+		if len(logFile) > 0 && !dryRunFromFlags(command) {
+			restore, err := teeOutputToFile(logFile)
+			if err != nil {
+				return err
+			}
+			defer restore()
+		}
 
-		ip, _ := command.Flags().GetIP("ip")
+		infof("Running: k3sup join\n")
 
-		serverIP, _ := command.Flags().GetIP("server-ip")
+		target, err := resolveSSHTarget(command, "ip")
+		if err != nil {
+			return err
+		}
+		ip := target.IP
+		hostsFile, _ := command.Flags().GetString("hosts-file")
+		if len(hostsFile) > 0 && ip != nil {
+			return fmt.Errorf("give either --ip or --hosts-file, not both")
+		}
+		if len(hostsFile) == 0 && ip == nil {
+			return fmt.Errorf("give a value for --ip or --hosts-file")
+		}
+		parallel, _ := command.Flags().GetInt("parallel")
+		if parallel < 1 {
+			return fmt.Errorf("--parallel must be at least 1")
+		}
+		logDir, _ := command.Flags().GetString("log-dir")
+		if len(logDir) > 0 && !dryRunFromFlags(command) {
+			if err := os.MkdirAll(logDir, 0700); err != nil {
+				return fmt.Errorf("unable to create --log-dir: %s", err)
+			}
+		}
 
-		fmt.Println("Server IP: " + serverIP.String())
+		serverTarget, err := resolveSSHTarget(command, "server-ip")
+		if err != nil {
+			return err
+		}
+		serverIP := serverTarget.IP
+
+		serverDiscovery, _ := command.Flags().GetString("server-discovery")
+		if serverIP == nil {
+			if len(serverDiscovery) == 0 {
+				return fmt.Errorf("give a value for --server-ip or --server-discovery")
+			}
+
+			discovered, err := discoverServer(serverDiscovery)
+			if err != nil {
+				return err
+			}
+			serverIP = discovered
+		}
+
+		infof("Server IP: %s\n", serverIP)
 
-		user, _ := command.Flags().GetString("user")
+		flagUser, _ := command.Flags().GetString("user")
+		user := withSSHConfig(command, "user", flagUser, target.User)
 
-		serverUser := user
-		if command.Flags().Changed("server-user") {
+		serverUser := withSSHConfig(command, "user", flagUser, serverTarget.User)
+		if flagGiven(command, "server-user") {
 			serverUser, _ = command.Flags().GetString("server-user")
 		}
 
-		sshKey, _ := command.Flags().GetString("ssh-key")
+		flagSSHKey, _ := command.Flags().GetString("ssh-key")
+		sshKey, identityFiles := withSSHConfigKeys(command, flagSSHKey, target)
+		serverSSHKey, serverIdentityFiles := withSSHConfigKeys(command, flagSSHKey, serverTarget)
+		if flagGiven(command, "server-ssh-key") {
+			serverSSHKey, _ = command.Flags().GetString("server-ssh-key")
+			serverIdentityFiles = nil
+		}
 		server, getServerErr := command.Flags().GetBool("server")
 		if getServerErr != nil {
 			return getServerErr
 		}
 
-		port, _ := command.Flags().GetInt("ssh-port")
-		serverPort := port
-		if command.Flags().Changed("server-ssh-port") {
+		flagPort, _ := command.Flags().GetInt("ssh-port")
+		port := withSSHConfigPort(command, "ssh-port", flagPort, target.Port)
+		serverPort := withSSHConfigPort(command, "ssh-port", flagPort, serverTarget.Port)
+		if flagGiven(command, "server-ssh-port") {
 			serverPort, _ = command.Flags().GetInt("server-ssh-port")
 		}
 
@@ -75,96 +183,334 @@ func MakeJoin() *cobra.Command {
 		if err != nil {
 			return err
 		}
+		k3sArgs, err := joinK3sArgsFromFlags(command)
+		if err != nil {
+			return err
+		}
 		k3sChannel, err := command.Flags().GetString("k3s-channel")
 		if err != nil {
 			return err
 		}
 
-		if len(k3sVersion) == 0 && len(k3sChannel) == 0 {
+		k3sCommit, err := k3sCommitFromFlags(command)
+		if err != nil {
+			return err
+		}
+		if len(k3sCommit) == 0 && len(k3sVersion) == 0 && len(k3sChannel) == 0 {
 			return fmt.Errorf("give a value for --k3s-version or --k3s-channel")
 		}
 
-		printCommand, err := command.Flags().GetBool("print-command")
+		vpnAuth, _ := command.Flags().GetString("vpn-auth")
+		if err := validateVPNAuth(vpnAuth); err != nil {
+			return err
+		}
+
+		nodeIP, nodeExternalIP, err := nodeIPsFromFlags(command)
 		if err != nil {
 			return err
 		}
+		nodeName, _ := command.Flags().GetString("node-name")
+		if err := validateNodeName(nodeName); err != nil {
+			return err
+		}
+		if len(nodeName) > 0 && len(hostsFile) > 0 {
+			return fmt.Errorf("--node-name cannot be used with --hosts-file, as every node would get the same name")
+		}
 
-		useSudo, err := command.Flags().GetBool("sudo")
+		nodeLabels, nodeTaints, err := nodeLabelsFromFlags(command)
 		if err != nil {
 			return err
 		}
-		sudoPrefix := ""
-		if useSudo {
-			sudoPrefix = "sudo "
+		kubeletArgs, kubeAPIServerArgs, err := componentArgsFromFlags(command)
+		if err != nil {
+			return err
+		}
+		if len(kubeAPIServerArgs) > 0 && !server {
+			return fmt.Errorf("--kube-apiserver-arg needs --server, agents do not run the kube-apiserver")
 		}
+		secretsEncryption, _ := command.Flags().GetBool("secrets-encryption")
+		if secretsEncryption && !server {
+			return fmt.Errorf("--secrets-encryption needs --server, agents do not store secrets")
+		}
+		hardening, err := hardeningFromFlags(command, server)
+		if err != nil {
+			return err
+		}
+		secretsEncryption = secretsEncryption || hardening.SecretsEncryption
 
-		sshKeyPath := expandPath(sshKey)
+		dataDir, _ := command.Flags().GetString("data-dir")
+		if err := validateDataDir("--data-dir", dataDir); err != nil {
+			return err
+		}
+		serverAPIPort, _ := command.Flags().GetInt("server-api-port")
+		if serverAPIPort < 1 || serverAPIPort > 65535 {
+			return fmt.Errorf("--server-api-port must be between 1 and 65535")
+		}
+		serverDataDir, _ := command.Flags().GetString("server-data-dir")
+		if err := validateDataDir("--server-data-dir", serverDataDir); err != nil {
+			return err
+		}
 
-		authMethod, closeSSHAgent, err := loadPublickey(sshKeyPath)
-		if err != nil {
-			return errors.Wrapf(err, "unable to load the ssh key with path %q", sshKeyPath)
+		if err := validateJoinExtraArgs(strings.Join(append([]string{k3sExtraArgs}, k3sArgs...), " "), nodeIP, nodeExternalIP, nodeName, dataDir, secretsEncryption); err != nil {
+			return err
+		}
+		if err := hardening.validateExtraArgs(strings.Join(append([]string{k3sExtraArgs}, k3sArgs...), " ")); err != nil {
+			return err
+		}
+
+		nodeArgs := append(install.VPNArgs(vpnAuth, nodeExternalIP), install.NodeLabelArgs(nodeLabels, nodeTaints)...)
+		if len(dataDir) > 0 {
+			nodeArgs = append(nodeArgs, fmt.Sprintf("--data-dir %s", dataDir))
+		}
+		if len(nodeIP) > 0 {
+			nodeArgs = append(nodeArgs, fmt.Sprintf("--node-ip %s", nodeIP))
+		}
+		if len(nodeName) > 0 {
+			nodeArgs = append(nodeArgs, fmt.Sprintf("--node-name %s", nodeName))
+		}
+		if secretsEncryption {
+			nodeArgs = append(nodeArgs, "--secrets-encryption")
+		}
+		nodeArgs = append(nodeArgs, hardening.k3sArgs()...)
+		if len(nodeArgs) > 0 {
+			k3sExtraArgs = strings.TrimSpace(strings.Join(nodeArgs, " ") + " " + k3sExtraArgs)
+		}
+		// The join command passes the extra args to the installer as
+		// arguments, so the quoted args can follow them. The component args
+		// are quoted too, as their values may contain < or >.
+		k3sArgs = append(k3sArgs, install.ComponentArgs(kubeletArgs, kubeAPIServerArgs)...)
+		if len(k3sArgs) > 0 {
+			k3sExtraArgs = strings.TrimSpace(k3sExtraArgs + " " + install.QuoteK3sArgs(k3sArgs))
 		}
 
-		defer closeSSHAgent()
+		channelURL, _ := command.Flags().GetString("channel-url")
+		if err := validateChannelURL(channelURL); err != nil {
+			return err
+		}
+		k3sVersion, err = versionFromFlags(command, k3sCommit, k3sVersion, k3sChannel, channelURL)
+		if err != nil {
+			return err
+		}
 
-		config := &ssh.ClientConfig{
-			User: serverUser,
-			Auth: []ssh.AuthMethod{
-				authMethod,
-			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		source, err := k3sSourceFromFlags(command)
+		if err != nil {
+			return err
 		}
 
-		address := fmt.Sprintf("%s:%d", serverIP.String(), serverPort)
-		operator, err := operator.NewSSHOperator(address, config)
+		installStr := source.withEnv(install.InstallEnv(k3sCommit, k3sVersion, k3sChannel, channelURL))
 
+		printCommand, err := command.Flags().GetBool("print-command")
 		if err != nil {
-			return errors.Wrapf(err, "unable to connect to (server) %s over ssh", address)
+			return err
 		}
 
-		defer operator.Close()
+		useSudo, err := command.Flags().GetBool("sudo")
+		if err != nil {
+			return err
+		}
+		sudoBinary, _ := command.Flags().GetString("sudo-binary")
+		sudoPrefix, err := makeSudoPrefix(useSudo, sudoBinary)
+		if err != nil {
+			return err
+		}
 
-		getTokenCommand := fmt.Sprintf(sudoPrefix + "cat /var/lib/rancher/k3s/server/node-token\n")
-		if printCommand {
-			fmt.Printf("ssh: %s\n", getTokenCommand)
+		shellPrefix, _ := command.Flags().GetString("remote-shell-prefix")
+		if err := validateShellPrefix(command, shellPrefix); err != nil {
+			return err
 		}
 
-		res, err := operator.Execute(getTokenCommand)
+		joinToken, err := tokenFromFlags(command)
+		if err != nil {
+			return err
+		}
+		expectedCAHash, _ := command.Flags().GetString("ca-hash")
+		if len(joinToken) > 0 && len(expectedCAHash) > 0 {
+			return fmt.Errorf("--ca-hash cannot be used with --token, as the server is not read over SSH, give the full K10 token for k3s to check the CA instead")
+		}
 
+		getTokenCommand := withShellPrefix(shellPrefix, sudoPrefix+"cat "+serverDataPath(serverDataDir, "node-token")+"\n")
+
+		registry, err := registryConfigFromFlags(command)
 		if err != nil {
-			return errors.Wrap(err, "unable to get join-token from server")
+			return err
+		}
+		manifests, err := manifestsFromFlags(command, dataDir)
+		if err != nil {
+			return err
+		}
+		if len(manifests.Files) > 0 && !server {
+			return fmt.Errorf("--manifest needs --server, only servers apply manifests")
 		}
 
-		if len(res.StdErr) > 0 {
-			fmt.Printf("Logs: %s", res.StdErr)
+		if dryRunFromFlags(command) {
+			hosts := []hostEntry{{IP: ip, User: user, Port: port}}
+			if len(hostsFile) > 0 {
+				hosts, err = parseHostsFile(hostsFile, user, port)
+				if err != nil {
+					return err
+				}
+			}
+
+			plan := dryRunPlan{}
+			planToken := joinToken
+			if len(planToken) == 0 {
+				planToken = "<node-token>"
+				plan.run(sshDestination(serverUser, serverIP.String(), serverPort), getTokenCommand)
+			}
+			for _, host := range hosts {
+				where := sshDestination(host.User, host.IP.String(), host.Port)
+				if len(joinToken) == 0 {
+					plan.note(where, "replace <node-token> with the output of the command on the server")
+				}
+				hardening.dryRun(&plan, where, sudoPrefix)
+				registry.dryRun(&plan, where, sudoPrefix)
+				manifests.dryRun(&plan, where, sudoPrefix)
+				plan.run(where, makeJoinInstallCommand(joinOptions{
+					ServerIP:      serverIP,
+					ServerAPIPort: serverAPIPort,
+					JoinToken:     planToken,
+					ExtraArgs:     k3sExtraArgs,
+					InstallStr:    installStr,
+					Source:        source,
+					SudoPrefix:    sudoPrefix,
+					ShellPrefix:   shellPrefix,
+				}, server))
+			}
+			plan.print(os.Stdout)
+			return nil
 		}
 
-		closeSSHAgent()
-		operator.Close()
+		sshKeyPath := expandPath(sshKey)
+		serverSSHKeyPath := expandPath(serverSSHKey)
+
+		sshOpts, err := sshOptionsFromFlags(command, target.ProxyJump)
+		if err != nil {
+			return err
+		}
+		defer sshOpts.zero()
+		sshOpts.IdentityFiles = identityFiles
+
+		// With a token the node joins without the server being read over
+		// SSH, so the server need not be reachable from here.
+		if len(joinToken) == 0 {
+			serverSSHOpts := sshOpts
+			serverSSHOpts.IdentityFiles = serverIdentityFiles
+			serverSSHOpts.Proxy, err = sshProxyFromFlags(command, serverTarget.ProxyJump)
+			if err != nil {
+				return err
+			}
+
+			address := net.JoinHostPort(serverIP.String(), strconv.Itoa(serverPort))
+			operator, err := connectNode(address, serverUser, serverSSHKeyPath, serverSSHOpts)
+			if err != nil {
+				return err
+			}
+
+			if err := checkSudo(operator, sudoPrefix); err != nil {
+				return err
+			}
+
+			serverReadyTimeout, _ := command.Flags().GetDuration("server-ready-timeout")
+			serverReadyInterval, _ := command.Flags().GetDuration("server-ready-interval")
+			serverArtifacts := []serverArtifact{nodeTokenArtifact(sudoPrefix, serverDataDir), readyzArtifact(sudoPrefix)}
+			if err := waitForServer(operator, serverArtifacts, serverReadyTimeout, serverReadyInterval); err != nil {
+				return err
+			}
+
+			if printCommand {
+				infof("ssh: %s\n", getTokenCommand)
+			}
+
+			res, err := operator.Execute(getTokenCommand)
+
+			if err != nil {
+				return errors.Wrap(withStderr(err, res), "unable to get join-token from server")
+			}
+
+			// Only stderr, stdout is the join token.
+			if len(res.StdErr) > 0 {
+				debugf("Join token stderr: %q\n", res.StdErr)
+			}
+
+			joinToken = string(res.StdOut)
+
+			if len(expectedCAHash) > 0 {
+				getCACommand := withShellPrefix(shellPrefix, sudoPrefix+"cat "+serverDataPath(serverDataDir, "tls/server-ca.crt")+"\n")
+				if printCommand {
+					infof("ssh: %s\n", getCACommand)
+				}
+
+				caRes, err := operator.Execute(getCACommand)
+				if err != nil {
+					return errors.Wrap(err, "unable to get CA certificate from server")
+				}
+
+				if hash := caHash(caRes.StdOut); hash != expectedCAHash {
+					warnf("CA hash of server %s is %s, expected %s. The server may not belong to the expected cluster.\n", serverIP.String(), hash, expectedCAHash)
+				} else {
+					infof("CA hash: %s\n", hash)
+				}
+			}
+
+			operator.Close()
+		}
 
-		joinToken := string(res.StdOut)
+		force, _ := command.Flags().GetBool("force")
+		metricsFile, _ := command.Flags().GetString("metrics-file")
+		stream, _ := command.Flags().GetBool("stream")
+
+		options := joinOptions{
+			ServerIP:      serverIP,
+			ServerAPIPort: serverAPIPort,
+			IP:            ip,
+			Port:          port,
+			User:          user,
+			SSHKeyPath:    sshKeyPath,
+			SSH:           sshOpts,
+			JoinToken:     joinToken,
+			ExtraArgs:     k3sExtraArgs,
+			InstallStr:    installStr,
+			Source:        source,
+			SudoPrefix:    sudoPrefix,
+			ShellPrefix:   shellPrefix,
+			Hardening:     hardening,
+			Registry:      registry,
+			Manifests:     manifests,
+			PrintCommand:  printCommand,
+			Force:         force,
+			Stream:        streamOutput(stream),
+		}
 
-		var boostrapErr error
-		if server {
-			boostrapErr = setupAdditionalServer(serverIP, ip, port, user, sshKeyPath, joinToken, k3sExtraArgs, k3sVersion, k3sChannel, printCommand)
-		} else {
-			boostrapErr = setupAgent(serverIP, ip, port, user, sshKeyPath, joinToken, k3sExtraArgs, k3sVersion, k3sChannel, printCommand)
+		if len(hostsFile) > 0 {
+			hosts, err := parseHostsFile(hostsFile, user, port)
+			if err != nil {
+				return err
+			}
+
+			results := runParallel(hosts, parallel, func(host hostEntry) error {
+				hostOptions := options
+				hostOptions.IP = host.IP
+				hostOptions.User = host.User
+				hostOptions.Port = host.Port
+				return joinNode(hostOptions, server, logDir)
+			})
+
+			metrics := []installMetric{}
+			for _, result := range results {
+				metrics = append(metrics, installMetric{Node: result.Host.IP.String(), Duration: result.Duration, Success: result.Err == nil})
+			}
+			recordInstallMetrics(metricsFile, metrics)
+			return printHostResults(results)
 		}
 
+		start := time.Now()
+		boostrapErr := joinNode(options, server, logDir)
+
+		recordInstallMetrics(metricsFile, []installMetric{{Node: ip.String(), Duration: time.Since(start), Success: boostrapErr == nil}})
 		return boostrapErr
 	}
 
 	command.PreRunE = func(command *cobra.Command, args []string) error {
-		_, ipErr := command.Flags().GetIP("ip")
-		if ipErr != nil {
-			return ipErr
-		}
-
-		_, ipErr = command.Flags().GetIP("server-ip")
-		if ipErr != nil {
-			return ipErr
-		}
-
 		_, sshPortErr := command.Flags().GetInt("ssh-port")
 		if sshPortErr != nil {
 			return sshPortErr
@@ -172,146 +518,222 @@ func MakeJoin() *cobra.Command {
 		return nil
 	}
 
+	supportDryRun(command)
+
 	return command
 }
 
-func setupAdditionalServer(serverIP, ip net.IP, port int, user, sshKeyPath, joinToken, k3sExtraArgs, k3sVersion, k3sChannel string, printCommand bool) error {
+// joinOptions describe how to install k3s on a node joining the cluster
+// of the server at ServerIP.
+type joinOptions struct {
+	ServerIP      net.IP
+	ServerAPIPort int
+	IP            net.IP
+	Port          int
+	User          string
+	SSHKeyPath    string
+	SSH           sshOptions
+	JoinToken     string
+	ExtraArgs     string
+	InstallStr    string
+	Source        k3sSource
+	SudoPrefix    string
+	ShellPrefix   string
+	Hardening     hardening
+	Registry      registryConfig
+	Manifests     manifestConfig
+	PrintCommand  bool
+	Force         bool
+	// Stream prints the output of the installer as it runs, each line
+	// prefixed with the IP of the node, so that the nodes of --hosts-file
+	// can be told apart.
+	Stream bool
+	// Log is the file of the node in --log-dir, which the output of the
+	// installer is written to, nil without --log-dir.
+	Log io.Writer
+}
 
-	authMethod, closeSSHAgent, err := loadPublickey(sshKeyPath)
-	if err != nil {
-		return errors.Wrapf(err, "unable to load the ssh key with path %q", sshKeyPath)
+// joinNode runs setupNode with the file of the node in logDir, when given,
+// as options.Log. The error which ended the join is written to it too.
+func joinNode(options joinOptions, serverAgent bool, logDir string) error {
+	if len(logDir) == 0 {
+		return setupNode(options, serverAgent)
 	}
 
-	defer closeSSHAgent()
-
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			authMethod,
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	logFile, err := openNodeLogFile(logDir, options.IP.String())
+	if err != nil {
+		return err
 	}
+	defer logFile.Close()
 
-	address := fmt.Sprintf("%s:%d", ip.String(), port)
-	operator, err := operator.NewSSHOperator(address, config)
-
+	options.Log = logFile
+	err = setupNode(options, serverAgent)
 	if err != nil {
-		return errors.Wrapf(err, "unable to connect to %s over ssh as %s", address, user)
+		fmt.Fprintln(logFile, "Error:", RedactError(err))
 	}
+	return err
+}
 
-	installStr := createVersionStr(k3sVersion, k3sChannel)
-	serverAgent := true
-
-	defer operator.Close()
-
-	installk3sExec := makeJoinExec(
-		serverIP.String(),
-		strings.TrimSpace(joinToken),
-		installStr,
-		k3sExtraArgs,
-		serverAgent,
-	)
-
-	installAgentServerCommand := fmt.Sprintf("%s | %s", getScript, installk3sExec)
-
-	if printCommand {
-		fmt.Printf("ssh: %s\n", installAgentServerCommand)
+func setupNode(options joinOptions, serverAgent bool) error {
+	role := "agent"
+	if serverAgent {
+		role = "server"
 	}
 
-	res, err := operator.Execute(installAgentServerCommand)
+	address := net.JoinHostPort(options.IP.String(), strconv.Itoa(options.Port))
+	operator, err := connectNode(address, options.User, options.SSHKeyPath, options.SSH)
 	if err != nil {
-		return errors.Wrap(err, "unable to setup agent")
-	}
-
-	if len(res.StdErr) > 0 {
-		fmt.Printf("Logs: %s", res.StdErr)
+		return err
 	}
 
-	joinRes := string(res.StdOut)
-	fmt.Printf("Output: %s", string(joinRes))
+	defer operator.Close()
 
-	return nil
-}
+	if !serverAgent && !options.Force {
+		joined, err := agentJoined(operator, options.SudoPrefix)
+		if err != nil {
+			return err
+		}
+		if joined {
+			infof("The k3s agent on %s has already joined a cluster, skipping. Use --force to join it again.\n", options.IP.String())
+			return nil
+		}
+	}
 
-func setupAgent(serverIP, ip net.IP, port int, user, sshKeyPath, joinToken, k3sExtraArgs, k3sVersion, k3sChannel string, printCommand bool) error {
+	printOSInfo(operator)
 
-	authMethod, closeSSHAgent, err := loadPublickey(sshKeyPath)
-	if err != nil {
-		return errors.Wrapf(err, "unable to load the ssh key with path %q", sshKeyPath)
+	if err := options.Hardening.apply(operator, options.IP.String(), options.SudoPrefix); err != nil {
+		return err
+	}
+	if err := options.Registry.apply(operator, options.IP.String(), role); err != nil {
+		return err
+	}
+	if err := options.Manifests.apply(operator); err != nil {
+		return err
 	}
 
-	defer closeSSHAgent()
+	installCommand := makeJoinInstallCommand(options, serverAgent)
 
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			authMethod,
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	if options.PrintCommand {
+		infof("ssh: %s\n", redactSecrets(installCommand))
 	}
 
-	address := fmt.Sprintf("%s:%d", ip.String(), port)
-	operator, err := operator.NewSSHOperator(address, config)
-
+	_, err = runInstaller(operator, installCommand, options.IP.String(), options.Stream, options.Log)
 	if err != nil {
-		return errors.Wrapf(err, "unable to connect to %s over ssh", address)
+		return errors.Wrapf(err, "unable to setup %s", role)
 	}
 
-	defer operator.Close()
-
-	installStr := createVersionStr(k3sVersion, k3sChannel)
-
-	serverAgent := false
+	return nil
+}
 
+func makeJoinInstallCommand(options joinOptions, serverAgent bool) string {
 	installK3sExec := makeJoinExec(
-		serverIP.String(),
-		strings.TrimSpace(joinToken),
-		installStr,
-		k3sExtraArgs,
+		options.ServerIP.String(),
+		options.ServerAPIPort,
+		strings.TrimSpace(options.JoinToken),
+		options.InstallStr,
+		options.ExtraArgs,
 		serverAgent,
 	)
 
-	installAgentCommand := fmt.Sprintf("%s | %s", getScript, installK3sExec)
+	return withShellPrefix(options.ShellPrefix, fmt.Sprintf("%s | %s", options.Source.scriptCommand(options.SudoPrefix), installK3sExec))
+}
 
-	if printCommand {
-		fmt.Printf("ssh: %s\n", installAgentCommand)
+// agentJoined reports whether the k3s agent is running and has registered
+// with a server, in which case joining again would reset its password.
+func agentJoined(operator operator.CommandOperator, sudoPrefix string) (bool, error) {
+	res, err := operator.Execute(fmt.Sprintf("if systemctl is-active --quiet k3s-agent && %stest -f /etc/rancher/node/password; then echo joined; fi", sudoPrefix))
+	if err != nil {
+		return false, fmt.Errorf("error received checking the k3s agent: %s", err)
 	}
+	return strings.TrimSpace(string(res.StdOut)) == "joined", nil
+}
 
-	res, err := operator.Execute(installAgentCommand)
+var k3sCommitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
 
-	if err != nil {
-		return errors.Wrap(err, "unable to setup agent")
+// k3sCommitFromFlags returns the --k3s-commit to install, the installer
+// only downloads the build of a commit by its full hash. A commit replaces
+// the version and channel, so they cannot be given with it.
+func k3sCommitFromFlags(command *cobra.Command) (string, error) {
+	commit, _ := command.Flags().GetString("k3s-commit")
+	if len(commit) == 0 {
+		return "", nil
 	}
 
-	if len(res.StdErr) > 0 {
-		fmt.Printf("Logs: %s", res.StdErr)
+	if !k3sCommitPattern.MatchString(commit) {
+		return "", fmt.Errorf("--k3s-commit must be the full 40 character hash of a k3s commit in lower case, got: %q", commit)
 	}
+	for _, flag := range []string{"k3s-version", "k3s-channel", "channel-url"} {
+		if flagGiven(command, flag) {
+			return "", fmt.Errorf("give either --k3s-commit or --%s, not both", flag)
+		}
+	}
+	return commit, nil
+}
 
-	joinRes := string(res.StdOut)
-	fmt.Printf("Output: %s", string(joinRes))
+func validateChannelURL(channelURL string) error {
+	if len(channelURL) == 0 {
+		return nil
+	}
 
+	u, err := url.Parse(channelURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("--channel-url must be a http or https URL, got: %q", channelURL)
+	}
 	return nil
 }
 
-func createVersionStr(k3sVersion, k3sChannel string) string {
-	installStr := ""
-	if len(k3sVersion) > 0 {
-		installStr = fmt.Sprintf("INSTALL_K3S_VERSION='%s'", k3sVersion)
-	} else {
-		installStr = fmt.Sprintf("INSTALL_K3S_CHANNEL='%s'", k3sChannel)
+// joinK3sArgsFromFlags returns the --server-arg values when joining a server
+// and the --agent-arg values when joining an agent.
+func joinK3sArgsFromFlags(command *cobra.Command) ([]string, error) {
+	server, _ := command.Flags().GetBool("server")
+	agentArgs, _ := command.Flags().GetStringArray("agent-arg")
+	serverArgs, _ := command.Flags().GetStringArray("server-arg")
+
+	if server {
+		if len(agentArgs) > 0 {
+			return nil, fmt.Errorf("--agent-arg cannot be used with --server, use --server-arg")
+		}
+		return serverArgs, validateK3sArgs("--server-arg", serverArgs)
+	}
+	if len(serverArgs) > 0 {
+		return nil, fmt.Errorf("--server-arg needs --server, use --agent-arg to join an agent")
+	}
+	return agentArgs, validateK3sArgs("--agent-arg", agentArgs)
+}
+
+// validateJoinExtraArgs rejects the flags of the node which are also given
+// in --k3s-extra-args or the k3s args, as k3s would be started with two
+// values.
+func validateJoinExtraArgs(extraArgs, nodeIP, nodeExternalIP, nodeName, dataDir string, secretsEncryption bool) error {
+	conflicts := []struct {
+		set  bool
+		flag string
+	}{
+		{len(nodeIP) > 0, "--node-ip"},
+		{len(nodeExternalIP) > 0, "--node-external-ip"},
+		{len(nodeName) > 0, "--node-name"},
+		{len(dataDir) > 0, "--data-dir"},
+		{secretsEncryption, "--secrets-encryption"},
 	}
-	return installStr
+	for _, conflict := range conflicts {
+		if conflict.set && install.HasK3sArg(extraArgs, conflict.flag) {
+			return fmt.Errorf("%s cannot be used together with %s in --k3s-extra-args, --agent-arg or --server-arg", conflict.flag, conflict.flag)
+		}
+	}
+	return nil
 }
 
-func makeJoinExec(serverIP, joinToken, installStr, k3sExtraArgs string, serverAgent bool) string {
+func makeJoinExec(serverIP string, serverAPIPort int, joinToken, installStr, k3sExtraArgs string, serverAgent bool) string {
+
+	serverURL := "https://" + net.JoinHostPort(serverIP, strconv.Itoa(serverAPIPort))
 
 	installEnvVar := []string{}
-	installEnvVar = append(installEnvVar, fmt.Sprintf("K3S_URL='https://%s:6443'", serverIP))
+	installEnvVar = append(installEnvVar, fmt.Sprintf("K3S_URL='%s'", serverURL))
 	installEnvVar = append(installEnvVar, fmt.Sprintf("K3S_TOKEN='%s'", joinToken))
 	installEnvVar = append(installEnvVar, installStr)
 
 	if serverAgent {
-		installEnvVar = append(installEnvVar, fmt.Sprintf("INSTALL_K3S_EXEC='server --server https://%s:6443'", serverIP))
+		installEnvVar = append(installEnvVar, fmt.Sprintf("INSTALL_K3S_EXEC='server --server %s'", serverURL))
 	}
 
 	joinExec := strings.Join(installEnvVar, " ")
@@ -1,7 +1,14 @@
 package cmd
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
 )
 
 type test struct {
@@ -38,7 +45,7 @@ func Test_makeJoinServerExec(t *testing.T) {
 	}
 	for _, tc := range tests {
 		t.Run(tc.title, func(t *testing.T) {
-			got := makeJoinExec(tc.serverIP, tc.joinToken, tc.installStr, tc.k3sExtraArgs, tc.serverAgent)
+			got := makeJoinExec(tc.serverIP, k3sAPIPort, tc.joinToken, tc.installStr, tc.k3sExtraArgs, tc.serverAgent)
 
 			if got != tc.installk3sExec {
 				t.Errorf("want: %s, got: %s", tc.installk3sExec, got)
@@ -68,11 +75,20 @@ func Test_makeJoinAgentExec(t *testing.T) {
 			k3sExtraArgs:   "--node-taint key=value:NoExecute",
 			serverAgent:    false,
 		},
+		{
+			title:          "Join Agent to an IPv6 server",
+			serverIP:       "2001:db8::10",
+			joinToken:      "K10c8bc21f68fef3f56d431a08df2e894481ab0a61a3c84cbd639b56449ad15523c::server:9d30861e1ba54177b8e4dd1426076e5d",
+			installStr:     "INSTALL_K3S_VERSION=1.18",
+			installk3sExec: "K3S_URL='https://[2001:db8::10]:6443' K3S_TOKEN='K10c8bc21f68fef3f56d431a08df2e894481ab0a61a3c84cbd639b56449ad15523c::server:9d30861e1ba54177b8e4dd1426076e5d' INSTALL_K3S_VERSION=1.18 sh -s - --node-ip 2001:db8::11",
+			k3sExtraArgs:   "--node-ip 2001:db8::11",
+			serverAgent:    false,
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.title, func(t *testing.T) {
-			got := makeJoinExec(tc.serverIP, tc.joinToken, tc.installStr, tc.k3sExtraArgs, tc.serverAgent)
+			got := makeJoinExec(tc.serverIP, k3sAPIPort, tc.joinToken, tc.installStr, tc.k3sExtraArgs, tc.serverAgent)
 
 			if got != tc.installk3sExec {
 				t.Errorf("want: %s, got: %s", tc.installk3sExec, got)
@@ -80,3 +96,242 @@ func Test_makeJoinAgentExec(t *testing.T) {
 		})
 	}
 }
+
+func Test_makeJoinExec_ServerAPIPort(t *testing.T) {
+	got := makeJoinExec("172.27.251.164", 7443, "token", "INSTALL_K3S_VERSION=1.18", "", true)
+	want := "K3S_URL='https://172.27.251.164:7443' K3S_TOKEN='token' INSTALL_K3S_VERSION=1.18 INSTALL_K3S_EXEC='server --server https://172.27.251.164:7443' sh -s -"
+	if got != want {
+		t.Errorf("want: %s, got: %s", want, got)
+	}
+}
+
+func Test_k3sCommitFromFlags(t *testing.T) {
+	commit := "3a8f1b9c2d4e5f60718293a4b5c6d7e8f9012345"
+	cases := []struct {
+		title   string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			title: "No commit",
+			args:  []string{"--k3s-version", "v1.19.1+k3s1"},
+		},
+		{
+			title: "Commit with the default channel",
+			args:  []string{"--k3s-commit", commit},
+			want:  commit,
+		},
+		{
+			title:   "Short hash",
+			args:    []string{"--k3s-commit", "3a8f1b9"},
+			wantErr: "--k3s-commit must be the full 40 character hash",
+		},
+		{
+			title:   "Upper case hash",
+			args:    []string{"--k3s-commit", strings.ToUpper(commit)},
+			wantErr: "--k3s-commit must be the full 40 character hash",
+		},
+		{
+			title:   "Commit and version",
+			args:    []string{"--k3s-commit", commit, "--k3s-version", "v1.19.1+k3s1"},
+			wantErr: "give either --k3s-commit or --k3s-version, not both",
+		},
+		{
+			title:   "Commit and channel",
+			args:    []string{"--k3s-commit", commit, "--k3s-channel", "stable"},
+			wantErr: "give either --k3s-commit or --k3s-channel, not both",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			command := MakeInstall()
+			if err := command.ParseFlags(c.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := k3sCommitFromFlags(command)
+			if len(c.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("want error containing %q, got: %v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != c.want {
+				t.Errorf("want: %q, got: %q", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_validateChannelURL(t *testing.T) {
+	for _, valid := range []string{"", "http://10.0.0.1:8080/channels", "https://channels.internal/v1-release/channels"} {
+		if err := validateChannelURL(valid); err != nil {
+			t.Errorf("want %q to be valid, got: %s", valid, err)
+		}
+	}
+
+	for _, invalid := range []string{"channels.internal", "ftp://channels.internal", "https://"} {
+		if err := validateChannelURL(invalid); err == nil {
+			t.Errorf("want %q to be invalid", invalid)
+		}
+	}
+}
+
+func Test_validateJoinExtraArgs(t *testing.T) {
+	if err := validateJoinExtraArgs("--node-label zone=a", "10.0.0.10", "203.0.113.10", "agent-1", "/srv/k3s", true); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := validateJoinExtraArgs("--node-ip 10.0.0.11", "", "", "", "", false); err != nil {
+		t.Errorf("unexpected error for a --node-ip only in the extra args: %s", err)
+	}
+
+	for _, extraArgs := range []string{"--node-ip 10.0.0.11", "--node-external-ip=203.0.113.11", "--node-name agent-2", "--data-dir /mnt/k3s", "--secrets-encryption"} {
+		if err := validateJoinExtraArgs(extraArgs, "10.0.0.10", "203.0.113.10", "agent-1", "/srv/k3s", true); err == nil {
+			t.Errorf("want an error for %q", extraArgs)
+		}
+	}
+}
+
+func Test_joinK3sArgsFromFlags(t *testing.T) {
+	cases := []struct {
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{args: []string{"--agent-arg", "--node-label=a b"}, want: []string{"--node-label=a b"}},
+		{args: []string{"--server", "--server-arg", "--node-label=a", "--server-arg", "it's"}, want: []string{"--node-label=a", "it's"}},
+		{args: []string{"--server-arg", "--node-label=a"}, wantErr: true},
+		{args: []string{"--server", "--agent-arg", "--node-label=a"}, wantErr: true},
+		{args: []string{"--agent-arg", " "}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		command := MakeJoin()
+		if err := command.ParseFlags(c.args); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := joinK3sArgsFromFlags(command)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("want an error for %q", c.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", c.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("want: %q, got: %q", c.want, got)
+		}
+	}
+}
+
+func Test_MakeJoin_TokenSkipsServer(t *testing.T) {
+	command := MakeJoin()
+	command.Flags().Bool("dry-run", true, "")
+	command.Flags().Set("ip", "192.168.0.101")
+	command.Flags().Set("server-ip", "192.168.0.100")
+	command.Flags().Set("token", "a-long-enough-secret")
+
+	out := captureStdout(t, logInfo, func() {
+		if err := command.RunE(command, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	if strings.Contains(out, "node-token") || strings.Contains(out, "@192.168.0.100") {
+		t.Errorf("want no read of the node-token from the server, got:\n%s", out)
+	}
+	if !strings.Contains(out, "K3S_TOKEN='<redacted>'") {
+		t.Errorf("want the token redacted in the join command, got:\n%s", out)
+	}
+}
+
+func Test_MakeJoin_SecretsEncryption(t *testing.T) {
+	command := MakeJoin()
+	command.Flags().Bool("dry-run", true, "")
+	command.Flags().Set("ip", "192.168.0.101")
+	command.Flags().Set("server-ip", "192.168.0.100")
+	command.Flags().Set("token", "a-long-enough-secret")
+	command.Flags().Set("secrets-encryption", "true")
+
+	err := command.RunE(command, nil)
+	if err == nil || !strings.Contains(err.Error(), "--secrets-encryption needs --server") {
+		t.Fatalf("want an error for an agent, got: %v", err)
+	}
+
+	command.Flags().Set("server", "true")
+	out := captureStdout(t, logInfo, func() {
+		if err := command.RunE(command, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	if !strings.Contains(out, "--secrets-encryption") {
+		t.Errorf("want --secrets-encryption in the join command, got:\n%s", out)
+	}
+}
+
+func Test_MakeJoin_TokenWithCAHash(t *testing.T) {
+	command := MakeJoin()
+	command.Flags().Set("ip", "192.168.0.101")
+	command.Flags().Set("server-ip", "192.168.0.100")
+	command.Flags().Set("token", "a-long-enough-secret")
+	command.Flags().Set("ca-hash", "abc")
+
+	if err := command.RunE(command, nil); err == nil || !strings.Contains(err.Error(), "--ca-hash cannot be used with --token") {
+		t.Errorf("want error for --ca-hash with --token, got: %v", err)
+	}
+}
+
+func Test_MakeJoin_HostsFilePrefixesOutput(t *testing.T) {
+	node := &operator.FakeOperator{Reply: func(command string) (operator.CommandRes, error) {
+		if strings.Contains(command, "get.k3s.io") {
+			return operator.CommandRes{StdOut: []byte("[INFO]  Using v1.19.1+k3s1 as release\n")}, nil
+		}
+		return operator.CommandRes{}, nil
+	}}
+	defer fakeNode(node)()
+
+	dir, err := ioutil.TempDir("", "k3sup-join")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hostsFile := filepath.Join(dir, "nodes.txt")
+	if err := ioutil.WriteFile(hostsFile, []byte("192.168.0.101\n192.168.0.102\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	command := MakeJoin()
+	command.Flags().Set("hosts-file", hostsFile)
+	command.Flags().Set("server-ip", "192.168.0.100")
+	command.Flags().Set("token", "a-long-enough-secret")
+
+	out := captureStdout(t, logDebug, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, want := range []string{"[192.168.0.101] [INFO]  Using v1.19.1+k3s1 as release\n", "[192.168.0.102] [INFO]  Using v1.19.1+k3s1 as release\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q, got:\n%s", want, out)
+		}
+	}
+
+	out = captureStdout(t, logInfo, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(out, "Using v1.19.1+k3s1") {
+		t.Errorf("want no output of the installer without --verbose or --stream, got:\n%s", out)
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const openSSHKeyMagic = "openssh-key-v1\x00"
+
+// readPrivateKey reads the key at path, with a hint when it cannot be read
+// because of its permissions. Permissions which ssh would refuse as too
+// open are only warned about.
+func readPrivateKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsPermission(err) {
+			return nil, fmt.Errorf("unable to read file: %s, %s. Check that the key is owned by the current user, i.e. chmod 600 %s", path, err, path)
+		}
+		return nil, fmt.Errorf("unable to read file: %s, %s", path, err)
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+		warnf("permissions %04o for %s are too open, ssh would refuse to use this key, run: chmod 600 %s\n", info.Mode().Perm(), path, path)
+	}
+	return key, nil
+}
+
+// parsePrivateKeyWithPassphrase decrypts key, which may be RSA, ECDSA or
+// ed25519 in the PEM or OpenSSH format.
+func parsePrivateKeyWithPassphrase(key, passphrase []byte) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+	if err != nil {
+		if err == x509.IncorrectPasswordError {
+			return nil, fmt.Errorf("parse private key with passphrase failed: incorrect passphrase for %s", describePrivateKey(key))
+		}
+		return nil, fmt.Errorf("parse private key with passphrase failed: %s (%s)", err, describePrivateKey(key))
+	}
+	return signer, nil
+}
+
+// describePrivateKey names the format and type of key for error messages,
+// so that users can tell why it could not be parsed.
+func describePrivateKey(key []byte) string {
+	trimmed := bytes.TrimSpace(key)
+	switch {
+	case len(trimmed) == 0:
+		return "the key file is empty"
+	case bytes.HasPrefix(trimmed, []byte("PuTTY-User-Key-File")):
+		return "a PuTTY key, convert it to the OpenSSH format with: puttygen key.ppk -O private-openssh -o id_rsa"
+	case bytes.HasPrefix(trimmed, []byte("ssh-")) || bytes.HasPrefix(trimmed, []byte("ecdsa-")):
+		return "a public key, give the path of the private key instead"
+	}
+
+	block, _ := pem.Decode(trimmed)
+	if block == nil {
+		return "no PEM block found, the file does not look like a private key"
+	}
+
+	if block.Type != "OPENSSH PRIVATE KEY" {
+		if _, encrypted := block.Headers["Proc-Type"]; encrypted {
+			return fmt.Sprintf("an encrypted PEM key with header %q", block.Type)
+		}
+		return fmt.Sprintf("a PEM key with header %q", block.Type)
+	}
+
+	if !bytes.HasPrefix(block.Bytes, []byte(openSSHKeyMagic)) {
+		return "an OpenSSH key with an invalid header"
+	}
+
+	var header struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}
+	if err := ssh.Unmarshal(block.Bytes[len(openSSHKeyMagic):], &header); err != nil {
+		return "an OpenSSH key which could not be decoded"
+	}
+
+	keyType := "unknown type"
+	if publicKey, err := ssh.ParsePublicKey(header.PubKey); err == nil {
+		keyType = publicKey.Type()
+	}
+	if header.CipherName != "none" {
+		return fmt.Sprintf("an OpenSSH %s key encrypted with %s", keyType, header.CipherName)
+	}
+	return fmt.Sprintf("an OpenSSH %s key", keyType)
+}
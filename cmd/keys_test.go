@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The keys in testdata/keys were generated with ssh-keygen, i.e.
+// ssh-keygen -t ed25519 -N k3sup -f ed25519_openssh_encrypted
+// apart from ed25519_pkcs8: openssl genpkey -algorithm ed25519
+func Test_loadSigners_KeyTypes(t *testing.T) {
+	keys := []string{"rsa_pem", "rsa_openssh", "ecdsa_pem", "ecdsa_openssh", "ed25519_openssh", "ed25519_pkcs8"}
+
+	for _, name := range keys {
+		t.Run(name, func(t *testing.T) {
+			signers, closeSSHAgent, err := loadSigners(filepath.Join("testdata", "keys", name), nil)
+			defer closeSSHAgent()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if keys, err := signers(); err != nil || len(keys) == 0 {
+				t.Fatalf("want a signer, got: %v", err)
+			}
+		})
+	}
+}
+
+func Test_parsePrivateKeyWithPassphrase(t *testing.T) {
+	keys := map[string]string{
+		"rsa_openssh_encrypted":     ssh.KeyAlgoRSA,
+		"ecdsa_openssh_encrypted":   ssh.KeyAlgoECDSA256,
+		"ed25519_openssh_encrypted": ssh.KeyAlgoED25519,
+	}
+
+	for name, keyType := range keys {
+		t.Run(name, func(t *testing.T) {
+			key, err := ioutil.ReadFile(filepath.Join("testdata", "keys", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ssh.ParsePrivateKey(key); err == nil {
+				t.Fatalf("want the key to need a passphrase")
+			}
+
+			signer, err := parsePrivateKeyWithPassphrase(key, []byte("k3sup"))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if signer.PublicKey().Type() != keyType {
+				t.Errorf("want: %s, got: %s", keyType, signer.PublicKey().Type())
+			}
+
+			_, err = parsePrivateKeyWithPassphrase(key, []byte("wrong"))
+			if err == nil || !strings.Contains(err.Error(), "incorrect passphrase") || !strings.Contains(err.Error(), keyType) {
+				t.Errorf("want an incorrect passphrase error naming %s, got: %v", keyType, err)
+			}
+		})
+	}
+}
+
+func Test_describePrivateKey(t *testing.T) {
+	read := func(name string) []byte {
+		key, err := ioutil.ReadFile(filepath.Join("testdata", "keys", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	cases := []struct {
+		name string
+		key  []byte
+		want string
+	}{
+		{"empty", []byte("\n"), "the key file is empty"},
+		{"public key", []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA user@host\n"), "a public key"},
+		{"putty", []byte("PuTTY-User-Key-File-2: ssh-rsa\n"), "a PuTTY key"},
+		{"not a key", []byte("hello world\n"), "no PEM block found"},
+		{"pem", read("rsa_pem"), `a PEM key with header "RSA PRIVATE KEY"`},
+		{"encrypted pem", []byte(privateKey), `an encrypted PEM key with header "RSA PRIVATE KEY"`},
+		{"openssh", read("ed25519_openssh"), "an OpenSSH ssh-ed25519 key"},
+		{"encrypted openssh", read("ecdsa_openssh_encrypted"), "an OpenSSH ecdsa-sha2-nistp256 key encrypted with aes256-ctr"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := describePrivateKey(c.key)
+			if !strings.HasPrefix(got, c.want) {
+				t.Errorf("want: %q, got: %q", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_loadSigners_ParseErrorDescribesKey(t *testing.T) {
+	file, err := ioutil.TempFile("", "k3sup-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	file.WriteString("ssh-rsa AAAAB3NzaC1yc2E user@host\n")
+
+	_, _, err = loadSigners(file.Name(), nil)
+	if err == nil || !strings.Contains(err.Error(), "a public key") {
+		t.Errorf("want the error to say the file is a public key, got: %v", err)
+	}
+}
+
+func Test_loadSigners_Passphrase(t *testing.T) {
+	for _, name := range []string{"rsa_openssh_encrypted", "ecdsa_openssh_encrypted", "ed25519_openssh_encrypted"} {
+		t.Run(name, func(t *testing.T) {
+			signers, closeSSHAgent, err := loadSigners(filepath.Join("testdata", "keys", name), []byte("k3sup"))
+			defer closeSSHAgent()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if keys, err := signers(); err != nil || len(keys) == 0 {
+				t.Fatalf("want a signer, got: %v", err)
+			}
+		})
+	}
+
+	_, _, err := loadSigners(filepath.Join("testdata", "keys", "ed25519_openssh_encrypted"), []byte("wrong"))
+	if err == nil || !strings.Contains(err.Error(), "incorrect passphrase") {
+		t.Errorf("want an incorrect passphrase error, got: %v", err)
+	}
+}
+
+func Test_loadSigners_NoTerminal(t *testing.T) {
+	sock, ok := os.LookupEnv("SSH_AUTH_SOCK")
+	os.Unsetenv("SSH_AUTH_SOCK")
+	if ok {
+		defer os.Setenv("SSH_AUTH_SOCK", sock)
+	}
+
+	// go test does not give the test a terminal on stdin, so the passphrase
+	// cannot be prompted for.
+	_, _, err := loadSigners(filepath.Join("testdata", "keys", "ed25519_openssh_encrypted"), nil)
+	if err == nil || !strings.Contains(err.Error(), "--ssh-key-passphrase-file") {
+		t.Errorf("want an error naming the passphrase options, got: %v", err)
+	}
+}
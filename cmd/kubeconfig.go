@@ -0,0 +1,414 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// caHash returns a short, stable fingerprint of a PEM-encoded CA bundle
+// which can be compared between nodes of the same cluster.
+func caHash(caPEM []byte) string {
+	sum := sha256.Sum256(caPEM)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// kubeconfigCAHash returns the caHash of the certificate-authority-data
+// of the first cluster in the kubeconfig.
+func kubeconfigCAHash(data []byte) (string, error) {
+	config, err := install.ParseKubeconfig(data)
+	if err != nil {
+		return "", err
+	}
+
+	for _, cluster := range config.Clusters {
+		if caData, ok := cluster.Cluster["certificate-authority-data"].(string); ok {
+			caPEM, err := base64.StdEncoding.DecodeString(caData)
+			if err != nil {
+				return "", fmt.Errorf("unable to decode certificate-authority-data: %s", err)
+			}
+			return caHash(caPEM), nil
+		}
+	}
+
+	return "", fmt.Errorf("no certificate-authority-data found in kubeconfig")
+}
+
+// externalizeCerts writes the embedded certificate and key data of the
+// cluster clusterName and the user userName to files in dir, and rewrites
+// the kubeconfig to reference those files instead.
+func externalizeCerts(data []byte, dir, clusterName, userName string) ([]byte, error) {
+	config, err := install.ParseKubeconfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cluster := range config.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		if err := externalizeField(cluster.Cluster, "certificate-authority", filepath.Join(dir, clusterName+"-ca.crt")); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, user := range config.Users {
+		if user.Name != userName {
+			continue
+		}
+		if err := externalizeField(user.User, "client-certificate", filepath.Join(dir, userName+"-client.crt")); err != nil {
+			return nil, err
+		}
+		if err := externalizeField(user.User, "client-key", filepath.Join(dir, userName+"-client.key")); err != nil {
+			return nil, err
+		}
+	}
+
+	return yaml.Marshal(config)
+}
+
+// externalizeField moves the base64 value of key+"-data" into the file at
+// path and replaces it with key referencing the file.
+func externalizeField(values map[string]interface{}, key, path string) error {
+	encoded, ok := values[key+"-data"].(string)
+	if !ok {
+		return nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("unable to decode %s-data: %s", key, err)
+	}
+
+	infof("Saving %s to: %s\n", key, path)
+	if err := ioutil.WriteFile(path, decoded, 0600); err != nil {
+		return err
+	}
+
+	delete(values, key+"-data")
+	values[key] = path
+	return nil
+}
+
+// mergeKubeconfigs adds the clusters, contexts and users of k3sconfig to
+// existing, replacing any with the same name, and switches to the
+// current-context of k3sconfig. As with kubectl config view --merge
+// --flatten, certificates and keys referenced by path are embedded.
+// Relative paths are resolved against dir, the directory of existing.
+func mergeKubeconfigs(existing, k3sconfig []byte, dir string) ([]byte, error) {
+	merged, err := install.ParseKubeconfig(existing)
+	if err != nil {
+		return nil, err
+	}
+	added, err := install.ParseKubeconfig(k3sconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cluster := range added.Clusters {
+		clusters := []install.KubeconfigCluster{}
+		for _, c := range merged.Clusters {
+			if c.Name != cluster.Name {
+				clusters = append(clusters, c)
+			}
+		}
+		merged.Clusters = append(clusters, cluster)
+	}
+
+	for _, context := range added.Contexts {
+		contexts := []install.KubeconfigContext{}
+		for _, c := range merged.Contexts {
+			if c.Name != context.Name {
+				contexts = append(contexts, c)
+			}
+		}
+		merged.Contexts = append(contexts, context)
+	}
+
+	for _, user := range added.Users {
+		users := []install.KubeconfigUser{}
+		for _, u := range merged.Users {
+			if u.Name != user.Name {
+				users = append(users, u)
+			}
+		}
+		merged.Users = append(users, user)
+	}
+
+	if len(merged.APIVersion) == 0 {
+		merged.APIVersion = added.APIVersion
+	}
+	if len(merged.Kind) == 0 {
+		merged.Kind = added.Kind
+	}
+	merged.CurrentContext = added.CurrentContext
+
+	for _, cluster := range merged.Clusters {
+		if err := embedField(cluster.Cluster, "certificate-authority", dir); err != nil {
+			return nil, err
+		}
+	}
+	for _, user := range merged.Users {
+		if err := embedField(user.User, "client-certificate", dir); err != nil {
+			return nil, err
+		}
+		if err := embedField(user.User, "client-key", dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return yaml.Marshal(merged)
+}
+
+// embedField replaces the file referenced by key with its content, base64
+// encoded in key+"-data", the reverse of externalizeField.
+func embedField(values map[string]interface{}, key, dir string) error {
+	path, ok := values[key].(string)
+	if !ok || len(path) == 0 {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read %s of kubeconfig: %s", key, err)
+	}
+
+	delete(values, key)
+	values[key+"-data"] = base64.StdEncoding.EncodeToString(data)
+	return nil
+}
+
+func setKubeconfigCurrentContext(data []byte, name string) ([]byte, error) {
+	config, err := install.ParseKubeconfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for _, context := range config.Contexts {
+		if context.Name == name {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", name)
+	}
+
+	config.CurrentContext = name
+	return yaml.Marshal(config)
+}
+
+func addOverwriteFlag(command *cobra.Command) {
+	command.Flags().Bool("overwrite", false, "With --merge, replace a context, cluster or user of the same name which belongs to another cluster without asking")
+}
+
+// kubeconfigConflicts describes the entries of existing which merging
+// k3sconfig would replace, although they belong to another server. Entries
+// for the same server are only refreshed, i.e. when installing again.
+func kubeconfigConflicts(existing, k3sconfig []byte) ([]string, error) {
+	current, err := install.ParseKubeconfig(existing)
+	if err != nil {
+		return nil, err
+	}
+	added, err := install.ParseKubeconfig(k3sconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	currentServers := kubeconfigServers(current)
+	addedServers := kubeconfigServers(added)
+
+	conflicts := []string{}
+	for _, cluster := range added.Clusters {
+		for _, c := range current.Clusters {
+			if c.Name == cluster.Name && currentServers[c.Name] != addedServers[cluster.Name] {
+				conflicts = append(conflicts, fmt.Sprintf("cluster %s (%s)", c.Name, currentServers[c.Name]))
+			}
+		}
+	}
+
+	for _, context := range added.Contexts {
+		addedServer := addedServers[fmt.Sprint(context.Context["cluster"])]
+		for _, c := range current.Contexts {
+			if c.Name != context.Name {
+				continue
+			}
+			if server := currentServers[fmt.Sprint(c.Context["cluster"])]; server != addedServer {
+				conflicts = append(conflicts, fmt.Sprintf("context %s (%s)", c.Name, server))
+			}
+		}
+	}
+
+	for _, user := range added.Users {
+		addedServer := ""
+		for _, c := range added.Contexts {
+			if c.Context["user"] == user.Name {
+				addedServer = addedServers[fmt.Sprint(c.Context["cluster"])]
+			}
+		}
+		// A user is another cluster's when a context of the existing
+		// kubeconfig uses it for another server.
+		for _, c := range current.Contexts {
+			if c.Context["user"] != user.Name {
+				continue
+			}
+			if server := currentServers[fmt.Sprint(c.Context["cluster"])]; server != addedServer {
+				conflicts = append(conflicts, fmt.Sprintf("user %s (used by context %s for %s)", user.Name, c.Name, server))
+				break
+			}
+		}
+	}
+
+	return conflicts, nil
+}
+
+// kubeconfigServers maps the name of each cluster to its server URL.
+func kubeconfigServers(config *install.KubeconfigFile) map[string]string {
+	servers := map[string]string{}
+	for _, cluster := range config.Clusters {
+		servers[cluster.Name] = fmt.Sprint(cluster.Cluster["server"])
+	}
+	return servers
+}
+
+// confirmOverwrite asks whether to replace the entries of another cluster
+// when stdin is a terminal, otherwise it refuses, so that automation never
+// replaces them without --overwrite.
+func confirmOverwrite(in io.Reader, interactive bool) error {
+	refused := fmt.Errorf("refusing to replace the entries of another cluster, give a different --context, --cluster-name or --user-name, or --overwrite to replace them")
+	if !interactive {
+		return refused
+	}
+
+	fmt.Printf("Replace them? [y/N]: ")
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return refused
+}
+
+// removeKubeconfigEntries removes the context named context, the cluster
+// named clusterName and the user named userName, and clears the
+// current-context when it referenced the removed context.
+func removeKubeconfigEntries(data []byte, context, clusterName, userName string) ([]byte, error) {
+	config, err := install.ParseKubeconfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := []install.KubeconfigCluster{}
+	for _, cluster := range config.Clusters {
+		if cluster.Name != clusterName {
+			clusters = append(clusters, cluster)
+		}
+	}
+	config.Clusters = clusters
+
+	contexts := []install.KubeconfigContext{}
+	for _, c := range config.Contexts {
+		if c.Name != context {
+			contexts = append(contexts, c)
+		}
+	}
+	config.Contexts = contexts
+
+	users := []install.KubeconfigUser{}
+	for _, user := range config.Users {
+		if user.Name != userName {
+			users = append(users, user)
+		}
+	}
+	config.Users = users
+
+	if config.CurrentContext == context {
+		config.CurrentContext = ""
+	}
+
+	return yaml.Marshal(config)
+}
+
+// defaultKubeconfigMode is the mode of the kubeconfig files which k3sup
+// writes, they hold the admin credentials of the cluster.
+const defaultKubeconfigMode os.FileMode = 0600
+
+func addKubeconfigModeFlag(command *cobra.Command) {
+	command.Flags().String("kubeconfig-mode", "0600", "File mode of the kubeconfig written to --local-path, in octal. The owner must be able to read and write it")
+}
+
+// kubeconfigModeFromFlags parses --kubeconfig-mode as an octal mode, i.e.
+// 0640 for a kubeconfig shared with a group.
+func kubeconfigModeFromFlags(command *cobra.Command) (os.FileMode, error) {
+	value, _ := command.Flags().GetString("kubeconfig-mode")
+	if len(value) == 0 {
+		return defaultKubeconfigMode, nil
+	}
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("--kubeconfig-mode must be an octal file mode such as 0600, got: %q", value)
+	}
+	if mode&0600 != 0600 {
+		return 0, fmt.Errorf("--kubeconfig-mode %s must let the owner read and write the kubeconfig", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path, which only
+// the owner can read, and renames it over path before setting mode. The
+// file at path is therefore never partly written, and does not keep the
+// more open mode of an older kubeconfig which it replaces. A symlink at
+// path is kept and its target is replaced.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	// tmpPath is cleared once it is renamed, so that a new file at the same
+	// path is not removed.
+	defer func() {
+		if len(tmpPath) > 0 {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	tmpPath = ""
+
+	return os.Chmod(path, mode)
+}
@@ -0,0 +1,759 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexellis/k3sup/pkg/install"
+)
+
+func Test_kubeconfigCAHash(t *testing.T) {
+	caPEM := []byte("-----BEGIN CERTIFICATE-----\nMIIBdjCCAR2gAwIBAgIBADAKBggqhkjOPQQDAjAjMSEwHwYDVQQDDBhrM3Mtc2Vy\n-----END CERTIFICATE-----\n")
+	config := fmt.Sprintf(`apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: https://127.0.0.1:6443
+  name: default
+`, base64.StdEncoding.EncodeToString(caPEM))
+
+	got, err := kubeconfigCAHash([]byte(config))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := caHash(caPEM)
+	if got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	if len(got) != 16 {
+		t.Errorf("want a hash of length 16, got: %d", len(got))
+	}
+}
+
+func Test_kubeconfigCAHash_Missing(t *testing.T) {
+	config := `apiVersion: v1
+clusters:
+- cluster:
+    server: https://127.0.0.1:6443
+  name: default
+`
+
+	_, err := kubeconfigCAHash([]byte(config))
+	if err == nil {
+		t.Fatalf("want error for kubeconfig without certificate-authority-data")
+	}
+}
+
+func Test_externalizeCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	config := fmt.Sprintf(`apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: https://192.168.0.1:6443
+  name: edge
+contexts:
+- context:
+    cluster: edge
+    user: edge
+  name: edge
+current-context: edge
+kind: Config
+preferences: {}
+users:
+- name: edge
+  user:
+    client-certificate-data: %s
+    client-key-data: %s
+`, encode("ca"), encode("cert"), encode("key"))
+
+	got, err := externalizeCerts([]byte(config), dir, "edge", "edge")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if strings.Contains(string(got), "-data:") {
+		t.Errorf("want no embedded data, got:\n%s", got)
+	}
+
+	files := map[string]string{
+		"edge-ca.crt":     "ca",
+		"edge-client.crt": "cert",
+		"edge-client.key": "key",
+	}
+	for name, want := range files {
+		path := filepath.Join(dir, name)
+		if !strings.Contains(string(got), path) {
+			t.Errorf("want kubeconfig to reference %s, got:\n%s", path, got)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unable to read %s: %s", path, err)
+		}
+		if string(data) != want {
+			t.Errorf("want %s to contain %q, got: %q", name, want, data)
+		}
+
+		info, _ := os.Stat(path)
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("want mode 0600 for %s, got: %o", name, info.Mode().Perm())
+		}
+	}
+}
+
+func Test_setKubeconfigCurrentContext(t *testing.T) {
+	config := `apiVersion: v1
+contexts:
+- context:
+    cluster: old
+    user: old
+  name: old
+- context:
+    cluster: edge
+    user: edge
+  name: edge
+current-context: old
+kind: Config
+`
+
+	got, err := setKubeconfigCurrentContext([]byte(config), "edge")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(string(got), "current-context: edge") {
+		t.Errorf("want current-context to be edge, got:\n%s", got)
+	}
+
+	if _, err := setKubeconfigCurrentContext([]byte(config), "missing"); err == nil {
+		t.Errorf("want error for a missing context")
+	}
+}
+
+func Test_kubeconfigServerURLFromFlags(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "url", args: []string{"--kubeconfig-server-url", "https://k3s.example.com:443/"}, want: "https://k3s.example.com:443"},
+		{name: "default port", args: []string{"--kubeconfig-server-url", "https://k3s.example.com"}, want: "https://k3s.example.com"},
+		{name: "http", args: []string{"--kubeconfig-server-url", "http://k3s.example.com"}, wantErr: true},
+		{name: "no scheme", args: []string{"--kubeconfig-server-url", "k3s.example.com:6443"}, wantErr: true},
+		{name: "path", args: []string{"--kubeconfig-server-url", "https://k3s.example.com/k3s"}, wantErr: true},
+		{name: "with port", args: []string{"--kubeconfig-server-url", "https://k3s.example.com", "--kubeconfig-server-port", "443"}, wantErr: true},
+		{name: "with host", args: []string{"--kubeconfig-server-url", "https://k3s.example.com", "--kubeconfig-host", "k3s.example.com"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			command := MakeInstall()
+			if err := command.ParseFlags(c.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := kubeconfigServerURLFromFlags(command)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != c.want {
+				t.Errorf("want: %q, got: %q", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_mergeKubeconfigs_DistinctUserNames(t *testing.T) {
+	first, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.101", "edge", "", "edge-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.102", "prod", "", "prod-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := mergeKubeconfigs(first, second, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	merged, err := install.ParseKubeconfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(merged.Users) != 2 || merged.Users[0].Name != "edge-admin" || merged.Users[1].Name != "prod-admin" {
+		t.Errorf("want the users of both clusters, got:\n%s", data)
+	}
+	for _, c := range merged.Contexts {
+		if c.Context["user"] != c.Name+"-admin" {
+			t.Errorf("want context %s to use the user %s-admin, got: %v", c.Name, c.Name, c.Context)
+		}
+	}
+}
+
+func Test_removeKubeconfigEntries_UserName(t *testing.T) {
+	config, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.101", "edge", "edge-cluster", "edge-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := removeKubeconfigEntries(config, "edge", "edge-cluster", "edge-admin")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	parsed, err := install.ParseKubeconfig(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Clusters) != 0 || len(parsed.Contexts) != 0 || len(parsed.Users) != 0 {
+		t.Errorf("want every entry removed, got:\n%s", got)
+	}
+}
+
+func Test_removeKubeconfigEntries(t *testing.T) {
+	config := `apiVersion: v1
+clusters:
+- cluster:
+    server: https://192.168.0.100:6443
+  name: edge
+- cluster:
+    server: https://192.168.0.200:6443
+  name: prod
+contexts:
+- context:
+    cluster: edge
+    user: edge
+  name: edge
+- context:
+    cluster: prod
+    user: prod
+  name: prod
+current-context: edge
+kind: Config
+preferences: {}
+users:
+- name: edge
+  user:
+    token: abc
+- name: prod
+  user:
+    token: def
+`
+
+	got, err := removeKubeconfigEntries([]byte(config), "edge", "edge", "edge")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	parsed, err := install.ParseKubeconfig(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(parsed.Clusters) != 1 || parsed.Clusters[0].Name != "prod" {
+		t.Errorf("want only the prod cluster, got:\n%s", got)
+	}
+	if len(parsed.Contexts) != 1 || parsed.Contexts[0].Name != "prod" {
+		t.Errorf("want only the prod context, got:\n%s", got)
+	}
+	if len(parsed.Users) != 1 || parsed.Users[0].Name != "prod" {
+		t.Errorf("want only the prod user, got:\n%s", got)
+	}
+	if parsed.CurrentContext != "" {
+		t.Errorf("want current-context to be cleared, got: %s", parsed.CurrentContext)
+	}
+}
+
+func Test_obtainKubeconfig_Output(t *testing.T) {
+	getConfig := "sudo cat /etc/rancher/k3s/k3s.yaml\n"
+	op := &scriptedOperator{replies: map[string]string{getConfig: kubeconfigExample}}
+
+	dir, err := ioutil.TempDir("", "k3sup-kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "kubeconfig")
+
+	out := &strings.Builder{}
+	_, err = obtainKubeconfig(op, getConfig, "192.168.0.100", kubeconfigOptions{
+		Context:   "edge",
+		LocalPath: localPath,
+		Output:    out,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(out.String(), "server: https://192.168.0.100:6443") || !strings.Contains(out.String(), "name: edge") {
+		t.Errorf("want the rewritten kubeconfig on the output, got:\n%s", out.String())
+	}
+	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+		t.Errorf("want no kubeconfig file when writing to the output, got: %v", err)
+	}
+}
+
+func Test_obtainKubeconfig_VerboseHidesKubeconfig(t *testing.T) {
+	getConfig := "sudo cat /etc/rancher/k3s/k3s.yaml\n"
+	op := &scriptedOperator{replies: map[string]string{getConfig: kubeconfigExample}}
+
+	var err error
+	printed := captureStdout(t, logDebug, func() {
+		_, err = obtainKubeconfig(op, getConfig, "192.168.0.100", kubeconfigOptions{Output: &strings.Builder{}})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if strings.Contains(printed, "certificate-authority-data") || strings.Contains(printed, "apiVersion") {
+		t.Errorf("want the kubeconfig not printed with --verbose, got:\n%s", printed)
+	}
+	if !strings.Contains(printed, "Fetched a kubeconfig of") {
+		t.Errorf("want the size of the kubeconfig printed, got:\n%s", printed)
+	}
+}
+
+func Test_validateKubeconfigStdout(t *testing.T) {
+	for _, name := range []string{"merge", "no-embed-certs", "set-current-context"} {
+		command := MakeInstall()
+		command.Flags().Set(name, "true")
+		if err := validateKubeconfigStdout(command); err == nil {
+			t.Errorf("want an error for --%s", name)
+		}
+	}
+
+	if err := validateKubeconfigStdout(MakeInstall()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func Test_mergeKubeconfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "work-ca.crt"), []byte("work CA"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	existing := `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority: work-ca.crt
+    server: https://work.example.com:6443
+  name: work
+- cluster:
+    server: https://192.168.0.100:6443
+  name: edge
+contexts:
+- context:
+    cluster: work
+    user: work
+  name: work
+- context:
+    cluster: edge
+    user: edge
+  name: edge
+current-context: work
+kind: Config
+users:
+- name: work
+  user:
+    token: work-token
+- name: edge
+  user:
+    token: old-token
+`
+	k3sconfig, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.101", "edge", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := mergeKubeconfigs([]byte(existing), k3sconfig, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	merged, err := install.ParseKubeconfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if merged.CurrentContext != "edge" {
+		t.Errorf("want the current-context switched to the new context, got: %q", merged.CurrentContext)
+	}
+	if len(merged.Clusters) != 2 || len(merged.Contexts) != 2 || len(merged.Users) != 2 {
+		t.Fatalf("want 2 clusters, contexts and users, got:\n%s", data)
+	}
+
+	for _, cluster := range merged.Clusters {
+		switch cluster.Name {
+		case "work":
+			if cluster.Cluster["certificate-authority-data"] != base64.StdEncoding.EncodeToString([]byte("work CA")) {
+				t.Errorf("want the CA of work to be embedded, got: %v", cluster.Cluster)
+			}
+			if _, ok := cluster.Cluster["certificate-authority"]; ok {
+				t.Errorf("want no certificate-authority path after embedding, got: %v", cluster.Cluster)
+			}
+		case "edge":
+			if cluster.Cluster["server"] != "https://192.168.0.101:6443" {
+				t.Errorf("want the edge cluster to be replaced, got: %v", cluster.Cluster)
+			}
+		}
+	}
+	for _, user := range merged.Users {
+		if user.Name == "edge" && user.User["token"] != nil {
+			t.Errorf("want the edge user to be replaced, got: %v", user.User)
+		}
+	}
+}
+
+func Test_mergeKubeconfigs_CurrentContext(t *testing.T) {
+	k3sconfig, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.101", "edge", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := mergeKubeconfigs([]byte("apiVersion: v1\nkind: Config\nclusters: []\n"), k3sconfig, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	merged, err := install.ParseKubeconfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.CurrentContext != "edge" {
+		t.Errorf("want the new context to be current when none was set, got: %q", merged.CurrentContext)
+	}
+}
+
+func Test_mergeConfigs_MissingFile(t *testing.T) {
+	k3sconfig := []byte(kubeconfigExample)
+	data, err := mergeConfigs(filepath.Join(os.TempDir(), "k3sup-does-not-exist", "config"), k3sconfig, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != kubeconfigExample {
+		t.Errorf("want the new kubeconfig when there is none to merge with, got:\n%s", data)
+	}
+}
+
+func Test_kubeconfigConflicts(t *testing.T) {
+	existing, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.100", "default", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.100", "default", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts, err := kubeconfigConflicts(existing, refresh)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("want no conflicts for the same server, got: %q", conflicts)
+	}
+
+	other, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.200", "default", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts, err = kubeconfigConflicts(existing, other)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{
+		"cluster default (https://192.168.0.100:6443)",
+		"context default (https://192.168.0.100:6443)",
+		"user default (used by context default for https://192.168.0.100:6443)",
+	}
+	if !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("want: %q, got: %q", want, conflicts)
+	}
+
+	renamed, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.200", "edge", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts, err = kubeconfigConflicts(existing, renamed)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("want no conflicts with another --context, got: %q", conflicts)
+	}
+}
+
+func Test_confirmOverwrite(t *testing.T) {
+	if err := confirmOverwrite(strings.NewReader("y\n"), false); err == nil {
+		t.Errorf("want an error without a terminal, even for y")
+	}
+	for _, answer := range []string{"y\n", "Yes\n"} {
+		if err := confirmOverwrite(strings.NewReader(answer), true); err != nil {
+			t.Errorf("want %q to confirm, got: %s", answer, err)
+		}
+	}
+	for _, answer := range []string{"\n", "n\n", ""} {
+		if err := confirmOverwrite(strings.NewReader(answer), true); err == nil {
+			t.Errorf("want %q to refuse", answer)
+		}
+	}
+}
+
+func Test_mergeConfigs_Overwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	existing, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.100", "default", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, existing, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.200", "default", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tests do not run with a terminal on stdin, so there is no prompt.
+	if _, err := mergeConfigs(path, other, false, false); err == nil || !strings.Contains(err.Error(), "--overwrite") {
+		t.Errorf("want an error naming --overwrite, got: %v", err)
+	}
+
+	data, err := mergeConfigs(path, other, true, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(string(data), "https://192.168.0.200:6443") || strings.Contains(string(data), "https://192.168.0.100:6443") {
+		t.Errorf("want the cluster replaced with --overwrite, got:\n%s", data)
+	}
+}
+
+func Test_mergeConfigs_KeepCurrentContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	existing, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.100", "work", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, existing, 0600); err != nil {
+		t.Fatal(err)
+	}
+	k3sconfig, err := install.RewriteKubeconfig(kubeconfigExample, "192.168.0.101", "edge", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for keep, want := range map[bool]string{false: "edge", true: "work"} {
+		data, err := mergeConfigs(path, k3sconfig, false, keep)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		merged, err := install.ParseKubeconfig(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged.CurrentContext != want {
+			t.Errorf("want current-context %q when keeping it is %t, got: %q", want, keep, merged.CurrentContext)
+		}
+	}
+}
+
+func Test_mergeKubeconfigs_MissingCertificate(t *testing.T) {
+	existing := "clusters:\n- cluster:\n    certificate-authority: /does/not/exist/ca.crt\n  name: work\n"
+	if _, err := mergeKubeconfigs([]byte(existing), []byte(kubeconfigExample), ""); err == nil {
+		t.Errorf("want error for a certificate which cannot be read")
+	}
+}
+
+func Test_kubeconfigModeFromFlags(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{value: "0600", want: 0600},
+		{value: "640", want: 0640},
+		{value: "0644", want: 0644},
+		{value: "0400", wantErr: true},
+		{value: "0700", want: 0700},
+		{value: "1777", wantErr: true},
+		{value: "0689", wantErr: true},
+		{value: "rw", wantErr: true},
+	}
+
+	for _, c := range cases {
+		command := MakeGetKubeconfig()
+		if err := command.ParseFlags([]string{"--kubeconfig-mode", c.value}); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := kubeconfigModeFromFlags(command)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("want an error for %q", c.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", c.value, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("want: %o, got: %o for %q", c.want, got, c.value)
+		}
+	}
+}
+
+func Test_writeFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// ioutil.WriteFile keeps the mode of an existing file, which
+	// writeFileAtomic must not.
+	os.Chmod(path, 0644)
+
+	if err := writeFileAtomic(path, []byte("new"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertFile(t, path, "new", 0600)
+
+	if err := writeFileAtomic(path, []byte("shared"), 0640); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertFile(t, path, "shared", 0640)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("want only the kubeconfig to be left, got %d files", len(entries))
+	}
+}
+
+func Test_writeFileAtomic_Symlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "dotfiles-config")
+	if err := ioutil.WriteFile(target, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "config")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(link, []byte("new"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("want the symlink to be kept")
+	}
+	assertFile(t, target, "new", 0600)
+}
+
+func Test_writeFileAtomic_MissingDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "config"), []byte("new"), 0600); err == nil {
+		t.Errorf("want an error when the directory does not exist")
+	}
+}
+
+func assertFile(t *testing.T, path, want string, wantMode os.FileMode) {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("want %q in %s, got: %q", want, path, data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != wantMode {
+		t.Errorf("want mode %o for %s, got: %o", wantMode, path, info.Mode().Perm())
+	}
+}
+
+func Test_kubeconfigEnvCommand(t *testing.T) {
+	if got, want := kubeconfigEnvCommand("linux", "/home/k3s/kubeconfig"), "export KUBECONFIG=/home/k3s/kubeconfig"; got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+	if got, want := kubeconfigEnvCommand("windows", `C:\Users\k3s\kubeconfig`), `$env:KUBECONFIG="C:\Users\k3s\kubeconfig"`; got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
+
+func Test_mergeKubeconfigs_CRLF(t *testing.T) {
+	crlf := strings.Replace(kubeconfigExample, "\n", "\r\n", -1)
+
+	k3sconfig, err := install.RewriteKubeconfig(crlf, "192.168.0.100", "edge", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	merged, err := mergeKubeconfigs([]byte(crlf), k3sconfig, "")
+	if err != nil {
+		t.Fatalf("unexpected error merging with a CRLF kubeconfig: %s", err)
+	}
+	if strings.Contains(string(merged), "\r") {
+		t.Errorf("want LF line endings in the merged kubeconfig, got: %q", merged)
+	}
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+// channelCacheTTL is how long list-channels reuses the channels it
+// fetched, so that running it a few times does not query the server each
+// time.
+const channelCacheTTL = 5 * time.Minute
+
+// channelCache is the last list of channels fetched from URL.
+type channelCache struct {
+	URL      string           `json:"url"`
+	Fetched  time.Time        `json:"fetched"`
+	Channels []releaseChannel `json:"channels"`
+}
+
+func MakeListChannels() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "list-channels",
+		Short: "List the k3s release channels and the version each installs",
+		Long: `List the release channels of the k3s channel server and the version each
+one installs today, for a minor channel such as v1.19 its latest patch
+release. Give a channel to install with --k3s-channel.`,
+		Example: `  k3sup list-channels
+  k3sup list-channels --refresh
+  k3sup list-channels --channel-url https://channels.example.com/v1-release/channels`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().String("channel-url", "", "Optional: URL of a self-hosted channel server, defaults to "+defaultChannelURL)
+	command.Flags().Bool("refresh", false, "Query the channel server even when the channels were fetched in the last "+channelCacheTTL.String())
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		channelURL, _ := command.Flags().GetString("channel-url")
+		if err := validateChannelURL(channelURL); err != nil {
+			return err
+		}
+		if len(channelURL) == 0 {
+			channelURL = defaultChannelURL
+		}
+		refresh, _ := command.Flags().GetBool("refresh")
+
+		channels, err := listChannels(channelURL, channelCachePath(), refresh, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return printChannels(os.Stdout, channels, defaultK3sChannel)
+	}
+
+	return command
+}
+
+// channelCachePath is where list-channels keeps the channels it fetched,
+// next to the other files of k3sup in ~/.k3sup, or empty when the home
+// directory is unknown.
+func channelCachePath() string {
+	home, err := homedir.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".k3sup", "channels.json")
+}
+
+// listChannels returns the channels of channelURL from the cache at
+// cachePath when they were fetched within channelCacheTTL of now, or else
+// from the channel server. A cache which cannot be read or written is
+// ignored.
+func listChannels(channelURL, cachePath string, refresh bool, now time.Time) ([]releaseChannel, error) {
+	if !refresh && len(cachePath) > 0 {
+		if data, err := ioutil.ReadFile(cachePath); err == nil {
+			cache := channelCache{}
+			if json.Unmarshal(data, &cache) == nil && cache.URL == channelURL && len(cache.Channels) > 0 &&
+				!cache.Fetched.After(now) && now.Sub(cache.Fetched) < channelCacheTTL {
+				debugf("Using the channels fetched from %s at %s\n", channelURL, cache.Fetched.Format(time.RFC3339))
+				return cache.Channels, nil
+			}
+		}
+	}
+
+	channels, err := fetchChannels(channelURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cachePath) > 0 {
+		data, _ := json.Marshal(channelCache{URL: channelURL, Fetched: now, Channels: channels})
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0700); err != nil {
+			debugf("Unable to cache the channels: %s\n", err)
+		} else if err := writeFileAtomic(cachePath, data, 0600); err != nil {
+			debugf("Unable to cache the channels: %s\n", err)
+		}
+	}
+	return channels, nil
+}
+
+// printChannels writes a table of channels to w, marking defaultChannel,
+// the default of --k3s-channel.
+func printChannels(w io.Writer, channels []releaseChannel, defaultChannel string) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CHANNEL\tVERSION")
+	for _, channel := range channels {
+		name := channel.Name
+		if name == defaultChannel {
+			name += " (default)"
+		}
+		version := channel.Latest
+		if len(version) == 0 {
+			version = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\n", name, version)
+	}
+	return table.Flush()
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_listChannels_Cache(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(channelsJSON))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "k3sup-channels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, ".k3sup", "channels.json")
+
+	now := time.Now()
+	steps := []struct {
+		url          string
+		at           time.Time
+		refresh      bool
+		wantRequests int
+	}{
+		{server.URL, now, false, 1},
+		{server.URL, now.Add(time.Minute), false, 1},
+		{server.URL, now.Add(time.Minute), true, 2},
+		{server.URL + "/other", now.Add(2 * time.Minute), false, 3},
+		{server.URL + "/other", now.Add(2*time.Minute + channelCacheTTL), false, 4},
+	}
+	for i, step := range steps {
+		channels, err := listChannels(step.url, cachePath, step.refresh, step.at)
+		if err != nil {
+			t.Fatalf("step %d: unexpected error: %s", i, err)
+		}
+		if len(channels) != 3 {
+			t.Errorf("step %d: want 3 channels, got: %v", i, channels)
+		}
+		if requests != step.wantRequests {
+			t.Errorf("step %d: want %d request(s) to the channel server, got: %d", i, step.wantRequests, requests)
+		}
+	}
+}
+
+func Test_printChannels(t *testing.T) {
+	out := bytes.Buffer{}
+	channels := []releaseChannel{{"stable", "v1.19.5+k3s2"}, {"v1.18", "v1.18.13+k3s1"}, {"v1.15", ""}}
+	if err := printChannels(&out, channels, "v1.18"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := `CHANNEL          VERSION
+stable           v1.19.5+k3s2
+v1.18 (default)  v1.18.13+k3s1
+v1.15            -
+`
+	if out.String() != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, out.String())
+	}
+}
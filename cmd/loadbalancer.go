@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// lbEndpoint is a load balancer in front of the API servers of an HA
+// cluster, from --lb-endpoint.
+type lbEndpoint struct {
+	Host string
+	Port int
+	// Timeout bounds the wait for the load balancer to serve /healthz.
+	Timeout time.Duration
+}
+
+func addLBEndpointFlags(command *cobra.Command) {
+	command.Flags().String("lb-endpoint", "", "Optional: host:port of a load balancer in front of the API servers, i.e. k3s.example.com:6443. It is added to --tls-san and written to the kubeconfig once it serves /healthz")
+	command.Flags().Duration("lb-timeout", 5*time.Minute, "Time to wait for --lb-endpoint to serve /healthz before the kubeconfig is written")
+}
+
+// lbEndpointFromFlags returns the --lb-endpoint, whose Host is empty when
+// none is given.
+func lbEndpointFromFlags(command *cobra.Command) (lbEndpoint, error) {
+	endpoint, _ := command.Flags().GetString("lb-endpoint")
+	if len(endpoint) == 0 {
+		return lbEndpoint{}, nil
+	}
+
+	for _, name := range []string{"kubeconfig-server-url", "kubeconfig-host", "kubeconfig-server-port"} {
+		if flagGiven(command, name) {
+			return lbEndpoint{}, fmt.Errorf("--%s cannot be used with --lb-endpoint, which sets the server of the kubeconfig", name)
+		}
+	}
+
+	host, portValue, err := net.SplitHostPort(endpoint)
+	if err != nil || len(host) == 0 {
+		return lbEndpoint{}, fmt.Errorf("--lb-endpoint must be a host and port such as k3s.example.com:6443, got: %q", endpoint)
+	}
+	if err := validateHost("lb-endpoint", host); err != nil {
+		return lbEndpoint{}, err
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil || port < 1 || port > 65535 {
+		return lbEndpoint{}, fmt.Errorf("the port of --lb-endpoint must be between 1 and 65535, got: %q", portValue)
+	}
+
+	timeout, _ := command.Flags().GetDuration("lb-timeout")
+	if timeout <= 0 {
+		return lbEndpoint{}, fmt.Errorf("--lb-timeout must be greater than zero")
+	}
+	return lbEndpoint{Host: host, Port: port, Timeout: timeout}, nil
+}
+
+// ServerURL is the server of the kubeconfig which goes through the load
+// balancer.
+func (e lbEndpoint) ServerURL() string {
+	return "https://" + net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// withTLSSAN adds the host of the load balancer to tlsSANs, so that the
+// certificate of the server is valid through it. The IP of the server,
+// which is the default of --tls-san, is kept for SSH tunnels and probes.
+func (e lbEndpoint) withTLSSAN(tlsSANs []string, ip net.IP) []string {
+	if len(e.Host) == 0 {
+		return tlsSANs
+	}
+	for _, san := range tlsSANs {
+		if strings.EqualFold(san, e.Host) {
+			return tlsSANs
+		}
+	}
+	if len(tlsSANs) == 0 {
+		tlsSANs = []string{ip.String()}
+	}
+	return append(tlsSANs, e.Host)
+}
+
+// waitForHealthz waits for the server of the kubeconfig to answer /healthz
+// with ok, so that a kubeconfig pointing at a load balancer is only written
+// once the load balancer forwards to a healthy API server. It does nothing
+// when timeout is 0.
+func waitForHealthz(kubeconfig []byte, timeout, interval time.Duration) error {
+	if timeout == 0 {
+		return nil
+	}
+
+	requestTimeout := 10 * time.Second
+	if timeout < requestTimeout {
+		requestTimeout = timeout
+	}
+	client, err := newAPIClient(kubeconfig, requestTimeout)
+	if err != nil {
+		return err
+	}
+
+	infof("Waiting for %s to serve /healthz\n", client.server)
+	deadline := time.Now().Add(timeout)
+	for {
+		status, body, err := client.do(http.MethodGet, "/healthz", "", nil)
+		if err == nil && status == http.StatusOK {
+			infof("The load balancer at %s forwards to a healthy API server\n", client.server)
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%d %s: %s", status, http.StatusText(status), strings.TrimSpace(string(body)))
+		}
+		debugf("%s/healthz: %s\n", client.server, err)
+
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("%s did not serve /healthz within %s, check that the load balancer forwards to the API server of the node, the kubeconfig was not written: %s",
+				client.server, timeout, err)
+		}
+		time.Sleep(interval)
+	}
+}
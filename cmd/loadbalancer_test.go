@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_lbEndpointFromFlags(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    []string
+		want    lbEndpoint
+		wantErr string
+	}{
+		{name: "none", args: []string{}},
+		{name: "hostname", args: []string{"--lb-endpoint", "k3s.example.com:6443"}, want: lbEndpoint{Host: "k3s.example.com", Port: 6443, Timeout: 5 * time.Minute}},
+		{name: "IPv6", args: []string{"--lb-endpoint", "[fd00::10]:443", "--lb-timeout", "1m"}, want: lbEndpoint{Host: "fd00::10", Port: 443, Timeout: time.Minute}},
+		{name: "no port", args: []string{"--lb-endpoint", "k3s.example.com"}, wantErr: "must be a host and port"},
+		{name: "bad port", args: []string{"--lb-endpoint", "k3s.example.com:70000"}, wantErr: "between 1 and 65535"},
+		{name: "bad host", args: []string{"--lb-endpoint", "k3s_lb:6443"}, wantErr: "--lb-endpoint must be a hostname or an IP"},
+		{name: "with server URL", args: []string{"--lb-endpoint", "k3s.example.com:6443", "--kubeconfig-server-url", "https://other:6443"}, wantErr: "--kubeconfig-server-url cannot be used with --lb-endpoint"},
+		{name: "zero timeout", args: []string{"--lb-endpoint", "k3s.example.com:6443", "--lb-timeout", "0s"}, wantErr: "--lb-timeout must be greater than zero"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			command := MakeInstall()
+			if err := command.ParseFlags(tc.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := lbEndpointFromFlags(command)
+			if len(tc.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("want an error containing %q, got: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.want {
+				t.Errorf("want: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_lbEndpoint_ServerURL(t *testing.T) {
+	if got := (lbEndpoint{Host: "fd00::10", Port: 443}).ServerURL(); got != "https://[fd00::10]:443" {
+		t.Errorf("want the IPv6 host in brackets, got: %q", got)
+	}
+}
+
+func Test_lbEndpoint_withTLSSAN(t *testing.T) {
+	ip := net.ParseIP("192.168.0.100")
+	lb := lbEndpoint{Host: "k3s.example.com", Port: 6443}
+
+	cases := []struct {
+		tlsSANs []string
+		want    []string
+	}{
+		{tlsSANs: nil, want: []string{"192.168.0.100", "k3s.example.com"}},
+		{tlsSANs: []string{"10.0.0.10"}, want: []string{"10.0.0.10", "k3s.example.com"}},
+		{tlsSANs: []string{"K3S.example.com"}, want: []string{"K3S.example.com"}},
+	}
+	for _, tc := range cases {
+		if got := lb.withTLSSAN(tc.tlsSANs, ip); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("want: %q, got: %q", tc.want, got)
+		}
+	}
+
+	if got := (lbEndpoint{}).withTLSSAN(nil, ip); got != nil {
+		t.Errorf("want no change without a load balancer, got: %q", got)
+	}
+}
+
+// healthzServer answers /healthz with 503 until it was asked unhealthy
+// times, as a load balancer does before its backends pass its checks.
+func healthzServer(unhealthy int) *httptest.Server {
+	mu := sync.Mutex{}
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		if unhealthy > 0 {
+			unhealthy--
+			http.Error(w, "no healthy upstream", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+}
+
+func Test_waitForHealthz(t *testing.T) {
+	server := healthzServer(2)
+	defer server.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	var err error
+	out := captureStdout(t, logInfo, func() {
+		err = waitForHealthz(testKubeconfig(server.URL, caPEM), 5*time.Second, time.Millisecond)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(out, "The load balancer at "+server.URL+" forwards to a healthy API server") {
+		t.Errorf("want the load balancer reported healthy, got: %q", out)
+	}
+}
+
+func Test_waitForHealthz_Timeout(t *testing.T) {
+	server := healthzServer(1000)
+	defer server.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	var err error
+	captureStdout(t, logQuiet, func() {
+		err = waitForHealthz(testKubeconfig(server.URL, caPEM), 50*time.Millisecond, 10*time.Millisecond)
+	})
+	if err == nil || !strings.Contains(err.Error(), "did not serve /healthz within 50ms") || !strings.Contains(err.Error(), "503 Service Unavailable: no healthy upstream") {
+		t.Errorf("want a timeout with the last answer, got: %v", err)
+	}
+}
+
+func Test_waitForHealthz_Skipped(t *testing.T) {
+	if err := waitForHealthz([]byte("not a kubeconfig"), 0, time.Second); err != nil {
+		t.Errorf("want nothing done without a timeout, got: %s", err)
+	}
+}
+
+func Test_MakeInstall_LBEndpointDryRun(t *testing.T) {
+	command := MakeInstall()
+	command.Flags().Bool("dry-run", true, "")
+	command.Flags().Set("ip", "192.168.0.100")
+	command.Flags().Set("lb-endpoint", "k3s.example.com:6443")
+
+	var err error
+	out := captureStdout(t, logInfo, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(out, "--tls-san 192.168.0.100 --tls-san k3s.example.com") {
+		t.Errorf("want the load balancer added to the certificate, got:\n%s", out)
+	}
+	if !strings.Contains(out, "# wait for https://k3s.example.com:6443 to serve /healthz") {
+		t.Errorf("want the wait for the load balancer in the plan, got:\n%s", out)
+	}
+}
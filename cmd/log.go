@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+// logLevel is how much k3sup prints, set by the global --quiet and
+// --verbose flags. Errors are returned to main and always printed.
+type logLevel int
+
+const (
+	// logQuiet prints nothing but errors, prompts and the output of a
+	// command, such as that of k3sup version or cert check.
+	logQuiet logLevel = iota
+	// logInfo prints the progress of a command, it is the default.
+	logInfo
+	// logDebug also prints each command run on a node, the output of the
+	// installer and how SSH connects.
+	logDebug
+)
+
+var verbosity = logInfo
+
+// verbosityFromFlags returns the level of the global --quiet and --verbose
+// flags, which is logInfo when they are not defined.
+func verbosityFromFlags(command *cobra.Command) (logLevel, error) {
+	quiet, _ := command.Flags().GetBool("quiet")
+	verbose, _ := command.Flags().GetBool("verbose")
+
+	switch {
+	case quiet && verbose:
+		return logInfo, fmt.Errorf("give either --quiet or --verbose, not both")
+	case quiet:
+		return logQuiet, nil
+	case verbose:
+		return logDebug, nil
+	}
+	return logInfo, nil
+}
+
+// infof prints the progress of a command, unless --quiet is given.
+func infof(format string, a ...interface{}) {
+	if verbosity >= logInfo {
+		fmt.Printf(format, a...)
+	}
+}
+
+// warnf prints a warning, which is no error, unless --quiet is given.
+func warnf(format string, a ...interface{}) {
+	infof("Warning: "+format, a...)
+}
+
+// debugf prints details only wanted when debugging, with --verbose.
+func debugf(format string, a ...interface{}) {
+	if verbosity >= logDebug {
+		fmt.Printf(format, a...)
+	}
+}
+
+// streamOutput is true when the output of the k3s installer is printed as
+// it runs, which is with --stream or --verbose, and never with --quiet.
+func streamOutput(stream bool) bool {
+	return verbosity >= logDebug || (stream && verbosity >= logInfo)
+}
+
+// maxErrorOutput and maxErrorLines bound how much of the stderr of a
+// failed command is added to its error, the installer can print far more.
+const (
+	maxErrorOutput = 2048
+	maxErrorLines  = 20
+)
+
+// truncateOutput returns the last lines of output, at most max bytes of
+// them, which are the most likely to say why a command failed, with a note
+// when some were cut.
+func truncateOutput(output []byte, max, lines int) string {
+	trimmed := strings.TrimSpace(string(output))
+	cut := false
+	if len(trimmed) > max {
+		trimmed, cut = trimmed[len(trimmed)-max:], true
+	}
+	if all := strings.Split(trimmed, "\n"); len(all) > lines {
+		trimmed, cut = strings.Join(all[len(all)-lines:], "\n"), true
+	}
+	if !cut {
+		return trimmed
+	}
+	return "[earlier output cut, run with --verbose for all of it]\n" + trimmed
+}
+
+// withStderr adds the stderr of a failed command to err, as it is otherwise
+// only printed with --verbose. It returns err as-is without stderr.
+func withStderr(err error, res operator.CommandRes) error {
+	stderr := truncateOutput(res.StdErr, maxErrorOutput, maxErrorLines)
+	if err == nil || len(stderr) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w\nstderr: %s", err, stderr)
+}
+
+// debugOutput prints the output of a command with --verbose, labelled with
+// what it was and with secrets masked.
+func debugOutput(what string, res operator.CommandRes) {
+	if len(res.StdOut) > 0 {
+		debugf("%s stdout: %q\n", what, redactSecrets(string(res.StdOut)))
+	}
+	if len(res.StdErr) > 0 {
+		debugf("%s stderr: %q\n", what, redactSecrets(string(res.StdErr)))
+	}
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+func Test_verbosityFromFlags(t *testing.T) {
+	cases := []struct {
+		args    []string
+		want    logLevel
+		wantErr bool
+	}{
+		{args: []string{}, want: logInfo},
+		{args: []string{"--quiet"}, want: logQuiet},
+		{args: []string{"-v"}, want: logDebug},
+		{args: []string{"--verbose"}, want: logDebug},
+		{args: []string{"--quiet", "--verbose"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		command := &cobra.Command{}
+		command.Flags().Bool("quiet", false, "")
+		command.Flags().BoolP("verbose", "v", false, "")
+		if err := command.ParseFlags(c.args); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := verbosityFromFlags(command)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("want an error for %q", c.args)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%q: want %d, got: %d, %v", c.args, c.want, got, err)
+		}
+	}
+
+	if got, err := verbosityFromFlags(&cobra.Command{}); err != nil || got != logInfo {
+		t.Errorf("want info without the flags, got: %d, %v", got, err)
+	}
+}
+
+// captureStdout returns what fn prints to stdout at level.
+func captureStdout(t *testing.T, level logLevel, fn func()) string {
+	stdout, err := ioutil.TempFile("", "k3sup-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(stdout.Name())
+	defer stdout.Close()
+
+	original, originalLevel := os.Stdout, verbosity
+	os.Stdout, verbosity = stdout, level
+	defer func() { os.Stdout, verbosity = original, originalLevel }()
+
+	fn()
+
+	out, err := ioutil.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func Test_logLevels(t *testing.T) {
+	cases := map[logLevel]string{
+		logQuiet: "",
+		logInfo:  "info\nWarning: warning\n",
+		logDebug: "info\nWarning: warning\ndebug\n",
+	}
+	for level, want := range cases {
+		got := captureStdout(t, level, func() {
+			infof("info\n")
+			warnf("%s\n", "warning")
+			debugf("debug\n")
+		})
+		if got != want {
+			t.Errorf("level %d, want: %q, got: %q", level, want, got)
+		}
+	}
+}
+
+func Test_runInstaller_LogLevels(t *testing.T) {
+	installer := "echo K10secret-token; echo installing >&2"
+	cases := []struct {
+		level  logLevel
+		stream bool
+		want   string
+	}{
+		{level: logQuiet, stream: true, want: ""},
+		{level: logInfo, want: ""},
+		{level: logInfo, stream: true, want: "[127.0.0.1] K10secret-token\n"},
+		{level: logDebug, want: "[127.0.0.1] K10secret-token\n"},
+	}
+	for _, c := range cases {
+		var res operator.CommandRes
+		var err error
+		got := captureStdout(t, c.level, func() {
+			res, err = runInstaller(operator.ExecOperator{}, installer, "127.0.0.1", streamOutput(c.stream), nil)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != c.want {
+			t.Errorf("level %d, stream %t: want %q printed, got: %q", c.level, c.stream, c.want, got)
+		}
+		if string(res.StdOut) != "K10secret-token\n" {
+			t.Errorf("want the output returned, got: %q", res.StdOut)
+		}
+	}
+}
+
+func Test_truncateOutput(t *testing.T) {
+	if got := truncateOutput([]byte("  failed\n"), 10, 5); got != "failed" {
+		t.Errorf("want the output trimmed, got: %q", got)
+	}
+
+	got := truncateOutput([]byte("0123456789abcdef"), 4, 5)
+	if !strings.HasSuffix(got, "\ncdef") || !strings.HasPrefix(got, "[earlier output cut") {
+		t.Errorf("want the last 4 bytes with a note, got: %q", got)
+	}
+
+	got = truncateOutput([]byte("one\ntwo\nthree\nfour\n"), 100, 2)
+	if want := "[earlier output cut, run with --verbose for all of it]\nthree\nfour"; got != want {
+		t.Errorf("want the last 2 lines with a note, want: %q, got: %q", want, got)
+	}
+}
+
+func Test_withStderr(t *testing.T) {
+	failed := errors.New("Process exited with status 1")
+
+	err := withStderr(failed, operator.CommandRes{StdErr: []byte("[ERROR] Download failed\n")})
+	if err == nil || err.Error() != "Process exited with status 1\nstderr: [ERROR] Download failed" {
+		t.Errorf("want stderr added to the error, got: %v", err)
+	}
+	if !errors.Is(err, failed) {
+		t.Errorf("want the error wrapped, got: %v", err)
+	}
+
+	if err := withStderr(failed, operator.CommandRes{StdOut: []byte("output")}); err != failed {
+		t.Errorf("want the error as-is without stderr, got: %v", err)
+	}
+	if err := withStderr(nil, operator.CommandRes{StdErr: []byte("warning")}); err != nil {
+		t.Errorf("want no error when the command succeeded, got: %v", err)
+	}
+}
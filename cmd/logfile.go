@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// timestampWriter prefixes each line written to it with a timestamp. Each
+// Write is written to w at once, with the timestamps of the lines it
+// starts.
+type timestampWriter struct {
+	w       io.Writer
+	now     func() time.Time
+	midLine bool
+}
+
+func newTimestampWriter(w io.Writer) *timestampWriter {
+	return &timestampWriter{w: w, now: time.Now}
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	out := bytes.Buffer{}
+	for rest := p; len(rest) > 0; {
+		if !t.midLine {
+			out.WriteString(t.now().Format(time.RFC3339) + " ")
+			t.midLine = true
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			out.Write(rest)
+			break
+		}
+		out.Write(rest[:i+1])
+		rest = rest[i+1:]
+		t.midLine = false
+	}
+	if _, err := t.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// nodeLogFile is the file of one node in the --log-dir of join, which the
+// output of the installer on that node is appended to with a timestamp on
+// each line. It may be written to from the goroutines of both stdout and
+// stderr.
+type nodeLogFile struct {
+	file *os.File
+	w    *timestampWriter
+	lock sync.Mutex
+}
+
+// openNodeLogFile opens the file of the node at ip in dir, which is named
+// after ip with any colon of an IPv6 address replaced.
+func openNodeLogFile(dir, ip string) (*nodeLogFile, error) {
+	path := filepath.Join(dir, strings.Replace(ip, ":", "_", -1)+".log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open log file: %s", err)
+	}
+	return &nodeLogFile{file: file, w: newTimestampWriter(file)}, nil
+}
+
+func (l *nodeLogFile) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.w.Write(p)
+}
+
+func (l *nodeLogFile) Close() error {
+	return l.file.Close()
+}
+
+// teeOutputToFile copies everything written to os.Stdout and os.Stderr,
+// including the output streamed from the node, into the file at path
+// with a timestamp on each line. The returned func restores the original
+// streams and closes the file.
+func teeOutputToFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open log file: %s", err)
+	}
+
+	logWriter := newTimestampWriter(file)
+	lock := &sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	tee := func(original *os.File) (*os.File, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 4096)
+			for {
+				n, err := r.Read(buf)
+				if n > 0 {
+					original.Write(buf[:n])
+					lock.Lock()
+					logWriter.Write(buf[:n])
+					lock.Unlock()
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+		return w, nil
+	}
+
+	stdout, stderr := os.Stdout, os.Stderr
+
+	stdoutPipe, err := tee(stdout)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	stderrPipe, err := tee(stderr)
+	if err != nil {
+		stdoutPipe.Close()
+		file.Close()
+		return nil, err
+	}
+
+	os.Stdout, os.Stderr = stdoutPipe, stderrPipe
+
+	restore := func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		stdoutPipe.Close()
+		stderrPipe.Close()
+		wg.Wait()
+		file.Close()
+	}
+
+	return restore, nil
+}
+
+// LogError appends err, as main prints it, to the --log-file of command. The output of a command is only copied to the log
+// file while it runs, so the error which ended it, or an invalid flag which
+// stopped it from running at all, would be missing otherwise. args are the
+// arguments of k3sup, which are searched for --log-file when cobra did not
+// get to parse it.
+func LogError(command *cobra.Command, args []string, err error) {
+	if command == nil || command.Flags().Lookup("log-file") == nil || dryRunFromFlags(command) {
+		return
+	}
+
+	path, _ := command.Flags().GetString("log-file")
+	if len(path) == 0 {
+		path = logFileFromArgs(args)
+	}
+	if len(path) == 0 {
+		path = os.Getenv(flagEnv("log-file"))
+	}
+	if len(path) == 0 {
+		return
+	}
+
+	file, openErr := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if openErr != nil {
+		return
+	}
+	defer file.Close()
+
+	fmt.Fprintln(newTimestampWriter(file), "Error:", RedactError(err))
+}
+
+// logFileFromArgs returns the value of --log-file in args, given as
+// "--log-file path" or "--log-file=path", or nothing.
+func logFileFromArgs(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--log-file" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--log-file=") {
+			return strings.TrimPrefix(arg, "--log-file=")
+		}
+	}
+	return ""
+}
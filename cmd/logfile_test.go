@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+func Test_timestampWriter(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := newTimestampWriter(&buf)
+	w.now = func() time.Time { return time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC) }
+
+	w.Write([]byte("Waiting for kubeconfig"))
+	w.Write([]byte("... ready\nCA hash: "))
+	w.Write([]byte("0123456789abcdef\n"))
+
+	want := "2020-06-01T12:00:00Z Waiting for kubeconfig... ready\n2020-06-01T12:00:00Z CA hash: 0123456789abcdef\n"
+	if buf.String() != want {
+		t.Errorf("want: %q, got: %q", want, buf.String())
+	}
+}
+
+// countingWriter counts the writes made to it.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+func Test_timestampWriter_OneWritePerWrite(t *testing.T) {
+	out := &countingWriter{}
+	w := newTimestampWriter(out)
+	w.now = func() time.Time { return time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC) }
+
+	w.Write([]byte("[INFO]  Using v1.19.4+k3s1 as release\n[INFO]  Downloading hash\n"))
+	if out.writes != 1 {
+		t.Errorf("want one write for both lines, got: %d", out.writes)
+	}
+	want := "2020-06-01T12:00:00Z [INFO]  Using v1.19.4+k3s1 as release\n2020-06-01T12:00:00Z [INFO]  Downloading hash\n"
+	if out.String() != want {
+		t.Errorf("want: %q, got: %q", want, out.String())
+	}
+}
+
+func Test_joinNode_LogDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := joinOptions{IP: net.ParseIP("127.0.0.1"), Port: 1, SSH: sshOptions{Timeouts: sshTimeouts{Dial: time.Second}}}
+	if err := joinNode(options, false, dir); err == nil {
+		t.Fatalf("want an error without an SSH server")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "127.0.0.1.log"))
+	if err != nil {
+		t.Fatalf("want the log file of the node written: %s", err)
+	}
+	if !strings.Contains(string(data), " Error: ") {
+		t.Errorf("want the error in the log file of the node, got: %q", data)
+	}
+}
+
+func Test_runInstaller_Log(t *testing.T) {
+	node := &operator.FakeOperator{Reply: func(string) (operator.CommandRes, error) {
+		return operator.CommandRes{StdOut: []byte("[INFO]  Using v1.19.4+k3s1 as release\n"), StdErr: []byte("K3S_TOKEN='secret'\n")}, nil
+	}}
+	log := bytes.Buffer{}
+	if _, err := runInstaller(node, "curl -sfL https://get.k3s.io | sh -", "192.168.0.100", false, &log); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := "[INFO]  Using v1.19.4+k3s1 as release\nK3S_TOKEN='<redacted>'\n"; log.String() != want {
+		t.Errorf("want the output in the log with secrets masked: %q, got: %q", want, log.String())
+	}
+}
+
+func Test_LogError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name string
+		args func(path string) []string
+	}{
+		{"parsed", func(path string) []string { return []string{"--log-file", path, "--no-such-flag"} }},
+		{"not parsed", func(path string) []string { return []string{"--no-such-flag", "--log-file=" + path} }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.Replace(c.name, " ", "-", -1)+".log")
+			args := c.args(path)
+
+			command := MakeJoin()
+			err := command.ParseFlags(args)
+			if err == nil {
+				t.Fatalf("want a flag error")
+			}
+			LogError(command, args, err)
+
+			data, readErr := ioutil.ReadFile(path)
+			if readErr != nil {
+				t.Fatalf("want the log file written: %s", readErr)
+			}
+			if !strings.Contains(string(data), " Error: unknown flag: --no-such-flag\n") {
+				t.Errorf("want the error in the log file, got: %q", data)
+			}
+		})
+	}
+}
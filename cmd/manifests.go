@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// manifestConfig is the set of manifests uploaded to the auto-deploying
+// manifests directory of a server before the k3s installer runs, so that
+// k3s applies them from its first start.
+type manifestConfig struct {
+	// DataDir is the --data-dir of k3s on the node, empty for the default.
+	DataDir string
+	Files   []manifestFile
+}
+
+// manifestFile is a manifest to upload, Name is its file name on the node.
+type manifestFile struct {
+	Name    string
+	Content []byte
+}
+
+func addManifestFlag(command *cobra.Command) {
+	command.Flags().StringArray("manifest", []string{}, "Optional: a YAML manifest, or a directory of them, for k3s to apply on start, can be given more than once")
+}
+
+// manifestsFromFlags reads and validates each --manifest. A directory gives
+// the .yaml and .yml files directly in it.
+func manifestsFromFlags(command *cobra.Command, dataDir string) (manifestConfig, error) {
+	paths, _ := command.Flags().GetStringArray("manifest")
+
+	config := manifestConfig{DataDir: dataDir}
+	seen := map[string]string{}
+	for _, manifestPath := range paths {
+		files, err := manifestPaths(expandPath(manifestPath))
+		if err != nil {
+			return manifestConfig{}, err
+		}
+
+		for _, file := range files {
+			name := filepath.Base(file)
+			if other, ok := seen[name]; ok {
+				return manifestConfig{}, fmt.Errorf("manifests %s and %s would both be written to %s", other, file, name)
+			}
+			seen[name] = file
+
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return manifestConfig{}, fmt.Errorf("unable to read manifest: %s", err)
+			}
+			if err := validateManifest(content); err != nil {
+				return manifestConfig{}, fmt.Errorf("manifest %s is not valid: %s", file, err)
+			}
+			config.Files = append(config.Files, manifestFile{Name: name, Content: content})
+		}
+	}
+	return config, nil
+}
+
+func isManifestFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// manifestPaths returns manifestPath if it is a file, or the manifests in
+// it, sorted by name, if it is a directory.
+func manifestPaths(manifestPath string) ([]string, error) {
+	info, err := os.Stat(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %s", err)
+	}
+
+	if !info.IsDir() {
+		if !isManifestFile(manifestPath) {
+			return nil, fmt.Errorf("manifest %s must have a .yaml or .yml extension", manifestPath)
+		}
+		return []string{manifestPath}, nil
+	}
+
+	entries, err := ioutil.ReadDir(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest directory: %s", err)
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isManifestFile(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(manifestPath, entry.Name()))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .yaml or .yml files found in %s", manifestPath)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// validateManifest checks that each document of content is YAML with a
+// kind, empty documents between separators are allowed.
+func validateManifest(content []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for i := 1; ; i++ {
+		document := map[string]interface{}{}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("document %d: %s", i, err)
+		}
+		if len(document) == 0 {
+			continue
+		}
+		if kind, _ := document["kind"].(string); len(kind) == 0 {
+			return fmt.Errorf("document %d has no kind", i)
+		}
+	}
+}
+
+func (m manifestConfig) path(file manifestFile) string {
+	return path.Join(serverDataPath(m.DataDir, "manifests"), file.Name)
+}
+
+// apply uploads the manifests to the node.
+func (m manifestConfig) apply(operator operator.CommandOperator) error {
+	for _, file := range m.Files {
+		infof("Writing %s\n", m.path(file))
+		if err := writeRemoteFile(operator, m.path(file), file.Content, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dryRun adds the upload of the manifests to plan.
+func (m manifestConfig) dryRun(plan *dryRunPlan, where, sudoPrefix string) {
+	for _, file := range m.Files {
+		plan.run(where, writeRemoteFileCommand(sudoPrefix, m.path(file), file.Content, 0600))
+	}
+}
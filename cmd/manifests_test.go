@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+const fluxNamespace = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: flux-system
+`
+
+func writeManifestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_manifestsFromFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeManifestFiles(t, dir, map[string]string{
+		"b.yml":     fluxNamespace,
+		"a.yaml":    "---\n" + fluxNamespace + "---\n" + strings.Replace(fluxNamespace, "flux-system", "argocd", 1),
+		"README.md": "not a manifest",
+	})
+	if err := os.Mkdir(filepath.Join(dir, "nested"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeManifestFiles(t, filepath.Join(dir, "nested"), map[string]string{"c.yaml": fluxNamespace})
+
+	single, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(single)
+	writeManifestFiles(t, single, map[string]string{"flux.yaml": fluxNamespace})
+
+	command := &cobra.Command{}
+	addManifestFlag(command)
+	command.Flags().Set("manifest", dir)
+	command.Flags().Set("manifest", filepath.Join(single, "flux.yaml"))
+
+	manifests, err := manifestsFromFlags(command, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	names := []string{}
+	for _, file := range manifests.Files {
+		names = append(names, file.Name)
+	}
+	if got := strings.Join(names, ","); got != "a.yaml,b.yml,flux.yaml" {
+		t.Errorf("want the manifests of the directory sorted and then the file, got: %s", got)
+	}
+}
+
+func Test_manifestsFromFlags_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeManifestFiles(t, dir, map[string]string{
+		"invalid.yaml": "kind: [Namespace\n",
+		"nokind.yaml":  "apiVersion: v1\nmetadata: {}\n",
+		"notes.txt":    fluxNamespace,
+	})
+	empty := filepath.Join(dir, "empty")
+	if err := os.Mkdir(empty, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		manifest string
+		want     string
+	}{
+		{filepath.Join(dir, "invalid.yaml"), "is not valid: document 1"},
+		{filepath.Join(dir, "nokind.yaml"), "document 1 has no kind"},
+		{filepath.Join(dir, "notes.txt"), "must have a .yaml or .yml extension"},
+		{filepath.Join(dir, "missing.yaml"), "unable to read manifest"},
+		{empty, "no .yaml or .yml files found"},
+	}
+
+	for _, c := range cases {
+		command := &cobra.Command{}
+		addManifestFlag(command)
+		command.Flags().Set("manifest", c.manifest)
+
+		if _, err := manifestsFromFlags(command, ""); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: want error containing %q, got: %v", c.manifest, c.want, err)
+		}
+	}
+}
+
+func Test_manifestsFromFlags_DuplicateName(t *testing.T) {
+	first, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(first)
+	second, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(second)
+
+	writeManifestFiles(t, first, map[string]string{"flux.yaml": fluxNamespace})
+	writeManifestFiles(t, second, map[string]string{"flux.yaml": fluxNamespace})
+
+	command := &cobra.Command{}
+	addManifestFlag(command)
+	command.Flags().Set("manifest", first)
+	command.Flags().Set("manifest", second)
+
+	if _, err := manifestsFromFlags(command, ""); err == nil || !strings.Contains(err.Error(), "would both be written to flux.yaml") {
+		t.Errorf("want error for two manifests of the same name, got: %v", err)
+	}
+}
+
+func Test_manifestConfig_apply(t *testing.T) {
+	op := &scriptedOperator{replies: map[string]string{}}
+	manifests := manifestConfig{
+		DataDir: "/srv/k3s",
+		Files:   []manifestFile{{Name: "flux.yaml", Content: []byte(fluxNamespace)}},
+	}
+
+	if err := manifests.apply(op); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "upload /srv/k3s/server/manifests/flux.yaml 600"
+	if len(op.commands) != 1 || op.commands[0] != want {
+		t.Errorf("want: %q, got: %q", want, op.commands)
+	}
+	if string(op.uploads["/srv/k3s/server/manifests/flux.yaml"]) != fluxNamespace {
+		t.Errorf("want the content of the manifest, got: %q", op.uploads)
+	}
+}
+
+func Test_manifestConfig_dryRun(t *testing.T) {
+	manifests := manifestConfig{Files: []manifestFile{{Name: "flux.yaml", Content: []byte(fluxNamespace)}}}
+
+	plan := dryRunPlan{}
+	manifests.dryRun(&plan, "root@192.168.0.100", "sudo ")
+
+	if len(plan.steps) != 1 || len(plan.steps[0].Commands) != 1 || !strings.Contains(plan.steps[0].Commands[0], "sudo mv '/var/lib/rancher/k3s/server/manifests/flux.yaml.k3sup-upload' '/var/lib/rancher/k3s/server/manifests/flux.yaml'") {
+		t.Errorf("want the upload of the manifest to the default data-dir, got: %+v", plan)
+	}
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addMetricsFileFlag(command *cobra.Command) {
+	command.Flags().String("metrics-file", "", "Optional: write the duration and outcome of the install on each node to a file in the Prometheus textfile collector format")
+}
+
+// installMetric records the outcome of installing k3s on a single node.
+type installMetric struct {
+	Node     string
+	Duration time.Duration
+	Success  bool
+}
+
+// formatInstallMetrics renders metrics in the Prometheus text exposition
+// format, as read by the node_exporter textfile collector.
+func formatInstallMetrics(metrics []installMetric) []byte {
+	buf := bytes.Buffer{}
+
+	buf.WriteString("# HELP k3sup_install_duration_seconds Time taken to install k3s on the node.\n")
+	buf.WriteString("# TYPE k3sup_install_duration_seconds gauge\n")
+	for _, m := range metrics {
+		fmt.Fprintf(&buf, "k3sup_install_duration_seconds{node=%q} %.3f\n", m.Node, m.Duration.Seconds())
+	}
+
+	buf.WriteString("# HELP k3sup_install_success Whether the last install of k3s on the node succeeded.\n")
+	buf.WriteString("# TYPE k3sup_install_success gauge\n")
+	for _, m := range metrics {
+		success := 0
+		if m.Success {
+			success = 1
+		}
+		fmt.Fprintf(&buf, "k3sup_install_success{node=%q} %d\n", m.Node, success)
+	}
+
+	return buf.Bytes()
+}
+
+// writeInstallMetrics writes the metrics to path via a temporary file, so
+// that a collector never reads a partially written file.
+func writeInstallMetrics(path string, metrics []installMetric) error {
+	absPath, _ := filepath.Abs(path)
+
+	file, err := ioutil.TempFile(filepath.Dir(absPath), ".k3sup-metrics-*")
+	if err != nil {
+		return fmt.Errorf("unable to write metrics to %s: %s", absPath, err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(formatInstallMetrics(metrics)); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to write metrics to %s: %s", absPath, err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), absPath)
+}
+
+// recordInstallMetrics writes the metrics to path when it is set. Failing
+// to write them does not fail the install.
+func recordInstallMetrics(path string, metrics []installMetric) {
+	if len(path) == 0 {
+		return
+	}
+	if err := writeInstallMetrics(path, metrics); err != nil {
+		infof("Unable to write metrics: %s\n", err)
+	}
+}
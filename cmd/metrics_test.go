@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+func Test_formatInstallMetrics(t *testing.T) {
+	metrics := []installMetric{
+		{Node: "192.168.0.100", Duration: 43200 * time.Millisecond, Success: true},
+		{Node: "192.168.0.101", Duration: 5 * time.Second, Success: false},
+	}
+
+	want := `# HELP k3sup_install_duration_seconds Time taken to install k3s on the node.
+# TYPE k3sup_install_duration_seconds gauge
+k3sup_install_duration_seconds{node="192.168.0.100"} 43.200
+k3sup_install_duration_seconds{node="192.168.0.101"} 5.000
+# HELP k3sup_install_success Whether the last install of k3s on the node succeeded.
+# TYPE k3sup_install_success gauge
+k3sup_install_success{node="192.168.0.100"} 1
+k3sup_install_success{node="192.168.0.101"} 0
+`
+
+	got := string(formatInstallMetrics(metrics))
+	if got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func Test_MakeJoin_MetricsFile(t *testing.T) {
+	node := &operator.FakeOperator{}
+	defer fakeNode(node)()
+
+	dir, err := ioutil.TempDir("", "k3sup-metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hostsFile := filepath.Join(dir, "nodes.txt")
+	if err := ioutil.WriteFile(hostsFile, []byte("192.168.0.101\n192.168.0.102\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	metricsFile := filepath.Join(dir, "k3sup.prom")
+
+	command := MakeJoin()
+	command.Flags().Set("hosts-file", hostsFile)
+	command.Flags().Set("server-ip", "192.168.0.100")
+	command.Flags().Set("token", "a-long-enough-secret")
+	command.Flags().Set("metrics-file", metricsFile)
+
+	captureStdout(t, logQuiet, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	metrics, err := ioutil.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatalf("want the metrics written: %s", err)
+	}
+	for _, want := range []string{`k3sup_install_success{node="192.168.0.101"} 1`, `k3sup_install_success{node="192.168.0.102"} 1`} {
+		if !strings.Contains(string(metrics), want) {
+			t.Errorf("want %q, got:\n%s", want, metrics)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+const detectOSScript = `cat /etc/os-release 2>/dev/null
+if [ -f /sys/fs/cgroup/cgroup.controllers ]; then
+  echo CGROUP_VERSION=2
+  grep -qw memory /sys/fs/cgroup/cgroup.controllers && echo MEMORY_CGROUP=1 || echo MEMORY_CGROUP=0
+else
+  echo CGROUP_VERSION=1
+  echo MEMORY_CGROUP=$(awk '$1 == "memory" { print $4 }' /proc/cgroups)
+fi
+echo MODEL=$(tr -d '\0' 2>/dev/null < /proc/device-tree/model)
+`
+
+// osInfo describes the distribution and kernel features of a node which
+// affect whether k3s can run on it.
+type osInfo struct {
+	ID            string
+	VersionID     string
+	PrettyName    string
+	CgroupVersion string
+	MemoryCgroup  bool
+	Model         string
+}
+
+func (o osInfo) String() string {
+	name := o.PrettyName
+	if len(name) == 0 {
+		name = strings.TrimSpace(o.ID + " " + o.VersionID)
+	}
+	if len(name) == 0 {
+		name = "unknown"
+	}
+	return fmt.Sprintf("%s (cgroup v%s)", name, o.CgroupVersion)
+}
+
+func detectOS(operator operator.CommandOperator) (osInfo, error) {
+	res, err := operator.Execute(detectOSScript)
+	if err != nil {
+		return osInfo{}, fmt.Errorf("error received detecting the operating system: %s", err)
+	}
+	return parseOSInfo(string(res.StdOut)), nil
+}
+
+func parseOSInfo(output string) osInfo {
+	info := osInfo{}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		value := strings.Trim(parts[1], `"'`)
+		switch parts[0] {
+		case "ID":
+			info.ID = value
+		case "VERSION_ID":
+			info.VersionID = value
+		case "PRETTY_NAME":
+			info.PrettyName = value
+		case "CGROUP_VERSION":
+			info.CgroupVersion = value
+		case "MEMORY_CGROUP":
+			info.MemoryCgroup = value == "1"
+		case "MODEL":
+			info.Model = value
+		}
+	}
+	return info
+}
+
+// osWarnings returns hints for known problems with the node's operating
+// system that stop k3s from starting or pods from being scheduled.
+func osWarnings(info osInfo) []string {
+	warnings := []string{}
+
+	if !info.MemoryCgroup {
+		if strings.HasPrefix(info.Model, "Raspberry Pi") {
+			warnings = append(warnings, "the memory cgroup is not enabled, add \"cgroup_memory=1 cgroup_enable=memory\" to /boot/cmdline.txt and reboot the Raspberry Pi")
+		} else {
+			warnings = append(warnings, "the memory cgroup is not enabled, add \"cgroup_memory=1 cgroup_enable=memory\" to the kernel command-line and reboot")
+		}
+	}
+
+	if info.CgroupVersion == "2" {
+		warnings = append(warnings, "cgroup v2 is in use, this requires k3s v1.20.4 or newer")
+	}
+
+	return warnings
+}
+
+// printOSInfo detects and prints the operating system of the node along
+// with any warnings, detection failures are not fatal.
+func printOSInfo(operator operator.CommandOperator) {
+	info, err := detectOS(operator)
+	if err != nil {
+		infof("Unable to detect the operating system: %s\n", err)
+		return
+	}
+
+	infof("Detected OS: %s\n", info)
+	for _, warning := range osWarnings(info) {
+		warnf("%s\n", warning)
+	}
+}
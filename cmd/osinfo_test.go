@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_parseOSInfo(t *testing.T) {
+	output := `PRETTY_NAME="Raspbian GNU/Linux 10 (buster)"
+NAME="Raspbian GNU/Linux"
+VERSION_ID="10"
+ID=raspbian
+CGROUP_VERSION=1
+MEMORY_CGROUP=0
+MODEL=Raspberry Pi 4 Model B Rev 1.2
+`
+
+	info := parseOSInfo(output)
+
+	if info.ID != "raspbian" || info.VersionID != "10" {
+		t.Errorf("unexpected distribution: %+v", info)
+	}
+	if info.String() != "Raspbian GNU/Linux 10 (buster) (cgroup v1)" {
+		t.Errorf("unexpected description: %q", info.String())
+	}
+	if info.MemoryCgroup {
+		t.Errorf("want memory cgroup to be disabled")
+	}
+
+	warnings := osWarnings(info)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "/boot/cmdline.txt") {
+		t.Errorf("want a Raspberry Pi cmdline.txt warning, got: %v", warnings)
+	}
+}
+
+func Test_osWarnings_CgroupV2(t *testing.T) {
+	info := parseOSInfo("ID=ubuntu\nVERSION_ID=\"21.10\"\nCGROUP_VERSION=2\nMEMORY_CGROUP=1\nMODEL=\n")
+
+	warnings := osWarnings(info)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "cgroup v2") {
+		t.Errorf("want a cgroup v2 warning, got: %v", warnings)
+	}
+}
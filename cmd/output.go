@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// installResult is printed by install with --output json, in place of the
+// messages which are printed to stderr instead.
+type installResult struct {
+	IP             string `json:"ip"`
+	Context        string `json:"context"`
+	KubeconfigPath string `json:"kubeconfigPath"`
+	// K3sVersion is the version which k3s reports once installed, empty
+	// when the install failed before that.
+	K3sVersion string `json:"k3sVersion"`
+	// CAHash is the CA hash of the kubeconfig, for join --ca-hash.
+	CAHash          string  `json:"caHash,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	// PhaseSeconds is the time taken by each phase, i.e. connect, install
+	// and kubeconfig.
+	PhaseSeconds map[string]float64 `json:"phaseSeconds,omitempty"`
+	// PostInstall is the output of each --post-install-cmd which ran.
+	PostInstall []postInstallOutput `json:"postInstall,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// outputFormatFromFlags returns the value of the global --output flag, which
+// is text when the flag is not defined.
+func outputFormatFromFlags(command *cobra.Command) (string, error) {
+	output, err := command.Flags().GetString("output")
+	if err != nil || len(output) == 0 {
+		return "text", nil
+	}
+	if output != "text" && output != "json" {
+		return "", fmt.Errorf("unsupported --output %q, use text or json", output)
+	}
+	return output, nil
+}
+
+// runWithJSONOutput runs task with os.Stdout pointing at os.Stderr, so that
+// the only thing printed to stdout is the JSON from result, even when task
+// fails.
+func runWithJSONOutput(task func() error, result func(err error, duration time.Duration) interface{}) error {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+
+	start := time.Now()
+	err := task()
+	os.Stdout = stdout
+
+	if writeErr := writeJSON(stdout, result(err, time.Since(start))); writeErr != nil && err == nil {
+		return writeErr
+	}
+	return err
+}
+
+func writeJSON(w io.Writer, value interface{}) error {
+	out, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
+
+// installOutcome is what an install found out about the server, for
+// --output json.
+type installOutcome struct {
+	// Context is the name --context-template gave the context, empty
+	// otherwise, as the context is then that of --context.
+	Context     string
+	K3sVersion  string
+	CAHash      string
+	PostInstall []postInstallOutput
+}
+
+func makeInstallResult(command *cobra.Command, err error, duration time.Duration, timer *phaseTimer, outcome installOutcome) installResult {
+	ip, _ := command.Flags().GetString("ip")
+	context, _ := command.Flags().GetString("context")
+	if len(outcome.Context) > 0 {
+		context = outcome.Context
+	}
+	localPath, _ := command.Flags().GetString("local-path")
+
+	absPath, _ := filepath.Abs(localPath)
+
+	result := installResult{
+		IP:              ip,
+		Context:         context,
+		KubeconfigPath:  absPath,
+		K3sVersion:      outcome.K3sVersion,
+		CAHash:          outcome.CAHash,
+		DurationSeconds: duration.Seconds(),
+		PhaseSeconds:    timer.seconds(),
+		PostInstall:     outcome.PostInstall,
+	}
+	if err != nil {
+		result.Error = redactSecrets(err.Error())
+	}
+	return result
+}
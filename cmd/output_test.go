@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	install "github.com/alexellis/k3sup/pkg/install"
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+func Test_outputFormatFromFlags(t *testing.T) {
+	command := &cobra.Command{}
+	if got, err := outputFormatFromFlags(command); err != nil || got != "text" {
+		t.Errorf("want text without the flag, got: %q, %v", got, err)
+	}
+
+	command.Flags().String("output", "text", "")
+	command.Flags().Set("output", "json")
+	if got, err := outputFormatFromFlags(command); err != nil || got != "json" {
+		t.Errorf("want json, got: %q, %v", got, err)
+	}
+
+	command.Flags().Set("output", "yaml")
+	if _, err := outputFormatFromFlags(command); err == nil {
+		t.Errorf("want an error for yaml")
+	}
+}
+
+func Test_runWithJSONOutput(t *testing.T) {
+	command := MakeInstall()
+	command.Flags().Set("ip", "192.168.0.100")
+	command.Flags().Set("k3s-version", "v1.19.1+k3s1")
+
+	out := captureStdout(t, logInfo, func() {
+		stdout := os.Stdout
+		err := runWithJSONOutput(func() error {
+			fmt.Println("progress which must not reach stdout")
+			return fmt.Errorf("unable to connect")
+		}, func(err error, duration time.Duration) interface{} {
+			return makeInstallResult(command, err, duration, nil, installOutcome{})
+		})
+		if err == nil || err.Error() != "unable to connect" {
+			t.Fatalf("want the error of the task, got: %v", err)
+		}
+		if os.Stdout != stdout {
+			t.Fatalf("want os.Stdout to be restored")
+		}
+	})
+
+	result := installResult{}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("want only JSON on stdout, got: %q, %s", out, err)
+	}
+	if result.IP != "192.168.0.100" || result.K3sVersion != "" || result.Context != "default" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.Error != "unable to connect" {
+		t.Errorf("want the error in the result, got: %q", result.Error)
+	}
+}
+
+func Test_MakeInstall_OutputJSON(t *testing.T) {
+	node := &operator.FakeOperator{
+		Replies: map[string]operator.CommandRes{
+			install.DetectVersionCommand: {StdOut: []byte("k3s version v1.19.1+k3s1 (b66760fc)\nactive\n")},
+		},
+		Reply: pretendReply,
+	}
+	defer fakeNode(node)()
+
+	dir, err := ioutil.TempDir("", "k3sup-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	command := MakeInstall()
+	command.Flags().String("output", "text", "")
+	command.Flags().Set("output", "json")
+	command.Flags().Set("ip", "192.168.0.100")
+	command.Flags().Set("k3s-channel", "v1.19")
+	command.Flags().Set("k3s-version", "v1.19.1+k3s1")
+	command.Flags().Set("local-path", filepath.Join(dir, "kubeconfig"))
+
+	out := captureStdout(t, logQuiet, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	result := installResult{}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("want only JSON on stdout, got: %q, %s", out, err)
+	}
+	if result.K3sVersion != "v1.19.1+k3s1" {
+		t.Errorf("want the version k3s reports, got: %q", result.K3sVersion)
+	}
+	hash, _ := kubeconfigCAHash([]byte(pretendKubeconfig))
+	if len(result.CAHash) == 0 || result.CAHash != hash {
+		t.Errorf("want the CA hash %q of the kubeconfig, got: %q", hash, result.CAHash)
+	}
+}
+
+func Test_makeInstallResult_Context(t *testing.T) {
+	command := MakeInstall()
+	command.Flags().Set("ip", "192.168.0.100")
+
+	if result := makeInstallResult(command, nil, time.Second, nil, installOutcome{}); result.Context != "default" {
+		t.Errorf("want the context of --context, got: %q", result.Context)
+	}
+	if result := makeInstallResult(command, nil, time.Second, nil, installOutcome{Context: "edge-1"}); result.Context != "edge-1" {
+		t.Errorf("want the context named from --context-template, got: %q", result.Context)
+	}
+}
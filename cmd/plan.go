@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// plan describes a set of nodes and the defaults used to connect to them
+type plan struct {
+	User    string     `yaml:"user"`
+	SSHKey  string     `yaml:"ssh-key"`
+	SSHPort int        `yaml:"ssh-port"`
+	Nodes   []planNode `yaml:"nodes"`
+}
+
+type planNode struct {
+	IP          string `yaml:"ip"`
+	User        string `yaml:"user"`
+	SSHKey      string `yaml:"ssh-key"`
+	SSHPort     int    `yaml:"ssh-port"`
+	Role        string `yaml:"role"`
+	ClusterInit bool   `yaml:"cluster-init"`
+
+	// source is the plan file the node was read from
+	source string
+}
+
+// loadPlan reads a plan from path and fills in the connection details of
+// each node from the defaults of the plan.
+func loadPlan(path string) (*plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read plan %s: %s", path, err)
+	}
+
+	p := &plan{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("unable to parse plan %s: %s", path, err)
+	}
+
+	if len(p.User) == 0 {
+		p.User = "root"
+	}
+	if len(p.SSHKey) == 0 {
+		p.SSHKey = "~/.ssh/id_rsa"
+	}
+	if p.SSHPort == 0 {
+		p.SSHPort = 22
+	}
+
+	for i := range p.Nodes {
+		node := &p.Nodes[i]
+		if net.ParseIP(node.IP) == nil {
+			return nil, fmt.Errorf("node %d in plan %s has an invalid ip: %q", i, path, node.IP)
+		}
+		if len(node.User) == 0 {
+			node.User = p.User
+		}
+		if len(node.SSHKey) == 0 {
+			node.SSHKey = p.SSHKey
+		}
+		if node.SSHPort == 0 {
+			node.SSHPort = p.SSHPort
+		}
+		node.source = path
+		switch node.Role {
+		case "":
+			node.Role = "server"
+		case "server", "agent":
+		default:
+			return nil, fmt.Errorf("node %s in plan %s has an invalid role: %q, use server or agent", node.IP, path, node.Role)
+		}
+		if node.ClusterInit && node.Role != "server" {
+			return nil, fmt.Errorf("node %s in plan %s sets cluster-init but is not a server", node.IP, path)
+		}
+	}
+
+	return p, nil
+}
+
+// loadPlans reads and merges the nodes of one or more plans, conflicts
+// between the plans are reported together before any node is contacted.
+func loadPlans(paths []string) (*plan, error) {
+	merged := &plan{}
+	for _, path := range paths {
+		p, err := loadPlan(path)
+		if err != nil {
+			return nil, err
+		}
+		merged.Nodes = append(merged.Nodes, p.Nodes...)
+	}
+
+	if err := validatePlan(merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+func validatePlan(p *plan) error {
+	conflicts := []string{}
+
+	seen := map[string]string{}
+	servers := 0
+	clusterInit := []string{}
+	for _, node := range p.Nodes {
+		if source, ok := seen[node.IP]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("duplicate ip %s in %s and %s", node.IP, source, node.source))
+		}
+		seen[node.IP] = node.source
+
+		if node.Role == "server" {
+			servers++
+		}
+		if node.ClusterInit {
+			clusterInit = append(clusterInit, fmt.Sprintf("%s (%s)", node.IP, node.source))
+		}
+	}
+
+	if len(clusterInit) > 1 {
+		conflicts = append(conflicts, fmt.Sprintf("multiple cluster-init nodes: %s", strings.Join(clusterInit, ", ")))
+	} else if len(clusterInit) == 0 && servers > 1 {
+		conflicts = append(conflicts, "multiple servers but no cluster-init node, set cluster-init: true on exactly one server")
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicts found in plan:\n  %s", strings.Join(conflicts, "\n  "))
+	}
+	return nil
+}
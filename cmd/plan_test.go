@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePlans(t *testing.T, plans map[string]string) (string, []string) {
+	dir, err := ioutil.TempDir("", "k3sup-plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{}
+	for name, content := range plans {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return dir, paths
+}
+
+func Test_loadPlans_Merge(t *testing.T) {
+	dir, paths := writePlans(t, map[string]string{
+		"servers.yaml": `user: ubuntu
+nodes:
+- ip: 192.168.0.100
+  cluster-init: true
+- ip: 192.168.0.101
+`,
+		"agents.yaml": `user: pi
+ssh-port: 2222
+nodes:
+- ip: 192.168.0.110
+  role: agent
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	p, err := loadPlans(paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(p.Nodes) != 3 {
+		t.Fatalf("want 3 nodes, got: %d", len(p.Nodes))
+	}
+
+	for _, node := range p.Nodes {
+		if node.IP == "192.168.0.110" && (node.User != "pi" || node.SSHPort != 2222 || node.Role != "agent") {
+			t.Errorf("want defaults of agents.yaml applied, got: %+v", node)
+		}
+		if node.IP == "192.168.0.101" && (node.User != "ubuntu" || node.SSHPort != 22 || node.Role != "server") {
+			t.Errorf("want defaults of servers.yaml applied, got: %+v", node)
+		}
+	}
+}
+
+func Test_loadPlans_Conflicts(t *testing.T) {
+	dir, paths := writePlans(t, map[string]string{
+		"a.yaml": `nodes:
+- ip: 192.168.0.100
+  cluster-init: true
+`,
+		"b.yaml": `nodes:
+- ip: 192.168.0.100
+  role: agent
+- ip: 192.168.0.101
+  cluster-init: true
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := loadPlans(paths)
+	if err == nil {
+		t.Fatalf("want conflicts to be reported")
+	}
+
+	for _, want := range []string{"duplicate ip 192.168.0.100", "multiple cluster-init nodes"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("want error to contain %q, got: %s", want, err)
+		}
+	}
+}
+
+func Test_loadPlans_NoClusterInit(t *testing.T) {
+	dir, paths := writePlans(t, map[string]string{
+		"servers.yaml": `nodes:
+- ip: 192.168.0.100
+- ip: 192.168.0.101
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	if _, err := loadPlans(paths); err == nil {
+		t.Fatalf("want error for multiple servers without a cluster-init node")
+	}
+}
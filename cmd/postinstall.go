@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+// postInstallOutput is the output of a --post-install-cmd, for the result
+// of --output json.
+type postInstallOutput struct {
+	Command string `json:"command"`
+	Stdout  string `json:"stdout"`
+	Stderr  string `json:"stderr"`
+	Error   string `json:"error,omitempty"`
+}
+
+func addPostInstallFlag(command *cobra.Command) {
+	command.Flags().StringArray("post-install-cmd", []string{}, "Optional: command to run on the node once k3s is installed and the kubeconfig fetched, repeat for more. They run in order and stop at the first which fails")
+}
+
+func postInstallCommandsFromFlags(command *cobra.Command) ([]string, error) {
+	commands, _ := command.Flags().GetStringArray("post-install-cmd")
+	for _, postInstall := range commands {
+		if len(strings.TrimSpace(postInstall)) == 0 {
+			return nil, fmt.Errorf("--post-install-cmd cannot be empty")
+		}
+	}
+	return commands, nil
+}
+
+// runPostInstallCommands runs commands on the node one after another,
+// stopping at the first which fails. It returns the output of those which
+// ran, the failed one last. Their output is only printed with --verbose.
+func runPostInstallCommands(op operator.StreamingOperator, shellPrefix string, commands []string) ([]postInstallOutput, error) {
+	outputs := []postInstallOutput{}
+	for i, command := range commands {
+		infof("Running post-install command %d of %d\n", i+1, len(commands))
+		debugf("Executing: %s\n", redactSecrets(command))
+
+		res, err := op.ExecuteStreaming(withShellPrefix(shellPrefix, command), ioutil.Discard, ioutil.Discard)
+		debugOutput("Post-install command", res)
+
+		output := postInstallOutput{
+			Command: redactSecrets(command),
+			Stdout:  redactSecrets(string(res.StdOut)),
+			Stderr:  redactSecrets(string(res.StdErr)),
+		}
+		if err != nil {
+			err = withStderr(fmt.Errorf("post-install command %d of %d failed: %w", i+1, len(commands), err), res)
+			output.Error = redactSecrets(err.Error())
+			return append(outputs, output), err
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+func Test_postInstallCommandsFromFlags(t *testing.T) {
+	command := MakeInstall()
+	command.Flags().Set("post-install-cmd", "kubectl create namespace apps")
+	command.Flags().Set("post-install-cmd", "echo done")
+
+	got, err := postInstallCommandsFromFlags(command)
+	want := []string{"kubectl create namespace apps", "echo done"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %q, got: %q %v", want, got, err)
+	}
+
+	command.Flags().Set("post-install-cmd", " ")
+	if _, err := postInstallCommandsFromFlags(command); err == nil {
+		t.Errorf("want an error for an empty command")
+	}
+}
+
+func Test_runPostInstallCommands_StopsAtFailure(t *testing.T) {
+	node := &operator.FakeOperator{Reply: func(command string) (operator.CommandRes, error) {
+		if strings.Contains(command, "false") {
+			return operator.CommandRes{StdErr: []byte("not allowed\n")}, errors.New("Process exited with status 1")
+		}
+		return operator.CommandRes{StdOut: []byte("ok\n")}, nil
+	}}
+
+	var outputs []postInstallOutput
+	var err error
+	captureStdout(t, logQuiet, func() {
+		outputs, err = runPostInstallCommands(node, "source /etc/profile", []string{"echo one", "false", "echo three"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "post-install command 2 of 3 failed") || !strings.Contains(err.Error(), "stderr: not allowed") {
+		t.Fatalf("want the failed command and its stderr in the error, got: %v", err)
+	}
+
+	want := []string{"source /etc/profile && echo one", "source /etc/profile && false"}
+	if !reflect.DeepEqual(node.Commands(), want) {
+		t.Errorf("want: %q, got: %q", want, node.Commands())
+	}
+	if len(outputs) != 2 || outputs[0].Stdout != "ok\n" || outputs[1].Stderr != "not allowed\n" || len(outputs[1].Error) == 0 {
+		t.Errorf("want the output of both commands which ran, got: %+v", outputs)
+	}
+}
+
+func Test_runPostInstallCommands_OutputOnlyWhenVerbose(t *testing.T) {
+	for level, want := range map[logLevel]bool{logInfo: false, logDebug: true} {
+		var outputs []postInstallOutput
+		var err error
+		out := captureStdout(t, level, func() {
+			outputs, err = runPostInstallCommands(operator.ExecOperator{}, "", []string{"echo created namespace apps"})
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got := strings.Contains(out, "created namespace apps"); got != want {
+			t.Errorf("level %d: want the output printed %t, got:\n%s", level, want, out)
+		}
+		if len(outputs) != 1 || outputs[0].Stdout != "created namespace apps\n" {
+			t.Errorf("want the output returned, got: %+v", outputs)
+		}
+	}
+}
+
+func Test_runPostInstallCommands_RedactsOutput(t *testing.T) {
+	node := &operator.FakeOperator{Reply: func(command string) (operator.CommandRes, error) {
+		return operator.CommandRes{StdOut: []byte("K3S_TOKEN='secret-value'\n")}, nil
+	}}
+
+	var outputs []postInstallOutput
+	captureStdout(t, logQuiet, func() {
+		outputs, _ = runPostInstallCommands(node, "", []string{"env K3S_TOKEN='secret-value' true"})
+	})
+	if len(outputs) != 1 || strings.Contains(outputs[0].Command, "secret-value") || strings.Contains(outputs[0].Stdout, "secret-value") {
+		t.Errorf("want the token redacted, got: %+v", outputs)
+	}
+}
+
+func Test_MakeInstall_PostInstallJSON(t *testing.T) {
+	node := &operator.FakeOperator{
+		Replies: map[string]operator.CommandRes{"kubectl create namespace apps": {StdOut: []byte("namespace/apps created\n")}},
+		Reply:   pretendReply,
+	}
+	defer fakeNode(node)()
+
+	dir, err := ioutil.TempDir("", "k3sup-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stdout, err := ioutil.TempFile(dir, "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	command := MakeInstall()
+	command.Flags().String("output", "text", "")
+	command.Flags().Set("output", "json")
+	command.Flags().Set("ip", "192.168.0.100")
+	command.Flags().Set("k3s-version", "v1.19.1+k3s1")
+	command.Flags().Set("local-path", filepath.Join(dir, "kubeconfig"))
+	command.Flags().Set("post-install-cmd", "kubectl create namespace apps")
+
+	// The progress goes to stderr, only the result to stdout.
+	original := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = original }()
+
+	err = command.RunE(command, nil)
+	os.Stdout = original
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	commands := node.Commands()
+	if commands[len(commands)-1] != "kubectl create namespace apps" {
+		t.Errorf("want the post-install command run last, got: %q", commands)
+	}
+
+	out, err := ioutil.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := installResult{}
+	if err := json.Unmarshal(out, &result); err != nil {
+		t.Fatalf("want JSON on stdout, got: %q, %s", out, err)
+	}
+	want := []postInstallOutput{{Command: "kubectl create namespace apps", Stdout: "namespace/apps created\n"}}
+	if !reflect.DeepEqual(result.PostInstall, want) {
+		t.Errorf("want: %+v, got: %+v", want, result.PostInstall)
+	}
+}
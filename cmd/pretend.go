@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+// nodeOperator is the connection to a node which install, join and upgrade
+// run their commands over.
+type nodeOperator interface {
+	operator.StreamingOperator
+	Close() error
+}
+
+// operatorFactory connects to the node at address as user.
+type operatorFactory func(address, user, sshKeyPath string, options sshOptions) (nodeOperator, error)
+
+// connectNode is how install, join and upgrade connect to a node, tests
+// replace it to run the commands against an operator.FakeOperator.
+var connectNode operatorFactory = func(address, user, sshKeyPath string, options sshOptions) (nodeOperator, error) {
+	op, err := connectSSH(address, user, sshKeyPath, options)
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func addPretendFlag(command *cobra.Command) {
+	command.Flags().Bool("pretend", false, "Go through the whole install against a pretend node which answers every command, without connecting to or changing anything, i.e. for a demo")
+}
+
+// pretendFactory returns an operatorFactory which connects to nothing, its
+// operators answer the commands of install as a node with k3s starting
+// would.
+func pretendFactory() operatorFactory {
+	return func(address, user, sshKeyPath string, options sshOptions) (nodeOperator, error) {
+		infof("Pretending to connect to %s as %s, nothing is run on it\n", address, user)
+		return &operator.FakeOperator{Reply: pretendReply, Audit: options.Audit.recorder(address)}, nil
+	}
+}
+
+// pretendCAData is the CA of the pretend kubeconfig, which is no real
+// certificate.
+var pretendCAData = base64.StdEncoding.EncodeToString([]byte("-----BEGIN CERTIFICATE-----\nazNzdXAgLS1wcmV0ZW5k\n-----END CERTIFICATE-----\n"))
+
+// pretendKubeconfig is the kubeconfig of a pretend server, as k3s writes
+// it, the credentials are made up.
+var pretendKubeconfig = fmt.Sprintf(`apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: https://127.0.0.1:6443
+  name: default
+contexts:
+- context:
+    cluster: default
+    user: default
+  name: default
+current-context: default
+kind: Config
+preferences: {}
+users:
+- name: default
+  user:
+    password: pretend
+    username: admin
+`, pretendCAData)
+
+// pretendReply answers a command of install as a node would on which k3s
+// is not yet installed, and then starts at once.
+func pretendReply(command string) (operator.CommandRes, error) {
+	reply := ""
+	switch {
+	case strings.Contains(command, "-n true > /dev/null 2>&1; then echo found"):
+		reply = "found\n"
+	case strings.Contains(command, detectOSScript):
+		reply = "PRETTY_NAME=\"Pretend Linux\"\nID=pretend\nCGROUP_VERSION=1\nMEMORY_CGROUP=1\n"
+	case strings.Contains(command, "else echo not-installed; fi"):
+		reply = "not-installed\n"
+	case strings.Contains(command, "then echo ready; fi"):
+		reply = "ready\n"
+	case command == contextFactsCommand:
+		reply = "pretend\nk3s version v0.0.0+pretend (pretend)\n"
+	case strings.Contains(command, "cat "+install.KubeconfigPath):
+		reply = pretendKubeconfig
+	}
+	return operator.CommandRes{StdOut: []byte(reply)}, nil
+}
@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+// fakeNode makes connectNode return node until the returned func is called.
+func fakeNode(node *operator.FakeOperator) func() {
+	original := connectNode
+	connectNode = func(address, user, sshKeyPath string, options sshOptions) (nodeOperator, error) {
+		return node, nil
+	}
+	return func() { connectNode = original }
+}
+
+func containsCommand(commands []string, part string) bool {
+	for _, command := range commands {
+		if strings.Contains(command, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_MakeInstall_FakeOperator(t *testing.T) {
+	node := &operator.FakeOperator{Reply: pretendReply}
+	defer fakeNode(node)()
+
+	dir, err := ioutil.TempDir("", "k3sup-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "kubeconfig")
+
+	command := MakeInstall()
+	command.Flags().Set("ip", "192.168.0.100")
+	command.Flags().Set("k3s-version", "v1.19.1+k3s1")
+	command.Flags().Set("local-path", localPath)
+
+	captureStdout(t, logQuiet, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !containsCommand(node.Commands(), "INSTALL_K3S_VERSION='v1.19.1+k3s1'") {
+		t.Errorf("want the installer run, got: %q", node.Commands())
+	}
+	kubeconfig, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatalf("want the kubeconfig saved: %s", err)
+	}
+	if !strings.Contains(string(kubeconfig), "server: https://192.168.0.100:6443") {
+		t.Errorf("want the kubeconfig to point at the node, got:\n%s", kubeconfig)
+	}
+}
+
+func Test_MakeInstall_FakeOperator_AlreadyInstalled(t *testing.T) {
+	node := &operator.FakeOperator{
+		Replies: map[string]operator.CommandRes{
+			install.DetectVersionCommand: {StdOut: []byte("k3s version v1.19.1+k3s1 (b66760fc)\nactive\n")},
+		},
+		Reply: pretendReply,
+	}
+	defer fakeNode(node)()
+
+	command := MakeInstall()
+	command.Flags().Set("ip", "192.168.0.100")
+	command.Flags().Set("k3s-version", "v1.19.1+k3s1")
+	command.Flags().Set("local-path", kubeconfigStdout)
+
+	var err error
+	printed := captureStdout(t, logQuiet, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if containsCommand(node.Commands(), "get.k3s.io") {
+		t.Errorf("want no installer for the same version, got: %q", node.Commands())
+	}
+	if !strings.Contains(printed, "name: default") {
+		t.Errorf("want the kubeconfig on stdout, got:\n%s", printed)
+	}
+}
+
+func Test_MakeInstall_Pretend(t *testing.T) {
+	original := connectNode
+	connectNode = func(address, user, sshKeyPath string, options sshOptions) (nodeOperator, error) {
+		t.Fatalf("want no connection to %s with --pretend", address)
+		return nil, nil
+	}
+	defer func() { connectNode = original }()
+
+	dir, err := ioutil.TempDir("", "k3sup-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "kubeconfig")
+
+	command := MakeInstall()
+	command.Flags().Set("ip", "192.168.0.100")
+	command.Flags().Set("k3s-version", "v1.19.1+k3s1")
+	command.Flags().Set("local-path", localPath)
+	command.Flags().Set("pretend", "true")
+
+	printed := captureStdout(t, logInfo, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(printed, "Installed k3s v1.19.1+k3s1 on 192.168.0.100") {
+		t.Errorf("want the summary of the install, got:\n%s", printed)
+	}
+	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+		t.Errorf("want no kubeconfig saved with --pretend, got: %v", err)
+	}
+}
+
+func Test_MakeInstall_ContextTemplate(t *testing.T) {
+	node := &operator.FakeOperator{
+		Replies: map[string]operator.CommandRes{
+			contextFactsCommand: {StdOut: []byte("edge-1\nk3s version v1.19.1+k3s1 (b66760fc)\n")},
+		},
+		Reply: pretendReply,
+	}
+	defer fakeNode(node)()
+
+	command := MakeInstall()
+	command.Flags().Set("ip", "192.168.0.100")
+	command.Flags().Set("k3s-version", "v1.19.1+k3s1")
+	command.Flags().Set("local-path", kubeconfigStdout)
+	command.Flags().Set("context-template", "{{.Hostname}}-{{.K3sVersion}}")
+
+	var err error
+	printed := captureStdout(t, logQuiet, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(printed, "current-context: edge-1-v1.19.1+k3s1") {
+		t.Errorf("want the context named from the template, got:\n%s", printed)
+	}
+	if command.Flags().Changed("context") {
+		t.Errorf("want --context left as given")
+	}
+
+	// The same command runs again, as for each node of a script.
+	captureStdout(t, logQuiet, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Errorf("unexpected error running again: %s", err)
+	}
+}
+
+func Test_MakeInstall_PretendLocal(t *testing.T) {
+	command := MakeInstall()
+	command.Flags().Set("local", "true")
+	command.Flags().Set("pretend", "true")
+
+	if err := command.RunE(command, nil); err == nil || !strings.Contains(err.Error(), "--pretend cannot be used with --local") {
+		t.Errorf("want an error for --pretend with --local, got: %v", err)
+	}
+}
+
+func Test_MakeJoin_FakeOperator(t *testing.T) {
+	node := &operator.FakeOperator{}
+	defer fakeNode(node)()
+
+	command := MakeJoin()
+	command.Flags().Set("ip", "192.168.0.101")
+	command.Flags().Set("server-ip", "192.168.0.100")
+	command.Flags().Set("token", "a-long-enough-secret")
+
+	var err error
+	captureStdout(t, logQuiet, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !containsCommand(node.Commands(), "K3S_URL='https://192.168.0.100:6443'") {
+		t.Errorf("want the agent joined to the server, got: %q", node.Commands())
+	}
+}
+
+func Test_MakeJoin_PinChannel(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Redirect(w, r, "https://github.com/k3s-io/k3s/releases/tag/v1.19.5+k3s2", http.StatusFound)
+	}))
+	defer server.Close()
+
+	node := &operator.FakeOperator{}
+	defer fakeNode(node)()
+
+	hostsFile, err := ioutil.TempFile("", "k3sup-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(hostsFile.Name())
+	hostsFile.WriteString("192.168.0.101\n192.168.0.102\n192.168.0.103\n")
+	hostsFile.Close()
+
+	command := MakeJoin()
+	command.Flags().Set("hosts-file", hostsFile.Name())
+	command.Flags().Set("server-ip", "192.168.0.100")
+	command.Flags().Set("token", "a-long-enough-secret")
+	command.Flags().Set("k3s-channel", "stable")
+	command.Flags().Set("channel-url", server.URL)
+	command.Flags().Set("pin-channel", "true")
+
+	captureStdout(t, logQuiet, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if requests != 1 {
+		t.Errorf("want the channel resolved once, got %d requests", requests)
+	}
+	installs := 0
+	for _, command := range node.Commands() {
+		if strings.Contains(command, "K3S_URL=") {
+			installs++
+			if !strings.Contains(command, "INSTALL_K3S_VERSION='v1.19.5+k3s2'") || strings.Contains(command, "INSTALL_K3S_CHANNEL") {
+				t.Errorf("want the pinned version installed, got: %s", command)
+			}
+		}
+	}
+	if installs != 3 {
+		t.Errorf("want 3 nodes joined, got: %d", installs)
+	}
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// sshProxy is a jump host which the connection to a node is made through.
+// An empty Address connects to the node directly. The user and key of the
+// node are used when User or KeyPath are empty.
+type sshProxy struct {
+	User    string
+	Address string
+	KeyPath string
+}
+
+func addSSHProxyFlags(command *cobra.Command) {
+	command.Flags().String("ssh-proxy", "", "Optional: jump host to connect to nodes through, as [user@]host[:port]. The address of the node is resolved by the jump host")
+	command.Flags().String("ssh-proxy-key", "", "Optional: the ssh key for the jump host, defaults to --ssh-key")
+}
+
+// sshProxyFromFlags returns the jump host given by --ssh-proxy, otherwise
+// the ProxyJump of the SSH config when there is one.
+func sshProxyFromFlags(command *cobra.Command, proxyJump string) (sshProxy, error) {
+	value, _ := command.Flags().GetString("ssh-proxy")
+	if !flagGiven(command, "ssh-proxy") && proxyJump != "none" {
+		value = proxyJump
+	}
+
+	proxy, err := parseSSHProxy(value)
+	if err != nil {
+		return sshProxy{}, fmt.Errorf("invalid --ssh-proxy %q: %s", value, err)
+	}
+
+	if keyPath, _ := command.Flags().GetString("ssh-proxy-key"); len(keyPath) > 0 {
+		proxy.KeyPath = expandPath(keyPath)
+	}
+	return proxy, nil
+}
+
+// parseSSHProxy parses [user@]host[:port], the port defaults to 22. Only a
+// single jump host is supported.
+func parseSSHProxy(value string) (sshProxy, error) {
+	proxy := sshProxy{}
+	if len(value) == 0 {
+		return proxy, nil
+	}
+	if strings.Contains(value, ",") {
+		return sshProxy{}, fmt.Errorf("only a single jump host is supported")
+	}
+
+	if at := strings.LastIndex(value, "@"); at >= 0 {
+		proxy.User = value[:at]
+		value = value[at+1:]
+		if len(proxy.User) == 0 {
+			return sshProxy{}, fmt.Errorf("empty user")
+		}
+	}
+
+	host, port := value, "22"
+	if h, p, err := net.SplitHostPort(value); err == nil {
+		host, port = h, p
+	}
+	host = strings.Trim(host, "[]")
+	if len(host) == 0 {
+		return sshProxy{}, fmt.Errorf("empty host")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return sshProxy{}, fmt.Errorf("invalid port %q", port)
+	}
+
+	proxy.Address = net.JoinHostPort(host, port)
+	return proxy, nil
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_parseSSHProxy(t *testing.T) {
+	tests := []struct {
+		title   string
+		value   string
+		want    sshProxy
+		wantErr bool
+	}{
+		{title: "empty connects directly", value: "", want: sshProxy{}},
+		{title: "host only", value: "bastion.example.com", want: sshProxy{Address: "bastion.example.com:22"}},
+		{title: "user and port", value: "jump@10.0.0.1:2222", want: sshProxy{User: "jump", Address: "10.0.0.1:2222"}},
+		{title: "IPv6 with port", value: "[fd00::1]:2222", want: sshProxy{Address: "[fd00::1]:2222"}},
+		{title: "empty user", value: "@bastion", wantErr: true},
+		{title: "invalid port", value: "bastion:ssh", wantErr: true},
+		{title: "chain of jump hosts", value: "one,two", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			got, err := parseSSHProxy(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("want an error for %q", test.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != test.want {
+				t.Errorf("want: %+v, got: %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func Test_NewSSHOperatorViaProxy(t *testing.T) {
+	bastion, stopBastion := startPasswordServer(t, "jump", "bastion")
+	defer stopBastion()
+	node, stopNode := startPasswordServer(t, "pi", "raspberry")
+	defer stopNode()
+
+	passwordConfig := func(user, password string) *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.Password(password)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         5 * time.Second,
+		}
+	}
+
+	op, err := operator.NewSSHOperatorViaProxy(bastion, passwordConfig("jump", "bastion"), node, passwordConfig("pi", "raspberry"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	res, err := op.ExecuteStreaming("hostname", ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(res.StdOut) != "ok\n" {
+		t.Errorf("want: %q, got: %q", "ok\n", res.StdOut)
+	}
+
+	if err := op.Close(); err != nil {
+		t.Errorf("unexpected error closing: %s", err)
+	}
+}
+
+func Test_NewSSHOperatorViaProxy_UnreachableNode(t *testing.T) {
+	bastion, stopBastion := startPasswordServer(t, "jump", "bastion")
+	defer stopBastion()
+
+	config := &ssh.ClientConfig{
+		User:            "jump",
+		Auth:            []ssh.AuthMethod{ssh.Password("bastion")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	}
+
+	// Nothing listens on port 1 of the jump host.
+	if _, err := operator.NewSSHOperatorViaProxy(bastion, config, "127.0.0.1:1", config); err == nil {
+		t.Fatalf("want an error when the jump host cannot reach the node")
+	}
+}
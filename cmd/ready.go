@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+// serverArtifact is something which a k3s server creates as it starts,
+// which is ready once its command prints "ready".
+type serverArtifact struct {
+	Name    string
+	Command string
+}
+
+func kubeconfigArtifact(sudoPrefix string) serverArtifact {
+	return serverArtifact{
+		Name:    "kubeconfig",
+		Command: fmt.Sprintf("if %stest -f /etc/rancher/k3s/k3s.yaml; then echo ready; fi", sudoPrefix),
+	}
+}
+
+// serviceArtifact is ready once the systemd service is active, i.e. after
+// it has been restarted.
+func serviceArtifact(service string) serverArtifact {
+	return serverArtifact{
+		Name:    service + " service",
+		Command: fmt.Sprintf("if systemctl is-active --quiet %s; then echo ready; fi", service),
+	}
+}
+
+func nodeTokenArtifact(sudoPrefix, dataDir string) serverArtifact {
+	return serverArtifact{
+		Name:    "node-token",
+		Command: fmt.Sprintf("if %stest -f %s; then echo ready; fi", sudoPrefix, serverDataPath(dataDir, "node-token")),
+	}
+}
+
+func readyzArtifact(sudoPrefix string) serverArtifact {
+	return serverArtifact{
+		Name:    "/readyz",
+		Command: fmt.Sprintf("if [ \"$(%sk3s kubectl get --raw /readyz 2>/dev/null)\" = \"ok\" ]; then echo ready; fi", sudoPrefix),
+	}
+}
+
+// nodeNameCommand prints the name which k3s registers the node with by
+// default, the lowercase hostname.
+const nodeNameCommand = "$(hostname | tr '[:upper:]' '[:lower:]')"
+
+// nodeNameArg is the node in a command, nodeName as given with --node-name
+// or else the hostname, as k3s names it.
+func nodeNameArg(nodeName string) string {
+	if len(nodeName) == 0 {
+		return nodeNameCommand
+	}
+	return operator.ShellQuote(nodeName)
+}
+
+func nodeArtifact(sudoPrefix, nodeName string) serverArtifact {
+	return serverArtifact{
+		Name:    "node",
+		Command: fmt.Sprintf("if %sk3s kubectl get node %s > /dev/null 2>&1; then echo ready; fi", sudoPrefix, nodeNameArg(nodeName)),
+	}
+}
+
+// nodeReadyArtifact is ready once the Ready condition of the node is True,
+// which is some time after the node has registered.
+func nodeReadyArtifact(sudoPrefix, nodeName string) serverArtifact {
+	return serverArtifact{
+		Name:    "node Ready condition",
+		Command: fmt.Sprintf("if [ \"$(%sk3s kubectl get node %s -o jsonpath='{.status.conditions[?(@.type==\"Ready\")].status}' 2>/dev/null)\" = \"True\" ]; then echo ready; fi", sudoPrefix, nodeNameArg(nodeName)),
+	}
+}
+
+// waitForServer polls each artifact in turn until all of them are ready,
+// or returns an error naming the artifact which was not ready in time.
+func waitForServer(operator operator.CommandOperator, artifacts []serverArtifact, timeout, interval time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+	if interval <= 0 {
+		return fmt.Errorf("--server-ready-interval must be greater than zero")
+	}
+
+	deadline := time.Now().Add(timeout)
+	for _, artifact := range artifacts {
+		infof("Waiting for %s", artifact.Name)
+		for {
+			res, err := operator.Execute(artifact.Command)
+			if err == nil && strings.TrimSpace(string(res.StdOut)) == "ready" {
+				infof(" ready\n")
+				break
+			}
+
+			if time.Now().Add(interval).After(deadline) {
+				infof("\n")
+				return fmt.Errorf("timed out after %s waiting for the server: %s is not ready", timeout, artifact.Name)
+			}
+
+			infof(".")
+			time.Sleep(interval)
+		}
+	}
+
+	return nil
+}
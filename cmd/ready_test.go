@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+// readyAfterOperator reports an artifact as ready once its command has
+// been executed the given number of times.
+type readyAfterOperator struct {
+	readyAfter map[string]int
+	calls      map[string]int
+}
+
+func (r *readyAfterOperator) Execute(command string) (operator.CommandRes, error) {
+	r.calls[command]++
+	if after, ok := r.readyAfter[command]; ok && r.calls[command] >= after {
+		return operator.CommandRes{StdOut: []byte("ready\n")}, nil
+	}
+	return operator.CommandRes{}, nil
+}
+
+func (r *readyAfterOperator) Upload(reader io.Reader, remotePath string, mode os.FileMode) error {
+	return fmt.Errorf("unexpected upload of %s", remotePath)
+}
+
+func Test_waitForServer(t *testing.T) {
+	kubeconfig := kubeconfigArtifact("sudo ")
+	readyz := readyzArtifact("sudo ")
+	op := &readyAfterOperator{
+		readyAfter: map[string]int{kubeconfig.Command: 1, readyz.Command: 3},
+		calls:      map[string]int{},
+	}
+
+	err := waitForServer(op, []serverArtifact{kubeconfig, readyz}, time.Second, time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if op.calls[readyz.Command] != 3 {
+		t.Errorf("want /readyz to be polled 3 times, got: %d", op.calls[readyz.Command])
+	}
+}
+
+func Test_waitForServer_Timeout(t *testing.T) {
+	kubeconfig := kubeconfigArtifact("")
+	token := nodeTokenArtifact("", "")
+	op := &readyAfterOperator{
+		readyAfter: map[string]int{kubeconfig.Command: 1},
+		calls:      map[string]int{},
+	}
+
+	err := waitForServer(op, []serverArtifact{kubeconfig, token}, 20*time.Millisecond, 5*time.Millisecond)
+	if err == nil {
+		t.Fatalf("want a timeout error")
+	}
+
+	if !strings.Contains(err.Error(), "node-token is not ready") {
+		t.Errorf("want the error to name the node-token, got: %s", err)
+	}
+}
+
+func Test_waitForNodeReady_Timeout(t *testing.T) {
+	op := &readyAfterOperator{readyAfter: map[string]int{}, calls: map[string]int{}}
+
+	err := waitForNodeReady(op, "sudo ", "", 20*time.Millisecond, 5*time.Millisecond)
+	if err == nil {
+		t.Fatalf("want a timeout error")
+	}
+
+	if !strings.Contains(err.Error(), "node Ready condition is not ready") {
+		t.Errorf("want the error to name the Ready condition, got: %s", err)
+	}
+}
+
+func Test_nodeReadyArtifact(t *testing.T) {
+	got := nodeReadyArtifact("sudo ", "").Command
+	want := `if [ "$(sudo k3s kubectl get node $(hostname | tr '[:upper:]' '[:lower:]') -o jsonpath='{.status.conditions[?(@.type=="Ready")].status}' 2>/dev/null)" = "True" ]; then echo ready; fi`
+	if got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
+
+func Test_nodeReadyArtifact_NodeName(t *testing.T) {
+	got := nodeReadyArtifact("", "edge-1").Command
+	if !strings.Contains(got, "k3s kubectl get node 'edge-1' -o jsonpath") {
+		t.Errorf("want the node of --node-name rather than the hostname, got: %q", got)
+	}
+	if got := labelControlPlaneCommand("sudo ", "edge-1"); !strings.HasPrefix(got, "sudo k3s kubectl label node 'edge-1' node-role") {
+		t.Errorf("want the node of --node-name labelled, got: %q", got)
+	}
+}
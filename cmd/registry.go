@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const registriesPath = "/etc/rancher/k3s/registries.yaml"
+
+// registryConfig is the registries.yaml written to a node before the k3s
+// installer runs, so that k3s uses it from its first start.
+type registryConfig struct {
+	// TemplatePath is a --registry-config-template, rendered for each node.
+	TemplatePath string
+	// Labels are the --node-label of the node, for the template.
+	Labels map[string]string
+	// Content is written as it is, from --registry-config or
+	// --registry-mirror.
+	Content []byte
+}
+
+func addRegistryFlags(command *cobra.Command) {
+	command.Flags().String("registry-config", "", "Optional: registries.yaml to write to the node before installing, i.e. for a private registry with credentials")
+	command.Flags().String("registry-mirror", "", "Optional: endpoint of a mirror for docker.io, written to registries.yaml on the node before installing")
+	command.Flags().String("registry-config-template", "", "Optional: Go template for registries.yaml, rendered with the node's .IP, .Hostname, .Role and the .Labels of --node-label and written to the node before installing")
+}
+
+func registryConfigFromFlags(command *cobra.Command) (registryConfig, error) {
+	templatePath, _ := command.Flags().GetString("registry-config-template")
+	configPath, _ := command.Flags().GetString("registry-config")
+	mirror, _ := command.Flags().GetString("registry-mirror")
+
+	given := 0
+	for _, value := range []string{templatePath, configPath, mirror} {
+		if len(value) > 0 {
+			given++
+		}
+	}
+	if given > 1 {
+		return registryConfig{}, fmt.Errorf("only one of --registry-config, --registry-mirror and --registry-config-template can be given")
+	}
+
+	switch {
+	case len(configPath) > 0:
+		content, err := ioutil.ReadFile(expandPath(configPath))
+		if err != nil {
+			return registryConfig{}, fmt.Errorf("unable to read registry config: %s", err)
+		}
+		if err := yaml.Unmarshal(content, &map[string]interface{}{}); err != nil {
+			return registryConfig{}, fmt.Errorf("registry config %s is not valid YAML: %s", configPath, err)
+		}
+		return registryConfig{Content: content}, nil
+	case len(mirror) > 0:
+		content, err := makeRegistryMirrorConfig(mirror)
+		if err != nil {
+			return registryConfig{}, err
+		}
+		return registryConfig{Content: content}, nil
+	}
+
+	// The labels were validated as key=value with the other flags of the
+	// node.
+	labels := map[string]string{}
+	nodeLabels, _ := command.Flags().GetStringArray("node-label")
+	for _, label := range nodeLabels {
+		if parts := strings.SplitN(label, "=", 2); len(parts) == 2 {
+			labels[parts[0]] = parts[1]
+		}
+	}
+	return registryConfig{TemplatePath: templatePath, Labels: labels}, nil
+}
+
+// makeRegistryMirrorConfig returns a registries.yaml which pulls images
+// from docker.io through endpoint.
+func makeRegistryMirrorConfig(endpoint string) ([]byte, error) {
+	if !isHTTPURL(endpoint) {
+		return nil, fmt.Errorf("--registry-mirror must be a http or https URL, got: %q", endpoint)
+	}
+
+	type mirror struct {
+		Endpoint []string `yaml:"endpoint"`
+	}
+	config := struct {
+		Mirrors map[string]mirror `yaml:"mirrors"`
+	}{
+		Mirrors: map[string]mirror{"docker.io": {Endpoint: []string{endpoint}}},
+	}
+	return yaml.Marshal(config)
+}
+
+// apply writes the registries.yaml to the node, if one was given.
+func (r registryConfig) apply(operator operator.CommandOperator, ip, role string) error {
+	if len(r.TemplatePath) > 0 {
+		return applyRegistryTemplate(operator, r.TemplatePath, ip, role, r.Labels)
+	}
+	if len(r.Content) == 0 {
+		return nil
+	}
+
+	infof("Writing %s\n", registriesPath)
+	return writeRemoteFile(operator, registriesPath, r.Content, 0600)
+}
+
+// dryRun adds the write of the registries.yaml to plan.
+func (r registryConfig) dryRun(plan *dryRunPlan, where, sudoPrefix string) {
+	if len(r.TemplatePath) > 0 {
+		plan.note(where, "render %s for the node and write it to %s", r.TemplatePath, registriesPath)
+	} else if len(r.Content) > 0 {
+		plan.run(where, writeRemoteFileCommand(sudoPrefix, registriesPath, r.Content, 0600))
+	}
+}
+
+// registryTemplateData is the metadata of a node available to a
+// --registry-config-template.
+type registryTemplateData struct {
+	IP       string
+	Hostname string
+	Role     string
+	Labels   map[string]string
+}
+
+// renderRegistryConfig renders a registries.yaml template and checks that
+// the result is valid YAML.
+func renderRegistryConfig(text string, data registryTemplateData) ([]byte, error) {
+	tmpl, err := template.New("registries").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse registry config template: %s", err)
+	}
+
+	out := bytes.Buffer{}
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, fmt.Errorf("unable to render registry config template: %s", err)
+	}
+
+	rendered := map[string]interface{}{}
+	if err := yaml.Unmarshal(out.Bytes(), &rendered); err != nil {
+		return nil, fmt.Errorf("rendered registry config for %s is not valid YAML: %s", data.IP, err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// applyRegistryTemplate renders the template at templatePath with the
+// metadata of the node and writes it to registries.yaml on the node.
+func applyRegistryTemplate(operator operator.CommandOperator, templatePath, ip, role string, labels map[string]string) error {
+	text, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return fmt.Errorf("unable to read registry config template: %s", err)
+	}
+
+	res, err := operator.Execute("hostname")
+	if err != nil {
+		return fmt.Errorf("error received reading the hostname: %s", err)
+	}
+
+	data := registryTemplateData{
+		IP:       ip,
+		Hostname: strings.TrimSpace(string(res.StdOut)),
+		Role:     role,
+		Labels:   labels,
+	}
+	if data.Labels == nil {
+		data.Labels = map[string]string{}
+	}
+
+	rendered, err := renderRegistryConfig(string(text), data)
+	if err != nil {
+		return err
+	}
+
+	infof("Writing %s\n", registriesPath)
+	return writeRemoteFile(operator, registriesPath, rendered, 0600)
+}
+
+// writeRemoteFile uploads data to path on the node, with the sudo of the
+// operator.
+func writeRemoteFile(operator operator.CommandOperator, filePath string, data []byte, mode os.FileMode) error {
+	if err := operator.Upload(bytes.NewReader(data), filePath, mode); err != nil {
+		return fmt.Errorf("error received writing %s: %s", filePath, err)
+	}
+	return nil
+}
+
+// writeRemoteFileCommand is the command of operator.Upload which
+// writeRemoteFile runs, with data piped to it, for --dry-run to print. The
+// content is base64 encoded so that it needs no quoting.
+func writeRemoteFileCommand(sudoPrefix, filePath string, data []byte, mode os.FileMode) string {
+	return fmt.Sprintf("echo %s | base64 -d | { %s; }",
+		base64.StdEncoding.EncodeToString(data),
+		operator.UploadCommand(sudoPrefix, filePath, mode))
+}
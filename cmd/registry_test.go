@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+const registryTemplate = `mirrors:
+  docker.io:
+    endpoint:
+{{- if eq .Labels.zone "edge" }}
+      - "https://edge-mirror.local:5000"
+{{- else }}
+      - "https://mirror.local:5000"
+{{- end }}
+configs:
+  "mirror.local:5000":
+    auth:
+      username: {{ .Hostname }}
+`
+
+func Test_renderRegistryConfig(t *testing.T) {
+	got, err := renderRegistryConfig(registryTemplate, registryTemplateData{
+		IP:       "192.168.0.101",
+		Hostname: "edge-1",
+		Labels:   map[string]string{"zone": "edge"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(string(got), "https://edge-mirror.local:5000") {
+		t.Errorf("want the edge mirror, got:\n%s", got)
+	}
+	if !strings.Contains(string(got), "username: edge-1") {
+		t.Errorf("want the hostname to be rendered, got:\n%s", got)
+	}
+}
+
+func Test_renderRegistryConfig_InvalidYAML(t *testing.T) {
+	_, err := renderRegistryConfig("mirrors: [{{ .Hostname }}", registryTemplateData{Hostname: "edge-1"})
+	if err == nil || !strings.Contains(err.Error(), "not valid YAML") {
+		t.Errorf("want an invalid YAML error, got: %v", err)
+	}
+}
+
+func Test_makeRegistryMirrorConfig(t *testing.T) {
+	got, err := makeRegistryMirrorConfig("https://mirror.local:5000")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := `mirrors:
+  docker.io:
+    endpoint:
+    - https://mirror.local:5000
+`
+	if string(got) != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	if _, err := makeRegistryMirrorConfig("mirror.local:5000"); err == nil {
+		t.Errorf("want error for an endpoint without a scheme")
+	}
+}
+
+func Test_registryConfigFromFlags(t *testing.T) {
+	file, err := ioutil.TempFile("", "registries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("configs:\n  \"registry.local:5000\":\n    auth:\n      username: k3s\n")
+	file.Close()
+
+	command := &cobra.Command{}
+	addRegistryFlags(command)
+	command.Flags().Set("registry-config", file.Name())
+
+	registry, err := registryConfigFromFlags(command)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(string(registry.Content), "username: k3s") {
+		t.Errorf("want the content of the file, got: %q", registry.Content)
+	}
+
+	command.Flags().Set("registry-mirror", "https://mirror.local:5000")
+	if _, err := registryConfigFromFlags(command); err == nil || !strings.Contains(err.Error(), "only one of") {
+		t.Errorf("want error for more than one registry flag, got: %v", err)
+	}
+}
+
+func Test_registryConfigFromFlags_Labels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	templatePath := filepath.Join(dir, "registries.yaml.tmpl")
+	if err := ioutil.WriteFile(templatePath, []byte(registryTemplate), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	command := MakeJoin()
+	command.Flags().Set("registry-config-template", templatePath)
+	command.Flags().Set("node-label", "zone=edge")
+	registry, err := registryConfigFromFlags(command)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if registry.Labels["zone"] != "edge" {
+		t.Fatalf("want the labels of --node-label, got: %v", registry.Labels)
+	}
+
+	op := &scriptedOperator{replies: map[string]string{"hostname": "edge-1\n"}}
+	if err := registry.apply(op, "192.168.0.101", "agent"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(string(op.uploads[registriesPath]), "https://edge-mirror.local:5000") {
+		t.Errorf("want the template rendered with the label, got: %q", op.uploads[registriesPath])
+	}
+}
+
+func Test_registryConfig_apply(t *testing.T) {
+	op := &scriptedOperator{replies: map[string]string{}}
+	registry := registryConfig{Content: []byte("mirrors: {}\n")}
+
+	if err := registry.apply(op, "192.168.0.100", "server"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "upload /etc/rancher/k3s/registries.yaml 600"
+	if len(op.commands) != 1 || op.commands[0] != want {
+		t.Errorf("want: %q, got: %q", want, op.commands)
+	}
+	if string(op.uploads[registriesPath]) != "mirrors: {}\n" {
+		t.Errorf("want the content of the registry config, got: %q", op.uploads[registriesPath])
+	}
+
+	op = &scriptedOperator{replies: map[string]string{}}
+	if err := (registryConfig{}).apply(op, "192.168.0.100", "server"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(op.commands) != 0 {
+		t.Errorf("want no commands without a registry config, got: %q", op.commands)
+	}
+}
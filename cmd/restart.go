@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	"github.com/spf13/cobra"
+)
+
+func MakeRestart() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "restart",
+		Short: "Restart k3s on a server via SSH",
+		Long: `Restart the k3s service on a server via SSH and wait for it to become ready,
+i.e. after changing its configuration by hand.`,
+		Example:      `  k3sup restart --ip 192.168.0.100 --user root --refresh-kubeconfig`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("ip", net.ParseIP("127.0.0.1"), "Public IP of node")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	addHostKeyFlags(command)
+	addSSHPasswordFlags(command)
+	addSSHTimeoutFlags(command)
+	addSSHProxyFlags(command)
+	command.Flags().Bool("sudo", true, "Use sudo to restart k3s. e.g. set to false when using the root user and no sudo is available.")
+	command.Flags().String("sudo-binary", "sudo", "The binary used for privilege escalation with --sudo, i.e. doas")
+	addSudoPasswordFlags(command)
+
+	command.Flags().Bool("refresh-kubeconfig", false, "Fetch the kubeconfig again once k3s is ready, i.e. after adding a TLS SAN")
+	command.Flags().String("local-path", "kubeconfig", "Local path to save the kubeconfig file")
+	addKubeconfigModeFlag(command)
+	command.Flags().String("context", "default", "Set the name of the kubeconfig context.")
+	command.Flags().Bool("merge", false, "Merge the config with existing kubeconfig if it already exists.")
+	addOverwriteFlag(command)
+
+	command.Flags().Duration("server-ready-timeout", time.Minute, "Time to wait for the kubeconfig and /readyz of the server after the restart")
+	command.Flags().Duration("server-ready-interval", 2*time.Second, "Interval between checks whilst waiting for the server")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		infof("Running: k3sup restart\n")
+
+		ip, _ := command.Flags().GetIP("ip")
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+
+		useSudo, _ := command.Flags().GetBool("sudo")
+		sudoBinary, _ := command.Flags().GetString("sudo-binary")
+		sudoPrefix, err := makeSudoPrefix(useSudo, sudoBinary)
+		if err != nil {
+			return err
+		}
+
+		refreshKubeconfig, _ := command.Flags().GetBool("refresh-kubeconfig")
+		localKubeconfig, _ := command.Flags().GetString("local-path")
+		context, _ := command.Flags().GetString("context")
+		merge, _ := command.Flags().GetBool("merge")
+		overwrite, _ := command.Flags().GetBool("overwrite")
+		kubeconfigMode, err := kubeconfigModeFromFlags(command)
+		if err != nil {
+			return err
+		}
+
+		serverReadyTimeout, _ := command.Flags().GetDuration("server-ready-timeout")
+		serverReadyInterval, _ := command.Flags().GetDuration("server-ready-interval")
+
+		sshOpts, err := sshOptionsFromFlags(command, "")
+		if err != nil {
+			return err
+		}
+		defer sshOpts.zero()
+
+		address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		operator, err := connectSSH(address, user, expandPath(sshKey), sshOpts)
+		if err != nil {
+			return err
+		}
+		defer operator.Close()
+
+		if err := checkSudo(operator, sudoPrefix); err != nil {
+			return err
+		}
+
+		infof("Restarting k3s\n")
+		if _, err := operator.Execute(sudoPrefix + "systemctl restart k3s"); err != nil {
+			return fmt.Errorf("error received restarting k3s: %s", err)
+		}
+
+		serverArtifacts := []serverArtifact{kubeconfigArtifact(sudoPrefix), readyzArtifact(sudoPrefix)}
+		if err := waitForServer(operator, serverArtifacts, serverReadyTimeout, serverReadyInterval); err != nil {
+			return err
+		}
+
+		if !refreshKubeconfig {
+			return nil
+		}
+
+		getConfigcommand := sudoPrefix + "cat " + install.KubeconfigPath + "\n"
+		_, err = obtainKubeconfig(operator, getConfigcommand, ip.String(), kubeconfigOptions{
+			Context:    context,
+			LocalPath:  localKubeconfig,
+			Merge:      merge,
+			Overwrite:  overwrite,
+			Mode:       kubeconfigMode,
+			RemotePath: install.KubeconfigPath,
+		})
+		return err
+	}
+
+	return command
+}
@@ -0,0 +1,348 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+// defaultSnapshotDir returns where k3s keeps the etcd snapshots in dataDir,
+// the --data-dir of k3s, unless it was given another --etcd-snapshot-dir.
+func defaultSnapshotDir(dataDir string) string {
+	return serverDataPath(dataDir, "db/snapshots")
+}
+
+func MakeSnapshot() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Save, list and restore etcd snapshots of a k3s server",
+		Long: `Save, list and restore the etcd snapshots of a k3s server which uses
+embedded etcd, i.e. one installed with --cluster.`,
+		Example: `  k3sup snapshot save --ip 192.168.0.100 --name before-upgrade
+  k3sup snapshot list --ip 192.168.0.100
+  k3sup snapshot restore --ip 192.168.0.100 --name before-upgrade-server-1-1600000000`,
+		SilenceUsage: true,
+	}
+
+	command.AddCommand(makeSnapshotSave())
+	command.AddCommand(makeSnapshotList())
+	command.AddCommand(makeSnapshotRestore())
+
+	return command
+}
+
+func addSnapshotFlags(command *cobra.Command) {
+	command.Flags().IP("ip", net.ParseIP("127.0.0.1"), "Public IP of the server")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	addHostKeyFlags(command)
+	addSSHPasswordFlags(command)
+	addSSHTimeoutFlags(command)
+	addSSHProxyFlags(command)
+	command.Flags().Bool("sudo", true, "Use sudo to run k3s. e.g. set to false when using the root user and no sudo is available.")
+	command.Flags().String("sudo-binary", "sudo", "The binary used for privilege escalation with --sudo, i.e. doas")
+	addSudoPasswordFlags(command)
+	command.Flags().String("data-dir", "", "Optional: the --data-dir k3s was installed with, defaults to "+defaultK3sDataDir)
+	command.Flags().String("snapshot-dir", "", "Optional: the directory of the snapshots on the server, as given to k3s with --etcd-snapshot-dir, defaults to server/db/snapshots in --data-dir")
+}
+
+// snapshotDirsFromFlags returns the --data-dir of k3s and the directory of
+// its snapshots.
+func snapshotDirsFromFlags(command *cobra.Command) (string, string, error) {
+	dataDir, _ := command.Flags().GetString("data-dir")
+	if err := validateDataDir("--data-dir", dataDir); err != nil {
+		return "", "", err
+	}
+	snapshotDir, _ := command.Flags().GetString("snapshot-dir")
+	if err := validateDataDir("--snapshot-dir", snapshotDir); err != nil {
+		return "", "", err
+	}
+	if len(snapshotDir) == 0 {
+		snapshotDir = defaultSnapshotDir(dataDir)
+	}
+	return dataDir, snapshotDir, nil
+}
+
+// connectSnapshotServer connects to the server given by the flags added by
+// addSnapshotFlags and returns the operator along with the sudo prefix.
+func connectSnapshotServer(command *cobra.Command) (*operator.SSHOperator, string, error) {
+	ip, _ := command.Flags().GetIP("ip")
+	user, _ := command.Flags().GetString("user")
+	sshKey, _ := command.Flags().GetString("ssh-key")
+	port, _ := command.Flags().GetInt("ssh-port")
+
+	useSudo, _ := command.Flags().GetBool("sudo")
+	sudoBinary, _ := command.Flags().GetString("sudo-binary")
+	sudoPrefix, err := makeSudoPrefix(useSudo, sudoBinary)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sshOpts, err := sshOptionsFromFlags(command, "")
+	if err != nil {
+		return nil, "", err
+	}
+	defer sshOpts.zero()
+
+	address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	op, err := connectSSH(address, user, expandPath(sshKey), sshOpts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := checkSudo(op, sudoPrefix); err != nil {
+		op.Close()
+		return nil, "", err
+	}
+
+	return op, sudoPrefix, nil
+}
+
+func makeSnapshotSave() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "save",
+		Short:        "Take an etcd snapshot on a k3s server",
+		Example:      `  k3sup snapshot save --ip 192.168.0.100 --name before-upgrade`,
+		SilenceUsage: true,
+	}
+
+	addSnapshotFlags(command)
+	command.Flags().String("name", "", "Optional: prefix for the name of the snapshot, k3s appends the node name and a timestamp")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		infof("Running: k3sup snapshot save\n")
+
+		name, _ := command.Flags().GetString("name")
+		if len(name) > 0 {
+			if err := validateSnapshotName(name); err != nil {
+				return err
+			}
+		}
+		dataDir, snapshotDir, err := snapshotDirsFromFlags(command)
+		if err != nil {
+			return err
+		}
+
+		op, sudoPrefix, err := connectSnapshotServer(command)
+		if err != nil {
+			return err
+		}
+		defer op.Close()
+
+		if _, err := op.Execute(makeSnapshotSaveCommand(sudoPrefix, name, dataDir, snapshotDir)); err != nil {
+			return fmt.Errorf("error received saving snapshot: %s", err)
+		}
+
+		return nil
+	}
+
+	return command
+}
+
+func makeSnapshotList() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "list",
+		Short:        "List the etcd snapshots on a k3s server",
+		Example:      `  k3sup snapshot list --ip 192.168.0.100`,
+		SilenceUsage: true,
+	}
+
+	addSnapshotFlags(command)
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		_, snapshotDir, err := snapshotDirsFromFlags(command)
+		if err != nil {
+			return err
+		}
+
+		op, sudoPrefix, err := connectSnapshotServer(command)
+		if err != nil {
+			return err
+		}
+		defer op.Close()
+
+		res, err := op.Execute(fmt.Sprintf("%sls -1 %s 2>/dev/null || true", sudoPrefix, snapshotDir))
+		if err != nil {
+			return fmt.Errorf("error received listing snapshots: %s", err)
+		}
+
+		printSnapshotList(os.Stdout, parseSnapshotList(string(res.StdOut)), snapshotDir)
+		return nil
+	}
+
+	return command
+}
+
+func makeSnapshotRestore() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore an etcd snapshot on a k3s server",
+		Long: `Restore an etcd snapshot on a k3s server by stopping k3s, resetting the
+cluster from the snapshot and starting k3s again. The kubeconfig is fetched
+again once the server is ready.
+
+Any other servers must be stopped before the restore, and have the
+server/db directory of their --data-dir, /var/lib/rancher/k3s/server/db by
+default, removed before they are started again, so that they rejoin the
+restored cluster.`,
+		Example:      `  k3sup snapshot restore --ip 192.168.0.100 --name before-upgrade-server-1-1600000000`,
+		SilenceUsage: true,
+	}
+
+	addSnapshotFlags(command)
+	command.Flags().String("name", "", "Name of the snapshot to restore, as printed by k3sup snapshot list")
+	command.Flags().String("local-path", "kubeconfig", "Local path to save the kubeconfig file")
+	addKubeconfigModeFlag(command)
+	command.Flags().String("context", "default", "Set the name of the kubeconfig context.")
+	command.Flags().Bool("merge", false, "Merge the config with existing kubeconfig if it already exists.")
+	addOverwriteFlag(command)
+	command.Flags().Duration("server-ready-timeout", 5*time.Minute, "Time to wait for the kubeconfig and /readyz of the server after the restore")
+	command.Flags().Duration("server-ready-interval", 2*time.Second, "Interval between checks whilst waiting for the server")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		infof("Running: k3sup snapshot restore\n")
+
+		name, _ := command.Flags().GetString("name")
+		if err := validateSnapshotName(name); err != nil {
+			return err
+		}
+		dataDir, snapshotDir, err := snapshotDirsFromFlags(command)
+		if err != nil {
+			return err
+		}
+		ip, _ := command.Flags().GetIP("ip")
+		localKubeconfig, _ := command.Flags().GetString("local-path")
+		context, _ := command.Flags().GetString("context")
+		merge, _ := command.Flags().GetBool("merge")
+		overwrite, _ := command.Flags().GetBool("overwrite")
+		kubeconfigMode, err := kubeconfigModeFromFlags(command)
+		if err != nil {
+			return err
+		}
+		serverReadyTimeout, _ := command.Flags().GetDuration("server-ready-timeout")
+		serverReadyInterval, _ := command.Flags().GetDuration("server-ready-interval")
+
+		op, sudoPrefix, err := connectSnapshotServer(command)
+		if err != nil {
+			return err
+		}
+		defer op.Close()
+
+		snapshotPath := path.Join(snapshotDir, name)
+		res, err := op.Execute(fmt.Sprintf("if %stest -f %s; then echo found; fi", sudoPrefix, snapshotPath))
+		if err != nil {
+			return fmt.Errorf("error received checking snapshot: %s", err)
+		}
+		if strings.TrimSpace(string(res.StdOut)) != "found" {
+			return fmt.Errorf("snapshot %s not found, use k3sup snapshot list to see the available snapshots", snapshotPath)
+		}
+
+		for _, step := range makeSnapshotRestoreSteps(sudoPrefix, dataDir, snapshotPath) {
+			infof("%s\n", step.Name)
+			if _, err := op.Execute(step.Command); err != nil {
+				return fmt.Errorf("error received whilst %s: %s", strings.ToLower(step.Name), err)
+			}
+		}
+
+		serverArtifacts := []serverArtifact{kubeconfigArtifact(sudoPrefix), readyzArtifact(sudoPrefix)}
+		if err := waitForServer(op, serverArtifacts, serverReadyTimeout, serverReadyInterval); err != nil {
+			return err
+		}
+
+		getConfigcommand := sudoPrefix + "cat " + install.KubeconfigPath + "\n"
+		_, err = obtainKubeconfig(op, getConfigcommand, ip.String(), kubeconfigOptions{
+			Context:    context,
+			LocalPath:  localKubeconfig,
+			Merge:      merge,
+			Overwrite:  overwrite,
+			Mode:       kubeconfigMode,
+			RemotePath: install.KubeconfigPath,
+		})
+		return err
+	}
+
+	return command
+}
+
+// snapshotStep is one command of the restore flow, Name is printed as
+// progress before the command runs.
+type snapshotStep struct {
+	Name    string
+	Command string
+}
+
+func makeSnapshotSaveCommand(sudoPrefix, name, dataDir, snapshotDir string) string {
+	command := sudoPrefix + "k3s etcd-snapshot save"
+	if len(name) > 0 {
+		command += " --name " + name
+	}
+	if len(dataDir) > 0 && dataDir != defaultK3sDataDir {
+		command += " --data-dir " + dataDir
+	}
+	if snapshotDir != defaultSnapshotDir(dataDir) {
+		command += " --etcd-snapshot-dir " + snapshotDir
+	}
+	return command
+}
+
+func makeSnapshotRestoreSteps(sudoPrefix, dataDir, snapshotPath string) []snapshotStep {
+	reset := fmt.Sprintf("%sk3s server --cluster-reset --cluster-reset-restore-path=%s", sudoPrefix, snapshotPath)
+	if len(dataDir) > 0 && dataDir != defaultK3sDataDir {
+		reset += " --data-dir " + dataDir
+	}
+	return []snapshotStep{
+		{Name: "Stopping k3s", Command: sudoPrefix + "systemctl stop k3s"},
+		{Name: "Restoring snapshot", Command: reset},
+		{Name: "Starting k3s", Command: sudoPrefix + "systemctl start k3s"},
+	}
+}
+
+// validateSnapshotName rejects names which would escape the snapshot
+// directory or be interpreted by the remote shell.
+func validateSnapshotName(name string) error {
+	if len(name) == 0 {
+		return fmt.Errorf("give a value for --name")
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("invalid snapshot name %q, only letters, digits, '-', '_' and '.' are allowed", name)
+		}
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return nil
+}
+
+// printSnapshotList prints the name of each snapshot on a line of its own,
+// as snapshot restore takes it with --name.
+func printSnapshotList(w io.Writer, snapshots []string, snapshotDir string) {
+	if len(snapshots) == 0 {
+		fmt.Fprintf(w, "No snapshots found in %s\n", snapshotDir)
+		return
+	}
+	for _, snapshot := range snapshots {
+		fmt.Fprintln(w, snapshot)
+	}
+}
+
+func parseSnapshotList(output string) []string {
+	snapshots := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 {
+			snapshots = append(snapshots, line)
+		}
+	}
+	return snapshots
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_validateSnapshotName(t *testing.T) {
+	valid := []string{"before-upgrade", "on-demand-server-1-1600000000", "etcd-snapshot_1.zip"}
+	for _, name := range valid {
+		if err := validateSnapshotName(name); err != nil {
+			t.Errorf("want %q to be valid, got: %s", name, err)
+		}
+	}
+
+	invalid := []string{"", "..", "../etc/passwd", "snap; rm -rf /", "a b"}
+	for _, name := range invalid {
+		if err := validateSnapshotName(name); err == nil {
+			t.Errorf("want %q to be invalid", name)
+		}
+	}
+}
+
+func Test_makeSnapshotSaveCommand(t *testing.T) {
+	got := makeSnapshotSaveCommand("sudo ", "before-upgrade", "", defaultSnapshotDir(""))
+	want := "sudo k3s etcd-snapshot save --name before-upgrade"
+	if got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	got = makeSnapshotSaveCommand("", "", "", "/mnt/snapshots")
+	want = "k3s etcd-snapshot save --etcd-snapshot-dir /mnt/snapshots"
+	if got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	got = makeSnapshotSaveCommand("", "", "/srv/k3s", defaultSnapshotDir("/srv/k3s"))
+	want = "k3s etcd-snapshot save --data-dir /srv/k3s"
+	if got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
+
+func Test_makeSnapshotRestoreSteps(t *testing.T) {
+	steps := makeSnapshotRestoreSteps("sudo ", "", "/var/lib/rancher/k3s/server/db/snapshots/snap-1")
+
+	got := []string{}
+	for _, step := range steps {
+		got = append(got, step.Command)
+	}
+	want := []string{
+		"sudo systemctl stop k3s",
+		"sudo k3s server --cluster-reset --cluster-reset-restore-path=/var/lib/rancher/k3s/server/db/snapshots/snap-1",
+		"sudo systemctl start k3s",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	steps = makeSnapshotRestoreSteps("sudo ", "/srv/k3s", "/srv/k3s/server/db/snapshots/snap-1")
+	if want := "sudo k3s server --cluster-reset --cluster-reset-restore-path=/srv/k3s/server/db/snapshots/snap-1 --data-dir /srv/k3s"; steps[1].Command != want {
+		t.Errorf("want: %q, got: %q", want, steps[1].Command)
+	}
+}
+
+func Test_snapshotDirsFromFlags(t *testing.T) {
+	command := makeSnapshotList()
+	if dataDir, snapshotDir, err := snapshotDirsFromFlags(command); err != nil || dataDir != "" || snapshotDir != "/var/lib/rancher/k3s/server/db/snapshots" {
+		t.Errorf("want the default snapshot dir, got: %q, %q, %v", dataDir, snapshotDir, err)
+	}
+
+	command.Flags().Set("data-dir", "/srv/k3s")
+	if _, snapshotDir, err := snapshotDirsFromFlags(command); err != nil || snapshotDir != "/srv/k3s/server/db/snapshots" {
+		t.Errorf("want the snapshot dir in --data-dir, got: %q, %v", snapshotDir, err)
+	}
+
+	command.Flags().Set("snapshot-dir", "/mnt/snapshots")
+	if _, snapshotDir, err := snapshotDirsFromFlags(command); err != nil || snapshotDir != "/mnt/snapshots" {
+		t.Errorf("want --snapshot-dir, got: %q, %v", snapshotDir, err)
+	}
+
+	command.Flags().Set("data-dir", "srv/k3s")
+	if _, _, err := snapshotDirsFromFlags(command); err == nil {
+		t.Errorf("want an error for a relative --data-dir")
+	}
+}
+
+func Test_parseSnapshotList(t *testing.T) {
+	got := parseSnapshotList("snap-1\n\nsnap-2\n")
+	want := []string{"snap-1", "snap-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	if got := parseSnapshotList(""); len(got) != 0 {
+		t.Errorf("want no snapshots, got: %q", got)
+	}
+}
+
+func Test_printSnapshotList(t *testing.T) {
+	out := bytes.Buffer{}
+	printSnapshotList(&out, []string{"snap-1", "snap-2"}, defaultSnapshotDir(""))
+	if want := "snap-1\nsnap-2\n"; out.String() != want {
+		t.Errorf("want: %q, got: %q", want, out.String())
+	}
+
+	out.Reset()
+	printSnapshotList(&out, []string{}, "/mnt/snapshots")
+	if want := "No snapshots found in /mnt/snapshots\n"; out.String() != want {
+		t.Errorf("want: %q, got: %q", want, out.String())
+	}
+}
@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// sshHostConfig holds the settings of ~/.ssh/config which k3sup uses to
+// connect to a Host alias.
+type sshHostConfig struct {
+	HostName      string
+	User          string
+	Port          int
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+type sshConfigBlock struct {
+	patterns []string
+	options  [][2]string
+}
+
+// sshConfig is a minimal reader for the OpenSSH client config, supporting
+// Host blocks with wildcards and negation. Match blocks are skipped.
+type sshConfig struct {
+	blocks []sshConfigBlock
+}
+
+// loadSSHConfig reads the SSH config at path, a missing file gives an
+// empty config.
+func loadSSHConfig(path string) (*sshConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &sshConfig{}, nil
+		}
+		return nil, fmt.Errorf("unable to read SSH config %s: %s", path, err)
+	}
+	defer file.Close()
+
+	config := &sshConfig{}
+	// Options before the first Host apply to every host.
+	current := &sshConfigBlock{patterns: []string{"*"}}
+	skip := false
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value := splitSSHConfigLine(line)
+		switch strings.ToLower(key) {
+		case "host":
+			config.blocks = append(config.blocks, *current)
+			current = &sshConfigBlock{patterns: strings.Fields(value)}
+			skip = false
+		case "match":
+			config.blocks = append(config.blocks, *current)
+			current = &sshConfigBlock{}
+			skip = true
+		default:
+			if !skip {
+				current.options = append(current.options, [2]string{strings.ToLower(key), value})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read SSH config %s: %s", path, err)
+	}
+	config.blocks = append(config.blocks, *current)
+
+	return config, nil
+}
+
+func splitSSHConfigLine(line string) (string, string) {
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return line, ""
+	}
+	value := strings.TrimLeft(line[i:], " \t=")
+	return line[:i], strings.Trim(value, "\"")
+}
+
+// lookup returns the settings for alias, the first value of each option
+// wins as it does for ssh, apart from IdentityFile which accumulates.
+// found is false when no Host block other than "*" matched alias.
+func (c *sshConfig) lookup(alias string) (hostConfig sshHostConfig, found bool) {
+	seen := map[string]bool{}
+
+	for _, block := range c.blocks {
+		if !matchSSHHost(block.patterns, alias) {
+			continue
+		}
+		for _, pattern := range block.patterns {
+			if pattern != "*" {
+				found = true
+			}
+		}
+
+		for _, option := range block.options {
+			key, value := option[0], option[1]
+			if key == "identityfile" {
+				hostConfig.IdentityFiles = append(hostConfig.IdentityFiles, value)
+				continue
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			switch key {
+			case "hostname":
+				hostConfig.HostName = strings.Replace(value, "%h", alias, -1)
+			case "user":
+				hostConfig.User = value
+			case "port":
+				hostConfig.Port, _ = strconv.Atoi(value)
+			case "proxyjump":
+				hostConfig.ProxyJump = value
+			}
+		}
+	}
+
+	return hostConfig, found
+}
+
+// matchSSHHost reports whether host matches any of the patterns and none
+// of the negated ones.
+func matchSSHHost(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if ok, _ := filepath.Match(pattern[1:], host); ok {
+				return false
+			}
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, host); ok {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// sshTarget is a node given by IP or by a Host alias of the SSH config.
+type sshTarget struct {
+	IP   net.IP
+	User string
+	Port int
+	// IdentityFiles are those of the SSH config which exist, in order.
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+func addSSHConfigFlag(command *cobra.Command) {
+	command.Flags().String("ssh-config", "~/.ssh/config", "SSH config used to resolve a Host alias given instead of an IP, set to \"\" to ignore it")
+}
+
+// resolveSSHTarget resolves the value of the flag name, an IP or a Host
+// alias of the SSH config. An empty value gives an empty target.
+func resolveSSHTarget(command *cobra.Command, name string) (sshTarget, error) {
+	value, _ := command.Flags().GetString(name)
+	if len(value) == 0 {
+		return sshTarget{}, nil
+	}
+	if ip := net.ParseIP(value); ip != nil {
+		return sshTarget{IP: ip}, nil
+	}
+
+	configPath, _ := command.Flags().GetString("ssh-config")
+	if len(configPath) == 0 {
+		return sshTarget{}, fmt.Errorf("--%s %q is not an IP address", name, value)
+	}
+
+	config, err := loadSSHConfig(expandPath(configPath))
+	if err != nil {
+		return sshTarget{}, err
+	}
+
+	hostConfig, found := config.lookup(value)
+	if !found {
+		return sshTarget{}, fmt.Errorf("--%s %q is not an IP address or a Host in %s", name, value, configPath)
+	}
+
+	return makeSSHTarget(value, hostConfig)
+}
+
+func makeSSHTarget(alias string, hostConfig sshHostConfig) (sshTarget, error) {
+	hostName := hostConfig.HostName
+	if len(hostName) == 0 {
+		hostName = alias
+	}
+
+	ip := net.ParseIP(hostName)
+	if ip == nil {
+		ips, err := net.LookupIP(hostName)
+		if err != nil || len(ips) == 0 {
+			return sshTarget{}, fmt.Errorf("unable to resolve HostName %q of %s: %v", hostName, alias, err)
+		}
+		ip = ips[0]
+		for _, candidate := range ips {
+			if candidate.To4() != nil {
+				ip = candidate
+				break
+			}
+		}
+	}
+
+	target := sshTarget{
+		IP:        ip,
+		User:      hostConfig.User,
+		Port:      hostConfig.Port,
+		ProxyJump: hostConfig.ProxyJump,
+	}
+
+	// ssh tries each identity file which exists, in order.
+	for _, identityFile := range hostConfig.IdentityFiles {
+		path := expandPath(identityFile)
+		if _, err := os.Stat(path); err == nil {
+			target.IdentityFiles = append(target.IdentityFiles, path)
+		}
+	}
+
+	infof("Resolved %s from the SSH config to %s\n", alias, ip)
+	return target, nil
+}
+
+// withSSHConfig returns the value of a flag when it was given, otherwise
+// the value from the SSH config, when there is one.
+func withSSHConfig(command *cobra.Command, name, flagValue, configValue string) string {
+	if flagGiven(command, name) || len(configValue) == 0 {
+		return flagValue
+	}
+	return configValue
+}
+
+// withSSHConfigKeys returns the value of --ssh-key when it was given,
+// otherwise the first IdentityFile of target along with the others, which
+// are offered after it.
+func withSSHConfigKeys(command *cobra.Command, flagValue string, target sshTarget) (string, []string) {
+	if flagGiven(command, "ssh-key") || len(target.IdentityFiles) == 0 {
+		return flagValue, nil
+	}
+	return target.IdentityFiles[0], target.IdentityFiles[1:]
+}
+
+func withSSHConfigPort(command *cobra.Command, name string, flagValue, configValue int) int {
+	if flagGiven(command, name) || configValue == 0 {
+		return flagValue
+	}
+	return configValue
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+const sshConfigExample = `# Global options
+ServerAliveInterval 30
+
+Host edge-*
+  User pi
+  IdentityFile ~/.ssh/edge_rsa
+
+Host edge-1
+  HostName 192.168.0.101
+  Port 2222
+  User ubuntu
+  IdentityFile ~/.ssh/edge1_rsa
+
+Host bastion !edge-*
+  HostName=10.0.0.1
+
+Match host *.internal
+  User nobody
+
+Host *
+  User root
+  IdentityFile ~/.ssh/id_rsa
+`
+
+func writeSSHConfig(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "ssh_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func Test_sshConfig_lookup(t *testing.T) {
+	path := writeSSHConfig(t, sshConfigExample)
+	defer os.Remove(path)
+
+	config, err := loadSSHConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, found := config.lookup("edge-1")
+	if !found {
+		t.Fatalf("want edge-1 to be found")
+	}
+	want := sshHostConfig{
+		HostName:      "192.168.0.101",
+		User:          "pi",
+		Port:          2222,
+		IdentityFiles: []string{"~/.ssh/edge_rsa", "~/.ssh/edge1_rsa", "~/.ssh/id_rsa"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %+v, got: %+v", want, got)
+	}
+
+	got, found = config.lookup("bastion")
+	if !found || got.HostName != "10.0.0.1" || got.User != "root" {
+		t.Errorf("want bastion with HostName 10.0.0.1 and User root, got: %+v", got)
+	}
+
+	if _, found := config.lookup("unknown"); found {
+		t.Errorf("want unknown not to be found, as only Host * matches")
+	}
+}
+
+func Test_matchSSHHost(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		host     string
+		want     bool
+	}{
+		{[]string{"edge-*"}, "edge-1", true},
+		{[]string{"edge-?"}, "edge-10", false},
+		{[]string{"*", "!edge-1"}, "edge-1", false},
+		{[]string{"*", "!edge-1"}, "edge-2", true},
+		{[]string{"bastion", "jump"}, "jump", true},
+	}
+
+	for _, c := range cases {
+		if got := matchSSHHost(c.patterns, c.host); got != c.want {
+			t.Errorf("%v %q: want: %v, got: %v", c.patterns, c.host, c.want, got)
+		}
+	}
+}
+
+func Test_loadSSHConfig_Missing(t *testing.T) {
+	config, err := loadSSHConfig(filepath.Join(os.TempDir(), "k3sup-does-not-exist", "config"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, found := config.lookup("edge-1"); found {
+		t.Errorf("want no hosts in a missing config")
+	}
+}
+
+func Test_makeSSHTarget_IdentityFiles(t *testing.T) {
+	keys := []string{}
+	for i := 0; i < 2; i++ {
+		key, err := ioutil.TempFile("", "id_rsa")
+		if err != nil {
+			t.Fatal(err)
+		}
+		key.Close()
+		defer os.Remove(key.Name())
+		keys = append(keys, key.Name())
+	}
+
+	missing := filepath.Join(os.TempDir(), "k3sup-does-not-exist", "id_rsa")
+	target, err := makeSSHTarget("edge-1", sshHostConfig{
+		HostName:      "192.168.0.101",
+		User:          "pi",
+		Port:          2222,
+		IdentityFiles: []string{keys[0], missing, keys[1]},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if target.IP.String() != "192.168.0.101" || target.User != "pi" || target.Port != 2222 {
+		t.Errorf("unexpected target: %+v", target)
+	}
+	if !reflect.DeepEqual(target.IdentityFiles, keys) {
+		t.Errorf("want each identity file which exists: %q, got: %q", keys, target.IdentityFiles)
+	}
+
+	command := &cobra.Command{}
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "")
+	if key, others := withSSHConfigKeys(command, "~/.ssh/id_rsa", target); key != keys[0] || !reflect.DeepEqual(others, keys[1:]) {
+		t.Errorf("want the first identity file and then the others, got: %q, %q", key, others)
+	}
+	command.Flags().Set("ssh-key", "~/.ssh/edge_rsa")
+	if key, others := withSSHConfigKeys(command, "~/.ssh/edge_rsa", target); key != "~/.ssh/edge_rsa" || others != nil {
+		t.Errorf("want only --ssh-key when given, got: %q, %q", key, others)
+	}
+}
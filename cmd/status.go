@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addStatusFlag(command *cobra.Command) {
+	command.Flags().Bool("status", false, "Wait for the node to be Ready, then print the nodes and the pods of kube-system with the kubeconfig, as kubectl get would. Waits for the pods to run within the same --wait-timeout")
+}
+
+// statusObject is what the status needs of a node or pod from the API.
+type statusObject struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		Labels            map[string]string `json:"labels"`
+		CreationTimestamp time.Time         `json:"creationTimestamp"`
+	} `json:"metadata"`
+	Status struct {
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+		NodeInfo struct {
+			KubeletVersion string `json:"kubeletVersion"`
+		} `json:"nodeInfo"`
+		Phase             string `json:"phase"`
+		ContainerStatuses []struct {
+			Ready        bool `json:"ready"`
+			RestartCount int  `json:"restartCount"`
+			State        struct {
+				Waiting *struct {
+					Reason string `json:"reason"`
+				} `json:"waiting"`
+			} `json:"state"`
+		} `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+// condition returns the status of the condition t, i.e. "True" for a node
+// which is Ready, or "Unknown" when it has none.
+func (o statusObject) condition(t string) string {
+	for _, condition := range o.Status.Conditions {
+		if condition.Type == t {
+			return condition.Status
+		}
+	}
+	return "Unknown"
+}
+
+// podStatus is the STATUS of kubectl get pods, the reason a container is
+// waiting, such as ContainerCreating, or else the phase of the pod.
+func (o statusObject) podStatus() string {
+	for _, container := range o.Status.ContainerStatuses {
+		if container.State.Waiting != nil && len(container.State.Waiting.Reason) > 0 {
+			return container.State.Waiting.Reason
+		}
+	}
+	return o.Status.Phase
+}
+
+// settled is true for a pod which runs with all its containers ready, or
+// which has completed, such as the job of a helm chart.
+func (o statusObject) settled() bool {
+	if o.Status.Phase == "Succeeded" {
+		return true
+	}
+	if o.Status.Phase != "Running" {
+		return false
+	}
+	for _, container := range o.Status.ContainerStatuses {
+		if !container.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// printClusterStatus prints the nodes of the cluster of kubeconfig and the
+// pods of kube-system to w. It waits until deadline for the pods to run,
+// checking every interval, and prints them as they are after that, along
+// with the nodes as they are then.
+func printClusterStatus(w io.Writer, kubeconfig []byte, deadline time.Time, interval time.Duration) error {
+	client, err := newAPIClient(kubeconfig, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	var pods []statusObject
+	for {
+		pods, err = listStatusObjects(client, "/api/v1/namespaces/kube-system/pods")
+		if err != nil {
+			return err
+		}
+		pending := unsettledPods(pods)
+		if pending == 0 {
+			break
+		}
+		if time.Now().Add(interval).After(deadline) {
+			warnf("%d pods of kube-system are not running by the end of --wait-timeout\n", pending)
+			break
+		}
+		debugf("Waiting for %d pods of kube-system to run\n", pending)
+		time.Sleep(interval)
+	}
+
+	nodes, err := listStatusObjects(client, "/api/v1/nodes")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	fmt.Fprintln(w)
+	if err := printNodes(w, nodes, now); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	return printPods(w, pods, now)
+}
+
+// listStatusObjects returns the items of the list at path, by name.
+func listStatusObjects(client *apiClient, path string) ([]statusObject, error) {
+	status, body, err := client.do(http.MethodGet, path, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list %s from the API server at %s: %s", path, client.server, err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("the API server at %s answered %s with %d %s: %s", client.server, path, status, http.StatusText(status), apiErrorMessage(body))
+	}
+
+	list := struct {
+		Items []statusObject `json:"items"`
+	}{}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("unexpected answer to %s from the API server at %s: %s", path, client.server, err)
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Metadata.Name < list.Items[j].Metadata.Name
+	})
+	return list.Items, nil
+}
+
+func unsettledPods(pods []statusObject) int {
+	unsettled := 0
+	for _, pod := range pods {
+		if !pod.settled() {
+			unsettled++
+		}
+	}
+	return unsettled
+}
+
+// printNodes writes the nodes as kubectl get nodes does.
+func printNodes(w io.Writer, nodes []statusObject, now time.Time) error {
+	table := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(table, "NAME\tSTATUS\tROLES\tAGE\tVERSION")
+	for _, node := range nodes {
+		status := "NotReady"
+		if node.condition("Ready") == "True" {
+			status = "Ready"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", node.Metadata.Name, status, nodeRoles(node.Metadata.Labels),
+			shortAge(now.Sub(node.Metadata.CreationTimestamp)), node.Status.NodeInfo.KubeletVersion)
+	}
+	return table.Flush()
+}
+
+// printPods writes the pods as kubectl get pods does.
+func printPods(w io.Writer, pods []statusObject, now time.Time) error {
+	table := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(table, "NAMESPACE\tNAME\tREADY\tSTATUS\tRESTARTS\tAGE")
+	for _, pod := range pods {
+		ready, restarts := 0, 0
+		for _, container := range pod.Status.ContainerStatuses {
+			if container.Ready {
+				ready++
+			}
+			restarts += container.RestartCount
+		}
+		fmt.Fprintf(table, "kube-system\t%s\t%d/%d\t%s\t%d\t%s\n", pod.Metadata.Name, ready, len(pod.Status.ContainerStatuses),
+			pod.podStatus(), restarts, shortAge(now.Sub(pod.Metadata.CreationTimestamp)))
+	}
+	return table.Flush()
+}
+
+// nodeRoles returns the roles of the node-role.kubernetes.io/ labels, such
+// as control-plane,master, or <none>.
+func nodeRoles(labels map[string]string) string {
+	roles := []string{}
+	for label := range labels {
+		if role := strings.TrimPrefix(label, "node-role.kubernetes.io/"); role != label && len(role) > 0 {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		return "<none>"
+	}
+	sort.Strings(roles)
+	return strings.Join(roles, ",")
+}
+
+// shortAge formats d as the AGE of kubectl, i.e. 45s, 3m, 5h or 2d.
+func shortAge(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "0s"
+	case d < 2*time.Minute:
+		return fmt.Sprintf("%ds", int(d/time.Second))
+	case d < 2*time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+}
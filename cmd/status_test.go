@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// statusServer serves the node and pods of a single node cluster, whose
+// coredns pod is ContainerCreating until it was listed pending times.
+func statusServer(pending int) *httptest.Server {
+	mu := sync.Mutex{}
+	created := time.Now().Add(-5 * time.Minute).UTC().Format(time.RFC3339)
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, `{"kind": "Status", "message": "Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/v1/nodes":
+			fmt.Fprintf(w, `{"items": [{"metadata": {"name": "k3s-1", "creationTimestamp": %q,
+				"labels": {"node-role.kubernetes.io/master": "true", "node-role.kubernetes.io/control-plane": "true", "kubernetes.io/os": "linux"}},
+				"status": {"conditions": [{"type": "Ready", "status": "True"}], "nodeInfo": {"kubeletVersion": "v1.18.20+k3s1"}}}]}`, created)
+		case "/api/v1/namespaces/kube-system/pods":
+			coredns := `"phase": "Running", "containerStatuses": [{"ready": true, "restartCount": 1, "state": {"running": {}}}]`
+			if pending > 0 {
+				pending--
+				coredns = `"phase": "Pending", "containerStatuses": [{"ready": false, "restartCount": 0, "state": {"waiting": {"reason": "ContainerCreating"}}}]`
+			}
+			fmt.Fprintf(w, `{"items": [
+				{"metadata": {"name": "helm-install-traefik-abcde", "creationTimestamp": %q}, "status": {"phase": "Succeeded", "containerStatuses": [{"ready": false, "restartCount": 0}]}},
+				{"metadata": {"name": "coredns-7944c66d8d-xyz12", "creationTimestamp": %q}, "status": {%s}}]}`, created, created, coredns)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func Test_printClusterStatus(t *testing.T) {
+	server := statusServer(2)
+	defer server.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	w := &strings.Builder{}
+	var err error
+	captureStdout(t, logInfo, func() {
+		err = printClusterStatus(w, testKubeconfig(server.URL, caPEM), time.Now().Add(5*time.Second), time.Millisecond)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := `
+NAME    STATUS   ROLES                  AGE   VERSION
+k3s-1   Ready    control-plane,master   5m    v1.18.20+k3s1
+
+NAMESPACE     NAME                         READY   STATUS      RESTARTS   AGE
+kube-system   coredns-7944c66d8d-xyz12     1/1     Running     1          5m
+kube-system   helm-install-traefik-abcde   0/1     Succeeded   0          5m
+`
+	if w.String() != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, w.String())
+	}
+}
+
+func Test_printClusterStatus_PodsNotRunning(t *testing.T) {
+	server := statusServer(1000)
+	defer server.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	w := &strings.Builder{}
+	var err error
+	out := captureStdout(t, logInfo, func() {
+		err = printClusterStatus(w, testKubeconfig(server.URL, caPEM), time.Now().Add(50*time.Millisecond), 10*time.Millisecond)
+	})
+	if err != nil {
+		t.Fatalf("want the status printed rather than an error, got: %s", err)
+	}
+	if !strings.Contains(out, "Warning: 1 pods of kube-system are not running by the end of --wait-timeout") {
+		t.Errorf("want a warning for the pod which is not running, got: %q", out)
+	}
+	if !strings.Contains(w.String(), "ContainerCreating") {
+		t.Errorf("want the pod printed as it is, got:\n%s", w.String())
+	}
+}
+
+func Test_printClusterStatus_Error(t *testing.T) {
+	server := statusServer(0)
+	defer server.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	kubeconfig := []byte(strings.Replace(string(testKubeconfig(server.URL, caPEM)), "secret", "wrong", 1))
+
+	err := printClusterStatus(&strings.Builder{}, kubeconfig, time.Now().Add(time.Second), time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "answered /api/v1/namespaces/kube-system/pods with 401 Unauthorized: Unauthorized") {
+		t.Errorf("want the answer of the API server, got: %v", err)
+	}
+}
+
+func Test_shortAge(t *testing.T) {
+	cases := map[time.Duration]string{
+		-time.Second:     "0s",
+		45 * time.Second: "45s",
+		3 * time.Minute:  "3m",
+		5 * time.Hour:    "5h",
+		72 * time.Hour:   "3d",
+	}
+	for d, want := range cases {
+		if got := shortAge(d); got != want {
+			t.Errorf("%s: want %q, got %q", d, want, got)
+		}
+	}
+}
+
+func Test_nodeRoles(t *testing.T) {
+	if got := nodeRoles(map[string]string{"kubernetes.io/os": "linux"}); got != "<none>" {
+		t.Errorf("want <none> for an agent, got: %q", got)
+	}
+}
+
+func Test_MakeInstall_StatusDryRun(t *testing.T) {
+	command := MakeInstall()
+	command.Flags().Bool("dry-run", true, "")
+	command.Flags().Set("ip", "192.168.0.100")
+	command.Flags().Set("status", "true")
+
+	var err error
+	out := captureStdout(t, logInfo, func() {
+		err = command.RunE(command, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(out, "# print the nodes and the pods of kube-system with the kubeconfig") {
+		t.Errorf("want the status in the plan, got:\n%s", out)
+	}
+}
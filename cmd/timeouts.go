@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+// sshTimeouts bound how long k3sup waits for a node, Dial covers the TCP
+// connection and SSH handshake and Command each command run on the node.
+// A connection which fails to be made is retried Retries times, waiting
+// RetryDelay at first and twice as long after each attempt. Keepalive is
+// the interval of the keepalives sent over the connection, zero for none.
+type sshTimeouts struct {
+	Dial       time.Duration
+	Command    time.Duration
+	Retries    int
+	RetryDelay time.Duration
+	Keepalive  time.Duration
+}
+
+// maxRetryDelay caps the backoff between attempts to connect.
+const maxRetryDelay = 30 * time.Second
+
+func addSSHTimeoutFlags(command *cobra.Command) {
+	command.Flags().Duration("ssh-timeout", 30*time.Second, "Time to wait to connect to each node over SSH, set to 0 to wait forever")
+	command.Flags().Duration("command-timeout", 0, "Optional: time after which a command run on a node, such as the k3s installer, is killed, i.e. 10m")
+	command.Flags().Int("ssh-retries", 0, "Optional: times to retry connecting when the node refuses the connection or times out, i.e. whilst it boots")
+	command.Flags().Duration("ssh-retry-delay", 2*time.Second, "Time to wait before the first retry with --ssh-retries, doubling after each attempt")
+	command.Flags().Duration("ssh-keepalive-interval", 30*time.Second, "Interval of the keepalives sent to each node, so that a NAT or firewall does not drop the connection during a long install, set to 0 to send none")
+}
+
+func sshTimeoutsFromFlags(command *cobra.Command) (sshTimeouts, error) {
+	dial, _ := command.Flags().GetDuration("ssh-timeout")
+	commandTimeout, _ := command.Flags().GetDuration("command-timeout")
+	retries, _ := command.Flags().GetInt("ssh-retries")
+	retryDelay, _ := command.Flags().GetDuration("ssh-retry-delay")
+	keepalive, _ := command.Flags().GetDuration("ssh-keepalive-interval")
+
+	if dial < 0 {
+		return sshTimeouts{}, fmt.Errorf("--ssh-timeout must not be negative")
+	}
+	if commandTimeout < 0 {
+		return sshTimeouts{}, fmt.Errorf("--command-timeout must not be negative")
+	}
+
+	if retries < 0 {
+		return sshTimeouts{}, fmt.Errorf("--ssh-retries must not be negative")
+	}
+	if retryDelay <= 0 {
+		return sshTimeouts{}, fmt.Errorf("--ssh-retry-delay must be greater than zero")
+	}
+	if keepalive < 0 {
+		return sshTimeouts{}, fmt.Errorf("--ssh-keepalive-interval must not be negative")
+	}
+
+	return sshTimeouts{Dial: dial, Command: commandTimeout, Retries: retries, RetryDelay: retryDelay, Keepalive: keepalive}, nil
+}
+
+// dialWithRetries calls dial until it succeeds, fails with an error other
+// than a connection error, or the retries run out.
+func dialWithRetries(address string, timeouts sshTimeouts, dial func() (*operator.SSHOperator, error)) (*operator.SSHOperator, error) {
+	delay := timeouts.RetryDelay
+	for attempt := 1; ; attempt++ {
+		sshOperator, err := dial()
+		if err == nil || attempt > timeouts.Retries || !operator.IsConnectionError(err) {
+			return sshOperator, err
+		}
+
+		infof("Unable to connect to %s: %s, retrying in %s (retry %d of %d)\n", address, err, delay, attempt, timeouts.Retries)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
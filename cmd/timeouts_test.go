@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+func Test_dialWithRetries(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}
+
+	cases := []struct {
+		name      string
+		retries   int
+		failures  []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"connects first time", 3, nil, 1, false},
+		{"connects after refusals", 3, []error{refused, refused}, 3, false},
+		{"retries run out", 2, []error{refused, refused, refused, refused}, 3, true},
+		{"no retries by default", 0, []error{refused}, 1, true},
+		{"authentication is not retried", 3, []error{errors.New("ssh: handshake failed: ssh: unable to authenticate")}, 1, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			calls := 0
+			dial := func() (*operator.SSHOperator, error) {
+				calls++
+				if calls <= len(c.failures) {
+					return nil, c.failures[calls-1]
+				}
+				return &operator.SSHOperator{}, nil
+			}
+
+			timeouts := sshTimeouts{Retries: c.retries, RetryDelay: time.Millisecond}
+			_, err := dialWithRetries("192.168.0.100:22", timeouts, dial)
+			if c.wantErr && err == nil {
+				t.Fatalf("want an error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if calls != c.wantCalls {
+				t.Errorf("want %d attempts, got: %d", c.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func Test_sshTimeoutsFromFlags_Keepalive(t *testing.T) {
+	command := MakeInstall()
+	timeouts, err := sshTimeoutsFromFlags(command)
+	if err != nil || timeouts.Keepalive != 30*time.Second {
+		t.Errorf("want keepalives every 30s by default, got: %s %v", timeouts.Keepalive, err)
+	}
+
+	command.Flags().Set("ssh-keepalive-interval", "-1s")
+	if _, err := sshTimeoutsFromFlags(command); err == nil || err.Error() != "--ssh-keepalive-interval must not be negative" {
+		t.Errorf("want an error for a negative interval, got: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Names of the phases of install which are timed.
+const (
+	phaseConnect     = "connect"
+	phaseInstall     = "install"
+	phaseServerReady = "server-ready"
+	phaseKubeconfig  = "kubeconfig"
+	phaseApply       = "apply"
+	phaseNodeReady   = "node-ready"
+)
+
+// installPhase is the time taken by a phase of install.
+type installPhase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// phaseTimer records how long each phase of an install took, for the
+// summary printed at the end and the result of --output json.
+type phaseTimer struct {
+	start  time.Time
+	phases []installPhase
+}
+
+func newPhaseTimer() *phaseTimer {
+	return &phaseTimer{start: time.Now()}
+}
+
+// begin starts the phase name, the returned func ends it and records how
+// long it took, whether or not it failed.
+func (t *phaseTimer) begin(name string) func() {
+	start := time.Now()
+	return func() {
+		t.phases = append(t.phases, installPhase{Name: name, Duration: time.Since(start)})
+	}
+}
+
+func (t *phaseTimer) ran(name string) bool {
+	for _, phase := range t.phases {
+		if phase.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// seconds returns the duration of each phase in seconds, or nil when none
+// was timed.
+func (t *phaseTimer) seconds() map[string]float64 {
+	if t == nil || len(t.phases) == 0 {
+		return nil
+	}
+	seconds := map[string]float64{}
+	for _, phase := range t.phases {
+		seconds[phase.Name] += phase.Duration.Seconds()
+	}
+	return seconds
+}
+
+// summary is the line printed at the end of an install, i.e. "Installed k3s
+// v1.19.1+k3s1 on 192.168.0.100 in 43s (connect 1.2s, install 30.1s)".
+func (t *phaseTimer) summary(k3s, ip string, total time.Duration) string {
+	phases := []string{}
+	for _, phase := range t.phases {
+		phases = append(phases, fmt.Sprintf("%s %s", phase.Name, roundDuration(phase.Duration)))
+	}
+
+	line := fmt.Sprintf("Installed %s on %s in %s", k3s, ip, roundDuration(total))
+	if !t.ran(phaseInstall) {
+		line = fmt.Sprintf("%s was already installed on %s, finished in %s", k3s, ip, roundDuration(total))
+	}
+	if len(phases) > 0 {
+		line += " (" + strings.Join(phases, ", ") + ")"
+	}
+	return line
+}
+
+// roundDuration rounds d for printing, to a tenth of a second below a
+// minute and to a second above.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
+
+// describeK3s names what install put on the node in the summary, the
+// version which k3s reported once installed, or else the one asked for.
+func describeK3s(installed, k3sCommit, k3sVersion, k3sChannel string) string {
+	switch {
+	case len(installed) > 0 && len(k3sCommit) > 0:
+		return fmt.Sprintf("k3s %s of commit %s", installed, k3sCommit[:7])
+	case len(installed) > 0:
+		return "k3s " + installed
+	case len(k3sCommit) > 0:
+		return "k3s commit " + k3sCommit[:7]
+	case len(k3sVersion) > 0:
+		return "k3s " + k3sVersion
+	}
+	return fmt.Sprintf("k3s from the %s channel", k3sChannel)
+}
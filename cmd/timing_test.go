@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_phaseTimer_summary(t *testing.T) {
+	timer := &phaseTimer{phases: []installPhase{
+		{Name: phaseConnect, Duration: 1234 * time.Millisecond},
+		{Name: phaseInstall, Duration: 30100 * time.Millisecond},
+		{Name: phaseKubeconfig, Duration: 420 * time.Millisecond},
+	}}
+
+	got := timer.summary("k3s v1.19.1+k3s1", "192.168.0.100", 43*time.Second+300*time.Millisecond)
+	want := "Installed k3s v1.19.1+k3s1 on 192.168.0.100 in 43.3s (connect 1.2s, install 30.1s, kubeconfig 400ms)"
+	if got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
+
+func Test_phaseTimer_summary_AlreadyInstalled(t *testing.T) {
+	timer := &phaseTimer{phases: []installPhase{{Name: phaseConnect, Duration: time.Second}}}
+
+	got := timer.summary("k3s from the stable channel", "192.168.0.100", 125*time.Second+400*time.Millisecond)
+	want := "k3s from the stable channel was already installed on 192.168.0.100, finished in 2m5s (connect 1s)"
+	if got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
+
+func Test_phaseTimer_begin(t *testing.T) {
+	timer := newPhaseTimer()
+	end := timer.begin(phaseConnect)
+	end()
+
+	if !timer.ran(phaseConnect) || timer.ran(phaseInstall) {
+		t.Errorf("want only the connect phase recorded, got: %+v", timer.phases)
+	}
+	if seconds := timer.seconds(); len(seconds) != 1 {
+		t.Errorf("want the seconds of one phase, got: %v", seconds)
+	}
+	if seconds := (*phaseTimer)(nil).seconds(); seconds != nil {
+		t.Errorf("want no seconds without a timer, got: %v", seconds)
+	}
+}
+
+func Test_describeK3s(t *testing.T) {
+	cases := []struct {
+		installed, commit, version, channel string
+		want                                string
+	}{
+		{"", "1b8b27c3cb02c3e0da30fa4bd3e8a3e3ff0ab2a1", "", "v1.18", "k3s commit 1b8b27c"},
+		{"", "", "v1.19.1+k3s1", "v1.18", "k3s v1.19.1+k3s1"},
+		{"", "", "", "stable", "k3s from the stable channel"},
+		{"v1.19.5+k3s2", "", "", "stable", "k3s v1.19.5+k3s2"},
+		{"v1.19.5+k3s2", "1b8b27c3cb02c3e0da30fa4bd3e8a3e3ff0ab2a1", "", "", "k3s v1.19.5+k3s2 of commit 1b8b27c"},
+	}
+	for _, c := range cases {
+		if got := describeK3s(c.installed, c.commit, c.version, c.channel); got != c.want {
+			t.Errorf("want: %q, got: %q", c.want, got)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+const detectUninstallScript = `if [ -x /usr/local/bin/k3s-uninstall.sh ]; then echo server; elif [ -x /usr/local/bin/k3s-agent-uninstall.sh ]; then echo agent; else echo none; fi`
+
+func MakeUninstall() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "uninstall",
+		Short: "Uninstall k3s from a server or agent",
+		Long: `Uninstall k3s from a server or agent by running the uninstall script
+which the k3s installer left on the node.`,
+		Example: `  k3sup uninstall --ip 192.168.0.100 --user root
+  k3sup uninstall --ip 192.168.0.100 --purge-kubeconfig --local-path ~/.kube/config --context my-k3s
+  k3sup uninstall --local`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("ip", net.ParseIP("127.0.0.1"), "Public IP of node")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	addHostKeyFlags(command)
+	addSSHPasswordFlags(command)
+	addSSHTimeoutFlags(command)
+	addSSHProxyFlags(command)
+	command.Flags().Bool("sudo", true, "Use sudo to uninstall k3s. e.g. set to false when using the root user and no sudo is available.")
+	command.Flags().String("sudo-binary", "sudo", "The binary used for privilege escalation with --sudo, i.e. doas")
+	addSudoPasswordFlags(command)
+	command.Flags().Bool("local", false, "Uninstall k3s from this computer without using ssh")
+
+	command.Flags().Bool("purge-kubeconfig", false, "Remove the context, cluster and user of the node from the local kubeconfig")
+	command.Flags().String("local-path", "kubeconfig", "Local path of the kubeconfig file to purge")
+	command.Flags().String("context", "default", "The name of the kubeconfig context to purge")
+	command.Flags().String("cluster-name", "", "Optional: the name of the kubeconfig cluster to purge, defaults to --context")
+	command.Flags().String("user-name", "", "Optional: the name of the kubeconfig user to purge, defaults to --context")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		infof("Running: k3sup uninstall\n")
+
+		local, _ := command.Flags().GetBool("local")
+		useSudo, _ := command.Flags().GetBool("sudo")
+		sudoBinary, _ := command.Flags().GetString("sudo-binary")
+		sudoPrefix, err := makeSudoPrefix(useSudo, sudoBinary)
+		if err != nil {
+			return err
+		}
+
+		if local {
+			commandTimeout, _ := command.Flags().GetDuration("command-timeout")
+			audit, err := auditLogFromFlags(command)
+			if err != nil {
+				return err
+			}
+			if err := uninstallK3s(operator.ExecOperator{CommandTimeout: commandTimeout, Audit: audit.recorder("localhost")}, sudoPrefix); err != nil {
+				return err
+			}
+		} else {
+			ip, _ := command.Flags().GetIP("ip")
+			user, _ := command.Flags().GetString("user")
+			sshKey, _ := command.Flags().GetString("ssh-key")
+			port, _ := command.Flags().GetInt("ssh-port")
+
+			sshOpts, err := sshOptionsFromFlags(command, "")
+			if err != nil {
+				return err
+			}
+			defer sshOpts.zero()
+
+			address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+			op, err := connectSSH(address, user, expandPath(sshKey), sshOpts)
+			if err != nil {
+				return err
+			}
+			defer op.Close()
+
+			if err := uninstallK3s(op, sudoPrefix); err != nil {
+				return err
+			}
+		}
+
+		purgeKubeconfig, _ := command.Flags().GetBool("purge-kubeconfig")
+		if !purgeKubeconfig {
+			return nil
+		}
+
+		localKubeconfig, _ := command.Flags().GetString("local-path")
+		context, _ := command.Flags().GetString("context")
+		clusterName, _ := command.Flags().GetString("cluster-name")
+		if clusterName == "" {
+			clusterName = context
+		}
+		userName, _ := command.Flags().GetString("user-name")
+		if userName == "" {
+			userName = context
+		}
+
+		return purgeLocalKubeconfig(expandPath(localKubeconfig), context, clusterName, userName)
+	}
+
+	return command
+}
+
+// uninstallK3s runs the uninstall script for the server or the agent,
+// whichever the k3s installer left on the node.
+func uninstallK3s(operator operator.CommandOperator, sudoPrefix string) error {
+	if err := checkSudo(operator, sudoPrefix); err != nil {
+		return err
+	}
+
+	res, err := operator.Execute(detectUninstallScript)
+	if err != nil {
+		return fmt.Errorf("error received detecting k3s: %s", err)
+	}
+
+	script := ""
+	switch role := strings.TrimSpace(string(res.StdOut)); role {
+	case "server":
+		script = "/usr/local/bin/k3s-uninstall.sh"
+	case "agent":
+		script = "/usr/local/bin/k3s-agent-uninstall.sh"
+	default:
+		infof("k3s is not installed, nothing to uninstall\n")
+		return nil
+	}
+
+	infof("Running: %s\n", script)
+	if _, err := operator.Execute(sudoPrefix + script); err != nil {
+		return fmt.Errorf("error received running %s: %s", script, err)
+	}
+	return nil
+}
+
+func purgeLocalKubeconfig(path, context, clusterName, userName string) error {
+	absPath, _ := filepath.Abs(path)
+	data, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			infof("No kubeconfig found at %s, nothing to purge\n", absPath)
+			return nil
+		}
+		return err
+	}
+
+	purged, err := removeKubeconfigEntries(data, context, clusterName, userName)
+	if err != nil {
+		return err
+	}
+
+	infof("Removing context %s, cluster %s and user %s from %s\n", context, clusterName, userName, absPath)
+	return writeFileAtomic(absPath, purged, defaultKubeconfigMode)
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+// scriptedOperator replies to known commands with fixed output and
+// records every command it was given. An upload is recorded as the command
+// "upload <path> <mode>" and its content is kept in uploads.
+type scriptedOperator struct {
+	replies  map[string]string
+	commands []string
+	uploads  map[string][]byte
+}
+
+func (s *scriptedOperator) Execute(command string) (operator.CommandRes, error) {
+	s.commands = append(s.commands, command)
+	return operator.CommandRes{StdOut: []byte(s.replies[command])}, nil
+}
+
+func (s *scriptedOperator) Upload(reader io.Reader, remotePath string, mode os.FileMode) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	s.commands = append(s.commands, fmt.Sprintf("upload %s %o", remotePath, mode))
+	if s.uploads == nil {
+		s.uploads = map[string][]byte{}
+	}
+	s.uploads[remotePath] = data
+	return nil
+}
+
+func Test_uninstallK3s(t *testing.T) {
+	cases := []struct {
+		role string
+		want []string
+	}{
+		{"server\n", []string{detectUninstallScript, "/usr/local/bin/k3s-uninstall.sh"}},
+		{"agent\n", []string{detectUninstallScript, "/usr/local/bin/k3s-agent-uninstall.sh"}},
+		{"none\n", []string{detectUninstallScript}},
+	}
+
+	for _, c := range cases {
+		op := &scriptedOperator{replies: map[string]string{detectUninstallScript: c.role}}
+		if err := uninstallK3s(op, ""); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(op.commands, c.want) {
+			t.Errorf("role %q, want: %q, got: %q", c.role, c.want, op.commands)
+		}
+	}
+}
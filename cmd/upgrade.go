@@ -0,0 +1,455 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	"github.com/spf13/cobra"
+)
+
+func MakeUpgrade() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrade k3s on a server or agent in place",
+		Long: `Upgrade k3s on a server or agent via SSH by running the k3s installer
+again for the new version, with the arguments and environment of the existing
+k3s service so that its configuration and data are kept.
+
+Upgrade the nodes of a HA cluster one at a time, servers first, waiting for
+each node to be Ready before moving on to the next.`,
+		Example: `  k3sup upgrade --ip 192.168.0.100 --user root --k3s-version v1.19.5+k3s1
+  k3sup upgrade --ip 192.168.0.101 --user root --k3s-channel stable --wait-for-ready`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("ip", net.ParseIP("127.0.0.1"), "Public IP of node")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	addHostKeyFlags(command)
+	addSSHPasswordFlags(command)
+	addSSHTimeoutFlags(command)
+	addSSHProxyFlags(command)
+	command.Flags().Bool("sudo", true, "Use sudo to upgrade k3s. e.g. set to false when using the root user and no sudo is available.")
+	command.Flags().String("sudo-binary", "sudo", "The binary used for privilege escalation with --sudo, i.e. doas")
+	addSudoPasswordFlags(command)
+
+	command.Flags().String("k3s-version", "", "Version to upgrade to, overrides k3s-channel")
+	command.Flags().String("k3s-channel", "", "Release channel to upgrade to: stable, latest, or i.e. v1.19")
+	command.Flags().String("channel-url", "", "Optional: URL of a self-hosted channel server used to resolve --k3s-channel")
+	addK3sSourceFlags(command)
+	command.Flags().Bool("force-downgrade", false, "Run the installer even when the version is older than the one installed")
+
+	command.Flags().Bool("wait-for-ready", false, "Wait after the upgrade until the node reports Ready, or until the k3s-agent service is active on an agent")
+	command.Flags().Duration("wait-timeout", 2*time.Minute, "Time to wait for the node with --wait-for-ready")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		infof("Running: k3sup upgrade\n")
+
+		ip, _ := command.Flags().GetIP("ip")
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+
+		useSudo, _ := command.Flags().GetBool("sudo")
+		sudoBinary, _ := command.Flags().GetString("sudo-binary")
+		sudoPrefix, err := makeSudoPrefix(useSudo, sudoBinary)
+		if err != nil {
+			return err
+		}
+
+		k3sVersion, _ := command.Flags().GetString("k3s-version")
+		k3sChannel, _ := command.Flags().GetString("k3s-channel")
+		channelURL, _ := command.Flags().GetString("channel-url")
+		if len(k3sVersion) == 0 && len(k3sChannel) == 0 {
+			return fmt.Errorf("give a value for --k3s-version or --k3s-channel")
+		}
+		if err := validateChannelURL(channelURL); err != nil {
+			return err
+		}
+
+		source, err := k3sSourceFromFlags(command)
+		if err != nil {
+			return err
+		}
+
+		forceDowngrade, _ := command.Flags().GetBool("force-downgrade")
+		waitForReady, _ := command.Flags().GetBool("wait-for-ready")
+		waitTimeout, _ := command.Flags().GetDuration("wait-timeout")
+
+		// The channel is always pinned, so that the version can be compared
+		// with the one installed and the node ends up on exactly it.
+		if len(k3sVersion) == 0 {
+			k3sVersion, err = pinChannel(channelURL, k3sChannel)
+			if err != nil {
+				return err
+			}
+		}
+
+		sshOpts, err := sshOptionsFromFlags(command, "")
+		if err != nil {
+			return err
+		}
+		defer sshOpts.zero()
+
+		address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		op, err := connectNode(address, user, expandPath(sshKey), sshOpts)
+		if err != nil {
+			return err
+		}
+		defer op.Close()
+
+		role, err := upgradeK3s(op, upgradeOptions{
+			SudoPrefix:     sudoPrefix,
+			K3sVersion:     k3sVersion,
+			Source:         source,
+			ForceDowngrade: forceDowngrade,
+		})
+		if err != nil || !waitForReady {
+			return err
+		}
+
+		if role == "agent" {
+			return waitForServer(op, []serverArtifact{serviceActiveArtifact("k3s-agent")}, waitTimeout, 2*time.Second)
+		}
+		nodeName, err := serviceNodeName(op, "k3s")
+		if err != nil {
+			return err
+		}
+		return waitForNodeReady(op, sudoPrefix, nodeName, waitTimeout, 2*time.Second)
+	}
+
+	return command
+}
+
+type upgradeOptions struct {
+	SudoPrefix     string
+	K3sVersion     string
+	Source         k3sSource
+	ForceDowngrade bool
+}
+
+// upgradeK3s runs the installer for options.K3sVersion on a server or agent
+// and returns its role. A node which is already at the version is left
+// alone.
+func upgradeK3s(operator operator.CommandOperator, options upgradeOptions) (string, error) {
+	if err := checkSudo(operator, options.SudoPrefix); err != nil {
+		return "", err
+	}
+
+	res, err := operator.Execute(detectUninstallScript)
+	if err != nil {
+		return "", fmt.Errorf("error received detecting k3s: %s", err)
+	}
+
+	service := ""
+	role := strings.TrimSpace(string(res.StdOut))
+	switch role {
+	case "server":
+		service = "k3s"
+	case "agent":
+		service = "k3s-agent"
+	default:
+		return "", fmt.Errorf("k3s is not installed on the node, use k3sup install or k3sup join instead")
+	}
+
+	before, err := installedK3sVersion(operator)
+	if err != nil {
+		return "", err
+	}
+
+	cmp, err := compareK3sVersions(options.K3sVersion, before)
+	if err != nil {
+		return "", err
+	}
+	if cmp == 0 {
+		infof("k3s %s is already at %s, nothing to upgrade\n", role, before)
+		return role, nil
+	}
+	if cmp < 0 && !options.ForceDowngrade {
+		return "", fmt.Errorf("refusing to downgrade k3s from %s to %s, use --force-downgrade to run the installer anyway", before, options.K3sVersion)
+	}
+
+	res, err = operator.Execute(fmt.Sprintf("systemctl show %s -p ExecStart", service))
+	if err != nil {
+		return "", fmt.Errorf("error received reading the %s service: %s", service, err)
+	}
+	serviceArgs, err := parseExecStartArgs(string(res.StdOut))
+	if err != nil {
+		return "", fmt.Errorf("unable to read the arguments of the %s service: %s", service, err)
+	}
+
+	upgradeCommand, err := makeUpgradeCommand(service, serviceArgs, options)
+	if err != nil {
+		return "", err
+	}
+
+	infof("Upgrading k3s %s from %s to %s\n", role, before, options.K3sVersion)
+	debugf("Executing: %s\n", redactSecrets(upgradeCommand))
+	res, err = operator.Execute(upgradeCommand)
+	debugOutput("Upgrade", res)
+	if err != nil {
+		return "", fmt.Errorf("error received upgrading k3s: %w", withStderr(err, res))
+	}
+
+	after, err := installedK3sVersion(operator)
+	if err != nil {
+		return "", err
+	}
+	if after != options.K3sVersion {
+		return "", fmt.Errorf("k3s reports version %q after the upgrade from %s, expected %s", after, before, options.K3sVersion)
+	}
+
+	infof("Upgraded k3s %s from %s to %s\n", role, before, after)
+	return role, nil
+}
+
+func installedK3sVersion(operator operator.CommandOperator) (string, error) {
+	res, err := operator.Execute(install.DetectVersionCommand)
+	if err != nil {
+		return "", fmt.Errorf("error received reading the version of k3s: %s", err)
+	}
+
+	version, _ := install.ParseK3sVersion(string(res.StdOut))
+	if len(version) == 0 {
+		return "", fmt.Errorf("unable to read the version of k3s from: %q", strings.TrimSpace(string(res.StdOut)))
+	}
+	return version, nil
+}
+
+// makeUpgradeCommand runs the installer with the environment file and the
+// arguments of the existing service, the installer would otherwise replace
+// them with its defaults. The arguments include the command, server or
+// agent.
+func makeUpgradeCommand(service string, serviceArgs []string, options upgradeOptions) (string, error) {
+	if len(serviceArgs) == 0 {
+		return "", fmt.Errorf("the %s service has no arguments", service)
+	}
+
+	quoted := []string{}
+	for _, arg := range serviceArgs {
+		quoted = append(quoted, operator.ShellQuote(arg))
+	}
+
+	loadEnv := fmt.Sprintf("set -a && eval \"$(%scat /etc/systemd/system/%s.service.env 2>/dev/null)\" && set +a", options.SudoPrefix, service)
+	installStr := options.Source.withEnv(install.VersionEnv(options.K3sVersion, "", ""))
+
+	return fmt.Sprintf("%s && %s | %s sh -s - %s", loadEnv, options.Source.scriptCommand(options.SudoPrefix), installStr, strings.Join(quoted, " ")), nil
+}
+
+// parseExecStartArgs returns the arguments after the binary from the output
+// of systemctl show -p ExecStart, i.e.
+// "ExecStart={ path=/usr/local/bin/k3s ; argv[]=/usr/local/bin/k3s server --tls-san 10.0.0.1 ; ... }"
+// Arguments are unquoted as systemd quotes them, so that one with a space,
+// i.e. --node-label 'zone=eu west', stays one argument.
+func parseExecStartArgs(output string) ([]string, error) {
+	const argvPrefix = "argv[]="
+
+	start := strings.Index(output, argvPrefix)
+	if start < 0 {
+		return nil, fmt.Errorf("no ExecStart found in: %q", strings.TrimSpace(output))
+	}
+
+	fields, err := splitSystemdWords(output[start+len(argvPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse ExecStart %q: %s", strings.TrimSpace(output), err)
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("no arguments found in ExecStart: %q", strings.TrimSpace(output))
+	}
+	return fields[1:], nil
+}
+
+// systemdEscapes are the C-style escapes systemd unquotes, besides \xNN.
+var systemdEscapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+	's': ' ', '\\': '\\', '"': '"', '\'': '\'',
+}
+
+// splitSystemdWords splits value into words as systemd does for a command
+// line: words are separated by whitespace, may be quoted in double or single
+// quotes and may contain backslash escapes. It stops at an unquoted ";"
+// word, which ends the argv in the output of systemctl show.
+func splitSystemdWords(value string) ([]string, error) {
+	words := []string{}
+	for i := 0; i < len(value); {
+		for i < len(value) && strings.IndexByte(" \t\n", value[i]) >= 0 {
+			i++
+		}
+		if i == len(value) {
+			break
+		}
+
+		word := []byte{}
+		quote := byte(0)
+		quoted := false
+		for ; i < len(value); i++ {
+			c := value[i]
+			if quote == 0 && strings.IndexByte(" \t\n", c) >= 0 {
+				break
+			}
+
+			switch {
+			case c == '\\':
+				if i+1 == len(value) {
+					return nil, fmt.Errorf("trailing backslash")
+				}
+				i++
+				if value[i] == 'x' {
+					if i+2 >= len(value) {
+						return nil, fmt.Errorf("short \\x escape")
+					}
+					b, err := strconv.ParseUint(value[i+1:i+3], 16, 8)
+					if err != nil {
+						return nil, fmt.Errorf("invalid \\x escape %q", value[i-1:i+3])
+					}
+					word = append(word, byte(b))
+					i += 2
+					continue
+				}
+				escaped, ok := systemdEscapes[value[i]]
+				if !ok {
+					return nil, fmt.Errorf("invalid escape %q", value[i-1:i+1])
+				}
+				word = append(word, escaped)
+			case quote != 0 && c == quote:
+				quote = 0
+			case quote == 0 && (c == '"' || c == '\''):
+				quote = c
+				quoted = true
+			default:
+				word = append(word, c)
+			}
+		}
+		if quote != 0 {
+			return nil, fmt.Errorf("unterminated %c quote", quote)
+		}
+
+		if !quoted && string(word) == ";" {
+			break
+		}
+		words = append(words, string(word))
+	}
+	return words, nil
+}
+
+// serviceNodeName returns the --node-name which the service runs k3s with,
+// or nothing when k3s names the node after the host.
+func serviceNodeName(operator operator.CommandOperator, service string) (string, error) {
+	res, err := operator.Execute(fmt.Sprintf("systemctl show %s -p ExecStart", service))
+	if err != nil {
+		return "", fmt.Errorf("error received reading the %s service: %s", service, err)
+	}
+	serviceArgs, err := parseExecStartArgs(string(res.StdOut))
+	if err != nil {
+		return "", fmt.Errorf("unable to read the arguments of the %s service: %s", service, err)
+	}
+	return nodeNameFromArgs(serviceArgs), nil
+}
+
+// nodeNameFromArgs returns the value of --node-name in the arguments of
+// k3s, given as "--node-name name" or "--node-name=name".
+func nodeNameFromArgs(args []string) string {
+	nodeName := ""
+	for i, arg := range args {
+		if arg == "--node-name" && i+1 < len(args) {
+			nodeName = args[i+1]
+		} else if strings.HasPrefix(arg, "--node-name=") {
+			nodeName = strings.TrimPrefix(arg, "--node-name=")
+		}
+	}
+	return nodeName
+}
+
+func serviceActiveArtifact(service string) serverArtifact {
+	return serverArtifact{
+		Name:    service + " service",
+		Command: fmt.Sprintf("if systemctl is-active --quiet %s; then echo ready; fi", service),
+	}
+}
+
+// k3sRelease is a parsed k3s version such as v1.19.5-rc1+k3s2.
+type k3sRelease struct {
+	numbers    [3]int
+	prerelease string
+	build      int
+}
+
+func parseK3sRelease(version string) (k3sRelease, error) {
+	release := k3sRelease{}
+	invalid := fmt.Errorf("unable to parse k3s version %q, want i.e. v1.19.5+k3s1", version)
+
+	rest := strings.TrimPrefix(version, "v")
+	if i := strings.Index(rest, "+k3s"); i >= 0 {
+		build, err := strconv.Atoi(rest[i+len("+k3s"):])
+		if err != nil {
+			return release, invalid
+		}
+		release.build = build
+		rest = rest[:i]
+	}
+	if i := strings.Index(rest, "-"); i >= 0 {
+		release.prerelease = rest[i+1:]
+		rest = rest[:i]
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return release, invalid
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return release, invalid
+		}
+		release.numbers[i] = n
+	}
+	return release, nil
+}
+
+// compareK3sVersions returns -1, 0 or 1 when a is older than, the same as
+// or newer than b. A release candidate is older than its release.
+func compareK3sVersions(a, b string) (int, error) {
+	ra, err := parseK3sRelease(a)
+	if err != nil {
+		return 0, err
+	}
+	rb, err := parseK3sRelease(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range ra.numbers {
+		if ra.numbers[i] != rb.numbers[i] {
+			return compareInts(ra.numbers[i], rb.numbers[i]), nil
+		}
+	}
+	if ra.prerelease != rb.prerelease {
+		switch {
+		case len(ra.prerelease) == 0:
+			return 1, nil
+		case len(rb.prerelease) == 0:
+			return -1, nil
+		case ra.prerelease < rb.prerelease:
+			return -1, nil
+		default:
+			return 1, nil
+		}
+	}
+	return compareInts(ra.build, rb.build), nil
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
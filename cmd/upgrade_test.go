@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+const agentExecStart = "ExecStart={ path=/usr/local/bin/k3s ; argv[]=/usr/local/bin/k3s agent --node-label zone=a ; ignore_errors=no ; start_time=[n/a] ; stop_time=[n/a] ; pid=0 ; code=(null) ; status=0/0 }\n"
+
+func Test_parseExecStartArgs(t *testing.T) {
+	got, err := parseExecStartArgs("ExecStart={ path=/usr/local/bin/k3s ; argv[]=/usr/local/bin/k3s server --tls-san 10.0.0.1 --disable traefik ; ignore_errors=no ; start_time=[n/a] }\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{"server", "--tls-san", "10.0.0.1", "--disable", "traefik"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	got, err = parseExecStartArgs(`ExecStart={ path=/usr/local/bin/k3s ; argv[]=/usr/local/bin/k3s agent --node-label "zone=eu west" '--node-taint' 'a=b;c' --data-dir /srv/k3s\x20data --node-label tier=\"web\" ";" ; ignore_errors=no }`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want = []string{"agent", "--node-label", "zone=eu west", "--node-taint", "a=b;c", "--data-dir", "/srv/k3s data", "--node-label", `tier="web"`, ";"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	for _, output := range []string{
+		"",
+		"ExecStart=\n",
+		"ExecStart={ path=/usr/local/bin/k3s ; argv[]=/usr/local/bin/k3s ; }",
+		"ExecStart={ path=/usr/local/bin/k3s ; argv[]=/usr/local/bin/k3s server --node-label 'zone=eu ; }",
+		`ExecStart={ path=/usr/local/bin/k3s ; argv[]=/usr/local/bin/k3s server --tls-san \q ; }`,
+	} {
+		if _, err := parseExecStartArgs(output); err == nil {
+			t.Errorf("want error for %q", output)
+		}
+	}
+}
+
+func Test_compareK3sVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.19.5+k3s1", "v1.19.5+k3s1", 0},
+		{"v1.19.5+k3s1", "v1.18.12+k3s1", 1},
+		{"v1.18.12+k3s1", "v1.19.5+k3s1", -1},
+		{"v1.19.5+k3s2", "v1.19.5+k3s1", 1},
+		{"v1.19.10+k3s1", "v1.19.9+k3s1", 1},
+		{"v1.19.5-rc1+k3s1", "v1.19.5+k3s1", -1},
+		{"v1.19.5-rc2+k3s1", "v1.19.5-rc1+k3s1", 1},
+		{"v1.0.0", "v0.10.2", 1},
+	}
+
+	for _, c := range cases {
+		got, err := compareK3sVersions(c.a, c.b)
+		if err != nil {
+			t.Fatalf("unexpected error comparing %s with %s: %s", c.a, c.b, err)
+		}
+		if got != c.want {
+			t.Errorf("compare %s with %s, want: %d, got: %d", c.a, c.b, c.want, got)
+		}
+	}
+
+	for _, version := range []string{"stable", "v1.19", "v1.19.x+k3s1", "v1.19.5+k3sX"} {
+		if _, err := compareK3sVersions(version, "v1.19.5+k3s1"); err == nil {
+			t.Errorf("want error for %q", version)
+		}
+	}
+}
+
+func Test_makeUpgradeCommand(t *testing.T) {
+	got, err := makeUpgradeCommand("k3s-agent", []string{"agent", "--node-label", "zone=a"}, upgradeOptions{
+		SudoPrefix: "sudo ",
+		K3sVersion: "v1.19.5+k3s1",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := `set -a && eval "$(sudo cat /etc/systemd/system/k3s-agent.service.env 2>/dev/null)" && set +a && curl -sfL https://get.k3s.io | INSTALL_K3S_VERSION='v1.19.5+k3s1' sh -s - 'agent' '--node-label' 'zone=a'`
+	if got != want {
+		t.Errorf("want: %q\ngot:  %q", want, got)
+	}
+
+	got, err = makeUpgradeCommand("k3s", []string{"server", "--node-label", "it's", "--kubelet-arg", "root-dir=/srv/my kubelet"}, upgradeOptions{K3sVersion: "v1.19.5+k3s1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := `sh -s - 'server' '--node-label' 'it'\''s' '--kubelet-arg' 'root-dir=/srv/my kubelet'`; !strings.HasSuffix(got, want) {
+		t.Errorf("want each argument quoted, got: %q", got)
+	}
+}
+
+func Test_nodeNameFromArgs(t *testing.T) {
+	cases := map[string][]string{
+		"":       {"server", "--tls-san", "10.0.0.1"},
+		"edge-1": {"server", "--node-name", "edge-1"},
+		"edge-2": {"agent", "--node-name=edge-2"},
+	}
+	for want, args := range cases {
+		if got := nodeNameFromArgs(args); got != want {
+			t.Errorf("want %q for %q, got: %q", want, args, got)
+		}
+	}
+}
+
+func Test_upgradeK3s(t *testing.T) {
+	op := &upgradingOperator{
+		scriptedOperator: scriptedOperator{replies: map[string]string{
+			detectUninstallScript:                   "agent\n",
+			"systemctl show k3s-agent -p ExecStart": agentExecStart,
+		}},
+	}
+
+	role, err := upgradeK3s(op, upgradeOptions{K3sVersion: "v1.19.5+k3s1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if role != "agent" {
+		t.Errorf("want role agent, got: %s", role)
+	}
+	if !op.upgraded {
+		t.Errorf("want the installer to be run, commands: %q", op.commands)
+	}
+}
+
+func Test_upgradeK3s_Downgrade(t *testing.T) {
+	op := &upgradingOperator{
+		scriptedOperator: scriptedOperator{replies: map[string]string{
+			detectUninstallScript:                   "agent\n",
+			"systemctl show k3s-agent -p ExecStart": agentExecStart,
+		}},
+	}
+
+	_, err := upgradeK3s(op, upgradeOptions{K3sVersion: "v1.17.14+k3s1"})
+	if err == nil || !strings.Contains(err.Error(), "--force-downgrade") {
+		t.Fatalf("want downgrade error, got: %v", err)
+	}
+	if op.upgraded {
+		t.Errorf("want the installer not to be run")
+	}
+
+	if _, err := upgradeK3s(op, upgradeOptions{K3sVersion: "v1.17.14+k3s1", ForceDowngrade: true}); err != nil {
+		t.Fatalf("unexpected error with ForceDowngrade: %s", err)
+	}
+	if !op.upgraded {
+		t.Errorf("want the installer to be run with ForceDowngrade")
+	}
+}
+
+func Test_upgradeK3s_SameVersion(t *testing.T) {
+	op := &upgradingOperator{
+		scriptedOperator: scriptedOperator{replies: map[string]string{detectUninstallScript: "server\n"}},
+	}
+
+	if _, err := upgradeK3s(op, upgradeOptions{K3sVersion: "v1.18.12+k3s1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{detectUninstallScript, install.DetectVersionCommand}
+	if !reflect.DeepEqual(op.commands, want) {
+		t.Errorf("want: %q, got: %q", want, op.commands)
+	}
+}
+
+func Test_upgradeK3s_NotInstalled(t *testing.T) {
+	op := &scriptedOperator{replies: map[string]string{detectUninstallScript: "none\n"}}
+	if _, err := upgradeK3s(op, upgradeOptions{K3sVersion: "v1.19.5+k3s1"}); err == nil {
+		t.Errorf("want error when k3s is not installed")
+	}
+}
+
+// upgradingOperator reports k3s v1.18.12+k3s1 until it runs the installer,
+// and the requested version after that.
+type upgradingOperator struct {
+	scriptedOperator
+	upgraded bool
+	version  string
+}
+
+func (u *upgradingOperator) Execute(command string) (operator.CommandRes, error) {
+	res, err := u.scriptedOperator.Execute(command)
+	switch {
+	case command == install.DetectVersionCommand:
+		version := "v1.18.12+k3s1"
+		if u.upgraded {
+			version = u.version
+		}
+		res.StdOut = []byte("k3s version " + version + " (b11612e2)\ninactive\n")
+	case strings.Contains(command, "INSTALL_K3S_VERSION="):
+		u.upgraded = true
+		u.version = strings.SplitN(strings.SplitN(command, "INSTALL_K3S_VERSION='", 2)[1], "'", 2)[0]
+	}
+	return res, err
+}
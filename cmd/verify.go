@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexellis/k3sup/pkg/install"
+	"github.com/spf13/cobra"
+)
+
+func addVerifyFlags(command *cobra.Command) {
+	command.Flags().Bool("verify", false, "Call /version of the API server with the kubeconfig once it is written, and fail when it cannot be reached, i.e. when a firewall blocks port 6443")
+	command.Flags().Duration("verify-timeout", 10*time.Second, "Time to wait for the API server with --verify")
+}
+
+// verifyTimeoutFromFlags returns the timeout for --verify, or 0 when the
+// kubeconfig is not to be verified.
+func verifyTimeoutFromFlags(command *cobra.Command) (time.Duration, error) {
+	verify, _ := command.Flags().GetBool("verify")
+	if !verify {
+		return 0, nil
+	}
+
+	timeout, _ := command.Flags().GetDuration("verify-timeout")
+	if timeout <= 0 {
+		return 0, fmt.Errorf("--verify-timeout must be greater than zero")
+	}
+	return timeout, nil
+}
+
+// verifyServer checks the API server can be reached with the kubeconfig,
+// it does nothing when timeout is 0.
+func verifyServer(kubeconfig []byte, timeout time.Duration) error {
+	if timeout == 0 {
+		return nil
+	}
+
+	server, version, err := verifyKubeconfig(kubeconfig, timeout)
+	if err != nil {
+		return err
+	}
+	infof("Verified the kubeconfig, the API server at %s runs %s\n", server, version)
+	return nil
+}
+
+// verifyKubeconfig calls /version of the server of the current-context of
+// the kubeconfig with its credentials, and returns the server URL and the
+// version of Kubernetes it runs.
+func verifyKubeconfig(data []byte, timeout time.Duration) (string, string, error) {
+	client, err := newAPIClient(data, timeout)
+	if err != nil {
+		return "", "", err
+	}
+	server := client.server
+
+	status, body, err := client.do(http.MethodGet, "/version", "", nil)
+	if err != nil {
+		return server, "", fmt.Errorf("unable to reach the API server at %s within %s, check that its port is open from this computer as well as SSH: %s", server, timeout, err)
+	}
+	if status != http.StatusOK {
+		return server, "", fmt.Errorf("the API server at %s answered /version with %d %s: %s", server, status, http.StatusText(status), strings.TrimSpace(string(body)))
+	}
+
+	version := struct {
+		GitVersion string `json:"gitVersion"`
+	}{}
+	if err := json.Unmarshal(body, &version); err != nil || len(version.GitVersion) == 0 {
+		return server, "", fmt.Errorf("unexpected answer to /version from the API server at %s: %q", server, body)
+	}
+	return server, version.GitVersion, nil
+}
+
+// apiClient calls the API server of the current-context of a kubeconfig
+// with its credentials.
+type apiClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// newAPIClient returns a client for the kubeconfig whose requests each
+// time out after timeout.
+func newAPIClient(data []byte, timeout time.Duration) (*apiClient, error) {
+	config, err := install.ParseKubeconfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, user, err := currentKubeconfigEntries(config)
+	if err != nil {
+		return nil, err
+	}
+
+	server, _ := cluster["server"].(string)
+	if len(server) == 0 {
+		return nil, fmt.Errorf("the cluster of the kubeconfig has no server")
+	}
+
+	tlsConfig, err := kubeconfigTLSConfig(cluster, user)
+	if err != nil {
+		return nil, err
+	}
+
+	token, _ := user["token"].(string)
+	return &apiClient{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}, nil
+}
+
+// do sends a request for path to the API server and returns the status
+// and body of its answer.
+func (c *apiClient) do(method, path, contentType string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, c.server+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	answer, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+	return res.StatusCode, answer, nil
+}
+
+// currentKubeconfigEntries returns the cluster and user of the
+// current-context of the kubeconfig.
+func currentKubeconfigEntries(config *install.KubeconfigFile) (map[string]interface{}, map[string]interface{}, error) {
+	var context map[string]interface{}
+	for _, c := range config.Contexts {
+		if c.Name == config.CurrentContext {
+			context = c.Context
+		}
+	}
+	if context == nil {
+		return nil, nil, fmt.Errorf("the current-context %q is not in the kubeconfig", config.CurrentContext)
+	}
+
+	clusterName, _ := context["cluster"].(string)
+	userName, _ := context["user"].(string)
+
+	var cluster, user map[string]interface{}
+	for _, c := range config.Clusters {
+		if c.Name == clusterName {
+			cluster = c.Cluster
+		}
+	}
+	for _, u := range config.Users {
+		if u.Name == userName {
+			user = u.User
+		}
+	}
+	if cluster == nil {
+		return nil, nil, fmt.Errorf("the cluster %q of the current-context is not in the kubeconfig", clusterName)
+	}
+	if user == nil {
+		user = map[string]interface{}{}
+	}
+	return cluster, user, nil
+}
+
+// kubeconfigTLSConfig trusts the certificate-authority-data of the cluster
+// and presents the client certificate of the user, when there is one.
+func kubeconfigTLSConfig(cluster, user map[string]interface{}) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if insecure, _ := cluster["insecure-skip-tls-verify"].(bool); insecure {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if caData, ok := cluster["certificate-authority-data"].(string); ok && len(caData) > 0 {
+		ca, err := base64.StdEncoding.DecodeString(caData)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode the certificate-authority-data of the kubeconfig: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in the certificate-authority-data of the kubeconfig")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certData, _ := user["client-certificate-data"].(string)
+	keyData, _ := user["client-key-data"].(string)
+	if len(certData) > 0 && len(keyData) > 0 {
+		cert, err := base64.StdEncoding.DecodeString(certData)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode the client-certificate-data of the kubeconfig: %s", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode the client-key-data of the kubeconfig: %s", err)
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate of the kubeconfig: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKubeconfig(server string, caPEM []byte) []byte {
+	return []byte(fmt.Sprintf(`apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: %s
+  name: k3s
+contexts:
+- context:
+    cluster: k3s
+    user: k3s
+  name: k3s
+current-context: k3s
+kind: Config
+users:
+- name: k3s
+  user:
+    token: secret
+`, base64.StdEncoding.EncodeToString(caPEM), server))
+}
+
+// selfSignedCA returns a PEM-encoded CA which has not signed anything.
+func selfSignedCA(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "k3s-server-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func Test_verifyKubeconfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"major": "1", "minor": "19", "gitVersion": "v1.19.5+k3s1"}`)
+	}))
+	defer server.Close()
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	gotServer, version, err := verifyKubeconfig(testKubeconfig(server.URL, caPEM), 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gotServer != server.URL || version != "v1.19.5+k3s1" {
+		t.Errorf("want: %s and v1.19.5+k3s1, got: %s and %s", server.URL, gotServer, version)
+	}
+}
+
+func Test_verifyKubeconfig_UntrustedCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"gitVersion": "v1.19.5+k3s1"}`)
+	}))
+	defer server.Close()
+
+	if _, _, err := verifyKubeconfig(testKubeconfig(server.URL, selfSignedCA(t)), 5*time.Second); err == nil {
+		t.Errorf("want an error when the server is not signed by the CA of the kubeconfig")
+	}
+}
+
+func Test_verifyKubeconfig_Unreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	_, _, err = verifyKubeconfig(testKubeconfig("https://"+address, selfSignedCA(t)), 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "unable to reach the API server") {
+		t.Errorf("want an error saying the server cannot be reached, got: %v", err)
+	}
+}
+
+func Test_verifyKubeconfig_Timeout(t *testing.T) {
+	// A listener which accepts connections but never answers, as a
+	// firewall which drops packets would.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	start := time.Now()
+	_, _, err = verifyKubeconfig(testKubeconfig("https://"+listener.Addr().String(), selfSignedCA(t)), 200*time.Millisecond)
+	if err == nil {
+		t.Fatalf("want an error when the server does not answer")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("want verify to give up after the timeout, took: %s", elapsed)
+	}
+}
+
+func Test_verifyTimeoutFromFlags(t *testing.T) {
+	command := MakeInstall()
+	if timeout, err := verifyTimeoutFromFlags(command); err != nil || timeout != 0 {
+		t.Errorf("want no timeout without --verify, got: %s, %v", timeout, err)
+	}
+
+	command.Flags().Set("verify", "true")
+	if timeout, err := verifyTimeoutFromFlags(command); err != nil || timeout != 10*time.Second {
+		t.Errorf("want the default timeout with --verify, got: %s, %v", timeout, err)
+	}
+
+	command.Flags().Set("verify-timeout", "0s")
+	if _, err := verifyTimeoutFromFlags(command); err == nil {
+		t.Errorf("want an error for a timeout of 0")
+	}
+}
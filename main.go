@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/alexellis/k3sup/cmd"
@@ -14,6 +15,14 @@ func main() {
 	cmdJoin := cmd.MakeJoin()
 	cmdApps := cmd.MakeApps()
 	cmdUpdate := cmd.MakeUpdate()
+	cmdCert := cmd.MakeCert()
+	cmdRestart := cmd.MakeRestart()
+	cmdSnapshot := cmd.MakeSnapshot()
+	cmdUninstall := cmd.MakeUninstall()
+	cmdUpgrade := cmd.MakeUpgrade()
+	cmdGetKubeconfig := cmd.MakeGetKubeconfig()
+	cmdCheck := cmd.MakeCheck()
+	cmdListChannels := cmd.MakeListChannels()
 
 	printk3supASCIIArt := cmd.PrintK3supASCIIArt
 
@@ -23,15 +32,35 @@ func main() {
 			printk3supASCIIArt()
 			cmd.Help()
 		},
+		PersistentPreRunE: cmd.PersistentPreRun,
+		SilenceErrors:     true,
 	}
 
+	rootCmd.PersistentFlags().Bool("dry-run", false, "Print the commands which install and join would run on each node, and the local files they would write, without connecting or running anything")
+	rootCmd.PersistentFlags().String("audit-log", "", "Append a JSON line for each command run on a node, with the host, exit status and duration, to this file. Passwords and tokens are redacted")
+	rootCmd.PersistentFlags().Bool("show-secrets", false, "Print tokens, datastore passwords and join keys in commands and errors, which are redacted by default. Only use it for debugging, as the output may end up in CI logs")
+	rootCmd.PersistentFlags().Bool("no-output-markers", false, "Keep anything the shell of a node prints around the output of a command, such as a login banner, rather than stripping it. For debugging")
+	rootCmd.PersistentFlags().Bool("quiet", false, "Only print errors, prompts and the output of the command, such as the JSON of --output json")
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Also print each command run on a node, the full output of the installer and how SSH connects, for debugging")
+	rootCmd.PersistentFlags().String("output", "text", "Output format of install, check and cert check: text or json. With json only the result is printed to stdout, progress goes to stderr")
+
 	rootCmd.AddCommand(cmdInstall)
 	rootCmd.AddCommand(cmdVersion)
 	rootCmd.AddCommand(cmdJoin)
 	rootCmd.AddCommand(cmdApps)
 	rootCmd.AddCommand(cmdUpdate)
+	rootCmd.AddCommand(cmdCert)
+	rootCmd.AddCommand(cmdRestart)
+	rootCmd.AddCommand(cmdSnapshot)
+	rootCmd.AddCommand(cmdUninstall)
+	rootCmd.AddCommand(cmdUpgrade)
+	rootCmd.AddCommand(cmdGetKubeconfig)
+	rootCmd.AddCommand(cmdCheck)
+	rootCmd.AddCommand(cmdListChannels)
 
-	if err := rootCmd.Execute(); err != nil {
+	if command, err := rootCmd.ExecuteC(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", cmd.RedactError(err))
+		cmd.LogError(command, os.Args[1:], err)
 		os.Exit(1)
 	}
 }
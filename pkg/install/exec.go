@@ -0,0 +1,387 @@
+package install
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+// DefaultAPIPort is the port the API server of k3s listens on, unless
+// ExecOptions.APIPort is set.
+const DefaultAPIPort = 6443
+
+// ExecOptions are the options of k3s on a server, the flags of k3sup
+// install which end up in INSTALL_K3S_EXEC.
+type ExecOptions struct {
+	Datastore      string
+	ExtraArgs      string
+	FlannelBackend string
+	// DisableNetworkPolicy turns off the network policy controller of k3s,
+	// which needs flannel, for --no-cni.
+	DisableNetworkPolicy bool
+	ClusterCIDR          string
+	ServiceCIDR          string
+	NoExtras             bool
+	Disable              []string
+	K3sVersion           string
+	VPNAuth              string
+	NodeExternalIP       string
+	NodeIP               string
+	NodeName             string
+	NodeLabels           []string
+	NodeTaints           []string
+	KubeletArgs          []string
+	KubeAPIServerArgs    []string
+	DataDir              string
+	// SecretsEncryption encrypts secrets at rest in the datastore. It
+	// cannot be turned on or off cleanly once the server stores secrets.
+	SecretsEncryption bool
+	// ProtectKernelDefaults makes the kubelet fail rather than change
+	// kernel params, and SELinux enables SELinux in containerd, as in the
+	// CIS hardening guide of k3s.
+	ProtectKernelDefaults bool
+	SELinux               bool
+	// APIPort is the port the API server listens on, k3s' default of
+	// DefaultAPIPort when zero.
+	APIPort int
+	// Server is the URL of an existing server for another server of an
+	// embedded etcd cluster to join.
+	Server string
+	// ServerArgs are passed to k3s one by one, each quoted for the shell,
+	// see InstallerShell.
+	ServerArgs []string
+}
+
+// MakeInstallExec builds INSTALL_K3S_EXEC for a server, or returns an error
+// naming the flags when they would give k3s conflicting options.
+func MakeInstallExec(cluster bool, ip net.IP, tlsSANs []string, options ExecOptions) (string, error) {
+	if err := validateInstallExec(cluster, options); err != nil {
+		return "", err
+	}
+
+	extraArgs := []string{}
+	if len(options.Datastore) > 0 {
+		extraArgs = append(extraArgs, fmt.Sprintf("--datastore-endpoint %s", options.Datastore))
+	}
+	if len(options.FlannelBackend) > 0 {
+		extraArgs = append(extraArgs, fmt.Sprintf("--flannel-backend %s", options.FlannelBackend))
+	}
+	if options.DisableNetworkPolicy {
+		extraArgs = append(extraArgs, "--disable-network-policy")
+	}
+	if len(options.ClusterCIDR) > 0 {
+		extraArgs = append(extraArgs, fmt.Sprintf("--cluster-cidr %s", options.ClusterCIDR))
+	}
+	if len(options.ServiceCIDR) > 0 {
+		extraArgs = append(extraArgs, fmt.Sprintf("--service-cidr %s", options.ServiceCIDR))
+	}
+	if len(options.DataDir) > 0 {
+		extraArgs = append(extraArgs, fmt.Sprintf("--data-dir %s", options.DataDir))
+	}
+	if options.SecretsEncryption {
+		extraArgs = append(extraArgs, "--secrets-encryption")
+	}
+	if options.ProtectKernelDefaults {
+		extraArgs = append(extraArgs, "--protect-kernel-defaults")
+	}
+	if options.SELinux {
+		extraArgs = append(extraArgs, "--selinux")
+	}
+	if options.customAPIPort() {
+		extraArgs = append(extraArgs, fmt.Sprintf("--https-listen-port %d", options.APIPort))
+	}
+	if len(options.NodeIP) > 0 {
+		extraArgs = append(extraArgs, fmt.Sprintf("--node-ip %s", options.NodeIP))
+	}
+	if len(options.NodeName) > 0 {
+		extraArgs = append(extraArgs, fmt.Sprintf("--node-name %s", options.NodeName))
+	}
+
+	disableFlag := "--disable"
+	if !supportsDisable(options.K3sVersion) {
+		disableFlag = "--no-deploy"
+	}
+	for _, component := range disabledComponents(options.NoExtras, options.Disable) {
+		extraArgs = append(extraArgs, fmt.Sprintf("%s %s", disableFlag, component))
+	}
+
+	extraArgs = append(extraArgs, VPNArgs(options.VPNAuth, options.NodeExternalIP)...)
+	extraArgs = append(extraArgs, NodeLabelArgs(options.NodeLabels, options.NodeTaints)...)
+	extraArgs = append(extraArgs, ComponentArgs(options.KubeletArgs, options.KubeAPIServerArgs)...)
+
+	extraArgs = append(extraArgs, options.ExtraArgs)
+	extraArgsCmdline := ""
+	for _, a := range extraArgs {
+		extraArgsCmdline += a + " "
+	}
+
+	installExec := "INSTALL_K3S_EXEC='server"
+	if cluster {
+		installExec += " --cluster-init"
+	}
+	if len(options.Server) > 0 {
+		installExec += fmt.Sprintf(" --server %s", options.Server)
+	}
+	if len(tlsSANs) == 0 {
+		tlsSANs = []string{ip.String()}
+	}
+	for _, san := range tlsSANs {
+		installExec += fmt.Sprintf(" --tls-san %s", san)
+	}
+
+	if trimmed := strings.TrimSpace(extraArgsCmdline); len(trimmed) > 0 {
+		installExec += fmt.Sprintf(" %s", trimmed)
+	}
+
+	installExec += "'"
+
+	return installExec, nil
+}
+
+// customAPIPort reports whether k3s needs --https-listen-port.
+func (o ExecOptions) customAPIPort() bool {
+	return o.APIPort > 0 && o.APIPort != DefaultAPIPort
+}
+
+// validateInstallExec rejects options which k3s would refuse to start with,
+// or which set the same k3s flag twice.
+func validateInstallExec(cluster bool, options ExecOptions) error {
+	if cluster && len(options.Datastore) > 0 {
+		return fmt.Errorf("--cluster and --datastore cannot be used together, use --cluster for embedded etcd or --datastore for an external datastore")
+	}
+
+	conflicts := []struct {
+		set     bool
+		flag    string
+		k3sFlag string
+	}{
+		{cluster, "--cluster", "--datastore-endpoint"},
+		{len(options.Datastore) > 0, "--datastore", "--datastore-endpoint"},
+		{len(options.Datastore) > 0, "--datastore", "--cluster-init"},
+		{cluster, "--cluster", "--server"},
+		{len(options.Server) > 0, "--server", "--server"},
+		{len(options.Server) > 0, "--server", "--cluster-init"},
+		{len(options.FlannelBackend) > 0, "--flannel-backend", "--flannel-backend"},
+		{options.DisableNetworkPolicy, "--no-cni", "--disable-network-policy"},
+		{len(options.ClusterCIDR) > 0, "--cluster-cidr", "--cluster-cidr"},
+		{len(options.ServiceCIDR) > 0, "--service-cidr", "--service-cidr"},
+		{len(options.DataDir) > 0, "--data-dir", "--data-dir"},
+		{options.SecretsEncryption, "--secrets-encryption", "--secrets-encryption"},
+		{options.ProtectKernelDefaults, "--protect-kernel-defaults", "--protect-kernel-defaults"},
+		{options.SELinux, "--selinux", "--selinux"},
+		{options.customAPIPort(), "--api-port", "--https-listen-port"},
+		{len(options.NodeExternalIP) > 0, "--node-external-ip", "--node-external-ip"},
+		{len(options.NodeIP) > 0, "--node-ip", "--node-ip"},
+		{len(options.NodeName) > 0, "--node-name", "--node-name"},
+		{len(options.VPNAuth) > 0, "--vpn-auth", "--vpn-auth"},
+	}
+	for _, conflict := range conflicts {
+		if conflict.set && HasK3sArg(options.ExtraArgs, conflict.k3sFlag) {
+			return fmt.Errorf("%s cannot be used together with %s in --k3s-extra-args", conflict.flag, conflict.k3sFlag)
+		}
+		if conflict.set && HasK3sArg(strings.Join(options.ServerArgs, " "), conflict.k3sFlag) {
+			return fmt.Errorf("%s cannot be used together with %s in --server-arg", conflict.flag, conflict.k3sFlag)
+		}
+	}
+
+	for _, flag := range []string{"--disable", "--no-deploy"} {
+		if !options.NoExtras || !HasK3sArg(options.ExtraArgs, flag) {
+			continue
+		}
+		for _, component := range []string{"servicelb", "traefik"} {
+			if hasK3sArgValue(options.ExtraArgs, flag, component) {
+				return fmt.Errorf("--no-extras already disables %s, remove %s %s from --k3s-extra-args", component, flag, component)
+			}
+		}
+	}
+
+	return nil
+}
+
+// QuoteK3sArgs quotes each of args for sh and joins them with spaces.
+func QuoteK3sArgs(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, operator.ShellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// InstallerShell runs the installer script from stdin, with args as the
+// arguments of k3s. The installer adds them after INSTALL_K3S_EXEC and
+// quotes them again in the systemd unit, whereas INSTALL_K3S_EXEC is split
+// on spaces, which is why --server-arg does not go into it.
+func InstallerShell(args []string) string {
+	if len(args) == 0 {
+		return "sh -"
+	}
+	return "sh -s - " + QuoteK3sArgs(args)
+}
+
+// HasK3sArg reports whether flag is given in args, as "--flag value" or
+// "--flag=value".
+func HasK3sArg(args, flag string) bool {
+	for _, field := range strings.Fields(args) {
+		if field == flag || strings.HasPrefix(field, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasK3sArgValue reports whether flag is given in args with value, alone
+// or in a comma separated list, as k3s accepts for --disable.
+func hasK3sArgValue(args, flag, value string) bool {
+	fields := strings.Fields(args)
+	for i, field := range fields {
+		given := ""
+		switch {
+		case strings.HasPrefix(field, flag+"="):
+			given = strings.TrimPrefix(field, flag+"=")
+		case field == flag && i+1 < len(fields):
+			given = fields[i+1]
+		}
+		for _, v := range strings.Split(given, ",") {
+			if v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// disabledComponents returns the components given by --disable, after
+// servicelb and traefik for --no-extras, without duplicates.
+func disabledComponents(noExtras bool, disable []string) []string {
+	components := []string{}
+	if noExtras {
+		components = append(components, "servicelb", "traefik")
+	}
+	components = append(components, disable...)
+
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, component := range components {
+		component = strings.TrimSpace(component)
+		if len(component) == 0 || seen[component] {
+			continue
+		}
+		seen[component] = true
+		unique = append(unique, component)
+	}
+	return unique
+}
+
+// supportsDisable reports whether the k3s version or channel accepts
+// --disable, which replaced --no-deploy in v1.17. Channels such as stable
+// and latest, and versions which cannot be parsed, are assumed to be new.
+func supportsDisable(k3sVersion string) bool {
+	parts := strings.SplitN(strings.TrimPrefix(k3sVersion, "v"), ".", 3)
+	if len(parts) < 2 {
+		return true
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return true
+	}
+	minorDigits := parts[1]
+	if i := strings.IndexFunc(minorDigits, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minorDigits = minorDigits[:i]
+	}
+	minor, err := strconv.Atoi(minorDigits)
+	if err != nil {
+		return true
+	}
+
+	return major > 1 || (major == 1 && minor >= 17)
+}
+
+// TokenEnv sets K3S_TOKEN for the installer when a token is given.
+func TokenEnv(token string) string {
+	if len(token) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("K3S_TOKEN='%s' ", token)
+}
+
+// VPNArgs are the k3s flags of --vpn-auth and --node-external-ip.
+func VPNArgs(vpnAuth, nodeExternalIP string) []string {
+	args := []string{}
+	if len(nodeExternalIP) > 0 {
+		args = append(args, fmt.Sprintf("--node-external-ip %s", nodeExternalIP))
+	}
+	if len(vpnAuth) > 0 {
+		args = append(args, fmt.Sprintf("--vpn-auth %s", vpnAuth))
+	}
+	return args
+}
+
+// ComponentArgs passes arguments on to the kubelet and the API server.
+func ComponentArgs(kubeletArgs, kubeAPIServerArgs []string) []string {
+	args := []string{}
+	for _, arg := range kubeletArgs {
+		args = append(args, fmt.Sprintf("--kubelet-arg=%s", arg))
+	}
+	for _, arg := range kubeAPIServerArgs {
+		args = append(args, fmt.Sprintf("--kube-apiserver-arg=%s", arg))
+	}
+	return args
+}
+
+// NodeLabelArgs are the k3s flags which label and taint the node.
+func NodeLabelArgs(labels, taints []string) []string {
+	args := []string{}
+	for _, label := range labels {
+		args = append(args, fmt.Sprintf("--node-label %s", label))
+	}
+	for _, taint := range taints {
+		args = append(args, fmt.Sprintf("--node-taint %s", taint))
+	}
+	return args
+}
+
+// DetectVersionCommand prints the version of k3s and the state of its
+// service, or nothing when k3s is not installed.
+const DetectVersionCommand = "if command -v k3s > /dev/null 2>&1; then k3s --version | head -n 1; systemctl is-active k3s || true; fi"
+
+// ParseK3sVersion reads the output of DetectVersionCommand, where the
+// first line is i.e. "k3s version v1.19.1+k3s1 (b66760fc)".
+func ParseK3sVersion(output string) (version, status string) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(lines[0])
+	if len(fields) < 3 || fields[0] != "k3s" || fields[1] != "version" {
+		return "", ""
+	}
+	if len(lines) > 1 {
+		status = strings.TrimSpace(lines[1])
+	}
+	return fields[2], status
+}
+
+// VersionEnv returns the environment for the installer to download
+// k3sVersion, or else the latest release of k3sChannel.
+func VersionEnv(k3sVersion, k3sChannel, channelURL string) string {
+	installStr := ""
+	if len(k3sVersion) > 0 {
+		installStr = fmt.Sprintf("INSTALL_K3S_VERSION='%s'", k3sVersion)
+	} else {
+		installStr = fmt.Sprintf("INSTALL_K3S_CHANNEL='%s'", k3sChannel)
+		if len(channelURL) > 0 {
+			installStr = fmt.Sprintf("INSTALL_K3S_CHANNEL_URL='%s' %s", channelURL, installStr)
+		}
+	}
+	return installStr
+}
+
+// InstallEnv returns the environment for the installer to download the
+// build of k3sCommit, or else the version or channel.
+func InstallEnv(k3sCommit, k3sVersion, k3sChannel, channelURL string) string {
+	if len(k3sCommit) > 0 {
+		return fmt.Sprintf("INSTALL_K3S_COMMIT='%s'", k3sCommit)
+	}
+	return VersionEnv(k3sVersion, k3sChannel, channelURL)
+}
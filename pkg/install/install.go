@@ -0,0 +1,210 @@
+// Package install installs k3s on a server, as k3sup install does, for Go
+// programs which drive k3sup as a library rather than through its CLI.
+package install
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+// DefaultChannel is the channel whose latest release is installed when
+// neither a version nor a channel is given.
+const DefaultChannel = "v1.18"
+
+// DefaultScriptURL is where the installer script of k3s is downloaded from.
+const DefaultScriptURL = "https://get.k3s.io"
+
+// InstallOptions are the options of Install.
+type InstallOptions struct {
+	// Operator runs the commands on the node, i.e. the SSHOperator of
+	// operator.NewSSHOperatorFromClient. Install does not close it.
+	Operator operator.StreamingOperator
+
+	// IP is the address of the node, which the certificate of the API
+	// server and the kubeconfig are for.
+	IP net.IP
+	// TLSSANs are added to the certificate of the API server instead of IP.
+	TLSSANs []string
+	// Cluster starts a server with embedded etcd, which others can join.
+	Cluster bool
+	// Exec are the flags of k3s, as in INSTALL_K3S_EXEC.
+	Exec ExecOptions
+
+	// K3sVersion is the version of k3s to install, or else the latest
+	// release of K3sChannel, DefaultChannel when both are empty.
+	K3sVersion string
+	K3sChannel string
+	// Token is the token of the cluster, k3s generates one when empty.
+	Token string
+
+	// Sudo reads the kubeconfig with sudo, for a user other than root.
+	Sudo bool
+	// ScriptURL is the installer script of k3s, DefaultScriptURL when empty.
+	ScriptURL string
+
+	// Kubeconfig are the names in the kubeconfig, and its server when it
+	// should not be IP on the port of the API server.
+	Kubeconfig KubeconfigOptions
+	// KubeconfigHost is the host the kubeconfig points at, IP when empty.
+	KubeconfigHost string
+
+	// InstallCommand, when set, runs the installer instead of the command
+	// made of the options, i.e. for a script which is not downloaded.
+	InstallCommand string
+	// SkipInstall reads the kubeconfig of a server which is already
+	// installed without running the installer.
+	SkipInstall bool
+	// GetConfigCommand, when set, reads the kubeconfig instead of cat, and
+	// Kubeconfig.RemotePath is then read over SFTP only when set.
+	GetConfigCommand string
+
+	// BeforeKubeconfig, when set, is called once the installer has run and
+	// before the kubeconfig is read, i.e. to wait for the server to start.
+	// It may change the names of the kubeconfig.
+	BeforeKubeconfig func(kubeconfig *KubeconfigOptions) error
+
+	// Output receives the output of the installer, nothing when nil.
+	Output io.Writer
+	// ErrorOutput receives the stderr of the installer, Output when nil.
+	ErrorOutput io.Writer
+}
+
+// InstallResult is the server which Install installed.
+type InstallResult struct {
+	// Kubeconfig is the kubeconfig of the server, rewritten for IP.
+	Kubeconfig []byte
+	// K3sVersion is the version of k3s now running, i.e. v1.19.1+k3s1.
+	K3sVersion string
+	// InstallExec is the INSTALL_K3S_EXEC given to the installer.
+	InstallExec string
+	// Duration is how long the install took.
+	Duration time.Duration
+}
+
+// InstallerError is a run of the k3s installer which exited with a
+// non-zero status, so that k3s was not installed.
+type InstallerError struct {
+	IP         string
+	ExitStatus int
+	Err        error
+}
+
+func (e InstallerError) Error() string {
+	return fmt.Sprintf("the k3s installer exited with status %d on %s", e.ExitStatus, e.IP)
+}
+
+func (e InstallerError) Unwrap() error {
+	return e.Err
+}
+
+// NewInstallerError returns an InstallerError for the error of the
+// installer, or err itself when the installer did not exit by itself, i.e.
+// the connection was lost or it timed out.
+func NewInstallerError(err error, ip string) error {
+	status := operator.ExitStatus(err)
+	if err == nil || status < 0 {
+		return err
+	}
+	return InstallerError{IP: ip, ExitStatus: status, Err: err}
+}
+
+// Install runs the installer of k3s on the node of options.Operator and
+// returns the kubeconfig of the new server. Unlike k3sup install it does
+// not check whether k3s is already installed, see SkipInstall, and leaves
+// saving or merging the kubeconfig to the caller.
+func Install(options InstallOptions) (InstallResult, error) {
+	start := time.Now()
+	if options.Operator == nil {
+		return InstallResult{}, fmt.Errorf("give an operator to run the install with")
+	}
+	if options.IP == nil {
+		return InstallResult{}, fmt.Errorf("give the IP of the node for its certificate and kubeconfig")
+	}
+
+	channel := options.K3sChannel
+	if len(options.K3sVersion) == 0 && len(channel) == 0 {
+		channel = DefaultChannel
+	}
+	exec := options.Exec
+	if len(exec.K3sVersion) == 0 {
+		exec.K3sVersion = options.K3sVersion
+		if len(exec.K3sVersion) == 0 {
+			exec.K3sVersion = channel
+		}
+	}
+
+	installExec, err := MakeInstallExec(options.Cluster, options.IP, options.TLSSANs, exec)
+	if err != nil {
+		return InstallResult{}, err
+	}
+
+	scriptURL := options.ScriptURL
+	if len(scriptURL) == 0 {
+		scriptURL = DefaultScriptURL
+	}
+	installK3sCommand := options.InstallCommand
+	if len(installK3sCommand) == 0 {
+		installK3sCommand = fmt.Sprintf("curl -sfL %s | %s%s %s %s\n",
+			operator.ShellQuote(scriptURL), TokenEnv(options.Token), installExec,
+			VersionEnv(options.K3sVersion, channel, ""), InstallerShell(exec.ServerArgs))
+	}
+
+	output := options.Output
+	if output == nil {
+		output = ioutil.Discard
+	}
+	errorOutput := options.ErrorOutput
+	if errorOutput == nil {
+		errorOutput = output
+	}
+	if !options.SkipInstall {
+		if _, err := options.Operator.ExecuteStreaming(installK3sCommand, output, errorOutput); err != nil {
+			if installerErr := NewInstallerError(err, options.IP.String()); installerErr != err {
+				return InstallResult{}, installerErr
+			}
+			return InstallResult{}, fmt.Errorf("unable to run the installer of k3s on %s: %w", options.IP, err)
+		}
+	}
+
+	kubeconfigOptions := options.Kubeconfig
+	if options.BeforeKubeconfig != nil {
+		if err := options.BeforeKubeconfig(&kubeconfigOptions); err != nil {
+			return InstallResult{}, err
+		}
+	}
+
+	result := InstallResult{InstallExec: installExec}
+	res, err := options.Operator.ExecuteStreaming(DetectVersionCommand, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return InstallResult{}, fmt.Errorf("unable to detect the version of k3s on %s: %w", options.IP, err)
+	}
+	result.K3sVersion, _ = ParseK3sVersion(string(res.StdOut))
+
+	getConfigCommand := options.GetConfigCommand
+	if len(getConfigCommand) == 0 {
+		sudoPrefix := ""
+		if options.Sudo {
+			sudoPrefix = "sudo "
+		}
+		getConfigCommand = sudoPrefix + "cat " + KubeconfigPath + "\n"
+		if len(kubeconfigOptions.RemotePath) == 0 && !options.Sudo {
+			kubeconfigOptions.RemotePath = KubeconfigPath
+		}
+	}
+	kubeconfigHost := options.KubeconfigHost
+	if len(kubeconfigHost) == 0 {
+		kubeconfigHost = options.IP.String()
+	}
+	result.Kubeconfig, err = ObtainKubeconfig(options.Operator, getConfigCommand, kubeconfigHost, kubeconfigOptions)
+	if err != nil {
+		return InstallResult{}, err
+	}
+
+	result.Duration = time.Since(start)
+	return result, nil
+}
@@ -0,0 +1,181 @@
+package install
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+func Test_Install(t *testing.T) {
+	node := &sftpNode{
+		FakeOperator: operator.FakeOperator{Replies: map[string]operator.CommandRes{
+			DetectVersionCommand: {StdOut: []byte("k3s version v1.18.9+k3s1 (630bebf9)\nactive\n")},
+		}},
+		files: map[string]string{KubeconfigPath: kubeconfigExample},
+	}
+	output := &bytes.Buffer{}
+
+	result, err := Install(InstallOptions{
+		Operator:   node,
+		IP:         net.ParseIP("192.168.0.100"),
+		Exec:       ExecOptions{NoExtras: true},
+		Token:      "secret",
+		Kubeconfig: KubeconfigOptions{Context: "edge"},
+		Output:     output,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := "curl -sfL 'https://get.k3s.io' | K3S_TOKEN='secret' INSTALL_K3S_EXEC='server --tls-san 192.168.0.100 --disable servicelb --disable traefik' INSTALL_K3S_CHANNEL='v1.18' sh -\n"
+	commands := node.Commands()
+	if len(commands) != 2 || commands[0] != want || commands[1] != DetectVersionCommand {
+		t.Fatalf("want the installer and the version, got: %q", commands)
+	}
+	if result.InstallExec != "INSTALL_K3S_EXEC='server --tls-san 192.168.0.100 --disable servicelb --disable traefik'" {
+		t.Errorf("unexpected INSTALL_K3S_EXEC: %q", result.InstallExec)
+	}
+	if result.K3sVersion != "v1.18.9+k3s1" {
+		t.Errorf("want version v1.18.9+k3s1, got %q", result.K3sVersion)
+	}
+
+	kubeconfig := string(result.Kubeconfig)
+	if !strings.Contains(kubeconfig, "server: https://192.168.0.100:6443") || !strings.Contains(kubeconfig, "name: edge") {
+		t.Errorf("want the kubeconfig rewritten for the node, got:\n%s", kubeconfig)
+	}
+	if len(node.reads) != 1 {
+		t.Errorf("want the kubeconfig read over SFTP, got reads: %q", node.reads)
+	}
+}
+
+func Test_Install_Sudo(t *testing.T) {
+	node := &sftpNode{
+		FakeOperator: operator.FakeOperator{Replies: map[string]operator.CommandRes{
+			"sudo cat /etc/rancher/k3s/k3s.yaml\n": {StdOut: []byte(kubeconfigExample)},
+		}},
+	}
+
+	result, err := Install(InstallOptions{
+		Operator:   node,
+		IP:         net.ParseIP("192.168.0.100"),
+		K3sVersion: "v1.19.1+k3s1",
+		Sudo:       true,
+		ScriptURL:  "https://mirror.example.com/k3s/install.sh",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	commands := node.Commands()
+	if len(commands) != 3 || !strings.HasPrefix(commands[0], "curl -sfL 'https://mirror.example.com/k3s/install.sh' | ") ||
+		!strings.Contains(commands[0], "INSTALL_K3S_VERSION='v1.19.1+k3s1'") {
+		t.Fatalf("want the installer of the mirror for the version, got: %q", commands)
+	}
+	if len(node.reads) != 0 {
+		t.Errorf("want the kubeconfig read with sudo rather than SFTP, got reads: %q", node.reads)
+	}
+	if !strings.Contains(string(result.Kubeconfig), "server: https://192.168.0.100:6443") {
+		t.Errorf("want the kubeconfig of the node, got:\n%s", result.Kubeconfig)
+	}
+}
+
+func Test_Install_InstallerFails(t *testing.T) {
+	node := &operator.FakeOperator{Reply: func(command string) (operator.CommandRes, error) {
+		return operator.CommandRes{}, errors.New("connection lost")
+	}}
+
+	_, err := Install(InstallOptions{Operator: node, IP: net.ParseIP("192.168.0.100")})
+	if err == nil || !strings.Contains(err.Error(), "unable to run the installer of k3s on 192.168.0.100: connection lost") {
+		t.Fatalf("want the error of the installer, got: %v", err)
+	}
+	if len(node.Commands()) != 1 {
+		t.Errorf("want nothing run after the installer failed, got: %q", node.Commands())
+	}
+}
+
+func Test_Install_SkipInstall(t *testing.T) {
+	node := &sftpNode{
+		FakeOperator: operator.FakeOperator{Replies: map[string]operator.CommandRes{
+			DetectVersionCommand:                     {StdOut: []byte("k3s version v1.19.1+k3s1 (b66760fc)\nactive\n")},
+			"prefix cat /etc/rancher/k3s/k3s.yaml\n": {StdOut: []byte(kubeconfigExample)},
+		}},
+	}
+
+	hooked := false
+	result, err := Install(InstallOptions{
+		Operator:         node,
+		IP:               net.ParseIP("192.168.0.100"),
+		KubeconfigHost:   "k3s.example.com",
+		SkipInstall:      true,
+		GetConfigCommand: "prefix cat /etc/rancher/k3s/k3s.yaml\n",
+		BeforeKubeconfig: func(kubeconfig *KubeconfigOptions) error {
+			hooked = true
+			kubeconfig.Context = "edge"
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{DetectVersionCommand, "prefix cat /etc/rancher/k3s/k3s.yaml\n"}
+	if commands := node.Commands(); !reflect.DeepEqual(commands, want) {
+		t.Fatalf("want: %q, got: %q", want, commands)
+	}
+	if !hooked {
+		t.Errorf("want BeforeKubeconfig called")
+	}
+	if len(node.reads) != 0 {
+		t.Errorf("want no SFTP without a remote path, got reads: %q", node.reads)
+	}
+	if result.K3sVersion != "v1.19.1+k3s1" {
+		t.Errorf("want version v1.19.1+k3s1, got %q", result.K3sVersion)
+	}
+	kubeconfig := string(result.Kubeconfig)
+	if !strings.Contains(kubeconfig, "server: https://k3s.example.com:6443") || !strings.Contains(kubeconfig, "name: edge") {
+		t.Errorf("want the kubeconfig for the host and context of the hook, got:\n%s", kubeconfig)
+	}
+}
+
+func Test_Install_BeforeKubeconfigFails(t *testing.T) {
+	node := &operator.FakeOperator{}
+
+	_, err := Install(InstallOptions{
+		Operator:       node,
+		IP:             net.ParseIP("192.168.0.100"),
+		InstallCommand: "sh install.sh\n",
+		BeforeKubeconfig: func(*KubeconfigOptions) error {
+			return errors.New("k3s did not start")
+		},
+	})
+	if err == nil || err.Error() != "k3s did not start" {
+		t.Fatalf("want the error of the hook, got: %v", err)
+	}
+	if commands := node.Commands(); !reflect.DeepEqual(commands, []string{"sh install.sh\n"}) {
+		t.Errorf("want only the installer of InstallCommand run, got: %q", commands)
+	}
+}
+
+func Test_Install_InvalidOptions(t *testing.T) {
+	cases := []struct {
+		name    string
+		options InstallOptions
+		want    string
+	}{
+		{name: "no operator", options: InstallOptions{IP: net.ParseIP("192.168.0.100")}, want: "give an operator"},
+		{name: "no IP", options: InstallOptions{Operator: &operator.FakeOperator{}}, want: "give the IP"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Install(tc.options); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("want an error containing %q, got: %v", tc.want, err)
+			}
+		})
+	}
+}
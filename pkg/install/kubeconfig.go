@@ -0,0 +1,285 @@
+package install
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// KubeconfigFile is the subset of a kubeconfig that k3sup needs to read
+// and rewrite, unknown keys are retained in Extra so that they survive
+// a round-trip.
+type KubeconfigFile struct {
+	APIVersion     string                 `yaml:"apiVersion"`
+	Clusters       []KubeconfigCluster    `yaml:"clusters"`
+	Contexts       []KubeconfigContext    `yaml:"contexts"`
+	CurrentContext string                 `yaml:"current-context"`
+	Kind           string                 `yaml:"kind"`
+	Preferences    map[string]interface{} `yaml:"preferences"`
+	Users          []KubeconfigUser       `yaml:"users"`
+	Extra          map[string]interface{} `yaml:",inline"`
+}
+
+// KubeconfigCluster is an entry of the clusters of a kubeconfig.
+type KubeconfigCluster struct {
+	Name    string                 `yaml:"name"`
+	Cluster map[string]interface{} `yaml:"cluster"`
+}
+
+// KubeconfigContext is an entry of the contexts of a kubeconfig.
+type KubeconfigContext struct {
+	Name    string                 `yaml:"name"`
+	Context map[string]interface{} `yaml:"context"`
+}
+
+// KubeconfigUser is an entry of the users of a kubeconfig.
+type KubeconfigUser struct {
+	Name string                 `yaml:"name"`
+	User map[string]interface{} `yaml:"user"`
+}
+
+// ParseKubeconfig reads the YAML of a kubeconfig.
+func ParseKubeconfig(data []byte) (*KubeconfigFile, error) {
+	config := &KubeconfigFile{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("unable to parse kubeconfig: %s", err)
+	}
+	return config, nil
+}
+
+// KubeconfigName is the name k3s gives to the cluster, context and user
+// of the kubeconfig it writes to /etc/rancher/k3s/k3s.yaml.
+const KubeconfigName = "default"
+
+// KubeconfigPath is where k3s writes the kubeconfig of a server, whatever
+// its --data-dir.
+const KubeconfigPath = "/etc/rancher/k3s/k3s.yaml"
+
+// RewriteKubeconfig points the server URL of the kubeconfig written by k3s
+// at ip, and renames its "default" context to context, its "default"
+// cluster to clusterName and its "default" user to userName. The cluster
+// and user default to context. Any other values, such as certificate data,
+// are left untouched.
+func RewriteKubeconfig(kubeconfig string, ip string, context string, clusterName string, userName string) ([]byte, error) {
+	if context == "" {
+		context = KubeconfigName
+	}
+	if clusterName == "" {
+		clusterName = context
+	}
+	if userName == "" {
+		userName = context
+	}
+
+	config, err := ParseKubeconfig([]byte(kubeconfig))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cluster := range config.Clusters {
+		if server, ok := cluster.Cluster["server"].(string); ok {
+			rewritten, err := rewriteServerHost(server, ip)
+			if err != nil {
+				return nil, err
+			}
+			cluster.Cluster["server"] = rewritten
+		}
+	}
+
+	for i := range config.Clusters {
+		if config.Clusters[i].Name == KubeconfigName {
+			config.Clusters[i].Name = clusterName
+		}
+	}
+
+	for i := range config.Users {
+		if config.Users[i].Name == KubeconfigName {
+			config.Users[i].Name = userName
+		}
+	}
+
+	for i := range config.Contexts {
+		c := &config.Contexts[i]
+		if c.Name == KubeconfigName {
+			c.Name = context
+		}
+		if c.Context == nil {
+			continue
+		}
+		if c.Context["cluster"] == KubeconfigName {
+			c.Context["cluster"] = clusterName
+		}
+		if c.Context["user"] == KubeconfigName {
+			c.Context["user"] = userName
+		}
+	}
+
+	if config.CurrentContext == KubeconfigName {
+		config.CurrentContext = context
+	}
+
+	return yaml.Marshal(config)
+}
+
+// rewriteServerHost replaces a loopback host in the server URL with ip,
+// keeping the scheme and port.
+func rewriteServerHost(server, ip string) (string, error) {
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse server URL %q: %s", server, err)
+	}
+
+	host := serverURL.Hostname()
+	if host != "127.0.0.1" && host != "localhost" {
+		return server, nil
+	}
+
+	if port := serverURL.Port(); len(port) > 0 {
+		serverURL.Host = net.JoinHostPort(ip, port)
+	} else if strings.Contains(ip, ":") {
+		serverURL.Host = "[" + ip + "]"
+	} else {
+		serverURL.Host = ip
+	}
+	return serverURL.String(), nil
+}
+
+// SetKubeconfigServerPort replaces the port of the server URL of every
+// cluster, keeping the host as it is.
+func SetKubeconfigServerPort(data []byte, port int) ([]byte, error) {
+	config, err := ParseKubeconfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cluster := range config.Clusters {
+		server, ok := cluster.Cluster["server"].(string)
+		if !ok {
+			continue
+		}
+
+		serverURL, err := url.Parse(server)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse server URL %q: %s", server, err)
+		}
+		serverURL.Host = net.JoinHostPort(serverURL.Hostname(), strconv.Itoa(port))
+		cluster.Cluster["server"] = serverURL.String()
+	}
+
+	return yaml.Marshal(config)
+}
+
+// SetKubeconfigServerURL replaces the server URL of every cluster, i.e.
+// with the URL of a load balancer in front of the servers.
+func SetKubeconfigServerURL(data []byte, serverURL string) ([]byte, error) {
+	config, err := ParseKubeconfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cluster := range config.Clusters {
+		if _, ok := cluster.Cluster["server"]; ok {
+			cluster.Cluster["server"] = serverURL
+		}
+	}
+
+	return yaml.Marshal(config)
+}
+
+// KubeconfigOptions control how ObtainKubeconfig reads and rewrites the
+// kubeconfig of a server.
+type KubeconfigOptions struct {
+	// Context names the context, KubeconfigName when empty. ClusterName and
+	// UserName name the cluster and the user, Context when empty.
+	Context     string
+	ClusterName string
+	UserName    string
+	// ServerPort replaces the port of the server URL when set.
+	ServerPort int
+	// ServerURL replaces the whole server URL when set.
+	ServerURL string
+	// RemotePath is read over SFTP when set and the operator can, before
+	// falling back to the command given to ObtainKubeconfig.
+	RemotePath string
+	// Debugf, when set, is told how the kubeconfig was read, but never
+	// what it holds.
+	Debugf func(format string, a ...interface{})
+}
+
+func (o KubeconfigOptions) debugf(format string, a ...interface{}) {
+	if o.Debugf != nil {
+		o.Debugf(format, a...)
+	}
+}
+
+// ObtainKubeconfig reads the kubeconfig of the server with
+// getConfigCommand, i.e. "sudo cat /etc/rancher/k3s/k3s.yaml", and points
+// it at ip with the names and server of options. With options.RemotePath it
+// is read over SFTP first, see readKubeconfig. SFTP does not use sudo, and
+// k3s writes the kubeconfig for root only unless --write-kubeconfig-mode is
+// given, so for any other user it always falls back to getConfigCommand.
+func ObtainKubeconfig(op operator.CommandOperator, getConfigCommand, ip string, options KubeconfigOptions) ([]byte, error) {
+	res, err := readKubeconfig(op, getConfigCommand, options)
+	if err != nil {
+		if stderr := strings.TrimSpace(string(res.StdErr)); len(stderr) > 0 {
+			return nil, fmt.Errorf("error received reading the kubeconfig: %w\nstderr: %s", err, stderr)
+		}
+		return nil, fmt.Errorf("error received reading the kubeconfig: %w", err)
+	}
+
+	// The kubeconfig holds the credentials of the cluster, so only its
+	// size is given.
+	options.debugf("Fetched a kubeconfig of %d bytes\n", len(res.StdOut))
+	if len(res.StdErr) > 0 {
+		options.debugf("Kubeconfig stderr: %q\n", res.StdErr)
+	}
+
+	kubeconfig, err := RewriteKubeconfig(string(res.StdOut), ip, options.Context, options.ClusterName, options.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.ServerPort > 0 {
+		kubeconfig, err = SetKubeconfigServerPort(kubeconfig, options.ServerPort)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(options.ServerURL) > 0 {
+		kubeconfig, err = SetKubeconfigServerURL(kubeconfig, options.ServerURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return kubeconfig, nil
+}
+
+// fileReader is an operator which can read a file of the node without
+// running a command, see operator.SSHOperator.ReadFile.
+type fileReader interface {
+	ReadFile(remotePath string) ([]byte, error)
+}
+
+// readKubeconfig reads the kubeconfig at options.RemotePath over SFTP when
+// it is set and the operator can, so that a login banner or a warning
+// printed by the shell cannot end up in it, or else runs getConfigCommand.
+// A user other than root may not read the file without sudo, so any
+// failure of SFTP falls back to getConfigCommand.
+func readKubeconfig(op operator.CommandOperator, getConfigCommand string, options KubeconfigOptions) (operator.CommandRes, error) {
+	remotePath := options.RemotePath
+	if reader, ok := op.(fileReader); ok && len(remotePath) > 0 {
+		data, err := reader.ReadFile(remotePath)
+		if err == nil {
+			options.debugf("Read %s over SFTP\n", remotePath)
+			return operator.CommandRes{StdOut: data}, nil
+		}
+		options.debugf("Unable to read %s over SFTP, falling back to cat: %s\n", remotePath, err)
+	}
+	return op.Execute(getConfigCommand)
+}
@@ -0,0 +1,351 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	operator "github.com/alexellis/k3sup/pkg/operator"
+)
+
+const kubeconfigExample = `
+apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: DATA+OMITTED
+    server: https://localhost:6443
+  name: default
+contexts:
+- context:
+    cluster: default
+    user: default
+  name: default
+current-context: default
+kind: Config
+preferences: {}
+users:
+- name: default
+  user:
+    password: 5ceb3a3e93621d265fd147929f3ace84
+    username: admin
+`
+
+func Test_RewriteKubeconfig(t *testing.T) {
+	var ip = "192.168.0.25"
+	var context = "context-test"
+
+	// Test master ip rewrite
+	kubeconfig, err := RewriteKubeconfig(kubeconfigExample, ip, context, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	re := regexp.MustCompile(`server:\s?https://(.*):\d+`)
+	group := re.FindSubmatch(kubeconfig)
+
+	if len(group) == 0 || string(group[1]) != ip {
+		t.Fatalf("unexpected error, got: %q, want: %q.", string(group[1]), ip)
+	}
+
+	kubeconfigExampleIPLocal := strings.Replace(kubeconfigExample, "localhost", "127.0.0.1", -1)
+	kubeconfig, err = RewriteKubeconfig(kubeconfigExampleIPLocal, ip, context, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group = re.FindSubmatch(kubeconfig)
+	if len(group) == 0 || string(group[1]) != ip {
+		t.Fatalf("unexpected error, got: %q, want: %q.", string(group[1]), ip)
+	}
+
+	// Test context
+	re = regexp.MustCompile(`default`)
+	expectedContextsToReplace := re.FindAllStringIndex(kubeconfigExample, -1)
+
+	kubeconfig, err = RewriteKubeconfig(kubeconfigExample, ip, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := re.FindAllIndex(kubeconfig, -1)
+
+	if len(match) != len(expectedContextsToReplace) {
+		t.Fatalf("unexpected error, got: %q, want: %q.", len(match), len(expectedContextsToReplace))
+	}
+
+	kubeconfig, err = RewriteKubeconfig(kubeconfigExample, ip, context, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	re = regexp.MustCompile(`context-test`)
+	match = re.FindAllIndex(kubeconfig, -1)
+
+	if len(match) != len(expectedContextsToReplace) {
+		t.Fatalf("unexpected error, got: %q, want: %q.", len(match), len(expectedContextsToReplace))
+	}
+}
+
+func Test_SetKubeconfigServerPort(t *testing.T) {
+	rewritten, err := RewriteKubeconfig(kubeconfigExample, "127.0.0.1", "default", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := SetKubeconfigServerPort(rewritten, 16443)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(string(got), "server: https://127.0.0.1:16443") {
+		t.Errorf("want server port to be 16443, got:\n%s", got)
+	}
+}
+
+func Test_SetKubeconfigServerURL(t *testing.T) {
+	rewritten, err := RewriteKubeconfig(kubeconfigExample, "192.168.0.100", "default", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := SetKubeconfigServerURL(rewritten, "https://k3s.example.com:443")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(string(got), "server: https://k3s.example.com:443") {
+		t.Errorf("want the server URL of the load balancer, got:\n%s", got)
+	}
+}
+
+func Test_RewriteKubeconfig_KeepsCertData(t *testing.T) {
+	// "ZGVmYXVsdA==" is base64 for "default", and must not be rewritten.
+	config := `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: ZGVmYXVsdA==
+    server: https://127.0.0.1:6443
+  name: default
+contexts:
+- context:
+    cluster: default
+    namespace: default
+    user: default
+  name: default
+current-context: default
+kind: Config
+preferences: {}
+users:
+- name: default
+  user:
+    client-certificate-data: c2VjcmV0LWRlZmF1bHQtZGF0YQ==
+    client-key-data: ZGVmYXVsdGRlZmF1bHQ=
+`
+
+	got, err := RewriteKubeconfig(config, "192.168.0.25", "prod", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	parsed, err := ParseKubeconfig(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if ca := parsed.Clusters[0].Cluster["certificate-authority-data"]; ca != "ZGVmYXVsdA==" {
+		t.Errorf("want certificate-authority-data untouched, got: %v", ca)
+	}
+	if key := parsed.Users[0].User["client-key-data"]; key != "ZGVmYXVsdGRlZmF1bHQ=" {
+		t.Errorf("want client-key-data untouched, got: %v", key)
+	}
+	if cert := parsed.Users[0].User["client-certificate-data"]; cert != "c2VjcmV0LWRlZmF1bHQtZGF0YQ==" {
+		t.Errorf("want client-certificate-data untouched, got: %v", cert)
+	}
+	if ns := parsed.Contexts[0].Context["namespace"]; ns != "default" {
+		t.Errorf("want namespace untouched, got: %v", ns)
+	}
+	if server := parsed.Clusters[0].Cluster["server"]; server != "https://192.168.0.25:6443" {
+		t.Errorf("want server rewritten, got: %v", server)
+	}
+
+	if parsed.Clusters[0].Name != "prod" || parsed.Users[0].Name != "prod" || parsed.Contexts[0].Name != "prod" {
+		t.Errorf("want cluster, user and context named prod, got:\n%s", got)
+	}
+	if parsed.Contexts[0].Context["cluster"] != "prod" || parsed.Contexts[0].Context["user"] != "prod" {
+		t.Errorf("want context to reference prod, got:\n%s", got)
+	}
+	if parsed.CurrentContext != "prod" {
+		t.Errorf("want current-context prod, got: %s", parsed.CurrentContext)
+	}
+}
+
+func Test_RewriteKubeconfig_IPv6(t *testing.T) {
+	cases := map[string]string{
+		"::1":          "server: https://[::1]:6443",
+		"fe80::1":      "server: https://[fe80::1]:6443",
+		"2001:db8::10": "server: https://[2001:db8::10]:6443",
+	}
+
+	for ip, want := range cases {
+		got, err := RewriteKubeconfig(kubeconfigExample, ip, "default", "", "")
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", ip, err)
+		}
+		if !strings.Contains(string(got), want) {
+			t.Errorf("want %q for %s, got:\n%s", want, ip, got)
+		}
+	}
+}
+
+func Test_RewriteKubeconfig_ClusterName(t *testing.T) {
+	got, err := RewriteKubeconfig(kubeconfigExample, "192.168.0.25", "prod", "edge", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	parsed, err := ParseKubeconfig(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if parsed.Clusters[0].Name != "edge" || parsed.Contexts[0].Context["cluster"] != "edge" {
+		t.Errorf("want cluster named edge, got:\n%s", got)
+	}
+	if parsed.Users[0].Name != "prod" || parsed.Contexts[0].Name != "prod" {
+		t.Errorf("want user and context named prod, got:\n%s", got)
+	}
+}
+
+func Test_RewriteKubeconfig_UserName(t *testing.T) {
+	got, err := RewriteKubeconfig(kubeconfigExample, "192.168.0.25", "prod", "prod-cluster", "prod-admin")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	parsed, err := ParseKubeconfig(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if parsed.Users[0].Name != "prod-admin" || parsed.Contexts[0].Context["user"] != "prod-admin" {
+		t.Errorf("want user named prod-admin, got:\n%s", got)
+	}
+	if parsed.Clusters[0].Name != "prod-cluster" || parsed.Contexts[0].Name != "prod" || parsed.CurrentContext != "prod" {
+		t.Errorf("want cluster prod-cluster and context prod, got:\n%s", got)
+	}
+}
+
+func Test_RewriteKubeconfig_RoundTrip(t *testing.T) {
+	got, err := RewriteKubeconfig(kubeconfigExample, "127.0.0.1", "", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want, err := ParseKubeconfig([]byte(strings.Replace(kubeconfigExample, "localhost", "127.0.0.1", 1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	parsed, err := ParseKubeconfig(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(want, parsed) {
+		t.Errorf("want: %v, got: %v", want, parsed)
+	}
+}
+
+func Test_RewriteKubeconfig_CRLF(t *testing.T) {
+	crlf := strings.Replace(kubeconfigExample, "\n", "\r\n", -1)
+
+	got, err := RewriteKubeconfig(crlf, "192.168.0.100", "edge", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(string(got), "\r") {
+		t.Errorf("want LF line endings in the written kubeconfig, got: %q", got)
+	}
+}
+
+// sftpNode is an operator.FakeOperator which also serves files over SFTP.
+type sftpNode struct {
+	operator.FakeOperator
+	files map[string]string
+	reads []string
+}
+
+func (s *sftpNode) ReadFile(remotePath string) ([]byte, error) {
+	s.reads = append(s.reads, remotePath)
+	content, ok := s.files[remotePath]
+	if !ok {
+		return nil, fmt.Errorf(`sftp: "Permission denied" (SSH_FX_PERMISSION_DENIED)`)
+	}
+	return []byte(content), nil
+}
+
+const getConfig = "sudo cat /etc/rancher/k3s/k3s.yaml\n"
+
+func Test_ObtainKubeconfig_SFTP(t *testing.T) {
+	banner := "Welcome to the node, authorized use only\n"
+	node := &sftpNode{
+		FakeOperator: operator.FakeOperator{Replies: map[string]operator.CommandRes{getConfig: {StdOut: []byte(banner + kubeconfigExample)}}},
+		files:        map[string]string{KubeconfigPath: kubeconfigExample},
+	}
+
+	kubeconfig, err := ObtainKubeconfig(node, getConfig, "192.168.0.100", KubeconfigOptions{RemotePath: KubeconfigPath})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(node.Commands()) != 0 {
+		t.Errorf("want no cat once SFTP read the kubeconfig, got: %q", node.Commands())
+	}
+	if strings.Contains(string(kubeconfig), "Welcome") || !strings.Contains(string(kubeconfig), "https://192.168.0.100:6443") {
+		t.Errorf("want the kubeconfig read over SFTP, got:\n%s", kubeconfig)
+	}
+}
+
+func Test_ObtainKubeconfig_SFTPFallsBackToCat(t *testing.T) {
+	node := &sftpNode{FakeOperator: operator.FakeOperator{Replies: map[string]operator.CommandRes{getConfig: {StdOut: []byte(kubeconfigExample)}}}}
+
+	printed := strings.Builder{}
+	debugf := func(format string, a ...interface{}) { fmt.Fprintf(&printed, format, a...) }
+	if _, err := ObtainKubeconfig(node, getConfig, "192.168.0.100", KubeconfigOptions{RemotePath: KubeconfigPath, Debugf: debugf}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(node.reads, []string{KubeconfigPath}) || !reflect.DeepEqual(node.Commands(), []string{getConfig}) {
+		t.Errorf("want SFTP tried and then cat, got reads %q and commands %q", node.reads, node.Commands())
+	}
+	if !strings.Contains(printed.String(), `falling back to cat: sftp: "Permission denied"`) {
+		t.Errorf("want the reason for the fallback, got:\n%s", printed.String())
+	}
+	if strings.Contains(printed.String(), "apiVersion") || !strings.Contains(printed.String(), "Fetched a kubeconfig of") {
+		t.Errorf("want only the size of the kubeconfig, got:\n%s", printed.String())
+	}
+}
+
+func Test_ObtainKubeconfig_NoRemotePathSkipsSFTP(t *testing.T) {
+	node := &sftpNode{
+		FakeOperator: operator.FakeOperator{Replies: map[string]operator.CommandRes{getConfig: {StdOut: []byte(kubeconfigExample)}}},
+		files:        map[string]string{KubeconfigPath: kubeconfigExample},
+	}
+
+	if _, err := ObtainKubeconfig(node, getConfig, "192.168.0.100", KubeconfigOptions{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(node.reads) != 0 || len(node.Commands()) != 1 {
+		t.Errorf("want only cat without a RemotePath, got reads %q and commands %q", node.reads, node.Commands())
+	}
+}
+
+func Test_ObtainKubeconfig_Error(t *testing.T) {
+	node := &operator.FakeOperator{Reply: func(command string) (operator.CommandRes, error) {
+		return operator.CommandRes{StdErr: []byte("cat: /etc/rancher/k3s/k3s.yaml: Permission denied\n")}, errors.New("exit status 1")
+	}}
+
+	_, err := ObtainKubeconfig(node, getConfig, "192.168.0.100", KubeconfigOptions{})
+	if err == nil || !strings.Contains(err.Error(), "stderr: cat: /etc/rancher/k3s/k3s.yaml: Permission denied") {
+		t.Errorf("want the stderr of cat in the error, got: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// FakeOperator is a StreamingOperator which connects to nothing and runs
+// nothing. It records each command and upload, and answers commands with
+// scripted output, for tests and demos.
+type FakeOperator struct {
+	// Replies is the result of a command, by the exact command.
+	Replies map[string]CommandRes
+	// Reply answers the commands missing from Replies, when it is nil they
+	// succeed without output.
+	Reply func(command string) (CommandRes, error)
+	// Audit is called with a record of each command and upload.
+	Audit Audit
+
+	mu       sync.Mutex
+	commands []string
+	uploads  map[string][]byte
+}
+
+// Commands returns the commands run so far, in order. An upload is
+// recorded as "upload <remotePath> <mode>", with the mode in octal.
+func (f *FakeOperator) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.commands...)
+}
+
+// Uploaded returns the content uploaded to remotePath, if any.
+func (f *FakeOperator) Uploaded(remotePath string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.uploads[remotePath]
+	return data, ok
+}
+
+func (f *FakeOperator) Execute(command string) (CommandRes, error) {
+	return f.ExecuteStreaming(command, ioutil.Discard, ioutil.Discard)
+}
+
+// ExecuteStreaming answers command and copies its output to stdout and
+// stderr, as the installer's output is streamed.
+func (f *FakeOperator) ExecuteStreaming(command string, stdout, stderr io.Writer) (res CommandRes, err error) {
+	start := time.Now()
+	defer func() { f.Audit.record(command, start, err) }()
+
+	f.mu.Lock()
+	f.commands = append(f.commands, command)
+	f.mu.Unlock()
+
+	if reply, ok := f.Replies[command]; ok {
+		res = reply
+	} else if f.Reply != nil {
+		res, err = f.Reply(command)
+	}
+
+	stdout.Write(res.StdOut)
+	stderr.Write(res.StdErr)
+	return res, err
+}
+
+func (f *FakeOperator) Upload(reader io.Reader, remotePath string, mode os.FileMode) (err error) {
+	command := fmt.Sprintf("upload %s %o", remotePath, mode)
+	var n int64
+	start := time.Now()
+	defer func() { f.Audit.recordUpload(command, remotePath, n, start, err) }()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	n = int64(len(data))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, command)
+	if f.uploads == nil {
+		f.uploads = map[string][]byte{}
+	}
+	f.uploads[remotePath] = data
+	return nil
+}
+
+// Close does nothing, there is no connection.
+func (f *FakeOperator) Close() error {
+	return nil
+}
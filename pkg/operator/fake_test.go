@@ -0,0 +1,49 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_FakeOperator(t *testing.T) {
+	failed := errors.New("exit status 1")
+	records := []AuditRecord{}
+	fake := &FakeOperator{
+		Replies: map[string]CommandRes{"hostname": {StdOut: []byte("node-1\n")}},
+		Reply: func(command string) (CommandRes, error) {
+			if command == "false" {
+				return CommandRes{StdErr: []byte("failed\n")}, failed
+			}
+			return CommandRes{}, nil
+		},
+		Audit: func(record AuditRecord) { records = append(records, record) },
+	}
+
+	stdout := bytes.Buffer{}
+	res, err := fake.ExecuteStreaming("hostname", &stdout, &bytes.Buffer{})
+	if err != nil || string(res.StdOut) != "node-1\n" || stdout.String() != "node-1\n" {
+		t.Errorf("want the scripted reply, got: %q %q %v", res.StdOut, stdout.String(), err)
+	}
+
+	if res, err := fake.Execute("false"); err != failed || string(res.StdErr) != "failed\n" {
+		t.Errorf("want the error of Reply, got: %q %v", res.StdErr, err)
+	}
+
+	if err := fake.Upload(strings.NewReader("data"), "/etc/rancher/k3s/registries.yaml", 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if data, ok := fake.Uploaded("/etc/rancher/k3s/registries.yaml"); !ok || string(data) != "data" {
+		t.Errorf("want the upload recorded, got: %q", data)
+	}
+
+	want := []string{"hostname", "false", "upload /etc/rancher/k3s/registries.yaml 600"}
+	if !reflect.DeepEqual(fake.Commands(), want) {
+		t.Errorf("want: %q, got: %q", want, fake.Commands())
+	}
+	if len(records) != 3 || records[1].ExitStatus != -1 || records[2].UploadBytes != 4 {
+		t.Errorf("want an audit record of each command, got: %+v", records)
+	}
+}
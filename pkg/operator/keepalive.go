@@ -0,0 +1,97 @@
+package ssh
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveMaxMissed is how many keepalives in a row may go unanswered
+// before the connection is closed, as ServerAliveCountMax of OpenSSH.
+const keepaliveMaxMissed = 3
+
+// keepalive sends keepalive requests over the connections of an operator
+// until it is stopped.
+type keepalive struct {
+	stop chan struct{}
+	once sync.Once
+}
+
+func (k *keepalive) Stop() {
+	k.once.Do(func() { close(k.stop) })
+}
+
+var errKeepaliveTimeout = errors.New("no reply to the keepalive")
+
+// StartKeepalive sends a keepalive request to the node every interval,
+// and to the jump host when there is one, so that a NAT or firewall does
+// not drop the connection whilst a command prints nothing for a while,
+// i.e. during a slow download. When keepaliveMaxMissed of them go
+// unanswered the connection is closed, so that a command on a connection
+// which is gone fails rather than waiting forever. Close stops it, and a
+// zero interval does nothing.
+func (s *SSHOperator) StartKeepalive(interval time.Duration) {
+	if interval <= 0 || s.keepalive != nil {
+		return
+	}
+
+	clients := []*ssh.Client{s.conn}
+	if s.proxy != nil {
+		clients = append(clients, s.proxy)
+	}
+	s.keepalive = &keepalive{stop: make(chan struct{})}
+	go s.keepalive.run(clients, interval)
+}
+
+func (k *keepalive) run(clients []*ssh.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-ticker.C:
+		}
+
+		var err error
+		for _, client := range clients {
+			if err = sendKeepalive(client, interval); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			missed = 0
+			continue
+		}
+
+		missed++
+		if missed >= keepaliveMaxMissed {
+			for _, client := range clients {
+				client.Close()
+			}
+			return
+		}
+	}
+}
+
+// sendKeepalive sends a keepalive request which wants a reply and waits at
+// most timeout for it. Servers reply with a failure to a request they do
+// not know, which is still a reply.
+func sendKeepalive(client *ssh.Client, timeout time.Duration) error {
+	replied := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		replied <- err
+	}()
+
+	select {
+	case err := <-replied:
+		return err
+	case <-time.After(timeout):
+		return errKeepaliveTimeout
+	}
+}
@@ -0,0 +1,132 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveServer serves an SSH connection on localhost, counting the
+// keepalives it receives. Once answer is false it stops replying to them,
+// as a node behind a connection which was dropped.
+type keepaliveServer struct {
+	mu       sync.Mutex
+	received int
+	answer   bool
+}
+
+func (k *keepaliveServer) count() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.received
+}
+
+func (k *keepaliveServer) setAnswer(answer bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.answer = answer
+}
+
+func newKeepaliveClient(t *testing.T) (*ssh.Client, *keepaliveServer) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	server := &keepaliveServer{answer: true}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		go func() {
+			for newChannel := range chans {
+				newChannel.Reject(ssh.Prohibited, "no channels")
+			}
+		}()
+		for req := range reqs {
+			server.mu.Lock()
+			if req.Type == "keepalive@openssh.com" {
+				server.received++
+			}
+			answer := server.answer
+			server.mu.Unlock()
+			if answer {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{User: "root", HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func Test_SSHOperator_StartKeepalive(t *testing.T) {
+	client, server := newKeepaliveClient(t)
+	op := &SSHOperator{conn: client}
+	op.StartKeepalive(10 * time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.count() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if server.count() < 3 {
+		t.Fatalf("want keepalives sent, got: %d", server.count())
+	}
+
+	if err := op.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-op.keepalive.stop:
+	default:
+		t.Errorf("want the keepalives stopped by Close")
+	}
+}
+
+func Test_SSHOperator_StartKeepalive_Unanswered(t *testing.T) {
+	client, server := newKeepaliveClient(t)
+	server.setAnswer(false)
+	op := &SSHOperator{conn: client}
+	op.StartKeepalive(10 * time.Millisecond)
+	defer op.Close()
+
+	closed := make(chan error, 1)
+	go func() { closed <- client.Wait() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want the connection closed after %d unanswered keepalives", keepaliveMaxMissed)
+	}
+}
+
+func Test_SSHOperator_StartKeepalive_Zero(t *testing.T) {
+	op := &SSHOperator{}
+	op.StartKeepalive(0)
+	if op.keepalive != nil {
+		t.Errorf("want no keepalives for a zero interval")
+	}
+}
@@ -0,0 +1,97 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// newOutputMarker returns a marker which the output of a command will not
+// contain by chance.
+func newOutputMarker() string {
+	random := make([]byte, 8)
+	rand.Read(random)
+	return "k3sup-output-" + hex.EncodeToString(random)
+}
+
+// withOutputMarkers wraps command so that a line of marker+"-begin" is
+// printed before its output and marker+"-end" after it, keeping its exit
+// status. Whatever the shell prints as it starts, such as a banner from
+// .bashrc, comes before the first marker.
+func withOutputMarkers(command, marker string) string {
+	return fmt.Sprintf("printf '%%s\\n' %s-begin\n%s\nk3sup_status=$?\nprintf '%%s\\n' %s-end\nexit $k3sup_status", marker, command, marker)
+}
+
+// markerWriter passes on to w only the output written between the markers
+// of withOutputMarkers. When the begin marker never arrives, i.e. the
+// shell did not run the wrapper, Flush passes on all of the output as is.
+type markerWriter struct {
+	w          io.Writer
+	begin, end []byte
+
+	line    []byte
+	started bool
+	ended   bool
+	skipped bytes.Buffer
+}
+
+func newMarkerWriter(w io.Writer, marker string) *markerWriter {
+	return &markerWriter{w: w, begin: []byte(marker + "-begin"), end: []byte(marker + "-end")}
+}
+
+func (m *markerWriter) Write(p []byte) (int, error) {
+	m.line = append(m.line, p...)
+	for {
+		i := bytes.IndexByte(m.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := m.line[:i+1]
+		m.line = m.line[i+1:]
+		if err := m.writeLine(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+func (m *markerWriter) writeLine(line []byte) error {
+	switch {
+	case m.ended:
+		return nil
+	case !m.started:
+		if bytes.Equal(bytes.TrimRight(line, "\r\n"), m.begin) {
+			m.started = true
+			return nil
+		}
+		m.skipped.Write(line)
+		return nil
+	}
+
+	// Output without a final newline runs into the end marker.
+	if content := bytes.TrimRight(line, "\r\n"); bytes.HasSuffix(content, m.end) {
+		m.ended = true
+		_, err := m.w.Write(content[:len(content)-len(m.end)])
+		return err
+	}
+	_, err := m.w.Write(line)
+	return err
+}
+
+// Flush writes any output after the last newline, or everything when the
+// begin marker was never seen.
+func (m *markerWriter) Flush() error {
+	if !m.started {
+		m.skipped.Write(m.line)
+		m.line = nil
+		_, err := m.w.Write(m.skipped.Bytes())
+		return err
+	}
+	line := m.line
+	m.line = nil
+	if len(line) == 0 {
+		return nil
+	}
+	return m.writeLine(line)
+}
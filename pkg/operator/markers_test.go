@@ -0,0 +1,120 @@
+package ssh
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func Test_markerWriter(t *testing.T) {
+	marker := "k3sup-output-test"
+	cases := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "banner before the command",
+			output: "Welcome to the node\n\nk3sup-output-test-begin\napiVersion: v1\nkind: Config\nk3sup-output-test-end\n",
+			want:   "apiVersion: v1\nkind: Config\n",
+		},
+		{
+			name:   "output without a final newline",
+			output: "k3sup-output-test-begin\nready" + "k3sup-output-test-end\n",
+			want:   "ready",
+		},
+		{
+			name:   "no output",
+			output: "k3sup-output-test-begin\nk3sup-output-test-end\n",
+			want:   "",
+		},
+		{
+			name:   "output after the end marker",
+			output: "k3sup-output-test-begin\nok\nk3sup-output-test-end\nlogout\n",
+			want:   "ok\n",
+		},
+		{
+			name:   "exited before the end marker",
+			output: "k3sup-output-test-begin\npartial\nlast",
+			want:   "partial\nlast",
+		},
+		{
+			name:   "no markers at all",
+			output: "output of a shell which did not run the wrapper\nlast",
+			want:   "output of a shell which did not run the wrapper\nlast",
+		},
+		{
+			name:   "carriage returns",
+			output: "banner\r\nk3sup-output-test-begin\r\nok\r\nk3sup-output-test-end\r\n",
+			want:   "ok\r\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Write a byte at a time, as the output may arrive in any chunks.
+			out := bytes.Buffer{}
+			w := newMarkerWriter(&out, marker)
+			for i := 0; i < len(tc.output); i++ {
+				w.Write([]byte{tc.output[i]})
+			}
+			w.Flush()
+
+			if out.String() != tc.want {
+				t.Errorf("want %q, got %q", tc.want, out.String())
+			}
+		})
+	}
+}
+
+func Test_withOutputMarkers(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run the wrapper with")
+	}
+	marker := newOutputMarker()
+
+	cases := []struct {
+		name       string
+		command    string
+		want       string
+		wantStatus int
+	}{
+		{name: "output", command: "echo one; echo two", want: "one\ntwo\n"},
+		{name: "no final newline", command: "printf ready", want: "ready"},
+		{name: "exit status", command: "echo failed; false", want: "failed\n", wantStatus: 1},
+		{name: "multiple lines", command: "if true; then\n  echo yes\nfi\n", want: "yes\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The echo stands in for a banner printed as the shell starts.
+			run := exec.Command("sh", "-c", "echo 'Welcome to the node'\n"+withOutputMarkers(tc.command, marker))
+			stdout := bytes.Buffer{}
+			w := newMarkerWriter(&stdout, marker)
+			run.Stdout = w
+			err := run.Run()
+			w.Flush()
+
+			status := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				status = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if status != tc.wantStatus {
+				t.Errorf("want exit status %d, got %d", tc.wantStatus, status)
+			}
+			if stdout.String() != tc.want {
+				t.Errorf("want %q, got %q", tc.want, stdout.String())
+			}
+		})
+	}
+}
+
+func Test_newOutputMarker(t *testing.T) {
+	first, second := newOutputMarker(), newOutputMarker()
+	if first == second || !strings.HasPrefix(first, "k3sup-output-") {
+		t.Errorf("want distinct markers, got %q and %q", first, second)
+	}
+}
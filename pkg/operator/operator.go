@@ -1,32 +1,348 @@
 package ssh
 
 import (
-	goexecute "github.com/alexellis/go-execute/pkg/v1"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
+// ErrTimeout is wrapped by the errors returned when a connection or a
+// command takes longer than its timeout, check for it with errors.Is.
+var ErrTimeout = errors.New("timed out")
+
 type CommandOperator interface {
 	Execute(command string) (CommandRes, error)
+
+	// Upload writes the content of reader to remotePath with mode,
+	// creating its parent directories, so that commands do not need to
+	// pass files through a command line.
+	Upload(reader io.Reader, remotePath string, mode os.FileMode) error
+}
+
+// StreamingOperator is a CommandOperator which can also copy the output of
+// a command to the caller's writers, one line at a time, whilst it runs.
+type StreamingOperator interface {
+	CommandOperator
+	ExecuteStreaming(command string, stdout, stderr io.Writer) (CommandRes, error)
+}
+
+// ContextOperator is a CommandOperator whose commands stop when the given
+// context is done.
+type ContextOperator interface {
+	CommandOperator
+	ExecuteContext(ctx context.Context, command string) (CommandRes, error)
+}
+
+// AuditRecord describes a command which an operator ran, for an audit log.
+type AuditRecord struct {
+	Command  string
+	Start    time.Time
+	Duration time.Duration
+
+	// UploadPath and UploadBytes describe the file written by Upload, whose
+	// content is not part of Command.
+	UploadPath  string
+	UploadBytes int64
+
+	// ExitStatus is -1 when the command did not exit by itself, i.e. it
+	// could not be started or was killed after a timeout.
+	ExitStatus int
+	Err        error
+}
+
+// Audit is called with a record of each command an operator has run.
+type Audit func(record AuditRecord)
+
+// record calls fn, when set, with a record of command which started at
+// start and returned err.
+func (fn Audit) record(command string, start time.Time, err error) {
+	fn.recordUpload(command, "", 0, start, err)
+}
+
+// recordUpload calls fn, when set, with a record of the command of Upload
+// which wrote n bytes to remotePath.
+func (fn Audit) recordUpload(command, remotePath string, n int64, start time.Time, err error) {
+	if fn == nil {
+		return
+	}
+	fn(AuditRecord{
+		Command:     command,
+		Start:       start,
+		Duration:    time.Since(start),
+		UploadPath:  remotePath,
+		UploadBytes: n,
+		ExitStatus:  ExitStatus(err),
+		Err:         err,
+	})
+}
+
+// ExitStatus returns the exit status of a command from the error it
+// returned, 0 for no error and -1 when the command did not exit by itself,
+// i.e. it could not be started or was killed after a timeout.
+func ExitStatus(err error) int {
+	var exitErr *exec.ExitError
+	var sshExitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	case errors.As(err, &sshExitErr):
+		return sshExitErr.ExitStatus()
+	}
+	return -1
 }
 
 type ExecOperator struct {
+	// CommandTimeout kills commands run by Execute and ExecuteStreaming
+	// which run for longer, zero means no timeout.
+	CommandTimeout time.Duration
+
+	// Context kills commands run by Execute and ExecuteStreaming once it
+	// is done, i.e. when the user presses Ctrl-C, nil means never.
+	Context context.Context
+
+	// Audit, when set, is called after each command.
+	Audit Audit
+
+	// Sudo is prepended to the commands of Upload, i.e. "sudo ", so that
+	// it can write where the user cannot. Without it Upload copies the
+	// file directly.
+	Sudo string
 }
 
+// Execute runs command and returns its output, which is not printed, as it
+// may hold secrets such as the node token. Use ExecuteStreaming to see it
+// whilst the command runs.
 func (ex ExecOperator) Execute(command string) (CommandRes, error) {
+	return ex.ExecuteStreaming(command, ioutil.Discard, ioutil.Discard)
+}
 
-	task := goexecute.ExecTask{
-		Command:     command,
-		Shell:       true,
-		StreamStdio: true,
+func (ex ExecOperator) ExecuteStreaming(command string, stdout, stderr io.Writer) (CommandRes, error) {
+	ctx, cancel := withCommandTimeout(ex.Context, ex.CommandTimeout)
+	defer cancel()
+
+	return ex.ExecuteStreamingContext(ctx, command, stdout, stderr)
+}
+
+func (ex ExecOperator) ExecuteContext(ctx context.Context, command string) (CommandRes, error) {
+	return ex.ExecuteStreamingContext(ctx, command, ioutil.Discard, ioutil.Discard)
+}
+
+// ExecuteStreamingContext runs command until it exits or ctx is done, in
+// which case the process is killed and an error wrapping ErrTimeout is
+// returned once ctx's deadline has passed.
+func (ex ExecOperator) ExecuteStreamingContext(ctx context.Context, command string, stdout, stderr io.Writer) (res CommandRes, err error) {
+	start := time.Now()
+	defer func() { ex.Audit.record(command, start, err) }()
+
+	return ex.run(ctx, command, nil, stdout, stderr)
+}
+
+// Upload copies the content of reader to remotePath, which is on this
+// computer. With Sudo the copy is made by the commands of UploadCommand.
+func (ex ExecOperator) Upload(reader io.Reader, remotePath string, mode os.FileMode) (err error) {
+	ctx, cancel := withCommandTimeout(ex.Context, ex.CommandTimeout)
+	defer cancel()
+
+	counter := &countingReader{r: reader}
+	command := UploadCommand(ex.Sudo, remotePath, mode)
+	start := time.Now()
+	defer func() { ex.Audit.recordUpload(command, remotePath, counter.n, start, err) }()
+
+	if len(ex.Sudo) == 0 {
+		return copyFile(counter, remotePath, mode)
+	}
+
+	errorOutput := bytes.Buffer{}
+	if _, err := ex.run(ctx, command, counter, ioutil.Discard, &errorOutput); err != nil {
+		return uploadError(remotePath, err, errorOutput.String())
+	}
+	return nil
+}
+
+// copyFile writes the content of reader to a temporary file next to
+// filePath, which is renamed into place once it is complete.
+func copyFile(reader io.Reader, filePath string, mode os.FileMode) error {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filePath)+".k3sup-upload")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
 	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+func (ex ExecOperator) run(ctx context.Context, command string, stdin io.Reader, stdout, stderr io.Writer) (CommandRes, error) {
+	cmd := exec.CommandContext(ctx, "/bin/bash", "-c", command)
+	cmd.Stdin = stdin
+
+	output := bytes.Buffer{}
+	stdOutWriter := NewLineWriter(stdout, "")
+	cmd.Stdout = io.MultiWriter(stdOutWriter, &output)
+
+	errorOutput := bytes.Buffer{}
+	stdErrWriter := NewLineWriter(stderr, "")
+	cmd.Stderr = io.MultiWriter(stdErrWriter, &errorOutput)
+
+	err := cmd.Run()
+
+	stdOutWriter.Flush()
+	stdErrWriter.Flush()
 
-	res, err := task.Execute()
 	if err != nil {
-		return CommandRes{}, err
+		if ctx.Err() != nil {
+			return CommandRes{}, timeoutError(ctx, ctx.Err(), fmt.Sprintf("running %q", command))
+		}
+		return CommandRes{StdErr: errorOutput.Bytes(), StdOut: output.Bytes()}, err
 	}
 
 	return CommandRes{
-		StdErr: []byte(res.Stderr),
-		StdOut: []byte(res.Stdout),
+		StdErr: errorOutput.Bytes(),
+		StdOut: output.Bytes(),
 	}, nil
+}
+
+// UploadCommand is the command Upload runs without SFTP, it writes stdin to
+// a temporary file next to remotePath, which only its owner can read until it has mode,
+// and then renames it into place, so that a failed upload never leaves part
+// of a file at remotePath. Each command is prefixed with sudo, as the
+// content does not go through a shell which could be run with it.
+func UploadCommand(sudo, remotePath string, mode os.FileMode) string {
+	tmp := remotePath + ".k3sup-upload"
+	return fmt.Sprintf("%smkdir -p %s && %ssh -c %s && %schmod %o %s && %smv %s %s",
+		sudo, ShellQuote(path.Dir(remotePath)),
+		sudo, ShellQuote("umask 077 && cat > "+ShellQuote(tmp)),
+		sudo, mode, ShellQuote(tmp),
+		sudo, ShellQuote(tmp), ShellQuote(remotePath))
+}
+
+// InstallCommand moves tmp, a file uploaded by SSHOperator over SFTP, to
+// remotePath with mode. It is copied to a temporary file next to remotePath,
+// which is then renamed into place as with UploadCommand, and tmp is removed
+// whether that worked or not.
+func InstallCommand(sudo, tmp, remotePath string, mode os.FileMode) string {
+	next := remotePath + ".k3sup-upload"
+	return fmt.Sprintf("%smkdir -p %s && %sinstall -m %o %s %s && %smv %s %s; k3sup_status=$?; rm -f %s; exit $k3sup_status",
+		sudo, ShellQuote(path.Dir(remotePath)),
+		sudo, mode, ShellQuote(tmp), ShellQuote(next),
+		sudo, ShellQuote(next), ShellQuote(remotePath),
+		ShellQuote(tmp))
+}
+
+// uploadError adds the output of the command of Upload to err.
+func uploadError(remotePath string, err error, stderr string) error {
+	if stderr = strings.TrimSpace(stderr); len(stderr) > 0 {
+		return fmt.Errorf("uploading %s: %w: %s", remotePath, err, stderr)
+	}
+	return fmt.Errorf("uploading %s: %w", remotePath, err)
+}
+
+// countingReader counts the bytes read through it, for the audit log.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func withCommandTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// timeoutError wraps ErrTimeout when err was caused by a deadline, and
+// context.Canceled when ctx was cancelled, so that callers can tell a
+// timeout or an interruption apart from other failures.
+func timeoutError(ctx context.Context, err error, action string) error {
+	if netErr, ok := err.(net.Error); (ok && netErr.Timeout()) || ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s: %w", action, ErrTimeout)
+	}
+	if ctx.Err() == context.Canceled {
+		return fmt.Errorf("%s: %w", action, context.Canceled)
+	}
+	return err
+}
 
+// LineWriter writes to an underlying writer one complete line at a time,
+// each starting with Prefix, so that the last line printed by a command
+// is visible as soon as it ends and lines of concurrent writers are not
+// mixed up. Call Flush to write a final line without a newline.
+type LineWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+	mu     sync.Mutex
+}
+
+func NewLineWriter(w io.Writer, prefix string) *LineWriter {
+	return &LineWriter{w: w, prefix: prefix}
+}
+
+func (l *LineWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := io.WriteString(l.w, l.prefix+string(l.buf[:i+1])); err != nil {
+			return 0, err
+		}
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (l *LineWriter) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(l.w, l.prefix+string(l.buf)+"\n")
+	l.buf = nil
+	return err
 }
@@ -0,0 +1,419 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_LineWriter(t *testing.T) {
+	out := bytes.Buffer{}
+	w := NewLineWriter(&out, "[node-1] ")
+
+	w.Write([]byte("Downloading k3s"))
+	if out.Len() != 0 {
+		t.Fatalf("want an incomplete line to be buffered, got: %q", out.String())
+	}
+
+	w.Write([]byte("\nInstalling k3s\nStarting"))
+	want := "[node-1] Downloading k3s\n[node-1] Installing k3s\n"
+	if out.String() != want {
+		t.Fatalf("want: %q, got: %q", want, out.String())
+	}
+
+	w.Flush()
+	want += "[node-1] Starting\n"
+	if out.String() != want {
+		t.Fatalf("want: %q, got: %q", want, out.String())
+	}
+}
+
+func Test_ExecOperator_ExecuteStreaming(t *testing.T) {
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+
+	res, err := ExecOperator{}.ExecuteStreaming("echo one; echo two >&2; printf three", &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if stdout.String() != "one\nthree\n" {
+		t.Errorf("want streamed stdout, got: %q", stdout.String())
+	}
+	if stderr.String() != "two\n" {
+		t.Errorf("want streamed stderr, got: %q", stderr.String())
+	}
+	if string(res.StdOut) != "one\nthree" || string(res.StdErr) != "two\n" {
+		t.Errorf("want captured output, got: %q %q", res.StdOut, res.StdErr)
+	}
+}
+
+func Test_ExecOperator_Execute_PrintsNothing(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	f, err := ioutil.TempFile("", "k3sup-execute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	os.Stdout, os.Stderr = f, f
+
+	op := ExecOperator{}
+	res, err := op.Execute("echo K10secret-token; echo failed >&2")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := op.ExecuteContext(context.Background(), "echo K10secret-token"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	os.Stdout, os.Stderr = stdout, stderr
+
+	printed, _ := ioutil.ReadFile(f.Name())
+	if len(printed) > 0 {
+		t.Errorf("want nothing printed, got: %q", printed)
+	}
+	if string(res.StdOut) != "K10secret-token\n" || string(res.StdErr) != "failed\n" {
+		t.Errorf("want the output returned, got: %q %q", res.StdOut, res.StdErr)
+	}
+}
+
+func Test_ExecOperator_ExecuteStreaming_ExitCode(t *testing.T) {
+	stdout := bytes.Buffer{}
+	_, err := ExecOperator{}.ExecuteStreaming("echo last line before failing; exit 3", &stdout, &bytes.Buffer{})
+	if err == nil {
+		t.Fatalf("want an error for a non-zero exit code")
+	}
+	if stdout.String() != "last line before failing\n" {
+		t.Errorf("want the output up to the failure, got: %q", stdout.String())
+	}
+}
+
+func Test_ExecOperator_ExecuteStreaming_OutputOnFailure(t *testing.T) {
+	res, err := ExecOperator{}.ExecuteStreaming("echo partial; echo failed >&2; exit 1", &bytes.Buffer{}, &bytes.Buffer{})
+	if err == nil {
+		t.Fatalf("want an error for a non-zero exit code")
+	}
+	if string(res.StdOut) != "partial\n" || string(res.StdErr) != "failed\n" {
+		t.Errorf("want the output captured before the failure, got: %q %q", res.StdOut, res.StdErr)
+	}
+}
+
+func Test_ExecOperator_CommandTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := ExecOperator{CommandTimeout: 50 * time.Millisecond}.ExecuteStreaming("sleep 5", &bytes.Buffer{}, &bytes.Buffer{})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("want ErrTimeout, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("want the command to be killed, took: %s", elapsed)
+	}
+}
+
+func Test_ExecOperator_Context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := ExecOperator{Context: ctx}.ExecuteStreaming("sleep 5", &bytes.Buffer{}, &bytes.Buffer{})
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Fatalf("want context.Canceled, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("want the command to be killed, took: %s", elapsed)
+	}
+}
+
+func Test_NewSSHOperator_DialTimeout(t *testing.T) {
+	// The listener accepts connections but never starts the SSH handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	config := &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         100 * time.Millisecond,
+	}
+
+	start := time.Now()
+	_, err = NewSSHOperator(listener.Addr().String(), config)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("want ErrTimeout, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("want the handshake to time out, took: %s", elapsed)
+	}
+}
+
+// newExecClient connects to an SSH server on localhost which answers each
+// command with "ok" and exit status 0.
+func newExecClient(t *testing.T) *ssh.Client {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			go func(channel ssh.Channel, requests <-chan *ssh.Request) {
+				defer channel.Close()
+				for req := range requests {
+					req.Reply(req.Type == "exec", nil)
+					if req.Type == "exec" {
+						channel.Write([]byte("ok\n"))
+						channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+						return
+					}
+				}
+			}(channel, requests)
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{User: "root", HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func Test_NewSSHOperatorFromClient(t *testing.T) {
+	client := newExecClient(t)
+	defer client.Close()
+
+	op := NewSSHOperatorFromClient(client)
+	op.NoOutputMarkers = true
+	res, err := op.ExecuteStreaming("hostname", ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(res.StdOut) != "ok\n" {
+		t.Errorf("want: %q, got: %q", "ok\n", res.StdOut)
+	}
+
+	if err := op.Close(); err != nil {
+		t.Errorf("unexpected error closing: %s", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("want the client of the caller left open, got: %s", err)
+	}
+	session.Close()
+}
+
+func Test_IsConnectionError(t *testing.T) {
+	// Nothing listens on the port once the listener is closed.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	config := &ssh.ClientConfig{User: "root", HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: time.Second}
+	_, refused := NewSSHOperator(address, config)
+
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"connection refused", refused, true},
+		{"timeout", fmt.Errorf("connecting: %w", ErrTimeout), true},
+		{"closed before the handshake", errors.New("ssh: handshake failed: EOF"), true},
+		{"node refused by the jump host", fmt.Errorf("through the jump host: %w", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed}), true},
+		{"prohibited by the jump host", fmt.Errorf("through the jump host: %w", &ssh.OpenChannelError{Reason: ssh.Prohibited}), false},
+		{"authentication", errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain"), false},
+		{"host key", errors.New("ssh: handshake failed: knownhosts: key mismatch"), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := IsConnectionError(c.err); got != c.want {
+				t.Errorf("want: %t, got: %t for %v", c.want, got, c.err)
+			}
+		})
+	}
+}
+
+func Test_ShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"":                       "''",
+		"k3s --version":          "'k3s --version'",
+		"echo 'a' && echo \"b\"": `'echo '\''a'\'' && echo "b"'`,
+	}
+
+	for value, want := range cases {
+		got := ShellQuote(value)
+		if got != want {
+			t.Errorf("want: %s, got: %s", want, got)
+		}
+
+		out, err := exec.Command("sh", "-c", "printf %s "+got).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != value {
+			t.Errorf("want sh to read back %q, got: %q", value, out)
+		}
+	}
+}
+
+func Test_ExecOperator_Upload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// "env " stands in for sudo, so that the commands of UploadCommand run.
+	for _, sudo := range []string{"", "env "} {
+		path := filepath.Join(dir, "sudo-"+strings.TrimSpace(sudo), "k3s", "registries.yaml")
+
+		var record AuditRecord
+		op := ExecOperator{Sudo: sudo, Audit: func(r AuditRecord) { record = r }}
+		if err := op.Upload(strings.NewReader("mirrors: {}\n"), path, 0640); err != nil {
+			t.Fatalf("sudo %q: unexpected error: %s", sudo, err)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "mirrors: {}\n" {
+			t.Errorf("sudo %q: want the content, got: %q", sudo, data)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("sudo %q: want mode 0640, got: %o", sudo, info.Mode().Perm())
+		}
+
+		if record.UploadPath != path || record.UploadBytes != int64(len("mirrors: {}\n")) || record.ExitStatus != 0 {
+			t.Errorf("sudo %q: unexpected audit record: %+v", sudo, record)
+		}
+
+		files, _ := ioutil.ReadDir(filepath.Dir(path))
+		if len(files) != 1 {
+			t.Errorf("sudo %q: want no temporary file to be left, got: %d files", sudo, len(files))
+		}
+	}
+}
+
+func Test_ExecOperator_Upload_Error(t *testing.T) {
+	op := ExecOperator{Sudo: "env "}
+	err := op.Upload(strings.NewReader("data"), "/proc/k3sup/registries.yaml", 0600)
+	if err == nil || !strings.Contains(err.Error(), "uploading /proc/k3sup/registries.yaml") {
+		t.Errorf("want an error naming the path, got: %v", err)
+	}
+}
+
+func Test_uploadCommand(t *testing.T) {
+	got := UploadCommand("sudo ", "/etc/rancher/k3s/registries.yaml", 0600)
+	want := "sudo mkdir -p '/etc/rancher/k3s' && " +
+		`sudo sh -c 'umask 077 && cat > '\''/etc/rancher/k3s/registries.yaml.k3sup-upload'\''' && ` +
+		"sudo chmod 600 '/etc/rancher/k3s/registries.yaml.k3sup-upload' && " +
+		"sudo mv '/etc/rancher/k3s/registries.yaml.k3sup-upload' '/etc/rancher/k3s/registries.yaml'"
+	if got != want {
+		t.Errorf("want: %s\ngot:  %s", want, got)
+	}
+}
+
+func Test_InstallCommand(t *testing.T) {
+	got := InstallCommand("sudo ", "/home/pi/.k3sup-upload-0123", "/etc/rancher/k3s/registries.yaml", 0600)
+	want := "sudo mkdir -p '/etc/rancher/k3s' && " +
+		"sudo install -m 600 '/home/pi/.k3sup-upload-0123' '/etc/rancher/k3s/registries.yaml.k3sup-upload' && " +
+		"sudo mv '/etc/rancher/k3s/registries.yaml.k3sup-upload' '/etc/rancher/k3s/registries.yaml'; " +
+		"k3sup_status=$?; rm -f '/home/pi/.k3sup-upload-0123'; exit $k3sup_status"
+	if got != want {
+		t.Errorf("want: %s\ngot:  %s", want, got)
+	}
+}
+
+func Test_withSudoPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-sudo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake sudo caches nothing, -v only checks the password it reads
+	// and -n runs the command.
+	fakeSudo := "#!/bin/sh\n" +
+		"case \"$1\" in\n" +
+		"-S) IFS= read -r password && [ \"$password\" = \"raspberry\" ] ;;\n" +
+		"-n) shift && exec \"$@\" ;;\n" +
+		"*) exit 1 ;;\n" +
+		"esac\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "sudo"), []byte(fakeSudo), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for password, want := range map[string]string{"raspberry": "mirrors: {}\n", "wrong": ""} {
+		cmd := exec.Command("sh", "-c", withSudoPassword("cat"))
+		cmd.Env = append(os.Environ(), "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+		cmd.Stdin = strings.NewReader(password + "\nmirrors: {}\n")
+		out, err := cmd.Output()
+		if password == "raspberry" && err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if password == "wrong" && err == nil {
+			t.Errorf("want an error for a wrong password")
+		}
+		if string(out) != want {
+			t.Errorf("password %q: want the command to read only the rest of stdin %q, got: %q", password, want, out)
+		}
+	}
+}
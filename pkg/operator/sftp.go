@@ -0,0 +1,120 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrSFTPUnavailable is returned by ReadFile, and handled by Upload, when
+// the node does not serve SFTP, i.e. the sftp subsystem of sshd is disabled.
+var ErrSFTPUnavailable = errors.New("sftp is not available")
+
+// ReadFile reads remotePath over SFTP, so that its content cannot be mixed
+// with a login banner or a warning printed by the shell, as the output of
+// cat can. The file is read as the SSH user, Sudo and SudoPassword do not
+// apply to SFTP, so a file which only root may read fails with a
+// permission error for any other user.
+func (s SSHOperator) ReadFile(remotePath string) (data []byte, err error) {
+	start := time.Now()
+	defer func() { s.Audit.record("sftp read "+remotePath, start, err) }()
+
+	err = s.withSFTP("reading "+remotePath, func(client *sftp.Client) error {
+		file, err := client.Open(remotePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		data, err = ioutil.ReadAll(file)
+		return err
+	})
+	return data, err
+}
+
+// sftpUpload writes the content of reader to a new file in the home
+// directory of the SSH user, which only that user may read, and returns
+// its path. The file is removed when it cannot be written completely.
+func (s SSHOperator) sftpUpload(reader io.Reader, remotePath string) (tmp string, err error) {
+	random := make([]byte, 8)
+	rand.Read(random)
+
+	err = s.withSFTP("uploading "+remotePath, func(client *sftp.Client) error {
+		home, err := client.Getwd()
+		if err != nil {
+			return err
+		}
+		tmp = path.Join(home, ".k3sup-upload-"+hex.EncodeToString(random))
+
+		file, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		if err != nil {
+			return err
+		}
+		err = file.Chmod(0600)
+		if err == nil {
+			_, err = io.Copy(file, reader)
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			client.Remove(tmp)
+		}
+		return err
+	})
+	return tmp, err
+}
+
+// withSFTP runs fn with an SFTP client over a session of its own. The
+// session is closed once s.Context is done or CommandTimeout has passed,
+// which fails the request fn is waiting for, what names it in the error.
+func (s SSHOperator) withSFTP(what string, fn func(client *sftp.Client) error) error {
+	ctx, cancel := withCommandTimeout(s.Context, s.CommandTimeout)
+	defer cancel()
+
+	sess, err := s.conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		return fmt.Errorf("%w: %s", ErrSFTPUnavailable, err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sess.Close()
+		case <-done:
+		}
+	}()
+
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err == nil {
+		err = fn(client)
+		client.Close()
+	}
+	if err != nil && ctx.Err() != nil {
+		return timeoutError(ctx, ctx.Err(), what)
+	}
+	return err
+}
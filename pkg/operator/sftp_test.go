@@ -0,0 +1,244 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// newSFTPOperator connects to an SSH server on localhost which serves the
+// files of this computer over its sftp subsystem, with serve, and runs
+// commands with sh. A nil serve refuses the subsystem, as a node without an
+// SFTP server does.
+func newSFTPOperator(t *testing.T, serve func(channel ssh.Channel)) *SSHOperator {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			go func(channel ssh.Channel, requests <-chan *ssh.Request) {
+				for req := range requests {
+					switch {
+					case req.Type == "subsystem" && serve != nil:
+						req.Reply(true, nil)
+						go serve(channel)
+					case req.Type == "exec":
+						req.Reply(true, nil)
+						go execCommand(channel, string(req.Payload[4:]))
+					default:
+						req.Reply(false, nil)
+					}
+				}
+			}(channel, requests)
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{User: "root", HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SSHOperator{conn: client}
+}
+
+// serveSFTP serves the files of this computer over channel.
+func serveSFTP(channel ssh.Channel) {
+	defer channel.Close()
+	server, err := sftp.NewServer(channel)
+	if err != nil {
+		return
+	}
+	server.Serve()
+}
+
+// execCommand runs command with sh over channel and sends its exit status.
+func execCommand(channel ssh.Channel, command string) {
+	defer channel.Close()
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = channel, channel, channel.Stderr()
+
+	status := uint32(0)
+	if err := cmd.Run(); err != nil {
+		status = 1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status = uint32(exitErr.ExitCode())
+		}
+	}
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+}
+
+func Test_SSHOperator_ReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Larger than a packet, so that it takes several reads.
+	kubeconfig := "apiVersion: v1\n" + strings.Repeat("# padding\n", 10000)
+	path := filepath.Join(dir, "k3s.yaml")
+	if err := ioutil.WriteFile(path, []byte(kubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	op := newSFTPOperator(t, serveSFTP)
+	defer op.Close()
+
+	var record AuditRecord
+	op.Audit = func(r AuditRecord) { record = r }
+	data, err := op.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != kubeconfig {
+		t.Errorf("want the whole file, got %d of %d bytes", len(data), len(kubeconfig))
+	}
+	if record.Command != "sftp read "+path {
+		t.Errorf("want the read in the audit log, got: %+v", record)
+	}
+
+	if _, err := op.ReadFile(filepath.Join(dir, "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want os.ErrNotExist for a missing file, got: %v", err)
+	}
+}
+
+func Test_SSHOperator_ReadFile_Unavailable(t *testing.T) {
+	op := newSFTPOperator(t, nil)
+	defer op.Close()
+
+	if _, err := op.ReadFile("/etc/rancher/k3s/k3s.yaml"); !errors.Is(err, ErrSFTPUnavailable) {
+		t.Errorf("want ErrSFTPUnavailable, got: %v", err)
+	}
+}
+
+func Test_SSHOperator_ReadFile_Timeout(t *testing.T) {
+	// The server accepts the subsystem but never answers.
+	op := newSFTPOperator(t, func(channel ssh.Channel) {})
+	defer op.Close()
+	op.CommandTimeout = 100 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := op.ReadFile("/etc/rancher/k3s/k3s.yaml")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf("want ErrTimeout, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want ReadFile to give up after CommandTimeout")
+	}
+}
+
+// chdir makes dir the working directory, which the SFTP server of
+// newSFTPOperator serves as the home directory, until the returned func is
+// called.
+func chdir(t *testing.T, dir string) func() {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return func() { os.Chdir(wd) }
+}
+
+func Test_SSHOperator_Upload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	home := filepath.Join(dir, "home")
+	if err := os.Mkdir(home, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer chdir(t, home)()
+
+	op := newSFTPOperator(t, serveSFTP)
+	defer op.Close()
+
+	var record AuditRecord
+	op.Audit = func(r AuditRecord) { record = r }
+	content := "mirrors: {}\n" + strings.Repeat("# padding\n", 10000)
+	path := filepath.Join(dir, "etc", "registries.yaml")
+	if err := op.Upload(strings.NewReader(content), path, 0640); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("want the whole file, got %d of %d bytes", len(data), len(content))
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("want mode 0640, got: %v", err)
+	}
+	if left, _ := ioutil.ReadDir(home); len(left) != 0 {
+		t.Errorf("want the uploaded file removed from the home directory, got: %s", left[0].Name())
+	}
+	if !strings.Contains(record.Command, "install -m 640 ") || record.UploadBytes != int64(len(content)) {
+		t.Errorf("want the install command and the size in the audit log, got: %+v", record)
+	}
+}
+
+func Test_SSHOperator_Upload_Unavailable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	op := newSFTPOperator(t, nil)
+	defer op.Close()
+
+	path := filepath.Join(dir, "registries.yaml")
+	if err := op.Upload(strings.NewReader("mirrors: {}\n"), path, 0600); err != nil {
+		t.Fatalf("want the content over stdin without SFTP, got: %s", err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "mirrors: {}\n" {
+		t.Errorf("want the file written, got: %q %v", data, err)
+	}
+}
@@ -2,37 +2,298 @@ package ssh
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
 type SSHOperator struct {
 	conn *ssh.Client
+
+	// proxy is the jump host which conn was opened through, if any.
+	proxy *ssh.Client
+
+	// borrowed is set when conn belongs to the caller of
+	// NewSSHOperatorFromClient, so Close leaves it open.
+	borrowed bool
+
+	// keepalive is set by StartKeepalive, and stopped by Close.
+	keepalive *keepalive
+
+	// CommandTimeout kills commands run by Execute and ExecuteStreaming
+	// which run for longer, zero means no timeout.
+	CommandTimeout time.Duration
+
+	// Context stops commands run by Execute and ExecuteStreaming once it
+	// is done, i.e. when the user presses Ctrl-C, nil means never.
+	Context context.Context
+
+	// Audit, when set, is called after each command.
+	Audit Audit
+
+	// SudoPassword, when set, runs each command as root with sudo, see
+	// withSudoPassword, whatever the binary of Sudo is, so callers must
+	// only set it when that is sudo. It is sent over stdin, so that it is
+	// never part of a command line on the node.
+	SudoPassword []byte
+
+	// Sudo is prepended to the commands of Upload, i.e. "sudo ", so that
+	// it can write where the user cannot.
+	Sudo string
+
+	// NoOutputMarkers runs commands as they are, rather than between the
+	// markers of withOutputMarkers, so that anything the shell prints
+	// around them, such as a banner, is part of their output. For
+	// debugging.
+	NoOutputMarkers bool
 }
 
-func (s SSHOperator) Close() error {
+// interruptGrace is how long a command has to exit after SIGINT when its
+// context is cancelled, before it is killed.
+const interruptGrace = 5 * time.Second
 
-	return s.conn.Close()
+// Close stops the keepalives of StartKeepalive, then closes the connection
+// to the node and the connection to the jump host which it was made
+// through. It leaves the connection of NewSSHOperatorFromClient open.
+func (s SSHOperator) Close() error {
+	if s.keepalive != nil {
+		s.keepalive.Stop()
+	}
+	if s.borrowed {
+		return nil
+	}
+	err := s.conn.Close()
+	if s.proxy != nil {
+		if proxyErr := s.proxy.Close(); err == nil {
+			err = proxyErr
+		}
+	}
+	return err
 }
 
+// NewSSHOperator connects to address, see NewSSHOperatorContext.
 func NewSSHOperator(address string, config *ssh.ClientConfig) (*SSHOperator, error) {
-	conn, err := ssh.Dial("tcp", address, config)
+	return NewSSHOperatorContext(context.Background(), address, config)
+}
+
+// NewSSHOperatorFromClient runs commands over client, a connection which
+// the caller has already made and authenticated, i.e. one from a pool or
+// over a transport of its own. The caller keeps owning client, Close of
+// the operator does not close it.
+func NewSSHOperatorFromClient(client *ssh.Client) *SSHOperator {
+	return &SSHOperator{conn: client, borrowed: true}
+}
+
+// NewSSHOperatorContext connects to address, giving up when ctx is done or
+// after config.Timeout, whichever is first. Both the TCP dial and the SSH
+// handshake are bounded, a timeout returns an error wrapping ErrTimeout.
+func NewSSHOperatorContext(ctx context.Context, address string, config *ssh.ClientConfig) (*SSHOperator, error) {
+	ctx, cancel := withDialTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	dialer := net.Dialer{}
+	netConn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, timeoutError(ctx, err, "connecting to "+address)
+	}
+
+	conn, err := handshake(ctx, netConn, address, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSHOperator{conn: conn}, nil
+}
+
+// NewSSHOperatorViaProxy connects to the jump host at proxyAddress and
+// then to address through it, so address is resolved by the jump host.
+// Each connection is bounded by the Timeout of its config.
+func NewSSHOperatorViaProxy(proxyAddress string, proxyConfig *ssh.ClientConfig, address string, config *ssh.ClientConfig) (*SSHOperator, error) {
+	proxy, err := NewSSHOperator(proxyAddress, proxyConfig)
 	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the jump host %s: %w", proxyAddress, err)
+	}
+
+	ctx, cancel := withDialTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	dialed := make(chan dialResult, 1)
+	go func() {
+		conn, err := proxy.conn.Dial("tcp", address)
+		dialed <- dialResult{conn, err}
+	}()
+
+	var netConn net.Conn
+	select {
+	case res := <-dialed:
+		if res.err != nil {
+			proxy.Close()
+			return nil, fmt.Errorf("unable to connect to %s through the jump host %s: %w", address, proxyAddress, res.err)
+		}
+		netConn = res.conn
+	case <-ctx.Done():
+		proxy.Close()
+		return nil, timeoutError(ctx, ctx.Err(), fmt.Sprintf("connecting to %s through the jump host %s", address, proxyAddress))
+	}
+
+	conn, err := handshake(ctx, netConn, address, config)
+	if err != nil {
+		proxy.Close()
 		return nil, err
 	}
 
-	operator := SSHOperator{
-		conn: conn,
+	return &SSHOperator{conn: conn, proxy: proxy.conn}, nil
+}
+
+// IsConnectionError reports whether err is a failure to reach the SSH
+// server, such as a refused connection or a timeout, which may go away once
+// the node has booted. Authentication and host key failures are not.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrTimeout) {
+		return true
 	}
 
-	return &operator, nil
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	var channelErr *ssh.OpenChannelError
+	if errors.As(err, &channelErr) {
+		return channelErr.Reason == ssh.ConnectionFailed
+	}
+
+	// sshd may accept connections and close them before it is ready, the
+	// handshake error only keeps the message of the cause.
+	message := err.Error()
+	return strings.HasSuffix(message, "handshake failed: EOF") || strings.Contains(message, "connection reset by peer")
 }
 
+func withDialTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// handshake runs the SSH handshake over netConn, closing netConn when it
+// fails or ctx is done first.
+func handshake(ctx context.Context, netConn net.Conn, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	type handshakeResult struct {
+		client *ssh.Client
+		err    error
+	}
+	done := make(chan handshakeResult, 1)
+	go func() {
+		conn, chans, reqs, err := ssh.NewClientConn(netConn, address, config)
+		if err != nil {
+			done <- handshakeResult{err: err}
+			return
+		}
+		done <- handshakeResult{client: ssh.NewClient(conn, chans, reqs)}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			netConn.Close()
+			return nil, res.err
+		}
+		return res.client, nil
+	case <-ctx.Done():
+		netConn.Close()
+		return nil, timeoutError(ctx, ctx.Err(), "connecting to "+address)
+	}
+}
+
+// Execute runs command and returns its output, which is not printed, as it
+// may hold secrets such as the node token. Use ExecuteStreaming to see it
+// whilst the command runs.
 func (s SSHOperator) Execute(command string) (CommandRes, error) {
+	return s.ExecuteStreaming(command, ioutil.Discard, ioutil.Discard)
+}
+
+func (s SSHOperator) ExecuteStreaming(command string, stdout, stderr io.Writer) (CommandRes, error) {
+	ctx, cancel := withCommandTimeout(s.Context, s.CommandTimeout)
+	defer cancel()
+
+	return s.ExecuteStreamingContext(ctx, command, stdout, stderr)
+}
+
+func (s SSHOperator) ExecuteContext(ctx context.Context, command string) (CommandRes, error) {
+	return s.ExecuteStreamingContext(ctx, command, ioutil.Discard, ioutil.Discard)
+}
+
+// ExecuteStreamingContext runs command until it exits or ctx is done, in
+// which case the remote process is killed and an error wrapping
+// ErrTimeout is returned once ctx's deadline has passed. When ctx is
+// cancelled the process is sent SIGINT first, so that it can clean up as
+// it would for Ctrl-C, and the error wraps context.Canceled. Only what
+// command prints to stdout is returned and streamed, not a banner printed
+// by the shell, unless NoOutputMarkers is set.
+func (s SSHOperator) ExecuteStreamingContext(ctx context.Context, command string, stdout, stderr io.Writer) (res CommandRes, err error) {
+	start := time.Now()
+	defer func() { s.Audit.record(command, start, err) }()
+
+	marker := ""
+	if !s.NoOutputMarkers {
+		marker = newOutputMarker()
+	}
+	return s.run(ctx, command, marker, nil, stdout, stderr)
+}
+
+// Upload writes the content of reader over SFTP to a file in the home
+// directory of the SSH user, see sftpUpload, which the commands of
+// InstallCommand then move to remotePath. Only those run with Sudo and
+// SudoPassword. A node without SFTP is given the content over the stdin of
+// the commands of UploadCommand instead.
+func (s SSHOperator) Upload(reader io.Reader, remotePath string, mode os.FileMode) (err error) {
+	counter := &countingReader{r: reader}
+	command := ""
+	start := time.Now()
+	defer func() { s.Audit.recordUpload(command, remotePath, counter.n, start, err) }()
+
+	tmp, err := s.sftpUpload(counter, remotePath)
+	var stdin io.Reader
+	switch {
+	case err == nil:
+		command = InstallCommand(s.Sudo, tmp, remotePath, mode)
+	case errors.Is(err, ErrSFTPUnavailable):
+		command, stdin = UploadCommand(s.Sudo, remotePath, mode), counter
+	default:
+		return fmt.Errorf("uploading %s: %w", remotePath, err)
+	}
+
+	ctx, cancel := withCommandTimeout(s.Context, s.CommandTimeout)
+	defer cancel()
 
+	errorOutput := bytes.Buffer{}
+	if _, err := s.run(ctx, command, "", stdin, ioutil.Discard, &errorOutput); err != nil {
+		return uploadError(remotePath, err, errorOutput.String())
+	}
+	return nil
+}
+
+// run runs command with stdin, which may be nil. With SudoPassword the
+// password is written to stdin first, see withSudoPassword. With a marker,
+// only the stdout between the markers of withOutputMarkers is kept.
+func (s SSHOperator) run(ctx context.Context, command, marker string, stdin io.Reader, stdout, stderr io.Writer) (CommandRes, error) {
 	sess, err := s.conn.NewSession()
 	if err != nil {
 		return CommandRes{}, err
@@ -49,7 +310,13 @@ func (s SSHOperator) Execute(command string) (CommandRes, error) {
 
 	wg := sync.WaitGroup{}
 
-	stdOutWriter := io.MultiWriter(os.Stdout, &output)
+	stdOutLines := NewLineWriter(stdout, "")
+	var stdOutWriter io.Writer = io.MultiWriter(stdOutLines, &output)
+	var stdOutMarkers *markerWriter
+	if len(marker) > 0 {
+		stdOutMarkers = newMarkerWriter(stdOutWriter, marker)
+		stdOutWriter = stdOutMarkers
+	}
 	wg.Add(1)
 	go func() {
 		io.Copy(stdOutWriter, sessStdOut)
@@ -61,19 +328,69 @@ func (s SSHOperator) Execute(command string) (CommandRes, error) {
 	}
 
 	errorOutput := bytes.Buffer{}
-	stdErrWriter := io.MultiWriter(os.Stderr, &errorOutput)
+	stdErrLines := NewLineWriter(stderr, "")
+	stdErrWriter := io.MultiWriter(stdErrLines, &errorOutput)
 	wg.Add(1)
 	go func() {
 		io.Copy(stdErrWriter, sessStderr)
 		wg.Done()
 	}()
 
-	err = sess.Run(command)
+	remoteCommand := command
+	if len(marker) > 0 {
+		remoteCommand = withOutputMarkers(command, marker)
+	}
+	sess.Stdin = stdin
+	if len(s.SudoPassword) > 0 {
+		remoteCommand = withSudoPassword(remoteCommand)
+		if stdin == nil {
+			stdin = strings.NewReader("")
+		}
+		sess.Stdin = io.MultiReader(bytes.NewReader(s.SudoPassword), strings.NewReader("\n"), stdin)
+	}
+
+	if err := sess.Start(remoteCommand); err != nil {
+		return CommandRes{}, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sess.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		exited := false
+		if ctx.Err() == context.Canceled {
+			sess.Signal(ssh.SIGINT)
+			select {
+			case <-done:
+				exited = true
+			case <-time.After(interruptGrace):
+			}
+		}
+		if !exited {
+			sess.Signal(ssh.SIGKILL)
+			sess.Close()
+			<-done
+		}
+		err = timeoutError(ctx, ctx.Err(), fmt.Sprintf("running %q", command))
+	}
 
 	wg.Wait()
 
+	if stdOutMarkers != nil {
+		stdOutMarkers.Flush()
+	}
+	stdOutLines.Flush()
+	stdErrLines.Flush()
+
 	if err != nil {
-		return CommandRes{}, err
+		if ctx.Err() != nil {
+			return CommandRes{}, err
+		}
+		return CommandRes{StdErr: errorOutput.Bytes(), StdOut: output.Bytes()}, err
 	}
 
 	return CommandRes{
@@ -82,6 +399,26 @@ func (s SSHOperator) Execute(command string) (CommandRes, error) {
 	}, nil
 }
 
+// withSudoPassword runs command as root with the password on the first line
+// of stdin. The shell reads that line and hands it to sudo -S -v through a
+// here-doc, then runs command with sudo -n on the credentials sudo cached.
+// Only the rest of stdin reaches command, even when sudo had cached them
+// already and would not have read a password.
+func withSudoPassword(command string) string {
+	return "IFS= read -r k3sup_sudo_password && " +
+		"sudo -S -p '' -v <<K3SUP_SUDO_PASSWORD && unset k3sup_sudo_password && sudo -n sh -c " + ShellQuote(command) + "\n" +
+		"$k3sup_sudo_password\n" +
+		"K3SUP_SUDO_PASSWORD"
+}
+
+// ShellQuote quotes value as a single word for sh, so that spaces, quotes
+// and $ in it are passed on unchanged.
+func ShellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// CommandRes is the output of a command. When the command itself fails,
+// i.e. exits with a non-zero status, it holds what was printed before that.
 type CommandRes struct {
 	StdOut []byte
 	StdErr []byte